@@ -0,0 +1,131 @@
+package goatcounter
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"zgo.at/errors"
+	"zgo.at/zdb"
+	"zgo.at/zstd/ztime"
+)
+
+type AnnotationID int32
+
+// Annotation marks an event such as a deploy or a newsletter on the dashboard
+// charts.
+type Annotation struct {
+	ID        AnnotationID `db:"annotation_id,id" json:"id"`
+	SiteID    SiteID       `db:"site_id" json:"-"`
+	At        time.Time    `db:"at" json:"at"`
+	Label     string       `db:"label" json:"label"`
+	Filter    string       `db:"filter" json:"filter"` // Only show on pages matching this filter.
+	CreatedAt time.Time    `db:"created_at" json:"created_at"`
+}
+
+func (Annotation) Table() string { return "annotations" }
+
+var _ zdb.Defaulter = &Annotation{}
+
+func (a *Annotation) Defaults(ctx context.Context) {
+	if a.SiteID == 0 {
+		a.SiteID = MustGetSite(ctx).ID
+	}
+	if a.CreatedAt.IsZero() {
+		a.CreatedAt = ztime.Now(ctx)
+	}
+	if a.At.IsZero() {
+		a.At = ztime.Now(ctx)
+	}
+	a.At = a.At.UTC().Truncate(time.Second)
+	a.Label = strings.TrimSpace(a.Label)
+	a.Filter = strings.TrimSpace(a.Filter)
+}
+
+var _ zdb.Validator = &Annotation{}
+
+func (a *Annotation) Validate(ctx context.Context) error {
+	v := NewValidate(ctx)
+	v.Required("site_id", a.SiteID)
+	v.Required("at", a.At)
+	v.Required("label", a.Label)
+	v.Len("label", a.Label, 0, 200)
+	v.Len("filter", a.Filter, 0, 512)
+	return v.ErrorOrNil()
+}
+
+// Matches reports if this annotation should be displayed on the chart for
+// this path; annotations without a filter are displayed on all charts.
+func (a Annotation) Matches(h HitList) bool {
+	return a.Filter == "" || Filter{Query: a.Filter}.Match(h.Path, h.Title, bool(h.Event))
+}
+
+// Insert a new annotation.
+func (a *Annotation) Insert(ctx context.Context) error {
+	err := zdb.Insert(ctx, a)
+	return errors.Wrap(err, "Annotation.Insert")
+}
+
+// Delete this annotation.
+func (a *Annotation) Delete(ctx context.Context) error {
+	err := zdb.Exec(ctx, `delete from annotations where annotation_id=$1 and site_id=$2`,
+		a.ID, MustGetSite(ctx).ID)
+	return errors.Wrapf(err, "Annotation.Delete(%d)", a.ID)
+}
+
+func (a *Annotation) ByID(ctx context.Context, id AnnotationID) error {
+	err := zdb.Get(ctx, a, `/* Annotation.ByID */
+		select * from annotations where annotation_id=$1 and site_id=$2`,
+		id, MustGetSite(ctx).ID)
+	return errors.Wrapf(err, "Annotation.ByID(%d)", id)
+}
+
+type Annotations []Annotation
+
+// List all annotations for the current site, newest first.
+func (a *Annotations) List(ctx context.Context) error {
+	err := zdb.Select(ctx, a, `/* Annotations.List */
+		select * from annotations where site_id=$1 order by at desc, annotation_id desc`,
+		MustGetSite(ctx).ID)
+	return errors.Wrap(err, "Annotations.List")
+}
+
+// ListRange lists the annotations for the current site in the time range,
+// oldest first.
+func (a *Annotations) ListRange(ctx context.Context, rng ztime.Range) error {
+	err := zdb.Select(ctx, a, `/* Annotations.ListRange */
+		select * from annotations
+		where site_id = :site and at >= :start and at <= :end
+		order by at asc, annotation_id asc`,
+		map[string]any{
+			"site":  MustGetSite(ctx).ID,
+			"start": rng.Start,
+			"end":   rng.End,
+		})
+	return errors.Wrap(err, "Annotations.ListRange")
+}
+
+// ChartAnnotation is an annotation as displayed on the dashboard charts, with
+// the day and hour in the user's timezone.
+type ChartAnnotation struct {
+	Day   string `json:"day"`
+	Hour  int    `json:"hour"`
+	Label string `json:"label"`
+}
+
+// Chart gets the annotations to display on the chart for this path, or on the
+// totals chart if h is nil.
+func (a Annotations) Chart(ctx context.Context, h *HitList) []ChartAnnotation {
+	var (
+		loc = MustGetUser(ctx).Settings.Timezone.Loc()
+		c   = make([]ChartAnnotation, 0, len(a))
+	)
+	for _, aa := range a {
+		if h != nil && !aa.Matches(*h) {
+			continue
+		}
+		t := aa.At.In(loc)
+		c = append(c, ChartAnnotation{Day: t.Format("2006-01-02"), Hour: t.Hour(), Label: aa.Label})
+	}
+	return c
+}
@@ -0,0 +1,59 @@
+package goatcounter_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	. "zgo.at/goatcounter/v2"
+	"zgo.at/goatcounter/v2/gctest"
+	"zgo.at/tz"
+	"zgo.at/zstd/ztime"
+)
+
+func TestAnnotations(t *testing.T) {
+	ctx := gctest.DB(t)
+
+	for _, a := range []Annotation{
+		{At: time.Date(2019, 6, 16, 22, 30, 0, 0, time.UTC), Label: "v2.3 released"},
+		{At: time.Date(2019, 6, 17, 9, 0, 0, 0, time.UTC), Label: "newsletter", Filter: "/blog"},
+		{At: time.Date(2019, 7, 1, 9, 0, 0, 0, time.UTC), Label: "out of range"},
+	} {
+		err := a.Insert(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := (&Annotation{}).Insert(ctx)
+	if err == nil {
+		t.Error("no error for empty label")
+	}
+
+	var a Annotations
+	err = a.ListRange(ctx, ztime.NewRange(time.Date(2019, 6, 1, 0, 0, 0, 0, time.UTC)).
+		To(time.Date(2019, 6, 30, 23, 59, 59, 0, time.UTC)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Display in the user's timezone (UTC+8).
+	MustGetUser(ctx).Settings.Timezone = tz.MustNew("", "Asia/Makassar")
+
+	tests := []struct {
+		h    *HitList
+		want string
+	}{
+		{nil, `[{2019-06-17 6 v2.3 released} {2019-06-17 17 newsletter}]`},
+		{&HitList{Path: "/blog/post"}, `[{2019-06-17 6 v2.3 released} {2019-06-17 17 newsletter}]`},
+		{&HitList{Path: "/about"}, `[{2019-06-17 6 v2.3 released}]`},
+	}
+	for _, tt := range tests {
+		t.Run("", func(t *testing.T) {
+			have := fmt.Sprintf("%v", a.Chart(ctx, tt.h))
+			if have != tt.want {
+				t.Errorf("\nhave: %s\nwant: %s", have, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,301 @@
+package goatcounter
+
+import (
+	"context"
+	"maps"
+	"math"
+	"slices"
+	"time"
+
+	"zgo.at/errors"
+	"zgo.at/goatcounter/v2/pkg/db2"
+	"zgo.at/goatcounter/v2/pkg/log"
+	"zgo.at/zdb"
+	"zgo.at/zstd/ztime"
+)
+
+// Settings for the anomaly detection; these are variables so tests can change
+// them.
+var (
+	AnomalyWeeks      = 8   // Number of weeks to use for the baseline.
+	AnomalyMinWeeks   = 4   // Need at least this many weeks of data.
+	AnomalyDetectDays = 3   // Number of (complete) days to check on every run.
+	AnomalyPaths      = 10  // Number of top paths to check, besides the site total.
+	AnomalyScore      = 4.0 // Minimum number of deviations from the baseline.
+	AnomalyMinDiff    = 10  // Minimum difference in visitors from the baseline.
+)
+
+// Kinds of anomalies.
+const (
+	AnomalySpike = "spike"
+	AnomalyDrop  = "drop"
+)
+
+type AnomalyID int64
+
+// Anomaly is a day where the number of visitors for a site or path was unusual
+// compared to the same day of the week in the previous weeks.
+type Anomaly struct {
+	ID        AnomalyID `db:"anomaly_id,id" json:"id"`
+	SiteID    SiteID    `db:"site_id" json:"-"`
+	PathID    PathID    `db:"path_id" json:"path_id"` // 0 for the site total.
+	Day       string    `db:"day" json:"day"`         // In UTC, as hit_counts.
+	Kind      string    `db:"kind" json:"kind"`
+	Count     int       `db:"count" json:"count"`
+	Expected  float64   `db:"expected" json:"expected"`
+	Score     float64   `db:"score" json:"score"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+
+	Path string `db:"path,noinsert" json:"path,omitempty"`
+}
+
+func (Anomaly) Table() string { return "anomalies" }
+
+// Date gets the day as a time.
+func (a Anomaly) Date() time.Time {
+	t, _ := time.Parse("2006-01-02", a.Day)
+	return t
+}
+
+// Diff gets the difference from the expected value as a percentage.
+func (a Anomaly) Diff() float64 {
+	if a.Expected == 0 {
+		return math.Inf(1)
+	}
+	return (float64(a.Count) - a.Expected) / a.Expected * 100
+}
+
+type Anomalies []Anomaly
+
+// List the anomalies for the current site in the time range, newest first.
+//
+// The site total is only included if the pathFilter doesn't filter anything.
+func (a *Anomalies) List(ctx context.Context, rng ztime.Range, pathFilter PathFilter, limit int) error {
+	var (
+		user                    = MustGetUser(ctx)
+		filterSQL, filterParams = pathFilter.SQL(ctx)
+	)
+	err := zdb.Select(ctx, a, `/* Anomalies.List */
+		select
+			anomaly_id, anomalies.site_id, path_id,
+			substr(cast(day as text), 0, 11) as day,
+			kind, count, expected, score, anomalies.created_at,
+			coalesce(paths.path, '') as path
+		from anomalies
+		left join paths using (path_id)
+		where
+			anomalies.site_id = :site and day >= :start and day <= :end and
+			(path_id = 0 or paths.path is not null) and
+			{{:filtered path_id != 0 and}}
+			:filter
+		order by day desc, abs(score) desc, path_id asc
+		limit :limit`,
+		filterParams, map[string]any{
+			"site":     MustGetSite(ctx).ID,
+			"start":    asUTCDate(user, rng.Start),
+			"end":      asUTCDate(user, rng.End),
+			"filter":   filterSQL,
+			"filtered": !pathFilter.IsZero(),
+			"limit":    limit,
+		})
+	return errors.Wrap(err, "Anomalies.List")
+}
+
+// ListTotals lists the anomalies for the site total in the time range, oldest
+// first.
+func (a *Anomalies) ListTotals(ctx context.Context, rng ztime.Range) error {
+	user := MustGetUser(ctx)
+	err := zdb.Select(ctx, a, `/* Anomalies.ListTotals */
+		select
+			anomaly_id, site_id, path_id,
+			substr(cast(day as text), 0, 11) as day,
+			kind, count, expected, score, created_at
+		from anomalies
+		where site_id = :site and path_id = 0 and day >= :start and day <= :end
+		order by day asc`,
+		map[string]any{
+			"site":  MustGetSite(ctx).ID,
+			"start": asUTCDate(user, rng.Start),
+			"end":   asUTCDate(user, rng.End),
+		})
+	return errors.Wrap(err, "Anomalies.ListTotals")
+}
+
+// DetectAnomalies looks for unusual days in the number of visitors for all
+// active sites, for the site total and the AnomalyPaths most visited paths.
+//
+// Every day is compared to the same day of the week in the previous
+// AnomalyWeeks weeks. The last AnomalyDetectDays complete days (in UTC) are
+// checked; days that already have an anomaly are skipped.
+func DetectAnomalies(ctx context.Context) (int, error) {
+	var sites Sites
+	err := sites.UnscopedList(ctx)
+	if err != nil {
+		return 0, errors.Wrap(err, "DetectAnomalies")
+	}
+
+	var (
+		n    int
+		errs []error
+	)
+	for _, s := range sites {
+		nn, err := detectAnomaliesSite(WithSite(ctx, &s))
+		n += nn
+		if err != nil {
+			// Don't let one site stop the detection for all the others.
+			err = errors.Wrapf(err, "DetectAnomalies: site %d", s.ID)
+			log.Module("anomalies").Error(ctx, err, "site", s.ID)
+			errs = append(errs, err)
+		}
+	}
+	return n, errors.Join(errs...)
+}
+
+func detectAnomaliesSite(ctx context.Context) (int, error) {
+	var (
+		siteID = MustGetSite(ctx).ID
+		end    = ztime.StartOf(ztime.Now(ctx).UTC(), ztime.Day)
+		first  = end.AddDate(0, 0, -AnomalyDetectDays)
+		start  = first.AddDate(0, 0, -7*AnomalyWeeks)
+	)
+
+	var paths []PathID
+	err := zdb.Select(ctx, &paths, `/* detectAnomaliesSite */
+		select path_id from hit_counts
+		where site_id = :site and hour >= :start and hour < :end
+		group by path_id
+		order by sum(total) desc, path_id asc
+		limit :limit`,
+		map[string]any{"site": siteID, "start": start, "end": end, "limit": AnomalyPaths})
+	if err != nil {
+		return 0, err
+	}
+
+	type row struct {
+		PathID PathID `db:"path_id"`
+		Day    string `db:"day"`
+		Total  int    `db:"total"`
+	}
+	var totals, perPath []row
+	err = zdb.Select(ctx, &totals, "load:anomalies.Daily", map[string]any{
+		"site": siteID, "start": start, "end": end, "per_path": false})
+	if err != nil {
+		return 0, err
+	}
+	if len(paths) > 0 {
+		err = zdb.Select(ctx, &perPath, "load:anomalies.Daily", map[string]any{
+			"site": siteID, "start": start, "end": end, "per_path": true,
+			"paths": db2.Array(ctx, paths), "in": db2.In(ctx)})
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	series := make(map[PathID]map[string]int)
+	for _, r := range append(totals, perPath...) {
+		if series[r.PathID] == nil {
+			series[r.PathID] = make(map[string]int)
+		}
+		series[r.PathID][r.Day] = r.Total
+	}
+
+	var n int
+	for _, pathID := range append([]PathID{0}, paths...) {
+		s := series[pathID]
+		if len(s) == 0 {
+			continue
+		}
+		firstData := slices.Min(slices.Collect(maps.Keys(s)))
+
+		for d := first; d.Before(end); d = d.AddDate(0, 0, 1) {
+			var history []int
+			for w := 1; w <= AnomalyWeeks; w++ {
+				day := d.AddDate(0, 0, -7*w).Format("2006-01-02")
+				if day < firstData {
+					break
+				}
+				history = append(history, s[day])
+			}
+			if len(history) < AnomalyMinWeeks {
+				continue
+			}
+
+			day := d.Format("2006-01-02")
+			kind, expected, score := anomalyScore(history, s[day])
+			if kind == "" {
+				continue
+			}
+
+			var exists bool
+			err := zdb.Get(ctx, &exists, `select exists(select 1 from anomalies where site_id=$1 and path_id=$2 and day=$3)`,
+				siteID, pathID, day)
+			if err != nil {
+				return n, err
+			}
+			if exists {
+				continue
+			}
+
+			err = zdb.Insert(ctx, &Anomaly{
+				SiteID:    siteID,
+				PathID:    pathID,
+				Day:       day,
+				Kind:      kind,
+				Count:     s[day],
+				Expected:  math.Round(expected*10) / 10,
+				Score:     math.Round(score*10) / 10,
+				CreatedAt: ztime.Now(ctx),
+			})
+			if err != nil {
+				return n, err
+			}
+			n++
+		}
+	}
+	return n, nil
+}
+
+// anomalyScore compares count to the history of the same day of the week.
+//
+// The expected value is the median of the history, and the score is the number
+// of deviations count is removed from that. The deviation is the (scaled)
+// median absolute deviation, or the square root of the expected value if that's
+// larger, as the MAD is often 0 for small numbers.
+//
+// The kind is empty if this isn't an anomaly.
+func anomalyScore(history []int, count int) (kind string, expected, score float64) {
+	h := make([]float64, 0, len(history))
+	for _, c := range history {
+		h = append(h, float64(c))
+	}
+	expected = median(h)
+
+	dev := make([]float64, 0, len(h))
+	for _, c := range h {
+		dev = append(dev, math.Abs(c-expected))
+	}
+	spread := max(median(dev)*1.4826, math.Sqrt(max(expected, 1)))
+
+	diff := float64(count) - expected
+	score = diff / spread
+	switch {
+	case math.Abs(score) < AnomalyScore || math.Abs(diff) < float64(AnomalyMinDiff):
+		return "", expected, score
+	case score > 0:
+		return AnomalySpike, expected, score
+	default:
+		return AnomalyDrop, expected, score
+	}
+}
+
+func median(l []float64) float64 {
+	if len(l) == 0 {
+		return 0
+	}
+	l = slices.Clone(l)
+	slices.Sort(l)
+	if len(l)%2 == 1 {
+		return l[len(l)/2]
+	}
+	return (l[len(l)/2-1] + l[len(l)/2]) / 2
+}
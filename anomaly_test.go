@@ -0,0 +1,72 @@
+package goatcounter_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	. "zgo.at/goatcounter/v2"
+	"zgo.at/goatcounter/v2/gctest"
+	"zgo.at/zstd/ztime"
+)
+
+func TestDetectAnomalies(t *testing.T) {
+	ctx := ztime.WithNow(gctest.DB(t), time.Date(2019, 6, 17, 12, 0, 0, 0, time.UTC))
+
+	// Five visitors every day for six weeks, and then 40 yesterday.
+	var hits []Hit
+	for d := 42; d > 1; d-- {
+		for range 5 {
+			hits = append(hits, Hit{Path: "/a", FirstVisit: true, CreatedAt: ztime.Now(ctx).AddDate(0, 0, -d)})
+		}
+	}
+	for range 40 {
+		hits = append(hits, Hit{Path: "/a", FirstVisit: true, CreatedAt: ztime.Now(ctx).AddDate(0, 0, -1)})
+	}
+	gctest.StoreHits(ctx, t, false, hits...)
+
+	n, err := DetectAnomalies(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("n = %d", n)
+	}
+
+	// Already detected, so shouldn't add anything.
+	n, err = DetectAnomalies(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("n = %d", n)
+	}
+
+	var a Anomalies
+	err = a.List(ctx, ztime.NewRange(ztime.Now(ctx).AddDate(0, 0, -7)).To(ztime.Now(ctx)), PathFilter{}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var have []string
+	for _, aa := range a {
+		have = append(have, fmt.Sprintf("%s %q %s %d %.0f", aa.Day, aa.Path, aa.Kind, aa.Count, aa.Expected))
+	}
+	want := `[2019-06-16 "" spike 40 5 2019-06-16 "/a" spike 40 5]`
+	if h := fmt.Sprintf("%v", have); h != want {
+		t.Errorf("\nhave: %s\nwant: %s", h, want)
+	}
+
+	// Only the path with a filter.
+	ids, err := FilterPathIDs(ctx, "/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var filtered Anomalies
+	err = filtered.List(ctx, ztime.NewRange(ztime.Now(ctx).AddDate(0, 0, -7)).To(ztime.Now(ctx)), PathFilterFromIDs(ids), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(filtered) != 1 || filtered[0].Path != "/a" {
+		t.Errorf("%v", filtered)
+	}
+}
@@ -3,7 +3,9 @@ package goatcounter
 import (
 	"context"
 	"fmt"
+	"net/netip"
 	"strings"
+	"sync"
 	"time"
 
 	"zgo.at/errors"
@@ -19,13 +21,19 @@ import (
 //
 // DO NOT change the values of these constants; they're stored in the database.
 const (
-	APIPermNothing    zint.Bitflag64 = 1 << iota
-	APIPermCount                     // 2
-	APIPermExport                    // 4
-	APIPermSiteRead                  // 8
-	APIPermSiteCreate                // 16
-	APIPermSiteUpdate                // 32
-	APIPermStats                     // 64
+	APIPermNothing     zint.Bitflag64 = 1 << iota
+	APIPermCount                      // 2
+	APIPermExport                     // 4
+	APIPermSiteRead                   // 8
+	APIPermSiteCreate                 // 16
+	APIPermSiteUpdate                 // 32
+	APIPermStats                      // 64
+	APIPermUserRead                   // 128
+	APIPermUserManage                 // 256
+	APIPermTokenManage                // 512
+	APIPermSiteDelete                 // 1024
+	APIPermAuditRead                  // 2048
+	APIPermAnnotations                // 4096
 )
 
 type APITokenID int32
@@ -40,8 +48,20 @@ type APIToken struct {
 	Permissions zint.Bitflag64 `db:"permissions" json:"permissions"`
 	Sites       SiteIDs        `db:"sites" json:"sites"`
 
+	// Only allow requests from these IP addresses or CIDR ranges; all
+	// addresses are allowed if this is empty.
+	AllowedIPs Strings `db:"allowed_ips" json:"allowed_ips"`
+
+	// Restrict statistics to paths matching this filter, with the same syntax
+	// as the dashboard filter. Tokens with a filter can only read statistics.
+	Filter string `db:"filter" json:"filter"`
+
+	ExpiresAt        *time.Time `db:"expires_at" json:"-"`
+	ExpiryNotifiedAt *time.Time `db:"expiry_notified_at" json:"-"`
+
 	CreatedAt  time.Time  `db:"created_at" json:"-"`
 	LastUsedAt *time.Time `db:"last_used_at" json:"-"`
+	UsageCount int        `db:"usage_count" json:"-"`
 }
 
 func (APIToken) Table() string { return "api_tokens" }
@@ -51,6 +71,10 @@ type PermissionFlag struct {
 	Flag        zint.Bitflag64
 }
 
+// APIPermReadOnly are the permissions that only read data, which are the only
+// permissions allowed for tokens with a Filter.
+const APIPermReadOnly = APIPermNothing | APIPermSiteRead | APIPermStats
+
 // PermissionFlags returns a list of all flags we know for the Permissions settings.
 func (t APIToken) PermissionFlags(only ...zint.Bitflag64) []PermissionFlag {
 	if len(only) > 1 {
@@ -87,6 +111,35 @@ func (t APIToken) PermissionFlags(only ...zint.Bitflag64) []PermissionFlag {
 			Label: "Update sites",
 			Flag:  APIPermSiteUpdate,
 		},
+		{
+			Label: "Delete sites",
+			Help:  "Delete sites with /api/v0/sites; this can't be undone",
+			Flag:  APIPermSiteDelete,
+		},
+		{
+			Label: "Read users",
+			Flag:  APIPermUserRead,
+		},
+		{
+			Label: "Manage users",
+			Help:  "Invite, update, and remove users with /api/v0/users",
+			Flag:  APIPermUserManage,
+		},
+		{
+			Label: "Manage API tokens",
+			Help:  "Create, rotate, and revoke API tokens with /api/v0/tokens",
+			Flag:  APIPermTokenManage,
+		},
+		{
+			Label: "Read audit log",
+			Help:  "Read the audit log with /api/v0/audit-log",
+			Flag:  APIPermAuditRead,
+		},
+		{
+			Label: "Manage annotations",
+			Help:  "Add and remove chart annotations with /api/v0/annotations",
+			Flag:  APIPermAnnotations,
+		},
 	}
 
 	if len(only) == 0 {
@@ -123,6 +176,24 @@ func (t APIToken) FormatPermissions() string {
 	if t.Permissions.Has(APIPermStats) {
 		all = append(all, "stats")
 	}
+	if t.Permissions.Has(APIPermUserRead) {
+		all = append(all, "user-read")
+	}
+	if t.Permissions.Has(APIPermUserManage) {
+		all = append(all, "user-manage")
+	}
+	if t.Permissions.Has(APIPermTokenManage) {
+		all = append(all, "token-manage")
+	}
+	if t.Permissions.Has(APIPermSiteDelete) {
+		all = append(all, "site-delete")
+	}
+	if t.Permissions.Has(APIPermAuditRead) {
+		all = append(all, "audit-read")
+	}
+	if t.Permissions.Has(APIPermAnnotations) {
+		all = append(all, "annotations")
+	}
 	return "'" + strings.Join(all, "', '") + "'"
 }
 
@@ -149,6 +220,17 @@ func (t *APIToken) Validate(ctx context.Context) error {
 	if len(t.Sites) == 0 {
 		v.Append("sites", z18n.T(ctx, "validate/need-one|must select at least one"))
 	}
+	for _, ip := range t.AllowedIPs {
+		if _, err := parsePrefix(ip); err != nil {
+			v.Append("allowed_ips", z18n.T(ctx, "validate/invalid-ip|%(ip) is not a valid IP address or CIDR range", ip))
+		}
+	}
+	if t.Filter != "" && t.Permissions&^APIPermReadOnly != 0 {
+		v.Append("filter", z18n.T(ctx, "validate/api-token-filter-read-only|tokens with a filter can only read sites and statistics"))
+	}
+	if t.ID == 0 && t.ExpiresAt != nil && !t.ExpiresAt.After(ztime.Now(ctx)) {
+		v.Append("expires_at", z18n.T(ctx, "validate/in-future|must be in the future"))
+	}
 	account := MustGetAccount(ctx)
 	if !t.Sites.All() {
 		for _, id := range t.Sites {
@@ -171,17 +253,147 @@ func (t *APIToken) Insert(ctx context.Context) error {
 	return errors.Wrap(err, "APIToken.Insert")
 }
 
-// Update the name and permissions.
+// Update the name, permissions, sites, and restrictions.
+//
+// The expiry notification is sent again if the expiry date was changed.
 func (t *APIToken) Update(ctx context.Context) error {
-	err := zdb.Update(ctx, t, "name", "permissions")
+	var cur APIToken
+	err := zdb.Get(ctx, &cur, `select * from api_tokens where api_token_id = ?`, t.ID)
+	if err != nil {
+		return errors.Wrap(err, "APIToken.Update")
+	}
+	if !equalTime(cur.ExpiresAt, t.ExpiresAt) {
+		t.ExpiryNotifiedAt = nil
+	}
+
+	err = zdb.Update(ctx, t, "name", "permissions", "sites", "allowed_ips", "filter",
+		"expires_at", "expiry_notified_at")
 	return errors.Wrap(err, "APIToken.Update")
 }
 
-// Touch sets the last used time to the current time.
-func (t *APIToken) Touch(ctx context.Context) error {
-	t.LastUsedAt = new(ztime.Now(ctx))
-	err := zdb.Update(ctx, t, "last_used_at")
-	return errors.Wrap(err, "APIToken.Touch")
+func equalTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+// Rotate generates a new secret token, invalidating the previous one.
+func (t *APIToken) Rotate(ctx context.Context) error {
+	t.Token = zcrypto.Secret256()
+	err := zdb.Update(ctx, t, "token")
+	return errors.Wrap(err, "APIToken.Rotate")
+}
+
+// Usage of API tokens is kept in memory, and written to the database with
+// PersistAPITokenUse(), so that API requests don't all need a write.
+var apiTokenUse struct {
+	mu  sync.Mutex
+	log []APITokenLog
+}
+
+// Use records that the token was used for a request: this sets the last used
+// time, increments the usage count, and adds the request to the token's log.
+//
+// This isn't written to the database until PersistAPITokenUse() is called.
+func (t *APIToken) Use(ctx context.Context, method, path, ip string) {
+	now := ztime.Now(ctx)
+	apiTokenUse.mu.Lock()
+	apiTokenUse.log = append(apiTokenUse.log, APITokenLog{
+		APITokenID: t.ID,
+		SiteID:     t.SiteID,
+		Method:     method,
+		Path:       path,
+		IP:         ip,
+		CreatedAt:  now,
+	})
+	apiTokenUse.mu.Unlock()
+	t.LastUsedAt, t.UsageCount = &now, t.UsageCount+1
+}
+
+// PersistAPITokenUse writes the API token usage recorded with Use() to the
+// database.
+func PersistAPITokenUse(ctx context.Context) error {
+	apiTokenUse.mu.Lock()
+	l := apiTokenUse.log
+	apiTokenUse.log = nil
+	apiTokenUse.mu.Unlock()
+	if len(l) == 0 {
+		return nil
+	}
+
+	type use struct {
+		n    int
+		last time.Time
+	}
+	used := make(map[APITokenID]use)
+	for _, e := range l {
+		u := used[e.APITokenID]
+		u.n++
+		if e.CreatedAt.After(u.last) {
+			u.last = e.CreatedAt
+		}
+		used[e.APITokenID] = u
+	}
+
+	err := zdb.TX(ctx, func(ctx context.Context) error {
+		for id, u := range used {
+			err := zdb.Exec(ctx, `/* PersistAPITokenUse */
+				update api_tokens set last_used_at = ?, usage_count = usage_count + ? where api_token_id = ?`,
+				u.last, u.n, id)
+			if err != nil {
+				return err
+			}
+		}
+
+		ins, err := zdb.NewBulkInsert(ctx, "api_token_log", []string{"api_token_id", "site_id", "method", "path", "ip", "created_at"})
+		if err != nil {
+			return err
+		}
+		for _, e := range l {
+			ins.Values(e.APITokenID, e.SiteID, e.Method, e.Path, e.IP, e.CreatedAt)
+		}
+		return ins.Finish()
+	})
+	return errors.Wrap(err, "PersistAPITokenUse")
+}
+
+// Expired reports if this token has expired.
+func (t APIToken) Expired(ctx context.Context) bool {
+	return t.ExpiresAt != nil && !t.ExpiresAt.After(ztime.Now(ctx))
+}
+
+// AllowIP reports if the token can be used from this IP address.
+func (t APIToken) AllowIP(ip string) bool {
+	if len(t.AllowedIPs) == 0 {
+		return true
+	}
+	a, err := netip.ParseAddr(ip)
+	if err != nil {
+		ap, err := netip.ParseAddrPort(ip)
+		if err != nil {
+			return false
+		}
+		a = ap.Addr()
+	}
+	a = a.Unmap()
+	for _, s := range t.AllowedIPs {
+		p, err := parsePrefix(s)
+		if err == nil && p.Contains(a) {
+			return true
+		}
+	}
+	return false
+}
+
+// ScopePaths gets the IDs of the paths this token is restricted to with Filter
+// on the current site, or nil if it's not restricted.
+func (t APIToken) ScopePaths(ctx context.Context) ([]PathID, error) {
+	if t.Filter == "" {
+		return nil, nil
+	}
+	ids, err := FilterPathIDs(ctx, t.Filter)
+	return ids, errors.Wrap(err, "APIToken.ScopePaths")
 }
 
 func (t *APIToken) ByID(ctx context.Context, id APITokenID) error {
@@ -205,9 +417,15 @@ func (t *APIToken) ByToken(ctx context.Context, token string) error {
 }
 
 func (t *APIToken) Delete(ctx context.Context) error {
-	err := zdb.Exec(ctx,
-		`/* APIToken.Delete */ delete from api_tokens where api_token_id=$1 and site_id=$2`,
-		t.ID, MustGetSite(ctx).IDOrParent())
+	err := zdb.TX(ctx, func(ctx context.Context) error {
+		err := zdb.Exec(ctx,
+			`/* APIToken.Delete */ delete from api_tokens where api_token_id=$1 and site_id=$2`,
+			t.ID, MustGetSite(ctx).IDOrParent())
+		if err != nil {
+			return err
+		}
+		return zdb.Exec(ctx, `delete from api_token_log where api_token_id=$1`, t.ID)
+	})
 	return errors.Wrapf(err, "APIToken.Delete(%d)", t.ID)
 }
 
@@ -253,3 +471,62 @@ func (t *APITokens) Delete(ctx context.Context, _ bool) error {
 	})
 	return errors.Wrap(err, "Users.Delete")
 }
+
+// ExpiringSoon lists all tokens that expire in the given period and for which
+// the owner hasn't been notified yet, across all sites.
+func (t *APITokens) ExpiringSoon(ctx context.Context, within time.Duration) error {
+	now := ztime.Now(ctx)
+	err := zdb.Select(ctx, t, `/* APITokens.ExpiringSoon */
+		select * from api_tokens
+		where expires_at is not null and expires_at > ? and expires_at <= ? and expiry_notified_at is null
+		order by api_token_id`,
+		now, now.Add(within))
+	return errors.Wrap(err, "APITokens.ExpiringSoon")
+}
+
+// NotifiedExpiry records that the owner was notified that this token expires
+// soon.
+func (t *APIToken) NotifiedExpiry(ctx context.Context) error {
+	t.ExpiryNotifiedAt = new(ztime.Now(ctx))
+	err := zdb.Update(ctx, t, "expiry_notified_at")
+	return errors.Wrap(err, "APIToken.NotifiedExpiry")
+}
+
+// WithAPIToken sets the API token used for this request.
+func WithAPIToken(ctx context.Context, t *APIToken) context.Context {
+	return context.WithValue(ctx, keyAPIToken, t)
+}
+
+// GetAPIToken gets the API token set with WithAPIToken(), or nil if there is
+// none.
+func GetAPIToken(ctx context.Context) *APIToken {
+	t, _ := ctx.Value(keyAPIToken).(*APIToken)
+	return t
+}
+
+type APITokenLogID int64
+
+// APITokenLog is an entry in the request log of an API token.
+type APITokenLog struct {
+	ID         APITokenLogID `db:"api_token_log_id,id" json:"-"`
+	APITokenID APITokenID    `db:"api_token_id" json:"-"`
+	SiteID     SiteID        `db:"site_id" json:"-"`
+	Method     string        `db:"method" json:"method"`
+	Path       string        `db:"path" json:"path"`
+	IP         string        `db:"ip" json:"ip"`
+	CreatedAt  time.Time     `db:"created_at" json:"created_at"`
+}
+
+func (APITokenLog) Table() string { return "api_token_log" }
+
+type APITokenLogs []APITokenLog
+
+// List the most recent requests for this token.
+func (l *APITokenLogs) List(ctx context.Context, id APITokenID, limit int) error {
+	err := zdb.Select(ctx, l, `/* APITokenLogs.List */
+		select * from api_token_log where api_token_id = ? and site_id = ?
+		order by api_token_log_id desc
+		limit ?`,
+		id, MustGetSite(ctx).IDOrParent(), limit)
+	return errors.Wrapf(err, "APITokenLogs.List(%d)", id)
+}
@@ -0,0 +1,70 @@
+package goatcounter_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	. "zgo.at/goatcounter/v2"
+	"zgo.at/goatcounter/v2/gctest"
+	"zgo.at/zstd/ztime"
+)
+
+func TestAPITokenAllowIP(t *testing.T) {
+	tests := []struct {
+		allowed Strings
+		ip      string
+		want    bool
+	}{
+		{nil, "192.0.2.1", true},
+		{Strings{"192.0.2.0/24"}, "192.0.2.42", true},
+		{Strings{"192.0.2.0/24"}, "192.0.2.42:1234", true},
+		{Strings{"192.0.2.0/24"}, "::ffff:192.0.2.42", true},
+		{Strings{"192.0.2.0/24"}, "192.0.3.42", false},
+		{Strings{"192.0.2.1", "2001:db8::/32"}, "2001:db8:1::1", true},
+		{Strings{"192.0.2.1"}, "not an ip", false},
+	}
+
+	for _, tt := range tests {
+		t.Run("", func(t *testing.T) {
+			have := APIToken{AllowedIPs: tt.allowed}.AllowIP(tt.ip)
+			if have != tt.want {
+				t.Errorf("AllowIP(%q) with %v: %t", tt.ip, tt.allowed, have)
+			}
+		})
+	}
+}
+
+func TestAPITokenValidate(t *testing.T) {
+	ctx := gctest.DB(t)
+
+	tests := []struct {
+		tok     APIToken
+		wantErr string
+	}{
+		{APIToken{Name: "x", Permissions: APIPermStats}, ""},
+		{APIToken{Name: "x", Permissions: APIPermStats, AllowedIPs: Strings{"192.0.2"}}, "allowed_ips"},
+		{APIToken{Name: "x", Permissions: APIPermStats, Filter: "/a"}, ""},
+		{APIToken{Name: "x", Permissions: APIPermStats | APIPermExport, Filter: "/a"}, "filter"},
+		{APIToken{Name: "x", Permissions: APIPermStats, ExpiresAt: new(ztime.Now(ctx).Add(time.Hour))}, ""},
+		{APIToken{Name: "x", Permissions: APIPermStats, ExpiresAt: new(ztime.Now(ctx).Add(-time.Hour))}, "expires_at"},
+	}
+
+	for _, tt := range tests {
+		t.Run("", func(t *testing.T) {
+			err := tt.tok.Insert(ctx)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if tt.tok.Expired(ctx) {
+					t.Error("expired")
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("wrong error: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,284 @@
+package goatcounter
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"slices"
+	"time"
+
+	"zgo.at/errors"
+	"zgo.at/json"
+	"zgo.at/zdb"
+	"zgo.at/zstd/ztime"
+)
+
+// Audit log actions.
+const (
+	AuditSettingsUpdate    = "settings.update"
+	AuditSiteCreate        = "site.create"
+	AuditSiteUpdate        = "site.update"
+	AuditSiteDelete        = "site.delete"
+	AuditAccountDelete     = "account.delete"
+	AuditAccountMerge      = "account.merge"
+	AuditUserCreate        = "user.create"
+	AuditUserUpdate        = "user.update"
+	AuditUserDelete        = "user.delete"
+	AuditAPITokenCreate    = "api_token.create"
+	AuditAPITokenUpdate    = "api_token.update"
+	AuditAPITokenRotate    = "api_token.rotate"
+	AuditAPITokenDelete    = "api_token.delete"
+	AuditSSOUpdate         = "sso.update"
+	AuditSSODelete         = "sso.delete"
+	AuditPathsPurge        = "paths.purge"
+	AuditPathsMerge        = "paths.merge"
+	AuditBotRuleCreate     = "bot_rule.create"
+	AuditBotRuleDelete     = "bot_rule.delete"
+	AuditRefspamCreate     = "refspam.create"
+	AuditRefspamDelete     = "refspam.delete"
+	AuditChannelRuleCreate = "channel_rule.create"
+	AuditChannelRuleDelete = "channel_rule.delete"
+	AuditShareLinkCreate   = "share_link.create"
+	AuditShareLinkDelete   = "share_link.delete"
+	AuditAnnotationCreate  = "annotation.create"
+	AuditAnnotationDelete  = "annotation.delete"
+	AuditImport            = "data.import"
+	AuditExport            = "data.export"
+)
+
+// AuditActions is a list of all actions, for filtering.
+var AuditActions = []string{
+	AuditSettingsUpdate, AuditSiteCreate, AuditSiteUpdate, AuditSiteDelete,
+	AuditAccountDelete, AuditAccountMerge, AuditUserCreate, AuditUserUpdate,
+	AuditUserDelete, AuditAPITokenCreate, AuditAPITokenUpdate,
+	AuditAPITokenRotate, AuditAPITokenDelete, AuditSSOUpdate, AuditSSODelete,
+	AuditPathsPurge, AuditPathsMerge, AuditBotRuleCreate, AuditBotRuleDelete,
+	AuditRefspamCreate, AuditRefspamDelete, AuditChannelRuleCreate,
+	AuditChannelRuleDelete, AuditShareLinkCreate, AuditShareLinkDelete,
+	AuditAnnotationCreate, AuditAnnotationDelete, AuditImport, AuditExport,
+}
+
+// AuditActor is who made a change, if it's not the user on the context.
+type AuditActor struct {
+	// Name of the actor; if this is set it's used instead of the current
+	// user, for example "goatcounter db" for CLI commands.
+	Name string
+
+	// IP address the request came from.
+	IP string
+
+	// API token used for the request.
+	APITokenID *APITokenID
+}
+
+// WithAuditActor sets the actor for audit log entries.
+func WithAuditActor(ctx context.Context, a AuditActor) context.Context {
+	return context.WithValue(ctx, keyAuditActor, a)
+}
+
+// GetAuditActor gets the actor set with WithAuditActor().
+func GetAuditActor(ctx context.Context) AuditActor {
+	a, _ := ctx.Value(keyAuditActor).(AuditActor)
+	return a
+}
+
+type AuditEntryID int64
+
+// AuditEntry is an entry in the audit log, recording who changed something.
+//
+// The audit log is append-only: entries are never updated, and are only
+// deleted when the account is permanently deleted.
+type AuditEntry struct {
+	ID           AuditEntryID `db:"audit_log_id,id" json:"id"`
+	SiteID       SiteID       `db:"site_id" json:"-"`              // Account this belongs to.
+	TargetSiteID SiteID       `db:"target_site_id" json:"site_id"` // Site the action was done on.
+
+	UserID     *UserID     `db:"user_id" json:"user_id"`
+	APITokenID *APITokenID `db:"api_token_id" json:"api_token_id"`
+	Actor      string      `db:"actor" json:"actor"` // Email or name, so it's kept when the user is deleted.
+	IP         string      `db:"ip" json:"ip"`
+
+	Action    string    `db:"action" json:"action"`
+	Before    AuditData `db:"before_data" json:"before"`
+	After     AuditData `db:"after_data" json:"after"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+}
+
+func (AuditEntry) Table() string { return "audit_log" }
+
+var _ zdb.Defaulter = &AuditEntry{}
+
+func (a *AuditEntry) Defaults(ctx context.Context) {
+	if a.CreatedAt.IsZero() {
+		a.CreatedAt = ztime.Now(ctx)
+	}
+}
+
+var _ zdb.Validator = &AuditEntry{}
+
+func (a *AuditEntry) Validate(ctx context.Context) error {
+	v := NewValidate(ctx)
+	v.Required("actor", a.Actor)
+	v.Required("action", a.Action)
+	v.Include("action", a.Action, AuditActions)
+	return v.ErrorOrNil()
+}
+
+// Insert a new entry.
+func (a *AuditEntry) Insert(ctx context.Context) error {
+	if a.ID > 0 {
+		return errors.New("ID > 0")
+	}
+	err := zdb.Insert(ctx, a)
+	return errors.Wrap(err, "AuditEntry.Insert")
+}
+
+// Audit records an action on the current site in the audit log.
+//
+// The actor is taken from the AuditActor on the context, or the current user if
+// it has no name. The before and after values are stored as JSON, and can be
+// nil.
+func Audit(ctx context.Context, action string, before, after any) error {
+	var (
+		site  = MustGetSite(ctx)
+		actor = GetAuditActor(ctx)
+		a     = AuditEntry{
+			SiteID:       site.IDOrParent(),
+			TargetSiteID: site.ID,
+			APITokenID:   actor.APITokenID,
+			Actor:        actor.Name,
+			IP:           actor.IP,
+			Action:       action,
+		}
+		err error
+	)
+	if a.Actor == "" {
+		if u := GetUser(ctx); u != nil && u.ID > 0 {
+			a.UserID, a.Actor = &u.ID, u.Email
+		}
+	}
+	a.Before, err = NewAuditData(before)
+	if err != nil {
+		return errors.Wrap(err, "Audit")
+	}
+	a.After, err = NewAuditData(after)
+	if err != nil {
+		return errors.Wrap(err, "Audit")
+	}
+	return a.Insert(ctx)
+}
+
+// AuditData is the state of something before or after a change, as JSON.
+type AuditData []byte
+
+// NewAuditData creates new AuditData from v; it returns nil if v is nil.
+func NewAuditData(v any) (AuditData, error) {
+	if v == nil {
+		return nil, nil
+	}
+	j, err := json.Marshal(v)
+	return AuditData(j), err
+}
+
+// String formats the JSON with indentation.
+func (d AuditData) String() string {
+	if len(d) == 0 {
+		return ""
+	}
+	var b bytes.Buffer
+	if err := json.Indent(&b, d, "", "  "); err != nil {
+		return string(d)
+	}
+	return b.String()
+}
+
+// MarshalJSON implements json.Marshaler.
+func (d AuditData) MarshalJSON() ([]byte, error) {
+	if len(d) == 0 {
+		return []byte("null"), nil
+	}
+	return d, nil
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (d *AuditData) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		*d = nil
+		return nil
+	}
+	*d = slices.Clone(b)
+	return nil
+}
+
+// Value implements the SQL Value function to determine what to store in the DB.
+func (d AuditData) Value() (driver.Value, error) {
+	if len(d) == 0 {
+		return nil, nil
+	}
+	return []byte(d), nil
+}
+
+// Scan converts the data returned from the DB into the struct.
+func (d *AuditData) Scan(v any) error {
+	switch vv := v.(type) {
+	case nil:
+		*d = nil
+	case []byte:
+		*d = slices.Clone(vv)
+	case string:
+		*d = AuditData(vv)
+	default:
+		return errors.Errorf("AuditData.Scan: unsupported type: %T", v)
+	}
+	return nil
+}
+
+// AuditFilter filters the audit log.
+type AuditFilter struct {
+	Action string
+	UserID UserID
+	SiteID SiteID    // Site the action was done on.
+	Start  time.Time // Inclusive.
+	End    time.Time // Exclusive.
+
+	// Only get entries with an ID lower than this, for pagination.
+	Before AuditEntryID
+}
+
+type AuditEntries []AuditEntry
+
+// List audit log entries for the current account, newest first.
+//
+// This returns limit entries at the most; the returned bool indicates if there
+// are more.
+func (a *AuditEntries) List(ctx context.Context, f AuditFilter, limit int) (bool, error) {
+	err := zdb.Select(ctx, a, `/* AuditEntries.List */
+		select * from audit_log where site_id = :site
+			{{:action and action = :action}}
+			{{:user and user_id = :user}}
+			{{:target and target_site_id = :target}}
+			{{:start and created_at >= :start}}
+			{{:end and created_at < :end}}
+			{{:before and audit_log_id < :before}}
+		order by audit_log_id desc
+		limit :limit`,
+		map[string]any{
+			"site":   MustGetSite(ctx).IDOrParent(),
+			"action": f.Action,
+			"user":   f.UserID,
+			"target": f.SiteID,
+			"start":  f.Start,
+			"end":    f.End,
+			"before": f.Before,
+			"limit":  limit + 1,
+		})
+	if err != nil {
+		return false, errors.Wrap(err, "AuditEntries.List")
+	}
+
+	more := len(*a) > limit
+	if more {
+		*a = (*a)[:limit]
+	}
+	return more, nil
+}
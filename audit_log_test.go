@@ -0,0 +1,83 @@
+package goatcounter_test
+
+import (
+	"testing"
+
+	"zgo.at/goatcounter/v2"
+	"zgo.at/goatcounter/v2/gctest"
+	"zgo.at/zstd/ztest"
+)
+
+func TestAudit(t *testing.T) {
+	ctx := gctest.DB(t)
+
+	err := goatcounter.Audit(ctx, goatcounter.AuditSiteUpdate,
+		map[string]any{"link_domain": ""}, map[string]any{"link_domain": "example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = goatcounter.Audit(goatcounter.WithAuditActor(ctx, goatcounter.AuditActor{Name: "goatcounter db"}),
+		goatcounter.AuditUserDelete, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = goatcounter.Audit(ctx, "nope", nil, nil)
+	if !ztest.ErrorContains(err, "action") {
+		t.Fatalf("wrong error: %v", err)
+	}
+
+	list := func(t *testing.T, f goatcounter.AuditFilter, limit int) (goatcounter.AuditEntries, bool) {
+		t.Helper()
+		var a goatcounter.AuditEntries
+		more, err := a.List(ctx, f, limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return a, more
+	}
+
+	t.Run("all", func(t *testing.T) {
+		a, more := list(t, goatcounter.AuditFilter{}, 10)
+		if len(a) != 2 || more {
+			t.Fatalf("len=%d; more=%t", len(a), more)
+		}
+		if a[0].Action != goatcounter.AuditUserDelete || a[0].Actor != "goatcounter db" || a[0].UserID != nil {
+			t.Errorf("wrong first entry: %#v", a[0])
+		}
+		if a[1].Actor != "test@gctest.localhost" || a[1].UserID == nil {
+			t.Errorf("wrong second entry: %#v", a[1])
+		}
+		if have, want := string(a[1].After), `{"link_domain":"example.com"}`; have != want {
+			t.Errorf("\nhave: %s\nwant: %s", have, want)
+		}
+		if a[0].Before != nil {
+			t.Errorf("before not nil: %s", a[0].Before)
+		}
+	})
+
+	t.Run("filter", func(t *testing.T) {
+		a, _ := list(t, goatcounter.AuditFilter{Action: goatcounter.AuditSiteUpdate}, 10)
+		if len(a) != 1 || a[0].Action != goatcounter.AuditSiteUpdate {
+			t.Errorf("action: %#v", a)
+		}
+		a, _ = list(t, goatcounter.AuditFilter{UserID: goatcounter.MustGetUser(ctx).ID}, 10)
+		if len(a) != 1 || a[0].Action != goatcounter.AuditSiteUpdate {
+			t.Errorf("user: %#v", a)
+		}
+		a, _ = list(t, goatcounter.AuditFilter{SiteID: 42}, 10)
+		if len(a) != 0 {
+			t.Errorf("site: %#v", a)
+		}
+	})
+
+	t.Run("paginate", func(t *testing.T) {
+		a, more := list(t, goatcounter.AuditFilter{}, 1)
+		if len(a) != 1 || !more {
+			t.Fatalf("len=%d; more=%t", len(a), more)
+		}
+		a, more = list(t, goatcounter.AuditFilter{Before: a[0].ID}, 1)
+		if len(a) != 1 || more || a[0].Action != goatcounter.AuditSiteUpdate {
+			t.Fatalf("len=%d; more=%t; %#v", len(a), more, a)
+		}
+	})
+}
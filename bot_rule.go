@@ -0,0 +1,346 @@
+package goatcounter
+
+import (
+	"context"
+	"net/netip"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"zgo.at/errors"
+	"zgo.at/zdb"
+	"zgo.at/zstd/zbool"
+	"zgo.at/zstd/ztime"
+)
+
+// BotCustom is set as Hit.Bot for pageviews matched by one of the site's
+// BotRules.
+//
+// isbot uses values <150 for backend detection and 150-199 for the JS
+// detection, so this doesn't conflict with either.
+const BotCustom = 200
+
+// Kinds of bot rules.
+const (
+	BotRuleUA   = "ua"   // Regular expression matched against the User-Agent.
+	BotRuleIP   = "ip"   // IP address or CIDR range.
+	BotRuleRate = "rate" // Maximum number of pageviews per session per minute.
+	BotRuleASN  = "asn"  // AS number of the network; needs an ASN database.
+)
+
+type BotRuleID int32
+
+// BotRule is a site-specific rule to mark pageviews as a bot, in addition to
+// what isbot already detects.
+type BotRule struct {
+	ID        BotRuleID `db:"bot_rule_id,id" json:"id"`
+	SiteID    SiteID    `db:"site_id" json:"site_id"`
+	Kind      string    `db:"kind" json:"kind"`
+	Value     string    `db:"value" json:"value"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+
+	re     *regexp.Regexp
+	prefix netip.Prefix
+	rate   int
+	asn    ASN
+}
+
+func (BotRule) Table() string { return "bot_rules" }
+
+var _ zdb.Defaulter = &BotRule{}
+
+func (r *BotRule) Defaults(ctx context.Context) {
+	if r.SiteID == 0 {
+		r.SiteID = MustGetSite(ctx).ID
+	}
+	r.Value = strings.TrimSpace(r.Value)
+	if r.CreatedAt.IsZero() {
+		r.CreatedAt = ztime.Now(ctx)
+	}
+}
+
+var _ zdb.Validator = &BotRule{}
+
+func (r *BotRule) Validate(ctx context.Context) error {
+	v := NewValidate(ctx)
+	v.Required("site_id", r.SiteID)
+	v.Required("value", r.Value)
+	v.Include("kind", r.Kind, []string{BotRuleUA, BotRuleIP, BotRuleRate, BotRuleASN})
+	v.Len("value", r.Value, 0, 512)
+	if r.Value != "" {
+		if err := r.compile(); err != nil {
+			v.Append("value", err.Error())
+		}
+	}
+	return v.ErrorOrNil()
+}
+
+// compile the value so it can be used by Match.
+func (r *BotRule) compile() error {
+	switch r.Kind {
+	case BotRuleUA:
+		re, err := regexp.Compile(r.Value)
+		if err != nil {
+			return err
+		}
+		r.re = re
+	case BotRuleIP:
+		p, err := parsePrefix(r.Value)
+		if err != nil {
+			return err
+		}
+		r.prefix = p
+	case BotRuleRate:
+		n, err := strconv.Atoi(r.Value)
+		if err != nil {
+			return err
+		}
+		if n < 1 {
+			return errors.New("must be 1 or more")
+		}
+		r.rate = n
+	case BotRuleASN:
+		n, err := ParseASN(r.Value)
+		if err != nil {
+			return err
+		}
+		r.asn = n
+	}
+	return nil
+}
+
+// parsePrefix parses an IP address or CIDR range; a single address is returned
+// as a prefix that contains just that address.
+func parsePrefix(v string) (netip.Prefix, error) {
+	if !strings.ContainsRune(v, '/') {
+		a, err := netip.ParseAddr(v)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return netip.PrefixFrom(a, a.BitLen()), nil
+	}
+	p, err := netip.ParsePrefix(v)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return p.Masked(), nil
+}
+
+// Match reports if the User-Agent, IP address, or AS number matches this rule.
+//
+// This always returns false for BotRuleRate rules, as that depends on the
+// memstore.
+func (r BotRule) Match(ua, ip string, asn ASN) bool {
+	switch r.Kind {
+	case BotRuleUA:
+		return r.re != nil && ua != "" && r.re.MatchString(ua)
+	case BotRuleIP:
+		if !r.prefix.IsValid() || ip == "" {
+			return false
+		}
+		a, err := netip.ParseAddr(ip)
+		return err == nil && r.prefix.Contains(a.Unmap())
+	case BotRuleASN:
+		return r.asn > 0 && asn == r.asn
+	}
+	return false
+}
+
+// Insert a new rule.
+func (r *BotRule) Insert(ctx context.Context) error {
+	err := zdb.Insert(ctx, r)
+	if err != nil {
+		return errors.Wrap(err, "BotRule.Insert")
+	}
+	cacheBotRules(ctx).Delete(r.SiteID)
+	return nil
+}
+
+// Delete this rule.
+func (r *BotRule) Delete(ctx context.Context) error {
+	err := zdb.Exec(ctx, `delete from bot_rules where bot_rule_id=$1 and site_id=$2`,
+		r.ID, MustGetSite(ctx).ID)
+	if err != nil {
+		return errors.Wrapf(err, "BotRule.Delete(%d)", r.ID)
+	}
+	cacheBotRules(ctx).Delete(MustGetSite(ctx).ID)
+	return nil
+}
+
+type BotRules []BotRule
+
+// List all rules for the current site.
+func (r *BotRules) List(ctx context.Context) error {
+	err := zdb.Select(ctx, r, `select * from bot_rules where site_id=$1 order by created_at`,
+		MustGetSite(ctx).ID)
+	return errors.Wrap(err, "BotRules.List")
+}
+
+// ForSite gets all the rules for the site, ready to be used with Match().
+//
+// This is cached, as it's called for every pageview.
+func (r *BotRules) ForSite(ctx context.Context, siteID SiteID) error {
+	if rr, ok := cacheBotRules(ctx).Get(siteID); ok {
+		*r = rr
+		return nil
+	}
+
+	err := zdb.Select(ctx, r, `select * from bot_rules where site_id=$1`, siteID)
+	if err != nil {
+		return errors.Wrap(err, "BotRules.ForSite")
+	}
+	for i := range *r {
+		// Rules are validated on insert, so this should never fail; just skip
+		// them if it does as they'll never match.
+		_ = (*r)[i].compile()
+	}
+	cacheBotRules(ctx).Set(siteID, *r)
+	return nil
+}
+
+// Match reports if any of the User-Agent, IP, or ASN rules match.
+func (r BotRules) Match(ua, ip string, asn ASN) bool {
+	for _, rr := range r {
+		if rr.Match(ua, ip, asn) {
+			return true
+		}
+	}
+	return false
+}
+
+// HasASN reports if there are any ASN rules, in which case the AS number needs
+// to be looked up for Match() even if it's not collected.
+func (r BotRules) HasASN() bool {
+	for _, rr := range r {
+		if rr.Kind == BotRuleASN {
+			return true
+		}
+	}
+	return false
+}
+
+// Rate gets the lowest rate limit, or 0 if there are no rate rules.
+func (r BotRules) Rate() int {
+	var rate int
+	for _, rr := range r {
+		if rr.Kind == BotRuleRate && rr.rate > 0 && (rate == 0 || rr.rate < rate) {
+			rate = rr.rate
+		}
+	}
+	return rate
+}
+
+// BotStat is an aggregate of recent bot traffic.
+type BotStat struct {
+	Value    string    `db:"value" json:"value"`
+	Count    int       `db:"count" json:"count"`
+	LastSeen time.Time `db:"last_seen" json:"last_seen"`
+}
+
+type BotStats []BotStat
+
+// ListByUA lists the most common User-Agent headers in the bots table.
+func (b *BotStats) ListByUA(ctx context.Context, limit int) error {
+	err := zdb.Select(ctx, b, `/* BotStats.ListByUA */
+		select user_agent as value, count(*) as count, max(created_at) as last_seen
+		from bots
+		where site_id = :site
+		group by user_agent
+		order by count desc, value
+		limit :limit`,
+		map[string]any{"site": MustGetSite(ctx).ID, "limit": limit})
+	return errors.Wrap(err, "BotStats.ListByUA")
+}
+
+// ListByIP lists the most common IP ranges in the bots table.
+func (b *BotStats) ListByIP(ctx context.Context, limit int) error {
+	err := zdb.Select(ctx, b, `/* BotStats.ListByIP */
+		select ip_range as value, count(*) as count, max(created_at) as last_seen
+		from bots
+		where site_id = :site and ip_range != ''
+		group by ip_range
+		order by count desc, value
+		limit :limit`,
+		map[string]any{"site": MustGetSite(ctx).ID, "limit": limit})
+	return errors.Wrap(err, "BotStats.ListByIP")
+}
+
+// RecoverBots adds all pageviews in the bots table for the given User-Agent
+// (kind is BotRuleUA) or IP range (kind is BotRuleIP) back as regular
+// pageviews, and removes them from the bots table.
+//
+// They're not stored in the database until the next memstore persist.
+func RecoverBots(ctx context.Context, kind, value string) (int, error) {
+	var col string
+	switch kind {
+	case BotRuleUA:
+		col = "user_agent"
+	case BotRuleIP:
+		col = "ip_range"
+	default:
+		return 0, errors.Errorf("RecoverBots: invalid kind: %q", kind)
+	}
+
+	var (
+		siteID = MustGetSite(ctx).ID
+		rows   []struct {
+			Path      string     `db:"path"`
+			UserAgent string     `db:"user_agent"`
+			IPRange   string     `db:"ip_range"`
+			Ref       string     `db:"ref"`
+			Location  string     `db:"location"`
+			Event     zbool.Bool `db:"event"`
+			CreatedAt time.Time  `db:"created_at"`
+		}
+	)
+	err := zdb.TX(ctx, func(ctx context.Context) error {
+		err := zdb.Select(ctx, &rows, `/* RecoverBots */
+			select path, user_agent, ip_range, ref, location, event, created_at
+			from bots
+			where site_id = $1 and `+col+` = $2`, siteID, value)
+		if err != nil {
+			return err
+		}
+		return zdb.Exec(ctx, `delete from bots where site_id = $1 and `+col+` = $2`, siteID, value)
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "RecoverBots")
+	}
+
+	hits := make([]Hit, 0, len(rows))
+	for _, r := range rows {
+		hits = append(hits, Hit{
+			Site:            siteID,
+			Path:            r.Path,
+			Ref:             r.Ref,
+			Event:           r.Event,
+			Location:        r.Location,
+			UserAgentHeader: r.UserAgent,
+			UserSessionID:   r.UserAgent + r.IPRange,
+			CreatedAt:       r.CreatedAt,
+			noBotRules:      true,
+		})
+	}
+	Memstore.Append(hits...)
+	return len(hits), nil
+}
+
+// ipRange gets the network for the IP address; this is a /24 for IPv4 and a
+// /48 for IPv6, which is the smallest that's usually routed on the internet.
+func ipRange(ip string) string {
+	a, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ""
+	}
+	a = a.Unmap()
+	bits := 48
+	if a.Is4() {
+		bits = 24
+	}
+	p, err := a.Prefix(bits)
+	if err != nil {
+		return ""
+	}
+	return p.String()
+}
@@ -0,0 +1,127 @@
+package goatcounter_test
+
+import (
+	"testing"
+
+	. "zgo.at/goatcounter/v2"
+	"zgo.at/goatcounter/v2/gctest"
+	"zgo.at/zdb"
+	"zgo.at/zstd/ztime"
+)
+
+func TestBotRuleMatch(t *testing.T) {
+	ctx := gctest.DB(t)
+
+	tests := []struct {
+		kind, value string
+		ua, ip      string
+		asn         ASN
+		want        bool
+	}{
+		{BotRuleUA, `(?i)crawler`, "Mozilla/5.0 SomeCrawler/1.0", "", 0, true},
+		{BotRuleUA, `(?i)crawler`, "Mozilla/5.0 Firefox/140.0", "", 0, false},
+		{BotRuleUA, `(?i)crawler`, "", "192.0.2.1", 0, false},
+		{BotRuleIP, `192.0.2.0/24`, "", "192.0.2.42", 0, true},
+		{BotRuleIP, `192.0.2.0/24`, "", "::ffff:192.0.2.42", 0, true},
+		{BotRuleIP, `192.0.2.0/24`, "", "192.0.3.42", 0, false},
+		{BotRuleIP, `192.0.2.1`, "", "192.0.2.1", 0, true},
+		{BotRuleIP, `192.0.2.1`, "", "192.0.2.2", 0, false},
+		{BotRuleIP, `2001:db8::/32`, "", "2001:db8:1::1", 0, true},
+		{BotRuleIP, `2001:db8::/32`, "", "not an ip", 0, false},
+		{BotRuleRate, `5`, "Mozilla/5.0", "192.0.2.1", 0, false},
+		{BotRuleASN, `AS64496`, "", "192.0.2.1", 64496, true},
+		{BotRuleASN, `64496`, "", "192.0.2.1", 64496, true},
+		{BotRuleASN, `64496`, "", "192.0.2.1", 64497, false},
+		{BotRuleASN, `64496`, "", "192.0.2.1", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.kind+" "+tt.value, func(t *testing.T) {
+			r := BotRule{Kind: tt.kind, Value: tt.value}
+			r.Defaults(ctx)
+			err := r.Validate(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			have := r.Match(tt.ua, tt.ip, tt.asn)
+			if have != tt.want {
+				t.Errorf("\nhave: %t\nwant: %t", have, tt.want)
+			}
+		})
+	}
+
+	for _, v := range [][]string{{BotRuleUA, "(x"}, {BotRuleIP, "192.0.2.0/99"}, {BotRuleRate, "0"}, {BotRuleASN, "ASx"}, {"x", "y"}} {
+		r := BotRule{Kind: v[0], Value: v[1]}
+		r.Defaults(ctx)
+		if err := r.Validate(ctx); err == nil {
+			t.Errorf("no error for %q", v)
+		}
+	}
+}
+
+func TestBotRules(t *testing.T) {
+	ctx := gctest.DB(t)
+	site := MustGetSite(ctx)
+
+	for _, r := range []BotRule{
+		{Kind: BotRuleUA, Value: `(?i)crawler`},
+		{Kind: BotRuleIP, Value: `192.0.2.0/24`},
+		{Kind: BotRuleRate, Value: `3`},
+	} {
+		err := r.Insert(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	hit := func(ua, ip string) Hit {
+		return Hit{Site: site.ID, Path: "/test", UserAgentHeader: ua, RemoteAddr: ip, CreatedAt: ztime.Now(ctx)}
+	}
+	Memstore.Append(
+		hit("Mozilla/5.0 SomeCrawler/1.0", "198.51.100.1"),
+		hit("Mozilla/5.0 Firefox/140.0", "192.0.2.42"))
+	for range 5 {
+		Memstore.Append(hit("Mozilla/5.0 Firefox/140.0", "198.51.100.2"))
+	}
+	_, err := Memstore.Persist(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count := func(tbl string) int {
+		t.Helper()
+		var n int
+		err := zdb.Get(ctx, &n, `select count(*) from `+tbl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	if h, b := count("hits"), count("bots"); h != 3 || b != 4 {
+		t.Errorf("hits: %d; bots: %d", h, b)
+	}
+
+	var byIP BotStats
+	err = byIP.ListByIP(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(byIP) != 2 || byIP[0].Value != "198.51.100.0/24" || byIP[0].Count != 3 || byIP[1].Value != "192.0.2.0/24" {
+		t.Errorf("%#v", byIP)
+	}
+
+	n, err := RecoverBots(ctx, BotRuleUA, "Mozilla/5.0 SomeCrawler/1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("recovered %d", n)
+	}
+	_, err = Memstore.Persist(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h, b := count("hits"), count("bots"); h != 4 || b != 3 {
+		t.Errorf("hits: %d; bots: %d", h, b)
+	}
+}
@@ -0,0 +1,403 @@
+package goatcounter
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"strings"
+	"time"
+
+	"zgo.at/errors"
+	"zgo.at/guru"
+	"zgo.at/z18n"
+	"zgo.at/zdb"
+	"zgo.at/zstd/ztime"
+)
+
+// Channels referrals are grouped in.
+const (
+	ChannelSearch   = "search"
+	ChannelSocial   = "social"
+	ChannelEmail    = "email"
+	ChannelPaid     = "paid"
+	ChannelReferral = "referral"
+	ChannelDirect   = "direct"
+	ChannelCampaign = "campaign"
+)
+
+// Channels is a list of all channels, in the order they should be displayed.
+var Channels = []string{ChannelSearch, ChannelSocial, ChannelEmail, ChannelPaid,
+	ChannelReferral, ChannelDirect, ChannelCampaign}
+
+// ChannelName gets the human-readable name for a channel.
+func ChannelName(ctx context.Context, ch string) string {
+	switch ch {
+	case ChannelSearch:
+		return z18n.T(ctx, "channel/search|Search")
+	case ChannelSocial:
+		return z18n.T(ctx, "channel/social|Social")
+	case ChannelEmail:
+		return z18n.T(ctx, "channel/email|Email")
+	case ChannelPaid:
+		return z18n.T(ctx, "channel/paid|Paid")
+	case ChannelReferral:
+		return z18n.T(ctx, "channel/referral|Referral")
+	case ChannelDirect:
+		return z18n.T(ctx, "channel/direct|Direct")
+	case ChannelCampaign:
+		return z18n.T(ctx, "channel/campaign|Campaign")
+	}
+	return ch
+}
+
+// Default channels for hostnames; subdomains are also matched.
+var channelHosts = map[string]string{
+	"bing.com":         ChannelSearch,
+	"duckduckgo.com":   ChannelSearch,
+	"ecosia.org":       ChannelSearch,
+	"search.brave.com": ChannelSearch,
+	"startpage.com":    ChannelSearch,
+	"qwant.com":        ChannelSearch,
+	"kagi.com":         ChannelSearch,
+	"search.yahoo.com": ChannelSearch,
+	"naver.com":        ChannelSearch,
+	"seznam.cz":        ChannelSearch,
+	"ask.com":          ChannelSearch,
+	"perplexity.ai":    ChannelSearch,
+
+	"facebook.com":         ChannelSocial,
+	"twitter.com":          ChannelSocial,
+	"t.co":                 ChannelSocial,
+	"x.com":                ChannelSocial,
+	"reddit.com":           ChannelSocial,
+	"linkedin.com":         ChannelSocial,
+	"lnkd.in":              ChannelSocial,
+	"instagram.com":        ChannelSocial,
+	"pinterest.com":        ChannelSocial,
+	"youtube.com":          ChannelSocial,
+	"tiktok.com":           ChannelSocial,
+	"tumblr.com":           ChannelSocial,
+	"vk.com":               ChannelSocial,
+	"quora.com":            ChannelSocial,
+	"weibo.com":            ChannelSocial,
+	"threads.net":          ChannelSocial,
+	"bsky.app":             ChannelSocial,
+	"mastodon.social":      ChannelSocial,
+	"discord.com":          ChannelSocial,
+	"t.me":                 ChannelSocial,
+	"news.ycombinator.com": ChannelSocial,
+	"lobste.rs":            ChannelSocial,
+
+	"mail.google.com":       ChannelEmail,
+	"mail.yahoo.com":        ChannelEmail,
+	"mail.proton.me":        ChannelEmail,
+	"mail.aol.com":          ChannelEmail,
+	"outlook.live.com":      ChannelEmail,
+	"outlook.office.com":    ChannelEmail,
+	"outlook.office365.com": ChannelEmail,
+	"app.fastmail.com":      ChannelEmail,
+
+	"googleadservices.com":  ChannelPaid,
+	"doubleclick.net":       ChannelPaid,
+	"googlesyndication.com": ChannelPaid,
+}
+
+// Default channels for names generated by cleanRefURL (RefSchemeGenerated).
+var channelGenerated = map[string]string{
+	"google":             ChannelSearch,
+	"yandex":             ChannelSearch,
+	"yahoo":              ChannelSearch,
+	"baidu":              ChannelSearch,
+	"email":              ChannelEmail,
+	"hacker news":        ChannelSocial,
+	"telegram messenger": ChannelSocial,
+	"slack chat":         ChannelSocial,
+	"www.reddit.com":     ChannelSocial,
+	"www.facebook.com":   ChannelSocial,
+	"lobste.rs":          ChannelSocial,
+}
+
+// Default channels for the source of campaigns (utm_source and the like);
+// anything else is ChannelCampaign.
+var channelCampaign = map[string]string{
+	"cpc":          ChannelPaid,
+	"ppc":          ChannelPaid,
+	"paid":         ChannelPaid,
+	"ads":          ChannelPaid,
+	"adwords":      ChannelPaid,
+	"googleads":    ChannelPaid,
+	"google_ads":   ChannelPaid,
+	"facebook_ads": ChannelPaid,
+	"email":        ChannelEmail,
+	"e-mail":       ChannelEmail,
+	"mail":         ChannelEmail,
+	"newsletter":   ChannelEmail,
+	"mailchimp":    ChannelEmail,
+}
+
+type ChannelRuleID int32
+
+// ChannelRule assigns a referrer to a channel, overriding the default
+// classification.
+//
+// The Value is matched against the referrer name (e.g. "Google" or a campaign
+// source), or against the hostname for HTTP referrers, in which case
+// subdomains are also matched.
+type ChannelRule struct {
+	ID        ChannelRuleID `db:"channel_rule_id,id" json:"id"`
+	SiteID    SiteID        `db:"site_id" json:"site_id"`
+	Channel   string        `db:"channel" json:"channel"`
+	Value     string        `db:"value" json:"value"`
+	CreatedAt time.Time     `db:"created_at" json:"created_at"`
+}
+
+func (ChannelRule) Table() string { return "channel_rules" }
+
+var _ zdb.Defaulter = &ChannelRule{}
+
+func (r *ChannelRule) Defaults(ctx context.Context) {
+	if r.SiteID == 0 {
+		r.SiteID = MustGetSite(ctx).ID
+	}
+	r.Value = strings.ToLower(strings.TrimSpace(r.Value))
+	if r.CreatedAt.IsZero() {
+		r.CreatedAt = ztime.Now(ctx)
+	}
+}
+
+var _ zdb.Validator = &ChannelRule{}
+
+func (r *ChannelRule) Validate(ctx context.Context) error {
+	v := NewValidate(ctx)
+	v.Required("site_id", r.SiteID)
+	v.Required("value", r.Value)
+	v.Include("channel", r.Channel, Channels)
+	v.Len("value", r.Value, 0, 512)
+	return v.ErrorOrNil()
+}
+
+// Match reports if this rule matches the referrer.
+func (r ChannelRule) Match(ref, scheme string) bool {
+	ref = strings.ToLower(ref)
+	if ref == r.Value {
+		return true
+	}
+	if scheme == RefSchemeHTTP {
+		host, _, _ := strings.Cut(ref, "/")
+		return host == r.Value || strings.HasSuffix(host, "."+r.Value)
+	}
+	return false
+}
+
+// Insert a new rule.
+func (r *ChannelRule) Insert(ctx context.Context) error {
+	err := zdb.Insert(ctx, r)
+	return errors.Wrap(err, "ChannelRule.Insert")
+}
+
+// Delete this rule.
+func (r *ChannelRule) Delete(ctx context.Context) error {
+	err := zdb.Exec(ctx, `delete from channel_rules where channel_rule_id=$1 and site_id=$2`,
+		r.ID, MustGetSite(ctx).ID)
+	return errors.Wrapf(err, "ChannelRule.Delete(%d)", r.ID)
+}
+
+type ChannelRules []ChannelRule
+
+// List all rules for the current site.
+func (r *ChannelRules) List(ctx context.Context) error {
+	err := zdb.Select(ctx, r, `select * from channel_rules where site_id=$1 order by created_at`,
+		MustGetSite(ctx).ID)
+	return errors.Wrap(err, "ChannelRules.List")
+}
+
+// Classify a referrer in to a channel.
+//
+// The site's rules are checked first, in the order they were added, and the
+// default classification is used if none match.
+func (r ChannelRules) Classify(ref, scheme string) string {
+	for _, rule := range r {
+		if rule.Match(ref, scheme) {
+			return rule.Channel
+		}
+	}
+
+	ref = strings.ToLower(ref)
+	if scheme == RefSchemeCampaign {
+		if ch, ok := channelCampaign[ref]; ok {
+			return ch
+		}
+		return ChannelCampaign
+	}
+	if ref == "" {
+		return ChannelDirect
+	}
+	switch scheme {
+	case RefSchemeGenerated:
+		if ch, ok := channelGenerated[ref]; ok {
+			return ch
+		}
+	case RefSchemeHTTP:
+		host, _, _ := strings.Cut(ref, "/")
+		for host != "" {
+			if ch, ok := channelHosts[host]; ok {
+				return ch
+			}
+			i := strings.IndexByte(host, '.')
+			if i == -1 {
+				break
+			}
+			host = host[i+1:]
+		}
+	}
+	return ChannelReferral
+}
+
+// channelRefIDs gets the IDs of all referrers for this site that are in the
+// channel.
+func channelRefIDs(ctx context.Context, channel string) ([]RefID, error) {
+	if !slices.Contains(Channels, channel) {
+		return nil, guru.New(400, z18n.T(ctx, "error/unknown-channel|unknown channel: %(channel)", channel))
+	}
+
+	var refs []struct {
+		ID        RefID  `db:"ref_id"`
+		Ref       string `db:"ref"`
+		RefScheme string `db:"ref_scheme"`
+	}
+	err := zdb.Select(ctx, &refs, `/* channelRefIDs */
+		with x as (
+			select distinct coalesce(ref_id, 1) as ref_id from ref_counts where site_id = $1
+		)
+		select
+			x.ref_id,
+			coalesce(refs.ref, '')         as ref,
+			coalesce(refs.ref_scheme, 'o') as ref_scheme
+		from x
+		left join refs using (ref_id)`,
+		MustGetSite(ctx).ID)
+	if err != nil {
+		return nil, errors.Wrap(err, "channelRefIDs")
+	}
+
+	var rules ChannelRules
+	err = rules.List(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "channelRefIDs")
+	}
+
+	linkDomain := MustGetSite(ctx).LinkDomainURL(false)
+	ids := make([]RefID, 0, 16)
+	for _, r := range refs {
+		if linkDomain != "" && strings.HasPrefix(r.Ref, linkDomain) {
+			continue
+		}
+		if rules.Classify(r.Ref, r.RefScheme) == channel {
+			ids = append(ids, r.ID)
+		}
+	}
+	return ids, nil
+}
+
+type channelRef struct {
+	Ref       string `db:"ref"`
+	RefScheme string `db:"ref_scheme"`
+	Count     int    `db:"count"`
+	channel   string
+}
+
+// listChannelRefs gets the counts for all referrers in the time period, with
+// their channel set.
+//
+// Channels are assigned when retrieving the data rather than when storing the
+// pageview, so that changes to the rules also apply to existing data.
+func listChannelRefs(ctx context.Context, rng ztime.Range, pathFilter PathFilter) ([]channelRef, error) {
+	var (
+		site                    = MustGetSite(ctx)
+		filterSQL, filterParams = pathFilter.SQL(ctx)
+		refs                    []channelRef
+	)
+	err := zdb.Select(ctx, &refs, "load:hit_stats.ListChannels", filterParams, map[string]any{
+		"site":   site.ID,
+		"start":  rng.Start,
+		"end":    rng.End,
+		"filter": filterSQL,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var rules ChannelRules
+	err = rules.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	linkDomain := site.LinkDomainURL(false)
+	filtered := refs[:0]
+	for _, r := range refs {
+		// Same as ListTopRefs: don't include internal links.
+		if linkDomain != "" && strings.HasPrefix(r.Ref, linkDomain) {
+			continue
+		}
+		r.channel = rules.Classify(r.Ref, r.RefScheme)
+		filtered = append(filtered, r)
+	}
+	return filtered, nil
+}
+
+// ListChannels lists the number of visitors per channel for the given time
+// period.
+func (h *HitStats) ListChannels(ctx context.Context, rng ztime.Range, pathFilter PathFilter, limit, offset int) error {
+	refs, err := listChannelRefs(ctx, rng, pathFilter)
+	if err != nil {
+		return errors.Wrap(err, "HitStats.ListChannels")
+	}
+
+	counts := make(map[string]int)
+	for _, r := range refs {
+		counts[r.channel] += r.Count
+	}
+	stats := make([]HitStat, 0, len(counts))
+	for _, ch := range Channels {
+		if counts[ch] > 0 {
+			stats = append(stats, HitStat{ID: ch, Name: ChannelName(ctx, ch), Count: counts[ch]})
+		}
+	}
+	slices.SortStableFunc(stats, func(a, b HitStat) int { return cmp.Compare(b.Count, a.Count) })
+
+	h.Stats, h.More = paginateStats(stats, limit, offset)
+	return nil
+}
+
+// ListChannel lists all referrers in a channel.
+func (h *HitStats) ListChannel(ctx context.Context, channel string, rng ztime.Range, pathFilter PathFilter, limit, offset int) error {
+	refs, err := listChannelRefs(ctx, rng, pathFilter)
+	if err != nil {
+		return errors.Wrap(err, "HitStats.ListChannel")
+	}
+
+	stats := make([]HitStat, 0, 16)
+	for _, r := range refs {
+		if r.channel == channel {
+			stats = append(stats, HitStat{Name: r.Ref, Count: r.Count, RefScheme: new(r.RefScheme)})
+		}
+	}
+	slices.SortFunc(stats, func(a, b HitStat) int {
+		return cmp.Or(cmp.Compare(b.Count, a.Count), strings.Compare(a.Name, b.Name))
+	})
+
+	h.Stats, h.More = paginateStats(stats, limit, offset)
+	return nil
+}
+
+func paginateStats(stats []HitStat, limit, offset int) ([]HitStat, bool) {
+	if offset >= len(stats) {
+		return []HitStat{}, false
+	}
+	stats = stats[offset:]
+	if len(stats) > limit {
+		return stats[:limit], true
+	}
+	return stats, false
+}
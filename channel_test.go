@@ -0,0 +1,146 @@
+package goatcounter_test
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	. "zgo.at/goatcounter/v2"
+	"zgo.at/goatcounter/v2/gctest"
+	"zgo.at/zstd/ztime"
+)
+
+func TestChannelRulesClassify(t *testing.T) {
+	rules := ChannelRules{
+		{Channel: ChannelPaid, Value: "example.net"},
+		{Channel: ChannelSocial, Value: "rss"},
+	}
+
+	tests := []struct {
+		ref, scheme, want string
+	}{
+		{"", RefSchemeOther, ChannelDirect},
+		{"Google", RefSchemeGenerated, ChannelSearch},
+		{"Hacker News", RefSchemeGenerated, ChannelSocial},
+		{"Email", RefSchemeGenerated, ChannelEmail},
+		{"RSS", RefSchemeGenerated, ChannelSocial},
+		{"duckduckgo.com", RefSchemeHTTP, ChannelSearch},
+		{"old.example.com/blog/post", RefSchemeHTTP, ChannelReferral},
+		{"t.co/abc", RefSchemeHTTP, ChannelSocial},
+		{"www.linkedin.com/feed", RefSchemeHTTP, ChannelSocial},
+		{"outlook.live.com/mail", RefSchemeHTTP, ChannelEmail},
+		{"www.example.net/x", RefSchemeHTTP, ChannelPaid},
+		{"notexample.net/x", RefSchemeHTTP, ChannelReferral},
+		{"cpc", RefSchemeCampaign, ChannelPaid},
+		{"Newsletter", RefSchemeCampaign, ChannelEmail},
+		{"spring-sale", RefSchemeCampaign, ChannelCampaign},
+		{"", RefSchemeCampaign, ChannelCampaign},
+		{"com.example.app", RefSchemeOther, ChannelReferral},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			have := rules.Classify(tt.ref, tt.scheme)
+			if have != tt.want {
+				t.Errorf("\nhave: %q\nwant: %q", have, tt.want)
+			}
+		})
+	}
+}
+
+func TestHitStatsListChannels(t *testing.T) {
+	ctx := gctest.DB(t)
+
+	r := ChannelRule{Channel: ChannelEmail, Value: "example.org"}
+	err := r.Insert(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gctest.StoreHits(ctx, t, false,
+		Hit{FirstVisit: true, Ref: "https://www.google.com/search"},
+		Hit{FirstVisit: true, Ref: "https://t.co/abc"},
+		Hit{FirstVisit: true, Ref: "https://example.com/x"},
+		Hit{FirstVisit: true, Ref: "https://example.org/"},
+		Hit{FirstVisit: true, Query: "?utm_source=newsletter"},
+		Hit{FirstVisit: true})
+
+	rng := ztime.NewRange(ztime.Now(ctx)).To(ztime.Now(ctx))
+
+	var stats HitStats
+	err = stats.ListChannels(ctx, rng, PathFilter{}, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	have := fmt.Sprintf("%v", stats.Stats)
+	want := "[{email Email 2 <nil>} {search Search 1 <nil>} {social Social 1 <nil>} {referral Referral 1 <nil>} {direct Direct 1 <nil>}]"
+	if have != want {
+		t.Errorf("\nhave: %s\nwant: %s", have, want)
+	}
+
+	stats = HitStats{}
+	err = stats.ListChannels(ctx, rng, PathFilter{}, 2, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats.Stats) != 2 || !stats.More || stats.Stats[0].ID != ChannelSocial {
+		t.Errorf("%v", stats)
+	}
+
+	stats = HitStats{}
+	err = stats.ListChannel(ctx, ChannelEmail, rng, PathFilter{}, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats.Stats) != 2 || !strings.HasPrefix(stats.Stats[0].Name, "example.org") || stats.Stats[1].Name != "newsletter" {
+		t.Errorf("%v", stats.Stats)
+	}
+}
+
+func TestPathFilterChannel(t *testing.T) {
+	ctx := gctest.DB(t)
+
+	gctest.StoreHits(ctx, t, false,
+		Hit{FirstVisit: true, Path: "/a", Ref: "https://www.google.com/search"},
+		Hit{FirstVisit: true, Path: "/b", Ref: "https://t.co/abc"},
+		Hit{FirstVisit: true, Path: "/c", Ref: "https://duckduckgo.com/"},
+		Hit{FirstVisit: true, Path: "/c/x"})
+
+	tests := []struct {
+		query, want string
+	}{
+		{"channel:search", "[/a /c]"},
+		{"channel:Social", "[/b]"},
+		{"channel:direct", "[/c/x]"},
+		{"channel:email", "[]"},
+		{"/c channel:search", "[/c]"},
+		{"channel:search :not", "[/b /c/x]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			ids, err := FilterPathIDs(ctx, tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			paths := make([]string, 0, len(ids))
+			for _, id := range ids {
+				var p Path
+				err := p.ByID(ctx, id)
+				if err != nil {
+					t.Fatal(err)
+				}
+				paths = append(paths, p.Path)
+			}
+			slices.Sort(paths)
+			if have := fmt.Sprintf("%v", paths); have != tt.want {
+				t.Errorf("\nhave: %s\nwant: %s", have, tt.want)
+			}
+		})
+	}
+
+	_, err := FilterPathIDs(ctx, "channel:nope")
+	if err == nil || !strings.Contains(err.Error(), "unknown channel") {
+		t.Errorf("wrong error: %v", err)
+	}
+}
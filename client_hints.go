@@ -0,0 +1,158 @@
+package goatcounter
+
+import (
+	"strconv"
+	"strings"
+
+	"zgo.at/gadget"
+)
+
+// ClientHints are the User-Agent Client Hints, in the format of the Sec-CH-UA
+// headers.
+//
+// Chromium-based browsers send a "frozen" User-Agent header (e.g. "Windows NT
+// 10.0" for Windows 11, and always "Mac OS X 10_15_7" on macOS), so these are
+// used to get the real values where possible.
+type ClientHints struct {
+	UA              string // Sec-CH-UA: "Chromium";v="124", "Google Chrome";v="124", "Not-A.Brand";v="99"
+	Platform        string // Sec-CH-UA-Platform: "Windows"
+	PlatformVersion string // Sec-CH-UA-Platform-Version: "15.0.0"
+	Mobile          string // Sec-CH-UA-Mobile: ?0 or ?1
+}
+
+// ClientHintsHeaders are the headers to list in Accept-CH.
+var ClientHintsHeaders = []string{"Sec-CH-UA", "Sec-CH-UA-Platform",
+	"Sec-CH-UA-Platform-Version", "Sec-CH-UA-Mobile"}
+
+// IsZero reports if no client hints are set.
+func (c ClientHints) IsZero() bool { return c == ClientHints{} }
+
+func (c ClientHints) String() string {
+	if c.IsZero() {
+		return ""
+	}
+	return strings.Join([]string{c.UA, c.Platform, c.PlatformVersion, c.Mobile}, "\x00")
+}
+
+// IsMobile reports if Sec-CH-UA-Mobile is set to true.
+func (c ClientHints) IsMobile() bool { return c.Mobile == "?1" }
+
+// Some brands are listed with a different name than what we use from the
+// User-Agent header.
+var clientHintsBrands = map[string]string{
+	"Google Chrome":  "Chrome",
+	"Chromium":       "Chrome",
+	"Microsoft Edge": "Edge",
+}
+
+// Browser gets the browser name and version from Sec-CH-UA, or "" if there is
+// none.
+//
+// The "GREASE" brands (e.g. "Not-A.Brand") are ignored, and "Chromium" is only
+// used if there is no other brand.
+func (c ClientHints) Browser() (string, string) {
+	var name, version string
+	for b := range strings.SplitSeq(c.UA, ",") {
+		brand, params, _ := strings.Cut(b, ";")
+		brand = unquote(brand)
+		if brand == "" || (strings.HasPrefix(brand, "Not") && strings.Contains(brand, "Brand")) {
+			continue
+		}
+		if name != "" && brand == "Chromium" {
+			continue
+		}
+
+		name, version = brand, ""
+		for p := range strings.SplitSeq(params, ";") {
+			if k, v, ok := strings.Cut(strings.TrimSpace(p), "="); ok && k == "v" {
+				version = truncVersion(unquote(v), 1, false)
+			}
+		}
+		if brand != "Chromium" {
+			break
+		}
+	}
+	if n, ok := clientHintsBrands[name]; ok {
+		name = n
+	}
+	return name, version
+}
+
+// System gets the system name and version from Sec-CH-UA-Platform and
+// Sec-CH-UA-Platform-Version, or "" if there is none.
+//
+// The names and versions are the same as what gadget uses.
+func (c ClientHints) System() (string, string) {
+	name, version := unquote(c.Platform), unquote(c.PlatformVersion)
+	switch name {
+	case "", "Unknown":
+		return "", ""
+	case "Chrome OS", "Chromium OS":
+		// Platform version is the build number, rather than the Chrome OS
+		// version; gadget doesn't use it either.
+		return "Chrome OS", ""
+	case "Windows":
+		return name, windowsVersion(version)
+	case "Android":
+		return name, truncVersion(version, 2, true)
+	case "macOS", "iOS":
+		return name, truncVersion(version, 2, false)
+	}
+	return name, ""
+}
+
+// apply the client hints to a parsed User-Agent header.
+func (c ClientHints) apply(ua *gadget.UserAgent) {
+	if c.IsZero() {
+		return
+	}
+	if name, version := c.Browser(); name != "" {
+		ua.BrowserName, ua.BrowserVersion = name, version
+	}
+	if name, version := c.System(); name != "" {
+		if name != ua.OSName || version != "" {
+			ua.OSVersion = version
+		}
+		ua.OSName = name
+	}
+}
+
+// Windows version from Sec-CH-UA-Platform-Version; see:
+// https://learn.microsoft.com/en-us/microsoft-edge/web-platform/how-to-detect-win11
+func windowsVersion(v string) string {
+	major, rest, _ := strings.Cut(v, ".")
+	minor, _, _ := strings.Cut(rest, ".")
+	n, err := strconv.Atoi(major)
+	switch {
+	case err != nil:
+		return ""
+	case n == 0 && minor == "1":
+		return "7"
+	case n == 0 && minor == "2":
+		return "8"
+	case n == 0 && minor == "3":
+		return "8.1"
+	case n >= 1 && n <= 10:
+		return "10"
+	case n >= 13:
+		return "11"
+	}
+	return ""
+}
+
+// Trim a version to n components; e.g. n=2: "14.4.1" → "14.4", and remove a
+// trailing .0 if trimZero is set.
+func truncVersion(v string, n int, trimZero bool) string {
+	s := strings.Split(v, ".")
+	if len(s) > n {
+		s = s[:n]
+	}
+	for trimZero && len(s) > 1 && s[len(s)-1] == "0" {
+		s = s[:len(s)-1]
+	}
+	return strings.Join(s, ".")
+}
+
+func unquote(s string) string {
+	return strings.Trim(strings.TrimSpace(s), `"`)
+}
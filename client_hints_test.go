@@ -0,0 +1,99 @@
+package goatcounter_test
+
+import (
+	"fmt"
+	"testing"
+
+	. "zgo.at/goatcounter/v2"
+	"zgo.at/goatcounter/v2/gctest"
+	"zgo.at/zdb"
+)
+
+func TestClientHints(t *testing.T) {
+	tests := []struct {
+		in                                              ClientHints
+		wantBrowser, wantBrowserV, wantSystem, wantSysV string
+	}{
+		{ClientHints{}, "", "", "", ""},
+		{ClientHints{
+			UA:              `"Chromium";v="124", "Google Chrome";v="124", "Not-A.Brand";v="99"`,
+			Platform:        `"Windows"`,
+			PlatformVersion: `"15.0.0"`,
+		}, "Chrome", "124", "Windows", "11"},
+		{ClientHints{
+			UA:              `"Not/A)Brand";v="8", "Chromium";v="126", "Microsoft Edge";v="126"`,
+			Platform:        `"Windows"`,
+			PlatformVersion: `"10.0.0"`,
+		}, "Edge", "126", "Windows", "10"},
+		{ClientHints{
+			UA:              `"Chromium";v="120", "Not_A Brand";v="8"`,
+			Platform:        `"Windows"`,
+			PlatformVersion: `"0.3.0"`,
+		}, "Chrome", "120", "Windows", "8.1"},
+		{ClientHints{
+			UA:              `"Opera";v="110", "Not?A_Brand";v="24", "Chromium";v="124"`,
+			Platform:        `"macOS"`,
+			PlatformVersion: `"14.4.1"`,
+		}, "Opera", "110", "macOS", "14.4"},
+		{ClientHints{ // Values from navigator.userAgentData aren't quoted.
+			UA:              `"Google Chrome";v="124", "Chromium";v="124"`,
+			Platform:        `Android`,
+			PlatformVersion: `14.0.0`,
+			Mobile:          `?1`,
+		}, "Chrome", "124", "Android", "14"},
+		{ClientHints{Platform: `"Chrome OS"`, PlatformVersion: `"15786.48.0"`}, "", "", "Chrome OS", ""},
+		{ClientHints{Platform: `"Linux"`}, "", "", "Linux", ""},
+		{ClientHints{Platform: `"Unknown"`}, "", "", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run("", func(t *testing.T) {
+			b, bv := tt.in.Browser()
+			s, sv := tt.in.System()
+			if b != tt.wantBrowser || bv != tt.wantBrowserV || s != tt.wantSystem || sv != tt.wantSysV {
+				t.Errorf("\nhave: %q %q %q %q\nwant: %q %q %q %q", b, bv, s, sv,
+					tt.wantBrowser, tt.wantBrowserV, tt.wantSystem, tt.wantSysV)
+			}
+		})
+	}
+}
+
+func TestUserAgentClientHints(t *testing.T) {
+	ctx := gctest.DB(t)
+
+	// Frozen User-Agent for Chrome on Windows 11.
+	frozen := "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36"
+
+	ua := UserAgent{UserAgent: frozen}
+	err := ua.GetOrInsert(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	withHints := UserAgent{UserAgent: frozen, Hints: ClientHints{
+		UA:              `"Chromium";v="124", "Microsoft Edge";v="124", "Not-A.Brand";v="99"`,
+		Platform:        `"Windows"`,
+		PlatformVersion: `"15.0.0"`,
+		Mobile:          "?0",
+	}}
+	err = withHints.GetOrInsert(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ua.BrowserID == withHints.BrowserID || ua.SystemID == withHints.SystemID {
+		t.Fatalf("same IDs: %#v\n%#v", ua, withHints)
+	}
+
+	var have []string
+	err = zdb.Select(ctx, &have, `
+		select n from (
+			select name || ' ' || version as n from browsers union all
+			select name || ' ' || version as n from systems
+		) x order by n`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "[Chrome 124 Edge 124 Windows 10 Windows 11]"
+	if h := fmt.Sprintf("%v", have); h != want {
+		t.Errorf("\nhave: %s\nwant: %s", h, want)
+	}
+}
@@ -0,0 +1,642 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.yaml.in/yaml/v3"
+	"golang.org/x/text/language"
+	"zgo.at/errors"
+	"zgo.at/goatcounter/v2"
+	"zgo.at/goatcounter/v2/pkg/log"
+	"zgo.at/json"
+	"zgo.at/z18n"
+	"zgo.at/zdb"
+	"zgo.at/zli"
+	"zgo.at/zstd/zcrypto"
+	"zgo.at/zstd/zint"
+	"zgo.at/zvalidate"
+)
+
+const usageApply = `
+Create or update sites, users, and API tokens from a configuration file.
+
+Overview:
+
+    The configuration is read from a YAML file, and everything in it is
+    created or updated to match; rows that aren't in the file are left alone.
+    All changes are made in a single transaction, so either everything is
+    applied or nothing is.
+
+    Use -dry-run to see what would be changed:
+
+        $ goatcounter apply -f config.yaml -dry-run
+        + site "stats.example.com"
+        ~ site "other.example.com"
+            public: "private" → "public"
+            ignore_ips: [] → ["127.0.0.1"]
+        + user "martin@example.com" on "stats.example.com"
+
+    The secret for new API tokens is printed once after they're created.
+
+Configuration:
+
+    sites:
+      - vhost: stats.example.com  # Domain to host this site at.
+        code:                     # Site code; only used for goatcounter.com.
+        link:                     # Link to this site; as -link in "db create site".
+        link_domain: example.com  # Site domain for linking.
+        public: private           # private, secret, or public.
+        allow_counter: false      # Allow the visitor counter.
+        data_retention: 0         # Days to keep pageviews; 0 is forever.
+        ignore_ips: []            # IP addresses to ignore.
+        collect_regions: []       # Countries to collect regions for.
+        collect_cities: []        # Countries to collect cities for.
+        collect: [referrer, user_agent, screen_size, location,
+                  location_region, location_city, language, session, hits]
+
+    users:
+      - site: stats.example.com   # Site to add the user to, as vhost or ID.
+        email: martin@example.com
+        access: {all: admin}      # none, readonly, settings, admin, or
+                                  # superuser. Use a site's vhost or ID as the
+                                  # key to set access for just that site, which
+                                  # can be none, readonly, or settings.
+        password:                 # Only used for new users; if this is empty
+                                  # the user needs to reset the password.
+
+    api_tokens:
+      - user: martin@example.com  # User to create API token for.
+        name: terraform           # Name; tokens are matched on user and name.
+        perm: [site_read, site_update]
+        sites: [all]              # Sites as vhost or ID, or "all". Defaults to
+                                  # the user's site.
+        expires: "2027-01-01"     # Expiry date; "" to never expire.
+        allowed_ips: [192.0.2.0/24]
+        filter: /blog             # Only allow reading stats for these paths.
+
+    Sites are matched on code if it's set, or vhost if it's not. Only the
+    fields that are in the file are changed; for example if there's no
+    "public" for a site then it's left at whatever value it currently has.
+
+    The values for "perm" are identical to -perm in "goatcounter db create
+    apitoken".
+
+Flags:
+
+  -db          Database connection: "sqlite+<file>" or "postgres+<connect>"
+               See "goatcounter help db" for detailed documentation. Default:
+               sqlite+./db/goatcounter.sqlite3 if that database file exists, or
+               sqlite+./goatcounter-data/db.sqlite3 if it doesn't.
+
+  -debug       Modules to debug, comma-separated or 'all' for all modules.
+               See "goatcounter help debug" for a list of modules.
+
+  -f, -file    Configuration file to read; use - to read from stdin.
+
+  -dry-run     Show the changes, but don't change anything.
+`
+
+type (
+	applyConfig struct {
+		Sites     []applySite     `yaml:"sites"`
+		Users     []applyUser     `yaml:"users"`
+		APITokens []applyAPIToken `yaml:"api_tokens"`
+	}
+	applySite struct {
+		Vhost          string    `yaml:"vhost"`
+		Code           string    `yaml:"code"`
+		Link           string    `yaml:"link"`
+		LinkDomain     *string   `yaml:"link_domain"`
+		Public         *string   `yaml:"public"`
+		AllowCounter   *bool     `yaml:"allow_counter"`
+		DataRetention  *int      `yaml:"data_retention"`
+		IgnoreIPs      *[]string `yaml:"ignore_ips"`
+		Collect        *[]string `yaml:"collect"`
+		CollectRegions *[]string `yaml:"collect_regions"`
+		CollectCities  *[]string `yaml:"collect_cities"`
+	}
+	applyUser struct {
+		Site     string            `yaml:"site"`
+		Email    string            `yaml:"email"`
+		Access   map[string]string `yaml:"access"`
+		Password string            `yaml:"password"`
+	}
+	applyAPIToken struct {
+		User       string    `yaml:"user"`
+		Name       string    `yaml:"name"`
+		Perm       []string  `yaml:"perm"`
+		Sites      []string  `yaml:"sites"`
+		Expires    *string   `yaml:"expires"`
+		AllowedIPs *[]string `yaml:"allowed_ips"`
+		Filter     *string   `yaml:"filter"`
+	}
+)
+
+// Values for "collect"; in the same order as they're listed in the help.
+var collectFlags = []struct {
+	name string
+	flag zint.Bitflag16
+}{
+	{"referrer", goatcounter.CollectReferrer},
+	{"user_agent", goatcounter.CollectUserAgent},
+	{"screen_size", goatcounter.CollectScreenSize},
+	{"location", goatcounter.CollectLocation},
+	{"location_region", goatcounter.CollectLocationRegion},
+	{"location_city", goatcounter.CollectLocationCity},
+	{"language", goatcounter.CollectLanguage},
+	{"session", goatcounter.CollectSession},
+	{"hits", goatcounter.CollectHits},
+}
+
+// Returned from the transaction to roll back on -dry-run.
+var errDryRun = errors.New("dry run")
+
+func cmdApply(f zli.Flags, ready chan<- struct{}, stop chan struct{}) error {
+	defer func() { ready <- struct{}{} }()
+
+	var (
+		dbConnect = f.String(defaultDB(), "db").Pointer()
+		debug     = f.StringList(nil, "debug")
+		file      = f.String("", "f", "file")
+		dryRun    = f.Bool(false, "dry-run")
+	)
+	if err := f.Parse(zli.FromEnv("GOATCOUNTER")); err != nil && !errors.As(err, &zli.ErrUnknownEnv{}) {
+		return err
+	}
+	log.SetDebug(debug.StringsSplit(","))
+
+	v := zvalidate.New()
+	v.Required("-f", file.String())
+	if v.HasErrors() {
+		return v
+	}
+
+	fp, err := zli.InputOrFile(file.String(), true)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+
+	var conf applyConfig
+	dec := yaml.NewDecoder(fp)
+	dec.KnownFields(true)
+	err = dec.Decode(&conf)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", file.String(), err)
+	}
+
+	db, _, err := connectDB(*dbConnect, "", []string{"pending"}, false, false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx := goatcounter.NewContext(context.Background(), db)
+	ctx = z18n.With(ctx, z18n.NewBundle(language.English).Locale("en"))
+	ctx = goatcounter.WithAuditActor(ctx, goatcounter.AuditActor{Name: "goatcounter apply"})
+
+	changes, err := apply(ctx, conf, dryRun.Bool())
+	if len(changes) == 0 && err == nil {
+		fmt.Fprintln(zli.Stdout, "no changes")
+	}
+	for _, c := range changes {
+		fmt.Fprintln(zli.Stdout, c)
+	}
+	return err
+}
+
+// apply the configuration to the database, returning a list of changes.
+func apply(ctx context.Context, conf applyConfig, dryRun bool) ([]string, error) {
+	var changes []string
+	err := zdb.TX(ctx, func(ctx context.Context) error {
+		for _, s := range conf.Sites {
+			c, err := applySiteConfig(ctx, s)
+			if err != nil {
+				return fmt.Errorf("site %q: %w", cmp.Or(s.Code, s.Vhost), err)
+			}
+			changes = append(changes, c...)
+		}
+		for _, u := range conf.Users {
+			c, err := applyUserConfig(ctx, u)
+			if err != nil {
+				return fmt.Errorf("user %q: %w", u.Email, err)
+			}
+			changes = append(changes, c...)
+		}
+		for _, t := range conf.APITokens {
+			c, err := applyAPITokenConfig(ctx, t, dryRun)
+			if err != nil {
+				return fmt.Errorf("API token %q: %w", t.Name, err)
+			}
+			changes = append(changes, c...)
+		}
+
+		if dryRun {
+			return errDryRun
+		}
+		return nil
+	})
+	if errors.Is(err, errDryRun) {
+		err = nil
+	}
+	return changes, err
+}
+
+func applySiteConfig(ctx context.Context, c applySite) ([]string, error) {
+	v := zvalidate.New()
+	if c.Vhost == "" && c.Code == "" {
+		v.Append("vhost", "vhost or code is required")
+	}
+	v.Domain("vhost", c.Vhost)
+	if v.HasErrors() {
+		return nil, v
+	}
+
+	var (
+		s   goatcounter.Site
+		err error
+	)
+	if c.Code != "" {
+		err = s.ByCode(ctx, c.Code)
+	} else {
+		err = s.ByHost(ctx, c.Vhost)
+	}
+	if err != nil && !zdb.ErrNoRows(err) {
+		return nil, err
+	}
+	exists := err == nil
+
+	var parent *goatcounter.Site
+	if c.Link != "" {
+		p, err := findParent(ctx, c.Link)
+		if err != nil {
+			return nil, err
+		}
+		parent = &p
+	}
+
+	if !exists {
+		s = goatcounter.Site{Code: cmp.Or(c.Code, "serve-"+zcrypto.Secret64())}
+		if c.Vhost != "" {
+			s.Cname = &c.Vhost
+		}
+		if parent != nil {
+			s.Parent, s.Settings, s.UserDefaults = &parent.ID, parent.Settings, parent.UserDefaults
+		}
+		_, err := c.set(&s)
+		if err != nil {
+			return nil, err
+		}
+		err = s.Insert(ctx)
+		if err != nil {
+			return nil, err
+		}
+		err = goatcounter.Audit(goatcounter.WithSite(ctx, &s), goatcounter.AuditSiteCreate, nil, s)
+		if err != nil {
+			return nil, err
+		}
+		return []string{fmt.Sprintf("+ site %q", cmp.Or(c.Vhost, c.Code))}, nil
+	}
+
+	before := s
+	var ch applyChanges
+	if c.Code != "" && c.Vhost != "" {
+		applySet(&ch, "vhost", &s.Cname, &c.Vhost)
+	}
+	set, err := c.set(&s)
+	if err != nil {
+		return nil, err
+	}
+	ch = append(ch, set...)
+	if len(ch) > 0 {
+		err := s.Update(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if parent != nil && (s.Parent == nil || *s.Parent != parent.ID) {
+		ch.add("link", s.Parent, parent.ID)
+		err := s.UpdateParent(goatcounter.WithSite(ctx, parent), &parent.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(ch) == 0 {
+		return nil, nil
+	}
+	err = goatcounter.Audit(goatcounter.WithSite(ctx, &s), goatcounter.AuditSiteUpdate, before, s)
+	if err != nil {
+		return nil, err
+	}
+	return append([]string{fmt.Sprintf("~ site %q", cmp.Or(c.Vhost, c.Code))}, ch.indent()...), nil
+}
+
+// set all the fields that are in the configuration.
+func (c applySite) set(s *goatcounter.Site) (applyChanges, error) {
+	var ch applyChanges
+	if c.LinkDomain != nil {
+		applySet(&ch, "link_domain", &s.LinkDomain, *c.LinkDomain)
+	}
+	if c.Public != nil {
+		applySet(&ch, "public", &s.Settings.Public, *c.Public)
+		if s.Settings.Public == "secret" && s.Settings.Secret == "" {
+			s.Settings.Secret = zcrypto.Secret128()
+		}
+	}
+	if c.AllowCounter != nil {
+		applySet(&ch, "allow_counter", &s.Settings.AllowCounter, *c.AllowCounter)
+	}
+	if c.DataRetention != nil {
+		applySet(&ch, "data_retention", &s.Settings.DataRetention, *c.DataRetention)
+	}
+	if c.IgnoreIPs != nil {
+		applySet(&ch, "ignore_ips", &s.Settings.IgnoreIPs, goatcounter.Strings(*c.IgnoreIPs))
+	}
+	if c.CollectRegions != nil {
+		applySet(&ch, "collect_regions", &s.Settings.CollectRegions, goatcounter.Strings(*c.CollectRegions))
+	}
+	if c.CollectCities != nil {
+		applySet(&ch, "collect_cities", &s.Settings.CollectCities, goatcounter.Strings(*c.CollectCities))
+	}
+	if c.Collect != nil {
+		collect := goatcounter.CollectNothing
+		for _, name := range *c.Collect {
+			var flag zint.Bitflag16
+			for _, f := range collectFlags {
+				if f.name == name {
+					flag = f.flag
+				}
+			}
+			if flag == 0 {
+				return nil, fmt.Errorf("collect: invalid value %q", name)
+			}
+			collect |= flag
+		}
+		if have := s.Settings.Collect | goatcounter.CollectNothing; have != collect {
+			ch.add("collect", have, collect)
+			s.Settings.Collect = collect
+		}
+	}
+	return ch, nil
+}
+
+func applyUserConfig(ctx context.Context, c applyUser) ([]string, error) {
+	v := zvalidate.New()
+	v.Required("site", c.Site)
+	v.Required("email", c.Email)
+	v.Email("email", c.Email)
+	v.Required("access", c.Access)
+	for _, a := range c.Access {
+		v.Include("access", a, []string{"none", "readonly", "settings", "admin", "superuser"})
+	}
+	if v.HasErrors() {
+		return nil, v
+	}
+
+	account, err := findParent(ctx, c.Site)
+	if err != nil {
+		return nil, err
+	}
+	ctx = goatcounter.WithSite(ctx, &account)
+
+	access := make(goatcounter.UserAccesses)
+	for k, a := range c.Access {
+		if k != "all" {
+			var s goatcounter.Site
+			err := s.Find(ctx, k)
+			if err != nil {
+				return nil, fmt.Errorf("access: %w", err)
+			}
+			k = strconv.FormatInt(int64(s.ID), 10)
+		}
+		access[k] = accessFlags[a]
+	}
+
+	var u goatcounter.User
+	err = u.ByEmail(ctx, c.Email)
+	if zdb.ErrNoRows(err) {
+		u = goatcounter.User{
+			Site:          account.ID,
+			Email:         c.Email,
+			EmailVerified: true,
+			Settings:      account.UserDefaults,
+			Access:        access,
+		}
+		if c.Password != "" {
+			u.Password = []byte(c.Password)
+		}
+		err := u.Insert(ctx, c.Password == "")
+		if err != nil {
+			return nil, err
+		}
+		err = goatcounter.Audit(ctx, goatcounter.AuditUserCreate, nil, u)
+		if err != nil {
+			return nil, err
+		}
+		return []string{fmt.Sprintf("+ user %q on %q", c.Email, c.Site)}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		before = u
+		ch     applyChanges
+	)
+	applySet(&ch, "access", &u.Access, access)
+	if len(ch) == 0 {
+		return nil, nil
+	}
+	err = u.Update(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	err = goatcounter.Audit(ctx, goatcounter.AuditUserUpdate, before, u)
+	if err != nil {
+		return nil, err
+	}
+	return append([]string{fmt.Sprintf("~ user %q on %q", c.Email, c.Site)}, ch.indent()...), nil
+}
+
+func applyAPITokenConfig(ctx context.Context, c applyAPIToken, dryRun bool) ([]string, error) {
+	v := zvalidate.New()
+	v.Required("user", c.User)
+	v.Required("name", c.Name)
+	v.Required("perm", c.Perm)
+	if v.HasErrors() {
+		return nil, v
+	}
+
+	user, ctx, err := lookupUser(ctx, c.User)
+	if err != nil {
+		return nil, err
+	}
+	perm, err := getPerm(strings.Join(c.Perm, ","))
+	if err != nil {
+		return nil, err
+	}
+	perm |= goatcounter.APIPermNothing
+
+	var exp *time.Time
+	if c.Expires != nil {
+		exp, err = getExpires(*c.Expires)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sites := goatcounter.SiteIDs{user.Site}
+	if len(c.Sites) == 1 && c.Sites[0] == "all" {
+		sites = goatcounter.SiteIDs{-1}
+	} else if len(c.Sites) > 0 {
+		sites = make(goatcounter.SiteIDs, 0, len(c.Sites))
+		for _, find := range c.Sites {
+			var s goatcounter.Site
+			err := s.Find(ctx, find)
+			if err != nil {
+				return nil, err
+			}
+			sites = append(sites, s.ID)
+		}
+	}
+
+	var tokens goatcounter.APITokens
+	err = tokens.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	i := slices.IndexFunc(tokens, func(t goatcounter.APIToken) bool { return t.Name == c.Name })
+	if i == -1 {
+		t := goatcounter.APIToken{
+			Name:        c.Name,
+			Permissions: perm,
+			Sites:       sites,
+			ExpiresAt:   exp,
+		}
+		if c.AllowedIPs != nil {
+			t.AllowedIPs = *c.AllowedIPs
+		}
+		if c.Filter != nil {
+			t.Filter = *c.Filter
+		}
+		err := t.Insert(ctx)
+		if err != nil {
+			return nil, err
+		}
+		err = goatcounter.Audit(ctx, goatcounter.AuditAPITokenCreate, nil, t)
+		if err != nil {
+			return nil, err
+		}
+		if dryRun {
+			return []string{fmt.Sprintf("+ API token %q for %q", c.Name, user.Email)}, nil
+		}
+		return []string{fmt.Sprintf("+ API token %q for %q: %s", c.Name, user.Email, t.Token)}, nil
+	}
+
+	var (
+		t      = tokens[i]
+		before = t
+		ch     applyChanges
+	)
+	if t.Permissions|goatcounter.APIPermNothing != perm {
+		ch.add("perm", t.Permissions, perm)
+		t.Permissions = perm
+	}
+	applySet(&ch, "sites", &t.Sites, sites)
+	if c.AllowedIPs != nil {
+		applySet(&ch, "allowed_ips", &t.AllowedIPs, goatcounter.Strings(*c.AllowedIPs))
+	}
+	if c.Filter != nil {
+		applySet(&ch, "filter", &t.Filter, *c.Filter)
+	}
+	if c.Expires != nil {
+		same := (exp == nil && t.ExpiresAt == nil) ||
+			(exp != nil && t.ExpiresAt != nil && exp.Equal(*t.ExpiresAt))
+		if !same {
+			ch.add("expires", t.ExpiresAt, exp)
+			t.ExpiresAt = exp
+		}
+	}
+	if len(ch) == 0 {
+		return nil, nil
+	}
+	err = t.Update(ctx)
+	if err != nil {
+		return nil, err
+	}
+	err = goatcounter.Audit(ctx, goatcounter.AuditAPITokenUpdate, before, t)
+	if err != nil {
+		return nil, err
+	}
+	return append([]string{fmt.Sprintf("~ API token %q for %q", c.Name, user.Email)}, ch.indent()...), nil
+}
+
+// applyChanges is a list of changes to display.
+type applyChanges []string
+
+func (ch *applyChanges) add(name string, have, want any) {
+	*ch = append(*ch, fmt.Sprintf("%s: %s → %s", name, applyFormat(have), applyFormat(want)))
+}
+
+func (ch applyChanges) indent() []string {
+	l := make([]string, 0, len(ch))
+	for _, c := range ch {
+		l = append(l, "    "+c)
+	}
+	return l
+}
+
+// applySet sets have to want if they're different, and records the change.
+func applySet[T any](ch *applyChanges, name string, have *T, want T) {
+	h, w := reflect.ValueOf(*have), reflect.ValueOf(want)
+	if (h.Kind() == reflect.Slice || h.Kind() == reflect.Map) && h.Len() == 0 && w.Len() == 0 {
+		return
+	}
+	if reflect.DeepEqual(*have, want) {
+		return
+	}
+	ch.add(name, *have, want)
+	*have = want
+}
+
+func applyFormat(v any) string {
+	switch vv := v.(type) {
+	case goatcounter.Strings: // Marshals as a comma-separated string.
+		v = append([]string{}, vv...)
+	case zint.Bitflag16:
+		names := make([]string, 0, len(collectFlags))
+		for _, f := range collectFlags {
+			if vv.Has(f.flag) {
+				names = append(names, f.name)
+			}
+		}
+		return "[" + strings.Join(names, ", ") + "]"
+	case goatcounter.UserAccesses:
+		keys := make([]string, 0, len(vv))
+		for k := range vv {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		l := make([]string, 0, len(keys))
+		for _, k := range keys {
+			l = append(l, k+": "+vv[k].String())
+		}
+		return "{" + strings.Join(l, ", ") + "}"
+	case zint.Bitflag64:
+		return "[" + goatcounter.APIToken{Permissions: vv}.FormatPermissions() + "]"
+	}
+	j, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return string(j)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"zgo.at/zdb"
+)
+
+func TestApply(t *testing.T) {
+	exit, _, out, ctx, dbc := startTest(t)
+
+	conf := filepath.Join(t.TempDir(), "config.yaml")
+	write := func(s string) {
+		t.Helper()
+		err := os.WriteFile(conf, []byte(strings.ReplaceAll(s, "\t", "  ")), 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	dump := func() string {
+		return zdb.DumpString(ctx, `select site_id, parent, cname from sites order by site_id`) +
+			zdb.DumpString(ctx, `select user_id, site_id, email, access from users order by user_id`) +
+			zdb.DumpString(ctx, `select api_token_id, user_id, name, permissions, sites from api_tokens order by api_token_id`)
+	}
+
+	write(`
+sites:
+	- vhost: stats.stats
+	- vhost: stats2.stats
+		link: stats.stats
+		public: public
+users:
+	- site: stats.stats
+		email: foo@foo.foo
+		access: {all: admin}
+api_tokens:
+	- user: foo@foo.foo
+		name: terraform
+		perm: [site_read, stats]
+		sites: [all]
+`)
+
+	{ // dry-run
+		before := dump()
+		runCmd(t, exit, "apply", "-db="+dbc, "-f", conf, "-dry-run")
+		wantExit(t, exit, out, 0)
+
+		want := "+ site \"stats.stats\"\n" +
+			"+ site \"stats2.stats\"\n" +
+			"+ user \"foo@foo.foo\" on \"stats.stats\"\n" +
+			"+ API token \"terraform\" for \"foo@foo.foo\"\n"
+		if out.String() != want {
+			t.Errorf("\nhave:\n%s\nwant:\n%s", out.String(), want)
+		}
+		if d := zdb.Diff(dump(), before); d != "" {
+			t.Error(d)
+		}
+		out.Reset()
+	}
+
+	{ // apply
+		runCmd(t, exit, "apply", "-db="+dbc, "-f", conf)
+		wantExit(t, exit, out, 0)
+		if !strings.Contains(out.String(), `+ API token "terraform" for "foo@foo.foo": `) {
+			t.Error(out.String())
+		}
+
+		want := `
+			site_id  parent  cname
+			1        NULL    gctest.localhost
+			2        NULL    stats.stats
+			3        2       stats2.stats
+			user_id  site_id  email                  access
+			1        1        test@gctest.localhost  {"all":"*"}
+			2        2        foo@foo.foo            {"all":"a"}
+			api_token_id  user_id  name       permissions  sites
+			1             2        terraform  73           [-1]`
+		if d := zdb.Diff(dump(), want); d != "" {
+			t.Error(d)
+		}
+		out.Reset()
+
+		runCmd(t, exit, "apply", "-db="+dbc, "-f", conf)
+		wantExit(t, exit, out, 0)
+		if out.String() != "no changes\n" {
+			t.Error(out.String())
+		}
+		out.Reset()
+	}
+
+	{ // update
+		write(`
+sites:
+	- vhost: stats2.stats
+		public: private
+		ignore_ips: [127.0.0.1]
+users:
+	- site: stats.stats
+		email: foo@foo.foo
+		access: {all: readonly}
+api_tokens:
+	- user: foo@foo.foo
+		name: terraform
+		perm: [site_read]
+		sites: [stats2.stats]
+`)
+		runCmd(t, exit, "apply", "-db="+dbc, "-f", conf)
+		wantExit(t, exit, out, 0)
+
+		want := `~ site "stats2.stats"
+    public: "public" → "private"
+    ignore_ips: [] → ["127.0.0.1"]
+~ user "foo@foo.foo" on "stats.stats"
+    access: {all: admin} → {all: read only}
+~ API token "terraform" for "foo@foo.foo"
+    perm: ['site-read', 'stats'] → ['site-read']
+    sites: [-1] → [3]
+`
+		if out.String() != want {
+			t.Errorf("\nhave:\n%s\nwant:\n%s", out.String(), want)
+		}
+		out.Reset()
+	}
+
+	{ // error rolls back everything
+		before := dump()
+		write(`
+sites:
+	- vhost: stats3.stats
+users:
+	- site: stats.stats
+		email: foo@foo.foo
+		access: {all: nope}
+`)
+		runCmd(t, exit, "apply", "-db="+dbc, "-f", conf)
+		wantExit(t, exit, out, 1)
+		if d := zdb.Diff(dump(), before); d != "" {
+			t.Error(d)
+		}
+	}
+}
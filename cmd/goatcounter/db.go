@@ -7,6 +7,7 @@ import (
 	"os"
 	"slices"
 	"strings"
+	"time"
 
 	"golang.org/x/text/language"
 	"zgo.at/errors"
@@ -21,6 +22,7 @@ import (
 	"zgo.at/zstd/zint"
 	"zgo.at/zstd/zstrconv"
 	"zgo.at/zstd/zstring"
+	"zgo.at/zstd/ztime"
 	"zgo.at/zvalidate"
 )
 
@@ -156,6 +158,26 @@ create and update commands:
                         site_read    Reading site information.
                         site_create  Creating new sites.
                         site_update  Updating existing sites.
+                        site_delete  Deleting sites.
+                        stats        Reading statistics.
+                        user_read    Reading users.
+                        user_manage  Inviting, updating, and removing users.
+                        token_manage Creating, rotating, and revoking API
+                                     tokens.
+                        audit_read   Reading the audit log.
+                        annotations  Adding and removing chart annotations.
+
+        -expires    Date the token expires, as 2006-01-02; the token can't be
+                    used after the end of this day (UTC). Use an empty string
+                    to never expire (the default).
+
+        -allowed-ips
+                    Comma-separated list of IP addresses or CIDR ranges the
+                    token can be used from. Default is to allow all addresses.
+
+        -filter     Only allow reading statistics for paths matching this
+                    filter, using the same syntax as the dashboard filter. The
+                    token can only have read permissions if this is set.
 
 migrate command:
 
@@ -599,6 +621,7 @@ func dbParseFlag(f zli.Flags, dbConnect *string, debug []string, createdb *bool)
 
 	ctx := goatcounter.NewContext(context.Background(), db)
 	ctx = z18n.With(ctx, z18n.NewBundle(language.English).Locale("en"))
+	ctx = goatcounter.WithAuditActor(ctx, goatcounter.AuditActor{Name: "goatcounter db"})
 	return db, ctx, nil
 }
 
@@ -653,7 +676,41 @@ func cmdDBDelete(f zli.Flags, cmd string, dbConnect *string, debug []string, cre
 	if err != nil {
 		return err
 	}
-	return finder.Delete(ctx, *force)
+	return zdb.TX(ctx, func(ctx context.Context) error {
+		err := auditDelete(ctx, finder)
+		if err != nil {
+			return err
+		}
+		return finder.Delete(ctx, *force)
+	})
+}
+
+// auditDelete records the deletion of everything in finder in the audit log.
+func auditDelete(ctx context.Context, finder findMany) error {
+	switch ff := finder.(type) {
+	case *goatcounter.Sites:
+		for _, s := range *ff {
+			err := goatcounter.Audit(goatcounter.WithSite(ctx, &s), goatcounter.AuditSiteDelete, s, nil)
+			if err != nil {
+				return err
+			}
+		}
+	case *goatcounter.Users:
+		for _, u := range *ff {
+			err := goatcounter.Audit(goatcounter.WithSite(ctx, &goatcounter.Site{ID: u.Site}), goatcounter.AuditUserDelete, u, nil)
+			if err != nil {
+				return err
+			}
+		}
+	case *goatcounter.APITokens:
+		for _, t := range *ff {
+			err := goatcounter.Audit(goatcounter.WithSite(ctx, &goatcounter.Site{ID: t.SiteID}), goatcounter.AuditAPITokenDelete, t, nil)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
 }
 
 func cmdDBSite(f zli.Flags, cmd string, dbConnect *string, debug []string, createdb *bool) error {
@@ -738,19 +795,26 @@ func cmdDBSiteCreate(ctx context.Context, vhost, email, link, pwd string) error
 		if err != nil {
 			return err
 		}
+		ctx = goatcounter.WithSite(ctx, &s)
+		err = goatcounter.Audit(ctx, goatcounter.AuditSiteCreate, nil, s)
+		if err != nil {
+			return err
+		}
 
 		if link == "" { // Create user as well.
-			err = (&goatcounter.User{
+			u := goatcounter.User{
 				Site:          s.ID,
 				Email:         email,
 				Password:      []byte(pwd),
 				EmailVerified: true,
 				Settings:      s.UserDefaults,
 				Access:        goatcounter.UserAccesses{"all": goatcounter.AccessSuperuser},
-			}).Insert(ctx, false)
+			}
+			err = u.Insert(ctx, false)
 			if err != nil {
 				return err
 			}
+			return goatcounter.Audit(ctx, goatcounter.AuditUserCreate, nil, u)
 		}
 		return nil
 	})
@@ -775,6 +839,7 @@ func cmdDBSiteUpdate(ctx context.Context, find []string,
 
 	return zdb.TX(ctx, func(ctx context.Context) error {
 		for _, s := range sites {
+			before := s
 			if link.Set() {
 				ps, err := findParent(ctx, link.String())
 				if err != nil {
@@ -794,6 +859,11 @@ func cmdDBSiteUpdate(ctx context.Context, find []string,
 					return err
 				}
 			}
+
+			err := goatcounter.Audit(goatcounter.WithSite(ctx, &s), goatcounter.AuditSiteUpdate, before, s)
+			if err != nil {
+				return err
+			}
 		}
 
 		return nil
@@ -867,13 +937,20 @@ func cmdDBUserCreate(ctx context.Context,
 		}
 	}
 
-	return (&goatcounter.User{
-		Site:     site.ID,
-		Email:    email,
-		Password: []byte(pwd),
-		Settings: site.UserDefaults,
-		Access:   getAccess(access),
-	}).Insert(ctx, false)
+	return zdb.TX(goatcounter.WithSite(ctx, &site), func(ctx context.Context) error {
+		u := goatcounter.User{
+			Site:     site.ID,
+			Email:    email,
+			Password: []byte(pwd),
+			Settings: site.UserDefaults,
+			Access:   getAccess(access),
+		}
+		err := u.Insert(ctx, false)
+		if err != nil {
+			return err
+		}
+		return goatcounter.Audit(ctx, goatcounter.AuditUserCreate, nil, u)
+	})
 }
 
 func cmdDBUserUpdate(ctx context.Context, find []string,
@@ -896,6 +973,7 @@ func cmdDBUserUpdate(ctx context.Context, find []string,
 	return zdb.TX(ctx, func(ctx context.Context) error {
 		for _, u := range users {
 			ctx = goatcounter.WithSite(ctx, &goatcounter.Site{ID: u.Site})
+			before := u
 
 			if email.Set() {
 				u.Email = email.String()
@@ -931,20 +1009,26 @@ func cmdDBUserUpdate(ctx context.Context, find []string,
 					return err
 				}
 			}
+
+			err := goatcounter.Audit(ctx, goatcounter.AuditUserUpdate, before, u)
+			if err != nil {
+				return err
+			}
 		}
 		return nil
 	})
 }
 
+var accessFlags = map[string]goatcounter.UserAccess{
+	"none":      goatcounter.AccessNone,
+	"readonly":  goatcounter.AccessReadOnly,
+	"settings":  goatcounter.AccessSettings,
+	"admin":     goatcounter.AccessAdmin,
+	"superuser": goatcounter.AccessSuperuser,
+}
+
 func getAccess(a string) goatcounter.UserAccesses {
-	return goatcounter.UserAccesses{
-		"all": map[string]goatcounter.UserAccess{
-			"readonly":  goatcounter.AccessReadOnly,
-			"settings":  goatcounter.AccessSettings,
-			"admin":     goatcounter.AccessAdmin,
-			"superuser": goatcounter.AccessSuperuser,
-		}[a],
-	}
+	return goatcounter.UserAccesses{"all": accessFlags[a]}
 }
 
 func cmdDBAPIToken(f zli.Flags, cmd string, dbConnect *string, debug []string, createdb *bool) error {
@@ -952,6 +1036,9 @@ func cmdDBAPIToken(f zli.Flags, cmd string, dbConnect *string, debug []string, c
 		user = f.String("", "user")
 		name = f.String("", "name")
 		perm = f.String("", "perm")
+		exp  = f.String("", "expires")
+		ips  = f.String("", "allowed-ips")
+		filt = f.String("", "filter")
 		find *[]string
 	)
 	if cmd == "update" {
@@ -964,13 +1051,14 @@ func cmdDBAPIToken(f zli.Flags, cmd string, dbConnect *string, debug []string, c
 	defer db.Close()
 
 	if cmd == "create" {
-		return cmdDBAPITokenCreate(ctx, user.String(), perm.String(), name.String())
+		return cmdDBAPITokenCreate(ctx, user.String(), perm.String(), name.String(),
+			exp.String(), ips.String(), filt.String())
 	}
-	return cmdDBAPITokenUpdate(ctx, *find, name, perm)
+	return cmdDBAPITokenUpdate(ctx, *find, name, perm, exp, ips, filt)
 }
 
 func cmdDBAPITokenCreate(ctx context.Context,
-	findUser, permFlag, name string,
+	findUser, permFlag, name, expFlag, ipsFlag, filter string,
 ) error {
 
 	v := zvalidate.New()
@@ -980,39 +1068,62 @@ func cmdDBAPITokenCreate(ctx context.Context,
 		return v
 	}
 
-	var siteID goatcounter.SiteID
-	findUserID, _ := zstrconv.ParseInt[goatcounter.UserID](findUser, 10)
-	err := zdb.Get(ctx, &siteID,
-		`select site_id from users where user_id = $1 or email = $2`,
-		findUserID, findUser)
+	user, ctx, err := lookupUser(ctx, findUser)
 	if err != nil {
 		return err
 	}
-	ctx = goatcounter.WithSite(ctx, &goatcounter.Site{ID: siteID})
 
-	var user goatcounter.User
-	err = user.Find(ctx, findUser)
+	perm, err := getPerm(permFlag)
 	if err != nil {
 		return err
 	}
-	ctx = goatcounter.WithUser(ctx, &user)
-
-	perm, err := getPerm(permFlag)
+	exp, err := getExpires(expFlag)
 	if err != nil {
 		return err
 	}
 
-	return (&goatcounter.APIToken{
-		SiteID:      user.Site,
-		UserID:      user.ID,
-		Name:        name,
-		Permissions: perm,
-		Sites:       goatcounter.SiteIDs{siteID},
-	}).Insert(ctx)
+	return zdb.TX(ctx, func(ctx context.Context) error {
+		t := goatcounter.APIToken{
+			SiteID:      user.Site,
+			UserID:      user.ID,
+			Name:        name,
+			Permissions: perm,
+			Sites:       goatcounter.SiteIDs{user.Site},
+			AllowedIPs:  zstring.Fields(ipsFlag, ","),
+			Filter:      filter,
+			ExpiresAt:   exp,
+		}
+		err := t.Insert(ctx)
+		if err != nil {
+			return err
+		}
+		return goatcounter.Audit(ctx, goatcounter.AuditAPITokenCreate, nil, t)
+	})
+}
+
+// lookupUser finds a user by ID or email, and returns a context with the user
+// and site set.
+func lookupUser(ctx context.Context, find string) (goatcounter.User, context.Context, error) {
+	var siteID goatcounter.SiteID
+	findUserID, _ := zstrconv.ParseInt[goatcounter.UserID](find, 10)
+	err := zdb.Get(ctx, &siteID,
+		`select site_id from users where user_id = $1 or email = $2`,
+		findUserID, find)
+	if err != nil {
+		return goatcounter.User{}, nil, err
+	}
+	ctx = goatcounter.WithSite(ctx, &goatcounter.Site{ID: siteID})
+
+	var user goatcounter.User
+	err = user.Find(ctx, find)
+	if err != nil {
+		return goatcounter.User{}, nil, err
+	}
+	return user, goatcounter.WithUser(ctx, &user), nil
 }
 
 func cmdDBAPITokenUpdate(ctx context.Context, find []string,
-	name, perm stringFlag,
+	name, perm, exp, ips, filter stringFlag,
 ) error {
 
 	v := zvalidate.New()
@@ -1031,6 +1142,7 @@ func cmdDBAPITokenUpdate(ctx context.Context, find []string,
 		for _, t := range tokens {
 			ctx = goatcounter.WithSite(ctx, &goatcounter.Site{ID: t.SiteID})
 			ctx = goatcounter.WithUser(ctx, &goatcounter.User{ID: t.UserID})
+			before := t
 
 			if name.Set() {
 				t.Name = name.String()
@@ -1042,25 +1154,61 @@ func cmdDBAPITokenUpdate(ctx context.Context, find []string,
 				}
 				t.Permissions = p
 			}
+			if exp.Set() {
+				e, err := getExpires(exp.String())
+				if err != nil {
+					return err
+				}
+				t.ExpiresAt = e
+			}
+			if ips.Set() {
+				t.AllowedIPs = zstring.Fields(ips.String(), ",")
+			}
+			if filter.Set() {
+				t.Filter = filter.String()
+			}
 
 			err := t.Update(ctx)
 			if err != nil {
 				return err
 			}
+			err = goatcounter.Audit(ctx, goatcounter.AuditAPITokenUpdate, before, t)
+			if err != nil {
+				return err
+			}
 		}
 		return nil
 	})
 }
 
+// getExpires parses the -expires flag; an empty string means it never expires.
+func getExpires(expFlag string) (*time.Time, error) {
+	if expFlag == "" {
+		return nil, nil
+	}
+	t, err := time.Parse("2006-01-02", expFlag)
+	if err != nil {
+		return nil, fmt.Errorf("-expires: invalid date %q", expFlag)
+	}
+	return new(ztime.EndOf(t, ztime.Day)), nil
+}
+
 func getPerm(permFlag string) (zint.Bitflag64, error) {
 	var perm zint.Bitflag64
 	for _, p := range zstring.Fields(permFlag, ",") {
 		pp, ok := map[string]zint.Bitflag64{
-			"count":       goatcounter.APIPermCount,
-			"export":      goatcounter.APIPermExport,
-			"site_read":   goatcounter.APIPermSiteRead,
-			"site_create": goatcounter.APIPermSiteCreate,
-			"site_update": goatcounter.APIPermSiteUpdate,
+			"count":        goatcounter.APIPermCount,
+			"export":       goatcounter.APIPermExport,
+			"site_read":    goatcounter.APIPermSiteRead,
+			"site_create":  goatcounter.APIPermSiteCreate,
+			"site_update":  goatcounter.APIPermSiteUpdate,
+			"site_delete":  goatcounter.APIPermSiteDelete,
+			"stats":        goatcounter.APIPermStats,
+			"user_read":    goatcounter.APIPermUserRead,
+			"user_manage":  goatcounter.APIPermUserManage,
+			"token_manage": goatcounter.APIPermTokenManage,
+			"audit_read":   goatcounter.APIPermAuditRead,
+			"annotations":  goatcounter.APIPermAnnotations,
 		}[p]
 		if !ok {
 			return 0, fmt.Errorf("-perm: invalid value %q", p)
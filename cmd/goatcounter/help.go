@@ -30,7 +30,7 @@ func cmdHelp(f zli.Flags, ready chan<- struct{}, stop chan struct{}) error {
 		}
 		if a == "all" {
 			topics = []string{"help", "version", "serve", "import",
-				"dashboard", "db", "monitor", "listen", "logfile", "log", "debug"}
+				"dashboard", "db", "apply", "monitor", "listen", "logfile", "log", "debug"}
 			break
 		}
 		topics = append(topics, strings.ToLower(a))
@@ -72,6 +72,7 @@ var usage = map[string]string{
 	"import":    usageImport,
 	"dashboard": usageDashboard,
 	"db":        helpDB,
+	"apply":     usageApply,
 	"listen":    helpListen,
 	"logfile":   helpLogfile,
 	"log":       helpLogfile,
@@ -100,6 +101,7 @@ Commands:
 
   dashboard    Show dashboard statistics in the terminal.
   db           Modify the database and print database info.
+  apply        Create or update sites, users, and API tokens from a file.
   monitor      Monitor for pageviews.
 
 Extra help topics:
@@ -60,7 +60,7 @@ func cmdMain(f zli.Flags, ready chan<- struct{}, stop chan struct{}) {
 	defer mainDone.Done()
 
 	cmd, err := f.ShiftCommand("help", "version", "serve", "import",
-		"dashboard", "db", "monitor",
+		"dashboard", "db", "apply", "monitor",
 		"saas", "goat")
 	if zslice.ContainsAny(f.Args, "-h", "-help", "--help") {
 		f.Args = append([]string{cmd}, f.Args...)
@@ -123,6 +123,8 @@ func cmdMain(f zli.Flags, ready chan<- struct{}, stop chan struct{}) {
 		run = func(f zli.Flags, ready chan<- struct{}, stop chan struct{}) error {
 			return cmdServe(f, ready, stop, true)
 		}
+	case "apply":
+		run = cmdApply
 	case "monitor":
 		run = cmdMonitor
 	case "import":
@@ -155,7 +155,22 @@ Flags:
                    -geodb maxmind:123456:abcdef
                    -geodb maxmind:123456:abcdef:/home/goatcounter/cities.mmdb
 
-               Updates are only done on restarts.
+               This can also be a http:// or https:// URL to download the
+               database from a mirror, such as an internal server on networks
+               without internet access. The URL can point to a .mmdb file, a
+               gzip'd .mmdb.gz file, or a .tar.gz file as distributed by
+               MaxMind. It's stored in goatcounter-data/auto.mmdb.
+
+               GoatCounter checks for updates every 6 hours and reloads the
+               database without restarting: MaxMind is checked once the
+               database is a day old, mirrors are checked with
+               If-Modified-Since, and a path is reloaded if the file changed.
+
+  -asndb       Path to mmdb ASN database, such as GeoLite2-ASN. This is used
+               for the "Networks" stats and bot rules for an ASN. GoatCounter
+               will automatically use the first .mmdb file with "asn" in the
+               name in ./goatcounter-data, if any exists. The database is
+               reloaded if the file changed. Default: not set.
 
   -ratelimit   Set rate limits for various actions; the syntax is
                "name:num-requests/seconds"; multiple values are separated by
@@ -205,6 +220,7 @@ func cmdServe(f zli.Flags, ready chan<- struct{}, stop chan struct{}, saas bool)
 		errorsFlag   = f.String("", "errors")
 		from         = f.String("", "email-from")
 		geodbFlag    = f.String("", "geodb")
+		asndbFlag    = f.String("", "asndb")
 		ratelimit    = f.String("", "ratelimit")
 		apiMax       = f.Int(0, "api-max")
 		storeEvery   = f.Int(10, "store-every")
@@ -234,7 +250,8 @@ func cmdServe(f zli.Flags, ready chan<- struct{}, stop chan struct{}, saas bool)
 
 	mailer := flagEmail(&v, smtp.String())
 	flagErrors(&v, errorsFlag.String(), mailer)
-	geodb := setupGeo(&v, geodbFlag.String())
+	geodb, geoSource := setupGeo(&v, geodbFlag.String())
+	asndb, asnPath := setupASN(&v, asndbFlag.String())
 	ratelimits := setupRatelimits(&v, ratelimit.String())
 	*from.Pointer() = flagFrom(&v, saas, from.String(), domain.String())
 	domainCount, urlStatic := setupDomains(&v, saas, dev.Bool(), domain.Pointer(),
@@ -256,7 +273,10 @@ func cmdServe(f zli.Flags, ready chan<- struct{}, stop chan struct{}, saas bool)
 	defer db.Close()
 
 	ctx = z18n.With(ctx, z18n.NewBundle(language.English).Locale("en"))
-	ctx = geo.With(ctx, geodb)
+	ctx = geo.WithSource(ctx, geodb, geoSource)
+	if asndb != nil {
+		ctx = geo.WithASN(ctx, asndb, asnPath)
+	}
 	ctx = blackmail.With(ctx, mailer)
 
 	if err := setupTpl(ctx, dev.Bool()); err != nil {
@@ -503,11 +523,11 @@ func flagEmail(v *zvalidate.Validator, smtp string) blackmail.Mailer {
 	return m
 }
 
-func setupGeo(v *zvalidate.Validator, geodbFlag string) *geoip2.Reader {
+func setupGeo(v *zvalidate.Validator, geodbFlag string) (*geoip2.Reader, string) {
 	if geodbFlag == "" {
 		ls, _ := os.ReadDir("goatcounter-data")
 		for _, f := range ls {
-			if strings.HasSuffix(f.Name(), ".mmdb") {
+			if strings.HasSuffix(f.Name(), ".mmdb") && !isASN(f.Name()) {
 				geodbFlag = "goatcounter-data/" + f.Name()
 				break
 			}
@@ -517,9 +537,31 @@ func setupGeo(v *zvalidate.Validator, geodbFlag string) *geoip2.Reader {
 	if err != nil {
 		v.Append("-geodb", fmt.Sprintf("loading GeoIP database: %s", err))
 	}
-	return geodb
+	return geodb, geodbFlag
 }
 
+func setupASN(v *zvalidate.Validator, asndbFlag string) (*geoip2.Reader, string) {
+	if asndbFlag == "" {
+		ls, _ := os.ReadDir("goatcounter-data")
+		for _, f := range ls {
+			if strings.HasSuffix(f.Name(), ".mmdb") && isASN(f.Name()) {
+				asndbFlag = "goatcounter-data/" + f.Name()
+				break
+			}
+		}
+		if asndbFlag == "" {
+			return nil, ""
+		}
+	}
+	asndb, err := geo.OpenASN(asndbFlag)
+	if err != nil {
+		v.Append("-asndb", fmt.Sprintf("loading ASN database: %s", err))
+	}
+	return asndb, asndbFlag
+}
+
+func isASN(name string) bool { return strings.Contains(strings.ToLower(name), "asn") }
+
 func setupRatelimits(v *zvalidate.Validator, ratelimit string) handlers.Ratelimits {
 	h := handlers.NewRatelimits()
 	if ratelimit != "" {
@@ -61,11 +61,18 @@ var (
 	keyCachePaths      = &struct{ n string }{""}
 	keyCacheRefs       = &struct{ n string }{""}
 	keyCacheLoc        = &struct{ n string }{""}
+	keyCacheNetworks   = &struct{ n string }{""}
+	keyCacheProps      = &struct{ n string }{""}
 	keyCacheCampaigns  = &struct{ n string }{""}
 	keyChangedTitles   = &struct{ n string }{""}
+	keyCacheBotRules   = &struct{ n string }{""}
+	keyCacheRefspam    = &struct{ n string }{""}
 	keyCacheSitesProxy = &struct{ n string }{""}
 
-	keyConfig = &struct{ n string }{""}
+	keyConfig     = &struct{ n string }{""}
+	keyAuditActor = &struct{ n string }{""}
+	keyAPIToken   = &struct{ n string }{""}
+	keyShareLink  = &struct{ n string }{""}
 )
 
 type GlobalConfig struct {
@@ -154,7 +161,7 @@ func MustGetUser(ctx context.Context) *User {
 // NewContext creates a new context with all values set.
 func NewContext(ctx context.Context, db zdb.DB) context.Context {
 	n := zdb.WithDB(context.Background(), db)
-	n = geo.With(n, geo.Get(ctx))
+	n = geo.Copy(n, ctx)
 	n = NewCache(n)
 	n = NewConfig(n)
 	m := blackmail.Get(ctx)
@@ -176,8 +183,12 @@ func NewCache(ctx context.Context) context.Context {
 	ctx = context.WithValue(ctx, keyCachePaths, zcache.New[string, Path](1*time.Hour, 5*time.Minute))
 	ctx = context.WithValue(ctx, keyCacheRefs, zcache.New[string, Ref](1*time.Hour, 5*time.Minute))
 	ctx = context.WithValue(ctx, keyCacheLoc, zcache.New[string, *Location](zcache.NoExpiration, zcache.NoExpiration))
+	ctx = context.WithValue(ctx, keyCacheNetworks, zcache.New[ASN, string](24*time.Hour, 1*time.Hour))
+	ctx = context.WithValue(ctx, keyCacheProps, zcache.New[string, Prop](1*time.Hour, 5*time.Minute))
 	ctx = context.WithValue(ctx, keyCacheCampaigns, zcache.New[string, *Campaign](24*time.Hour, 15*time.Minute))
 	ctx = context.WithValue(ctx, keyChangedTitles, zcache.New[string, []string](48*time.Hour, 1*time.Hour))
+	ctx = context.WithValue(ctx, keyCacheBotRules, zcache.New[SiteID, BotRules](1*time.Hour, 5*time.Minute))
+	ctx = context.WithValue(ctx, keyCacheRefspam, zcache.New[SiteID, map[string]struct{}](1*time.Hour, 5*time.Minute))
 	return ctx
 }
 
@@ -195,8 +206,12 @@ func Caches(ctx context.Context) map[string]interface {
 		"paths":          cachePaths(ctx),
 		"refs":           cacheRefs(ctx),
 		"loc":            cacheLoc(ctx),
+		"networks":       cacheNetworks(ctx),
+		"props":          cacheProps(ctx),
 		"campaigns":      cacheCampaigns(ctx),
 		"changed-titles": cacheChangedTitles(ctx),
+		"bot-rules":      cacheBotRules(ctx),
+		"refspam":        cacheRefspam(ctx),
 	}
 }
 
@@ -253,6 +268,18 @@ func cacheLoc(ctx context.Context) *zcache.Cache[string, *Location] {
 	}
 	return zcache.New[string, *Location](0, 0)
 }
+func cacheNetworks(ctx context.Context) *zcache.Cache[ASN, string] {
+	if c := ctx.Value(keyCacheNetworks); c != nil {
+		return c.(*zcache.Cache[ASN, string])
+	}
+	return zcache.New[ASN, string](0, 0)
+}
+func cacheProps(ctx context.Context) *zcache.Cache[string, Prop] {
+	if c := ctx.Value(keyCacheProps); c != nil {
+		return c.(*zcache.Cache[string, Prop])
+	}
+	return zcache.New[string, Prop](0, 0)
+}
 func cacheCampaigns(ctx context.Context) *zcache.Cache[string, *Campaign] {
 	if c := ctx.Value(keyCacheCampaigns); c != nil {
 		return c.(*zcache.Cache[string, *Campaign])
@@ -265,6 +292,18 @@ func cacheChangedTitles(ctx context.Context) *zcache.Cache[string, []string] {
 	}
 	return zcache.New[string, []string](0, 0)
 }
+func cacheBotRules(ctx context.Context) *zcache.Cache[SiteID, BotRules] {
+	if c := ctx.Value(keyCacheBotRules); c != nil {
+		return c.(*zcache.Cache[SiteID, BotRules])
+	}
+	return zcache.New[SiteID, BotRules](0, 0)
+}
+func cacheRefspam(ctx context.Context) *zcache.Cache[SiteID, map[string]struct{}] {
+	if c := ctx.Value(keyCacheRefspam); c != nil {
+		return c.(*zcache.Cache[SiteID, map[string]struct{}])
+	}
+	return zcache.New[SiteID, map[string]struct{}](0, 0)
+}
 func cacheSitesHost(ctx context.Context) *zcache.Proxy[string, SiteID, *Site] {
 	if c := ctx.Value(keyCacheSitesProxy); c != nil {
 		return c.(*zcache.Proxy[string, SiteID, *Site])
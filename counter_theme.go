@@ -0,0 +1,210 @@
+package goatcounter
+
+import (
+	"context"
+	"html/template"
+	"image/color"
+	"io"
+	"regexp"
+	"slices"
+	"strings"
+	"text/template/parse"
+
+	"zgo.at/errors"
+	"zgo.at/z18n"
+	"zgo.at/zvalidate"
+)
+
+// Fonts that can be used for the visitor counter.
+var CounterFonts = []string{"lato", "sans-serif", "serif", "monospace"}
+
+type (
+	// CounterTheme is a theme for the visitor counter.
+	//
+	// Every field is optional; the default from the built-in counter is used if
+	// it's empty.
+	CounterTheme struct {
+		Name       string `json:"name"`
+		Color      string `json:"color,omitempty"`      // Text and border colour, as #rrggbb.
+		Background string `json:"background,omitempty"` // Background colour, as #rrggbb.
+		Font       string `json:"font,omitempty"`       // One of CounterFonts.
+		Label      string `json:"label,omitempty"`      // Text above the number ("Views for this page:").
+
+		// Custom templates; these completely replace the HTML or SVG output.
+		HTML string `json:"html,omitempty"`
+		SVG  string `json:"svg,omitempty"`
+	}
+	CounterThemes []CounterTheme
+
+	// CounterTemplateData is passed to custom counter templates.
+	CounterTemplateData struct {
+		Count string // Formatted count.
+		Path  string // Path, or "TOTAL" for the site total.
+		Label string // Label from the theme, or the default.
+		Total bool   // Displaying the site total?
+	}
+)
+
+var reCounterThemeName = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,50}$`)
+
+func (t CounterTheme) Validate(ctx context.Context) error {
+	v := NewValidate(ctx)
+
+	if !reCounterThemeName.MatchString(t.Name) {
+		v.Append("name", z18n.T(ctx, "validate/counter-theme-name|must be 1 to 50 letters, numbers, - or _"))
+	}
+	v.HexColor("color", t.Color)
+	v.HexColor("background", t.Background)
+	if t.Font != "" {
+		v.Include("font", t.Font, CounterFonts)
+	}
+	v.Len("label", t.Label, 0, 100)
+	v.Len("html", t.HTML, 0, 16384)
+	v.Len("svg", t.SVG, 0, 16384)
+	if _, err := t.Template(false); err != nil {
+		v.Append("html", err.Error())
+	}
+	if _, err := t.Template(true); err != nil {
+		v.Append("svg", err.Error())
+	}
+	return v.ErrorOrNil()
+}
+
+// Get a theme by name.
+func (t CounterThemes) Get(name string) (CounterTheme, bool) {
+	i := slices.IndexFunc(t, func(tt CounterTheme) bool { return tt.Name == name })
+	if i == -1 {
+		return CounterTheme{}, false
+	}
+	return t[i], true
+}
+
+// Set a theme, replacing any existing theme with the same name.
+func (t *CounterThemes) Set(theme CounterTheme) {
+	if i := slices.IndexFunc(*t, func(tt CounterTheme) bool { return tt.Name == theme.Name }); i > -1 {
+		(*t)[i] = theme
+		return
+	}
+	*t = append(*t, theme)
+}
+
+// Remove a theme by name.
+func (t *CounterThemes) Remove(name string) {
+	*t = slices.DeleteFunc(*t, func(tt CounterTheme) bool { return tt.Name == name })
+}
+
+// CSSFont gets the CSS font-family for this theme.
+func (t CounterTheme) CSSFont() string {
+	switch t.Font {
+	case "", "lato":
+		return "Lato"
+	default:
+		return t.Font
+	}
+}
+
+// RGB gets the text and background colours, using the defaults from the
+// built-in counter if they're not set.
+func (t CounterTheme) RGB() (fg, bg color.RGBA) {
+	fg, bg = color.RGBA{R: 0x9a, G: 0x15, B: 0xa4, A: 0xff}, color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}
+	v := zvalidate.New()
+	if t.Color != "" {
+		fg.R, fg.G, fg.B = v.HexColor("", t.Color)
+	}
+	if t.Background != "" {
+		bg.R, bg.G, bg.B = v.HexColor("", t.Background)
+	}
+	return fg, bg
+}
+
+// Template gets the custom HTML or SVG template, or nil if there is none.
+//
+// Templates can only use the fields from CounterTemplateData, "if", "with", and
+// a few safe built-in functions; anything that may loop or allocate a lot of
+// memory (range, printf, etc.) isn't allowed.
+func (t CounterTheme) Template(svg bool) (*template.Template, error) {
+	text := t.HTML
+	if svg {
+		text = t.SVG
+	}
+	if strings.TrimSpace(text) == "" {
+		return nil, nil
+	}
+
+	tpl, err := template.New(t.Name).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	if len(tpl.Templates()) > 1 {
+		return nil, errors.New("can't use define or block")
+	}
+	err = checkCounterTemplate(tpl.Tree.Root)
+	if err != nil {
+		return nil, err
+	}
+
+	// Make sure it can be executed; this also catches unknown fields.
+	err = tpl.Execute(io.Discard, CounterTemplateData{Count: "42", Path: "/", Label: "Views"})
+	if err != nil {
+		return nil, err
+	}
+	return tpl, nil
+}
+
+var counterTemplateFuncs = []string{"and", "or", "not", "eq", "ne", "lt", "le", "gt", "ge", "len"}
+
+func checkCounterTemplate(node parse.Node) error {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return nil
+		}
+		for _, nn := range n.Nodes {
+			if err := checkCounterTemplate(nn); err != nil {
+				return err
+			}
+		}
+	case *parse.ActionNode:
+		return checkCounterTemplate(n.Pipe)
+	case *parse.IfNode:
+		return checkCounterBranch(n.BranchNode)
+	case *parse.WithNode:
+		return checkCounterBranch(n.BranchNode)
+	case *parse.PipeNode:
+		if n == nil {
+			return nil
+		}
+		for _, c := range n.Cmds {
+			if err := checkCounterTemplate(c); err != nil {
+				return err
+			}
+		}
+	case *parse.CommandNode:
+		for _, a := range n.Args {
+			if err := checkCounterTemplate(a); err != nil {
+				return err
+			}
+		}
+	case *parse.IdentifierNode:
+		if !slices.Contains(counterTemplateFuncs, n.Ident) {
+			return errors.Errorf("can't use function %q", n.Ident)
+		}
+	case *parse.RangeNode:
+		return errors.New("can't use range")
+	case *parse.TemplateNode:
+		return errors.New("can't use template")
+	case *parse.ChainNode:
+		return checkCounterTemplate(n.Node)
+	}
+	return nil
+}
+
+func checkCounterBranch(n parse.BranchNode) error {
+	if err := checkCounterTemplate(n.Pipe); err != nil {
+		return err
+	}
+	if err := checkCounterTemplate(n.List); err != nil {
+		return err
+	}
+	return checkCounterTemplate(n.ElseList)
+}
@@ -0,0 +1,65 @@
+package goatcounter_test
+
+import (
+	"strings"
+	"testing"
+
+	. "zgo.at/goatcounter/v2"
+	"zgo.at/goatcounter/v2/gctest"
+)
+
+func TestCounterThemeValidate(t *testing.T) {
+	ctx := gctest.DB(t)
+
+	tests := []struct {
+		in      CounterTheme
+		wantErr string
+	}{
+		{CounterTheme{Name: "dark", Color: "#fff", Background: "#222222", Font: "serif", Label: "Visitors"}, ""},
+		{CounterTheme{Name: "x", HTML: `{{if .Total}}Site{{else}}{{.Path}}{{end}}: {{.Count}}`}, ""},
+		{CounterTheme{Name: "x", SVG: `<svg><text>{{with .Label}}{{.}}{{end}} {{if eq .Count "0"}}none{{end}}</text></svg>`}, ""},
+
+		{CounterTheme{Name: ""}, "name: must be"},
+		{CounterTheme{Name: "a b"}, "name: must be"},
+		{CounterTheme{Name: "x", Color: "red"}, "color: must be a valid color code"},
+		{CounterTheme{Name: "x", Font: "comic-sans"}, "font: must be one of"},
+		{CounterTheme{Name: "x", HTML: `{{.Count`}, "html: "},
+		{CounterTheme{Name: "x", HTML: `{{.Nope}}`}, "html: "},
+		{CounterTheme{Name: "x", HTML: `{{range .Count}}{{end}}`}, "html: can't use range"},
+		{CounterTheme{Name: "x", HTML: `{{printf "%s" .Count}}`}, `html: can't use function "printf"`},
+		{CounterTheme{Name: "x", SVG: `{{define "a"}}x{{end}}`}, "svg: can't use define or block"},
+		{CounterTheme{Name: "x", SVG: `{{if true}}{{template "x"}}{{end}}`}, "svg: "},
+	}
+
+	for _, tt := range tests {
+		t.Run("", func(t *testing.T) {
+			err := tt.in.Validate(ctx)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("\nhave: %v\nwant: %s", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCounterThemeEscape(t *testing.T) {
+	theme := CounterTheme{Name: "x", HTML: `<span title="{{.Label}}">{{.Path}}</span>`}
+	tpl, err := theme.Template(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b strings.Builder
+	err = tpl.Execute(&b, CounterTemplateData{Path: `/<script>`, Label: `"x"`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `<span title="&#34;x&#34;">/&lt;script&gt;</span>`; b.String() != want {
+		t.Errorf("\nhave: %s\nwant: %s", b.String(), want)
+	}
+}
@@ -34,6 +34,12 @@ var Tasks = []Task{
 	{"cycle sessions", sessions, 1 * time.Minute},
 	{"persist hits", persistAndStat, time.Duration(persistInterval.Load())},
 	{"vacuum filters", oldFilters, 1 * time.Hour},
+	{"detect refspam", detectRefspam, 24 * time.Hour},
+	{"detect anomalies", detectAnomalies, 6 * time.Hour},
+	{"update GeoIP database", updateGeoDB, 6 * time.Hour},
+	{"vacuum API token logs", oldAPITokenLog, 24 * time.Hour},
+	{"vacuum WebAuthn challenges", oldWebAuthnChallenges, 1 * time.Hour},
+	{"email about expiring API tokens", APITokenExpiry, 1 * time.Hour},
 }
 
 var (
@@ -0,0 +1,52 @@
+package cron_test
+
+import (
+	"testing"
+	"time"
+
+	"zgo.at/goatcounter/v2"
+	"zgo.at/goatcounter/v2/gctest"
+	"zgo.at/zdb"
+)
+
+func TestDeviceStats(t *testing.T) {
+	ctx := gctest.DB(t)
+
+	site := goatcounter.MustGetSite(ctx)
+	now := time.Date(2019, 8, 31, 14, 42, 0, 0, time.UTC)
+
+	gctest.StoreHits(ctx, t, false, []goatcounter.Hit{
+		{Site: site.ID, CreatedAt: now, FirstVisit: true,
+			UserAgentHeader: "Mozilla/5.0 (X11; Linux x86_64; rv:79.0) Gecko/20100101 Firefox/79.0"},
+		{Site: site.ID, CreatedAt: now, FirstVisit: true,
+			UserAgentHeader: "Mozilla/5.0 (iPhone; CPU iPhone OS 17_1 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Mobile/15E148 Safari/604.1"},
+	}...)
+
+	// Stored in hits as well, so the stats can be regenerated from that.
+	have := zdb.DumpString(ctx, `select device, engine from hits order by hit_id`)
+	want := `
+		device   engine
+		desktop  Gecko
+		mobile   WebKit`
+	if d := zdb.Diff(have, want); d != "" {
+		t.Error(d)
+	}
+
+	have = zdb.DumpString(ctx, `select device, count from device_stats order by device`)
+	want = `
+		device   count
+		desktop  1
+		mobile   1`
+	if d := zdb.Diff(have, want); d != "" {
+		t.Error(d)
+	}
+
+	have = zdb.DumpString(ctx, `select engine, count from engine_stats order by engine`)
+	want = `
+		engine  count
+		Gecko   1
+		WebKit  1`
+	if d := zdb.Diff(have, want); d != "" {
+		t.Error(d)
+	}
+}
@@ -1,16 +1,24 @@
 package cron
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
 	"fmt"
 	"html/template"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
 	"math"
+	"slices"
 	"strings"
 
 	"zgo.at/blackmail"
 	"zgo.at/errors"
 	"zgo.at/goatcounter/v2"
 	"zgo.at/goatcounter/v2/pkg/log"
+	"zgo.at/goatcounter/v2/widgets"
 	"zgo.at/zdb"
 	"zgo.at/zstd/zstring"
 	"zgo.at/zstd/ztime"
@@ -48,21 +56,16 @@ func EmailReports(ctx context.Context) error {
 			continue
 		}
 
-		text, html, subject, err := reportText(ctx, site, user)
+		report, err := RenderReport(ctx, site, user, rng)
 		if err != nil {
 			return fmt.Errorf("cron.emailReports: user=%d: %w", user.ID, err)
 		}
-		if text == nil {
+		if report == nil {
 			el.Debug(ctx, "no text: bailing")
 			continue
 		}
 
-		err = blackmail.Get(ctx).Send(subject,
-			blackmail.From("GoatCounter reports", goatcounter.Config(ctx).EmailFrom),
-			blackmail.To(user.Email),
-			blackmail.HeadersAutoreply(),
-			blackmail.BodyText(text),
-			blackmail.BodyHTML(html))
+		err = SendReport(ctx, user, report, true)
 		if err != nil {
 			el.Error(ctx, err)
 			continue
@@ -76,6 +79,39 @@ func EmailReports(ctx context.Context) error {
 	return nil
 }
 
+// SendReport sends a report to the user, and the confirmed extra recipients
+// from the user's settings if extra is set and the user is an admin.
+func SendReport(ctx context.Context, user goatcounter.User, report *Report, extra bool) error {
+	rcpt := blackmail.To(user.Email)
+	if extra && user.AccessAdmin() && len(user.Settings.EmailReportOptions.Recipients) > 0 {
+		var list goatcounter.ReportRecipients
+		err := list.List(ctx, user.ID)
+		if err != nil {
+			return err
+		}
+		if c := list.Confirmed(); len(c) > 0 {
+			rcpt = append(rcpt, blackmail.Bcc(c...)...)
+		}
+	}
+
+	return blackmail.Get(ctx).Send(report.Subject,
+		blackmail.From("GoatCounter reports", goatcounter.Config(ctx).EmailFrom),
+		rcpt,
+		blackmail.HeadersAutoreply(),
+		blackmail.BodyText(report.Text),
+		blackmail.BodyHTML(report.HTML, inlineImages(report.Charts, blackmail.InlineImage)...))
+}
+
+// inlineImages creates the inline image parts for the charts; this is generic
+// as blackmail doesn't export the part type.
+func inlineImages[T any](charts [][]byte, inline func(ct, name string, body []byte) T) []T {
+	images := make([]T, 0, len(charts))
+	for i, c := range charts {
+		images = append(images, inline("image/png", fmt.Sprintf("chart-%d.png", i+1), c))
+	}
+	return images
+}
+
 // Get list of all users to send reports for.
 func reportUsers(ctx context.Context) (goatcounter.Users, error) {
 	query := `
@@ -94,111 +130,194 @@ func reportUsers(ctx context.Context) (goatcounter.Users, error) {
 	return users, errors.Wrap(err, "get users")
 }
 
+// Report is a rendered email report.
+type Report struct {
+	Subject    string
+	Text, HTML []byte
+
+	// PNG charts; the HTML references these as "cid:blackmail:1",
+	// "cid:blackmail:2", etc.
+	Charts [][]byte
+}
+
+// HTMLInline gets the HTML with the charts inlined as data: URLs, for
+// displaying outside of an email.
+func (r Report) HTMLInline() []byte {
+	html := r.HTML
+	for i, c := range r.Charts {
+		html = bytes.ReplaceAll(html, fmt.Appendf(nil, `src="cid:blackmail:%d"`, i+1),
+			[]byte(`src="data:image/png;base64,`+base64.StdEncoding.EncodeToString(c)+`"`))
+	}
+	return html
+}
+
 type (
 	reportArgs struct {
 		Context     context.Context
 		Account     goatcounter.Site
 		User        goatcounter.User
+		Options     goatcounter.EmailReportOptions
 		DisplayDate string
 		Sites       []reportArgsSite
 	}
 	reportArgsSite struct {
 		URL                          string
+		Visitors                     int
+		VisitorsDiff                 string
+		Chart                        int // Number of the inline chart, or 0 if there is none.
 		Pages                        goatcounter.HitLists
 		Total                        goatcounter.HitList
 		Refs                         goatcounter.HitStats
+		Stats                        []reportArgsStats
+		Anomalies                    goatcounter.Anomalies
 		TextPagesTable, TextRefTable template.HTML
+		TextAnomalyTable             template.HTML
 		Diffs                        []string
 	}
+	reportArgsStats struct {
+		Label     string
+		Stats     goatcounter.HitStats
+		TextTable template.HTML
+	}
 )
 
-func reportText(ctx context.Context, account goatcounter.Site, user goatcounter.User) (text, html []byte, subject string, err error) {
+// RenderReport renders the email report for all sites in the account.
+//
+// This will return nil if there were no visitors in the time range.
+func RenderReport(ctx context.Context, account goatcounter.Site, user goatcounter.User, rng ztime.Range) (*Report, error) {
 	var sites goatcounter.Sites
-	err = sites.ForAccount(ctx, account.ID)
+	err := sites.ForAccount(ctx, account.ID)
 	if err != nil {
-		return nil, nil, "", err
+		return nil, err
 	}
 
-	rng := user.EmailReportRange(ctx).UTC()
+	opts := user.Settings.EmailReportOptions
+	opts.Defaults()
+
 	args := reportArgs{
 		Context:     ctx,
 		Account:     account,
 		User:        user,
+		Options:     opts,
 		DisplayDate: rng.Start.Format(user.Settings.DateFormat),
 	}
 	if user.Settings.EmailReports != goatcounter.EmailReportDaily {
 		args.DisplayDate += " – " + rng.End.Format(user.Settings.DateFormat)
 	}
-	subject = fmt.Sprintf("Your GoatCounter report for %s", args.DisplayDate)
+	report := Report{Subject: fmt.Sprintf("Your GoatCounter report for %s", args.DisplayDate)}
 
 	for _, s := range sites {
-		sa, err := reportTextSite(ctx, s, user)
+		sa, err := reportTextSite(ctx, s, user, opts, rng)
 		if err != nil {
-			return nil, nil, "", err
+			return nil, err
 		}
-		if len(sa.Pages) > 0 {
-			args.Sites = append(args.Sites, sa)
+		if sa.Visitors == 0 {
+			continue
 		}
+		if opts.Chart {
+			chart, err := reportChart(sa.Total.Stats)
+			if err != nil {
+				return nil, err
+			}
+			report.Charts = append(report.Charts, chart)
+			sa.Chart = len(report.Charts)
+		}
+		args.Sites = append(args.Sites, sa)
 	}
 	if len(args.Sites) == 0 {
-		return nil, nil, "", nil
+		return nil, nil
 	}
 
-	text, err = ztpl.ExecuteBytes("email_report.gotxt", args)
+	report.Text, err = ztpl.ExecuteBytes("email_report.gotxt", args)
 	if err != nil {
-		return nil, nil, "", errors.Errorf("cron.report text: %w", err)
+		return nil, errors.Errorf("cron.report text: %w", err)
 	}
-	html, err = ztpl.ExecuteBytes("email_report.gohtml", args)
+	report.HTML, err = ztpl.ExecuteBytes("email_report.gohtml", args)
 	if err != nil {
-		return nil, nil, "", errors.Errorf("cron.report html: %w", err)
+		return nil, errors.Errorf("cron.report html: %w", err)
+	}
+	return &report, nil
+}
+
+// reportDiff formats the difference as a percentage.
+func reportDiff(d float64) string {
+	switch {
+	case math.IsInf(d, 0) || math.IsNaN(d):
+		return "(new)"
+	case d < 0:
+		return fmt.Sprintf("%+.0f%%", d)
+	default:
+		return fmt.Sprintf("%.0f%%", d)
 	}
-	return text, html, subject, nil
 }
 
-func reportTextSite(ctx context.Context, site goatcounter.Site, user goatcounter.User) (reportArgsSite, error) {
+func reportTextSite(ctx context.Context, site goatcounter.Site, user goatcounter.User,
+	opts goatcounter.EmailReportOptions, rng ztime.Range,
+) (reportArgsSite, error) {
 	ctx = goatcounter.WithSite(ctx, &site)
+	ctx = goatcounter.WithUser(ctx, &user)
 	var (
 		args = reportArgsSite{URL: site.URL(ctx)}
-		rng  = user.EmailReportRange(ctx).UTC()
+		d    = -rng.End.Sub(rng.Start)
+		prev = ztime.NewRange(rng.Start.Add(d)).To(rng.End.Add(d))
+		pf   goatcounter.PathFilter
+		err  error
 	)
-
-	{ // Get overview of paths.
-		_, _, err := args.Pages.List(ctx, rng, goatcounter.PathFilter{}, nil, 10, goatcounter.GroupDaily)
+	if opts.Filter != "" {
+		pf, err = goatcounter.PathFilterFromQuery(ctx, opts.Filter)
 		if err != nil {
 			return args, err
 		}
+	}
 
-		if len(args.Pages) == 0 { /// No pages: don't bother sending out anything.
+	{ // Get totals.
+		err := args.Total.Totals(ctx, rng, pf, goatcounter.GroupDaily, true)
+		if err != nil {
+			return args, err
+		}
+		args.Visitors = args.Total.Count
+		if args.Visitors == 0 { // No visitors: don't bother sending out anything.
 			return args, nil
 		}
 
-		err = args.Total.Totals(ctx, rng, goatcounter.PathFilter{}, goatcounter.GroupDaily, true)
-		if err != nil {
-			return args, err
+		if opts.Compare {
+			var prevTotal goatcounter.HitList
+			err := prevTotal.Totals(ctx, prev, pf, goatcounter.GroupDaily, true)
+			if err != nil {
+				return args, err
+			}
+			if prevTotal.Count > 0 {
+				args.VisitorsDiff = reportDiff(float64(args.Visitors-prevTotal.Count) / float64(prevTotal.Count) * 100)
+			}
 		}
+	}
 
-		d := -rng.End.Sub(rng.Start)
-		prev := ztime.NewRange(rng.Start.Add(d)).To(rng.End.Add(d))
-		diffs, err := args.Pages.Diff(ctx, rng, prev)
+	if slices.Contains(opts.Widgets, "pages") { // Get overview of paths.
+		_, _, err := args.Pages.List(ctx, rng, pf, nil, 10, goatcounter.GroupDaily)
 		if err != nil {
 			return args, err
 		}
 
+		var diffs []float64
+		if opts.Compare {
+			diffs, err = args.Pages.Diff(ctx, rng, prev)
+			if err != nil {
+				return args, err
+			}
+		}
+
 		diffStr := make([]string, len(args.Pages))
 		for i := range diffs {
-			switch {
-			case math.IsInf(diffs[i], 0):
-				diffStr[i] = "(new)"
-			case diffs[i] < 0:
-				diffStr[i] = fmt.Sprintf("%+.0f%%", diffs[i])
-			default:
-				diffStr[i] = fmt.Sprintf("%.0f%%", diffs[i])
-			}
+			diffStr[i] = reportDiff(diffs[i])
 		}
 		args.Diffs = diffStr
 
 		b := new(strings.Builder)
-		fmt.Fprintf(b, "    %-36s  %9s  %7s\n", "Path", "Visitors", "Growth")
+		if opts.Compare {
+			fmt.Fprintf(b, "    %-36s  %9s  %7s\n", "Path", "Visitors", "Growth")
+		} else {
+			fmt.Fprintf(b, "    %-45s  %9s\n", "Path", "Visitors")
+		}
 		b.WriteString("    " + strings.Repeat("-", 56) + "\n")
 		for i, p := range args.Pages {
 			path := p.Path
@@ -206,35 +325,147 @@ func reportTextSite(ctx context.Context, site goatcounter.Site, user goatcounter
 				path += " (e)"
 			}
 
-			fmt.Fprintf(b, "    %-36s  %9s  %7s\n",
-				template.HTMLEscapeString(zstring.ElideLeft(path, 35)),
-				tplfunc.Number(p.Count, user.Settings.NumberFormat),
-				diffStr[i])
+			if opts.Compare {
+				fmt.Fprintf(b, "    %-36s  %9s  %7s\n",
+					template.HTMLEscapeString(zstring.ElideLeft(path, 35)),
+					tplfunc.Number(p.Count, user.Settings.NumberFormat),
+					diffStr[i])
+			} else {
+				fmt.Fprintf(b, "    %-45s  %9s\n",
+					template.HTMLEscapeString(zstring.ElideLeft(path, 44)),
+					tplfunc.Number(p.Count, user.Settings.NumberFormat))
+			}
 		}
 		args.TextPagesTable = template.HTML(b.String())
 	}
 
-	{ // Get overview of refs.
-		err := args.Refs.ListTopRefs(ctx, rng, goatcounter.PathFilter{}, 10, 0)
+	if slices.Contains(opts.Widgets, "toprefs") { // Get overview of refs.
+		err := args.Refs.ListTopRefs(ctx, rng, pf, 10, 0)
 		if err != nil {
 			return args, err
 		}
+		args.TextRefTable = reportTable("Referrer", args.Refs, user)
+	}
 
-		b := new(strings.Builder)
-		fmt.Fprintf(b, "    %-45s  %9s\n", "Referrer", "Visitors")
-		b.WriteString("    " + strings.Repeat("-", 56) + "\n")
-		for _, r := range args.Refs.Stats {
-			path := r.Name
-			if path == "" {
-				path = "(no data)"
-			}
-			fmt.Fprintf(b, "    %-45s  %9s\n",
-				template.HTMLEscapeString(zstring.ElideLeft(path, 44)),
-				tplfunc.Number(r.Count, user.Settings.NumberFormat))
-			//refs[i].Path = zstring.ElideLeft(refs[i].Path, 44)
+	if slices.Contains(opts.Widgets, "anomalies") { // Get unusual days.
+		err := args.Anomalies.List(ctx, rng, pf, 10)
+		if err != nil {
+			return args, err
+		}
+		if len(args.Anomalies) > 0 {
+			args.TextAnomalyTable = reportAnomalyTable(args.Anomalies, user)
 		}
-		args.TextRefTable = template.HTML(b.String())
+	}
+
+	for _, w := range opts.Widgets {
+		if w == "pages" || w == "toprefs" || w == "anomalies" {
+			continue
+		}
+
+		var stats goatcounter.HitStats
+		switch w {
+		case "browsers":
+			err = stats.ListBrowsers(ctx, rng, pf, 10, 0)
+		case "systems":
+			err = stats.ListSystems(ctx, rng, pf, 10, 0)
+		case "sizes":
+			err = stats.ListSizes(ctx, rng, pf, true)
+		case "locations":
+			err = stats.ListLocations(ctx, rng, pf, 10, 0)
+		case "languages":
+			err = stats.ListLanguages(ctx, rng, pf, 10, 0)
+		case "networks":
+			err = stats.ListNetworks(ctx, rng, pf, 10, 0)
+		case "devices":
+			err = stats.ListDevices(ctx, rng, pf, 10, 0)
+		case "engines":
+			err = stats.ListEngines(ctx, rng, pf, 10, 0)
+		case "props":
+			err = stats.ListProps(ctx, rng, pf, 10, 0)
+		case "campaigns":
+			err = stats.ListCampaigns(ctx, rng, pf, 10, 0)
+		case "channels":
+			err = stats.ListChannels(ctx, rng, pf, 10, 0)
+		}
+		if err != nil {
+			return args, err
+		}
+		stats.Stats = stats.Stats[:min(len(stats.Stats), 10)]
+
+		label := widgets.NewWidget(ctx, w, 0).Label(ctx)
+		args.Stats = append(args.Stats, reportArgsStats{
+			Label:     label,
+			Stats:     stats,
+			TextTable: reportTable(label, stats, user),
+		})
 	}
 
 	return args, nil
 }
+
+// reportTable formats the stats as a text table.
+func reportTable(header string, stats goatcounter.HitStats, user goatcounter.User) template.HTML {
+	b := new(strings.Builder)
+	fmt.Fprintf(b, "    %-45s  %9s\n", header, "Visitors")
+	b.WriteString("    " + strings.Repeat("-", 56) + "\n")
+	for _, r := range stats.Stats {
+		name := r.Name
+		if name == "" {
+			name = "(no data)"
+		}
+		fmt.Fprintf(b, "    %-45s  %9s\n",
+			template.HTMLEscapeString(zstring.ElideLeft(name, 44)),
+			tplfunc.Number(r.Count, user.Settings.NumberFormat))
+	}
+	return template.HTML(b.String())
+}
+
+// reportAnomalyTable formats the anomalies as a text table.
+func reportAnomalyTable(anomalies goatcounter.Anomalies, user goatcounter.User) template.HTML {
+	b := new(strings.Builder)
+	fmt.Fprintf(b, "    %-10s  %-24s  %8s  %8s\n", "Day", "Path", "Visitors", "Expected")
+	b.WriteString("    " + strings.Repeat("-", 56) + "\n")
+	for _, a := range anomalies {
+		path := a.Path
+		if a.PathID == 0 {
+			path = "(all pages)"
+		}
+		fmt.Fprintf(b, "    %-10s  %-24s  %8s  %8s\n",
+			a.Day,
+			template.HTMLEscapeString(zstring.ElideLeft(path, 23)),
+			tplfunc.Number(a.Count, user.Settings.NumberFormat),
+			tplfunc.Number(int(math.Round(a.Expected)), user.Settings.NumberFormat))
+	}
+	return template.HTML(b.String())
+}
+
+// reportChart draws a bar chart of the visitors per day.
+func reportChart(stats []goatcounter.HitListStat) ([]byte, error) {
+	const width, height, pad = 600, 120, 2
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+
+	maxDay := 1
+	for _, s := range stats {
+		maxDay = max(maxDay, s.Daily)
+	}
+	if len(stats) > 0 {
+		var (
+			bar  = image.NewUniform(color.RGBA{R: 0x9a, G: 0x15, B: 0xa4, A: 0xff})
+			step = float64(width) / float64(len(stats))
+		)
+		for i, s := range stats {
+			h := int(float64(s.Daily) / float64(maxDay) * (height - pad))
+			x0, x1 := int(float64(i)*step), int(float64(i+1)*step)
+			if x1-x0 > 2 {
+				x1-- // Small gap between the bars.
+			}
+			draw.Draw(img, image.Rect(x0, height-h, x1, height), bar, image.Point{}, draw.Src)
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	err := png.Encode(buf, img)
+	return buf.Bytes(), err
+}
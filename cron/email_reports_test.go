@@ -179,6 +179,7 @@ func TestEmailReports(t *testing.T) {
 				(no data)                                              1
 				yy                                                     1
 				https://bb.test
+				Visitors: 9
 				Path                                   Visitors   Growth
 				/b                                            3    (new)
 				/d                                            2    (new)
@@ -228,3 +229,62 @@ func TestEmailReports(t *testing.T) {
 		})
 	}
 }
+
+func TestRenderReport(t *testing.T) {
+	files, _ := fs.Sub(os.DirFS(zgo.ModuleRoot()), "tpl")
+	err := ztpl.Init(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := ztime.WithNow(gctest.DB(t), time.Date(2019, 6, 17, 0, 1, 0, 0, time.UTC))
+	ctx = gctest.Site(ctx, t, nil, &goatcounter.User{
+		LastReportAt: ztime.Now(ctx).Add(-167 * time.Hour),
+		Settings: goatcounter.UserSettings{
+			EmailReports: goatcounter.EmailReportWeekly,
+			Timezone:     tz.UTC,
+			EmailReportOptions: goatcounter.EmailReportOptions{
+				Widgets: goatcounter.Strings{"browsers"},
+				Chart:   true,
+			},
+		},
+	})
+	sID := goatcounter.MustGetSite(ctx).ID
+	gctest.StoreHits(ctx, t, false,
+		goatcounter.Hit{Site: sID, FirstVisit: true, Path: "/a", CreatedAt: ztime.Now(ctx).Add(-1 * time.Hour),
+			UserAgentHeader: "Mozilla/5.0 (X11; Linux x86_64; rv:81.0) Gecko/20100101 Firefox/81.0"},
+		goatcounter.Hit{Site: sID, FirstVisit: true, Path: "/b", CreatedAt: ztime.Now(ctx).Add(-48 * time.Hour),
+			UserAgentHeader: "Mozilla/5.0 (X11; Linux x86_64; rv:81.0) Gecko/20100101 Firefox/81.0"},
+	)
+
+	user := goatcounter.MustGetUser(ctx)
+	report, err := cron.RenderReport(ctx, *goatcounter.MustGetSite(ctx), *user, user.EmailReportRange(ctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report == nil {
+		t.Fatal("report is nil")
+	}
+
+	text := string(report.Text)
+	for _, want := range []string{"Visitors: 2", "Browsers", "Firefox"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("%q not in text:\n%s", want, text)
+		}
+	}
+	for _, notWant := range []string{"Top 10 pages", "Top 10 referrers", "compared to the previous period"} {
+		if strings.Contains(text, notWant) {
+			t.Errorf("%q in text:\n%s", notWant, text)
+		}
+	}
+
+	if len(report.Charts) != 1 {
+		t.Fatalf("len(Charts) = %d", len(report.Charts))
+	}
+	if !strings.Contains(string(report.HTML), `src="cid:blackmail:1"`) {
+		t.Errorf("no chart in HTML:\n%s", report.HTML)
+	}
+	if !strings.Contains(string(report.HTMLInline()), `src="data:image/png;base64,`) {
+		t.Errorf("chart not inlined:\n%s", report.HTMLInline())
+	}
+}
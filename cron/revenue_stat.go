@@ -0,0 +1,64 @@
+package cron
+
+import (
+	"context"
+	"strconv"
+
+	"zgo.at/errors"
+	"zgo.at/goatcounter/v2"
+	"zgo.at/zdb"
+)
+
+func updateRevenueStats(ctx context.Context, hits []goatcounter.Hit) error {
+	err := zdb.TX(ctx, func(ctx context.Context) error {
+		type gt struct {
+			count      int
+			total      goatcounter.Money
+			day        string
+			refID      goatcounter.RefID
+			campaignID goatcounter.CampaignID
+			currency   string
+			pathID     goatcounter.PathID
+		}
+		grouped := map[string]gt{}
+		for _, h := range hits {
+			if h.Bot > 0 || h.Value == 0 {
+				continue
+			}
+
+			var campaignID goatcounter.CampaignID
+			if h.RevenueCampaignID != nil {
+				campaignID = *h.RevenueCampaignID
+			}
+
+			day := h.CreatedAt.Format("2006-01-02")
+			k := day + h.Currency + strconv.Itoa(int(h.RevenueRefID)) + "-" +
+				strconv.Itoa(int(campaignID)) + "-" + strconv.Itoa(int(h.PathID))
+			v := grouped[k]
+			if v.count == 0 {
+				v.day = day
+				v.refID = h.RevenueRefID
+				v.campaignID = campaignID
+				v.currency = h.Currency
+				v.pathID = h.PathID
+			}
+
+			// Count every event, rather than just the first visit.
+			v.count += 1
+			v.total += h.Value
+			grouped[k] = v
+		}
+
+		ins, err := goatcounter.Tables.RevenueStats.Bulk(ctx)
+		if err != nil {
+			return err
+		}
+
+		siteID := goatcounter.MustGetSite(ctx).ID
+		for _, v := range grouped {
+			ins.Values(siteID, v.pathID, v.day, v.refID, v.campaignID, v.currency, v.count, v.total)
+		}
+		return ins.Finish()
+	})
+	return errors.Wrap(err, "cron.updateRevenueStats")
+}
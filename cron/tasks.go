@@ -8,10 +8,12 @@ import (
 	"strings"
 	"time"
 
+	"zgo.at/blackmail"
 	"zgo.at/errors"
 	"zgo.at/goatcounter/v2"
 	"zgo.at/goatcounter/v2/acme"
 	"zgo.at/goatcounter/v2/pkg/db2"
+	"zgo.at/goatcounter/v2/pkg/geo"
 	"zgo.at/goatcounter/v2/pkg/log"
 	"zgo.at/zdb"
 	"zgo.at/zstd/ztime"
@@ -83,6 +85,34 @@ func oldBot(ctx context.Context) error {
 	return nil
 }
 
+func detectRefspam(ctx context.Context) error {
+	n, err := goatcounter.DetectRefspam(ctx)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		log.Module("cron").Infof(ctx, "detected %d possible refspam hosts for review", n)
+	}
+	return nil
+}
+
+func detectAnomalies(ctx context.Context) error {
+	n, err := goatcounter.DetectAnomalies(ctx)
+	if n > 0 {
+		log.Module("cron").Debugf(ctx, "detected %d anomalies", n)
+	}
+	return err
+}
+
+func updateGeoDB(ctx context.Context) error {
+	_, err := geo.Update(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = geo.UpdateASN(ctx)
+	return err
+}
+
 func persistAndStat(ctx context.Context) error {
 	l := log.Module("cron")
 	l.Debug(ctx, "persistAndStat started")
@@ -94,6 +124,11 @@ func persistAndStat(ctx context.Context) error {
 	}
 	tookMemstore := time.Since(start).Round(time.Millisecond)
 
+	// Not that important, so just log errors.
+	if err := goatcounter.PersistAPITokenUse(ctx); err != nil {
+		l.Error(ctx, err)
+	}
+
 	var (
 		startStats = ztime.Now(ctx)
 		grouped    = make(map[goatcounter.SiteID][]goatcounter.Hit)
@@ -142,6 +177,11 @@ func UpdateStats(ctx context.Context, site *goatcounter.Site, siteID goatcounter
 		updateSystemStats,
 		updateLocationStats,
 		updateLanguageStats,
+		updateNetworkStats,
+		updateDeviceStats,
+		updateEngineStats,
+		updatePropStats,
+		updateRevenueStats,
 		updateSizeStats,
 		updateCampaignStats,
 	}
@@ -214,8 +254,10 @@ func vacuumDeleted(ctx context.Context) error {
 		err := zdb.TX(ctx, func(ctx context.Context) error {
 			for _, t := range []string{"hits", "paths",
 				"hit_counts", "ref_counts",
-				"browser_stats", "system_stats", "location_stats", "language_stats", "size_stats",
-				"campaign_stats", "exports", "api_tokens", "users", "sites"} {
+				"browser_stats", "system_stats", "location_stats", "language_stats", "network_stats", "device_stats", "engine_stats", "prop_stats", "revenue_stats", "size_stats",
+				"campaign_stats", "props", "search_queries", "exports", "api_tokens", "bots", "bot_rules", "refspam", "channel_rules", "oidc",
+				"webauthn_credentials", "webauthn_challenges", "recovery_codes", "report_recipients", "audit_log", "api_token_log", "share_links", "anomalies", "annotations",
+				"users", "sites"} {
 
 				err := zdb.Exec(ctx, fmt.Sprintf(`delete from %s where site_id=%d`, t, s.ID))
 				if err != nil {
@@ -252,6 +294,60 @@ func oldFilters(ctx context.Context) error {
 	})
 }
 
+func oldAPITokenLog(ctx context.Context) error {
+	err := zdb.Exec(ctx, `delete from api_token_log where created_at < `+goatcounter.Interval(ctx, 30))
+	return errors.Wrap(err, "cron.oldAPITokenLog")
+}
+
+func oldWebAuthnChallenges(ctx context.Context) error {
+	err := zdb.Exec(ctx, `delete from webauthn_challenges where created_at < $1`,
+		ztime.Now(ctx).Add(-goatcounter.WebAuthnChallengeExpiry))
+	return errors.Wrap(err, "cron.oldWebAuthnChallenges")
+}
+
+// APITokenExpiry emails the owners of API tokens that expire in the next week.
+func APITokenExpiry(ctx context.Context) error {
+	var tokens goatcounter.APITokens
+	err := tokens.ExpiringSoon(ctx, 7*24*time.Hour)
+	if err != nil {
+		return errors.Errorf("cron.APITokenExpiry: %w", err)
+	}
+
+	l := log.Module("api-token-expiry")
+	for _, t := range tokens {
+		var site goatcounter.Site
+		err := site.ByID(ctx, t.SiteID)
+		if err != nil {
+			l.Error(ctx, err)
+			continue
+		}
+		var user goatcounter.User
+		err = user.ByID(ctx, t.UserID)
+		if err != nil {
+			l.Error(ctx, err)
+			continue
+		}
+
+		err = blackmail.Get(ctx).Send(
+			fmt.Sprintf("Your GoatCounter API token %q expires soon", t.Name),
+			blackmail.From("GoatCounter", goatcounter.Config(ctx).EmailFrom),
+			blackmail.To(user.Email),
+			blackmail.HeadersAutoreply(),
+			blackmail.BodyMustText(goatcounter.TplEmailAPITokenExpiry{
+				Context: ctx, Site: site, User: user, Token: t}.Render))
+		if err != nil {
+			l.Error(ctx, err)
+			continue
+		}
+
+		err = t.NotifiedExpiry(ctx)
+		if err != nil {
+			l.Error(ctx, err)
+		}
+	}
+	return nil
+}
+
 func sessions(ctx context.Context) error {
 	goatcounter.Memstore.EvictSessions(ctx)
 	return nil
@@ -1,10 +1,13 @@
 package cron_test
 
 import (
+	"bytes"
 	"fmt"
+	"strings"
 	"testing"
 	"time"
 
+	"zgo.at/blackmail"
 	"zgo.at/goatcounter/v2"
 	"zgo.at/goatcounter/v2/cron"
 	"zgo.at/goatcounter/v2/gctest"
@@ -61,3 +64,40 @@ func TestDataRetention(t *testing.T) {
 		t.Errorf("\ngot:  %s\nwant: %s", out, want)
 	}
 }
+
+func TestAPITokenExpiry(t *testing.T) {
+	ctx := gctest.DB(t)
+	buf := new(bytes.Buffer)
+	ctx = blackmail.With(ctx, blackmail.NewWriter(buf))
+
+	for _, tok := range []goatcounter.APIToken{
+		{Name: "token-a", Permissions: goatcounter.APIPermStats, ExpiresAt: new(ztime.Now(ctx).Add(3 * 24 * time.Hour))},
+		{Name: "token-b", Permissions: goatcounter.APIPermStats, ExpiresAt: new(ztime.Now(ctx).Add(30 * 24 * time.Hour))},
+		{Name: "token-c", Permissions: goatcounter.APIPermStats},
+	} {
+		err := tok.Insert(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := cron.APITokenExpiry(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have := strings.Count(buf.String(), "Subject: "); have != 1 {
+		t.Fatalf("sent %d emails:\n%s", have, buf.String())
+	}
+	if !strings.Contains(buf.String(), "token-a") || strings.Contains(buf.String(), "token-b") {
+		t.Errorf("wrong email:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	err = cron.APITokenExpiry(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "" {
+		t.Errorf("sent email twice:\n%s", buf.String())
+	}
+}
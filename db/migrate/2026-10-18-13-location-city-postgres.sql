@@ -0,0 +1,7 @@
+alter table locations add column city      varchar not null default '';
+alter table locations add column city_name varchar not null default '';
+
+alter table locations drop column iso_3166_2;
+alter table locations add column iso_3166_2 varchar generated always as
+	(country || (case region when '' then '' else ('-' || region) end) || (case city when '' then '' else (':' || city) end)) stored;
+create unique index "locations#iso_3166_2" on locations(iso_3166_2);
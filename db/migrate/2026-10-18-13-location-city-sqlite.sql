@@ -0,0 +1,20 @@
+create table locations_new (
+	location_id    integer        primary key autoincrement,
+
+	country        varchar        not null,
+	region         varchar        not null,
+	country_name   varchar        not null,
+	region_name    varchar        not null,
+	city           varchar        not null default '',
+	city_name      varchar        not null default '',
+	iso_3166_2     varchar        generated always as (country || (case region when '' then '' else ('-' || region) end) || (case city when '' then '' else (':' || city) end)) stored
+);
+
+insert into locations_new (location_id, country, region, country_name, region_name)
+	select location_id, country, region, country_name, region_name
+	from locations;
+
+drop table locations;
+
+alter table locations_new rename to locations;
+create unique index "locations#iso_3166_2" on locations(iso_3166_2);
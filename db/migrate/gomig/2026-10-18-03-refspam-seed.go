@@ -0,0 +1,12 @@
+package gomig
+
+import (
+	"context"
+
+	"zgo.at/goatcounter/v2"
+)
+
+func RefspamSeed(ctx context.Context) error {
+	_, err := goatcounter.SeedRefspam(ctx)
+	return err
+}
@@ -7,4 +7,5 @@ var Migrations = map[string]func(context.Context) error{
 	"2022-11-15-1-correct-hit-stats": CorrectHitStats,
 	"2025-07-01-1-share-api-tokens":  ShareAPITokens,
 	"2025-12-12-1-ref-scheme":        RefScheme,
+	"2026-10-18-03-refspam-seed":     RefspamSeed,
 }
@@ -0,0 +1,10 @@
+select
+	{{:per_path path_id,}}
+	{{:sqlite  date(hour)                  as day,}}
+	{{:sqlite! to_char(hour, 'YYYY-MM-DD') as day,}}
+	sum(total) as total
+from hit_counts
+where
+	site_id = :site and hour >= :start and hour < :end
+	{{:per_path and path_id :in (:paths)}}
+group by {{:per_path path_id,}} day
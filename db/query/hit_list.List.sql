@@ -11,6 +11,7 @@ with x as (
 		:filter and
 		hour >=:start and hour<=:end
 	group by path_id
+	{{:after having sum(total) < :a_count or (sum(total) = :a_count and path_id < :a_path)}}
 	order by total desc, path_id desc
 	limit :limit
 )
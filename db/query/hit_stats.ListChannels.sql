@@ -0,0 +1,14 @@
+with x as (
+	select
+		coalesce(ref_id, 1)     as ref_id,
+		coalesce(sum(total), 0) as count
+	from ref_counts
+	where site_id = :site and hour >= :start and hour <= :end and :filter
+	group by ref_id
+)
+select
+	x.count,
+	coalesce(refs.ref, '')         as ref,
+	coalesce(refs.ref_scheme, 'o') as ref_scheme
+from x
+left join refs using (ref_id)
@@ -0,0 +1,8 @@
+select
+	device     as id,
+	sum(count) as count
+from device_stats
+where site_id = :site and day >= :start and day <= :end and :filter
+group by device
+order by count desc, device asc
+limit :limit offset :offset
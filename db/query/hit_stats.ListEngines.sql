@@ -0,0 +1,9 @@
+select
+	engine     as id,
+	engine     as name,
+	sum(count) as count
+from engine_stats
+where site_id = :site and day >= :start and day <= :end and :filter
+group by engine
+order by count desc, engine asc
+limit :limit offset :offset
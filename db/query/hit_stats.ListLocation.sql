@@ -1,9 +1,26 @@
+{{:region
 select
-	coalesce(region_name, '(unknown)') as name,
-	sum(count)                  as count
+	locations.iso_3166_2 as id,
+	locations.city_name  as name,
+	sum(count)           as count
 from location_stats
-join locations on location = iso_3166_2
-where site_id = :site and day >= :start and day <= :end and :filter and country = :country
-group by iso_3166_2, name
+join locations on location = locations.iso_3166_2
+where site_id = :site and day >= :start and day <= :end and :filter and
+	locations.country = :country and locations.region = :region
+group by locations.iso_3166_2, locations.city_name
 order by count desc, name asc
 limit :limit offset :offset
+}}
+{{:region!
+select
+	r.iso_3166_2  as id,
+	r.region_name as name,
+	sum(count)    as count
+from location_stats
+join locations l on location = l.iso_3166_2
+join locations r on r.country = l.country and r.region = l.region and r.city = ''
+where site_id = :site and day >= :start and day <= :end and :filter and l.country = :country
+group by r.iso_3166_2, r.region_name
+order by count desc, name asc
+limit :limit offset :offset
+}}
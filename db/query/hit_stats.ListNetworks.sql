@@ -0,0 +1,20 @@
+with x as (
+	select
+		asn,
+		sum(count) as count
+	from network_stats
+	where site_id = :site and day >= :start and day <= :end and :filter
+	group by asn
+	order by count desc, asn asc
+	limit :limit offset :offset
+)
+select
+	cast(x.asn as varchar) as id,
+	case x.asn
+		when 0 then '(unknown)'
+		else coalesce(networks.name, '')
+	end                    as name,
+	x.count                as count
+from x
+left join networks on networks.asn = x.asn
+order by count desc, x.asn asc
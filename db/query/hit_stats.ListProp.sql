@@ -0,0 +1,9 @@
+select
+	props.value           as name,
+	sum(prop_stats.count) as count
+from prop_stats
+join props using (prop_id)
+where prop_stats.site_id = :site and day >= :start and day <= :end and :filter and props.key = :key
+group by props.value
+order by count desc, props.value asc
+limit :limit offset :offset
@@ -0,0 +1,9 @@
+select
+	props.key             as name,
+	sum(prop_stats.count) as count
+from prop_stats
+join props using (prop_id)
+where prop_stats.site_id = :site and day >= :start and day <= :end and :filter
+group by props.key
+order by count desc, props.key asc
+limit :limit offset :offset
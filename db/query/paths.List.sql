@@ -2,5 +2,6 @@ select * from paths
 where
 	site_id = :site
 	{{:after and path_id > :after}}
+	{{:have_only and path_id :in (:only)}}
 order by path, path_id
 {{:limit limit :limit}}
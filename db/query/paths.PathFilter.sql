@@ -9,4 +9,5 @@ where
 			:or
 			{{:match_title lower(title) :not like lower(:like)}}
 		{{:have_like )}}
+		{{:channel and path_id :not in (select path_id from ref_counts where site_id = :site and coalesce(ref_id, 1) :ref_in (:refs))}}
 	{{:invert )}}
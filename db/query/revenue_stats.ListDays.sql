@@ -0,0 +1,11 @@
+select
+	cast(day as varchar) as id,
+	cast(day as varchar) as name,
+	currency,
+	sum(count)           as count,
+	sum(total)           as total
+from revenue_stats
+where site_id = :site and day >= :start and day <= :end and :filter
+group by day, currency
+order by day asc, currency asc
+limit :limit offset :offset
@@ -0,0 +1,12 @@
+select
+	cast(revenue_stats.path_id as varchar) as id,
+	paths.path                             as name,
+	revenue_stats.currency                 as currency,
+	sum(revenue_stats.count)               as count,
+	sum(revenue_stats.total)               as total
+from revenue_stats
+join paths using (path_id)
+where revenue_stats.site_id = :site and day >= :start and day <= :end and :filter
+group by revenue_stats.path_id, paths.path, revenue_stats.currency
+order by total desc, name asc
+limit :limit offset :offset
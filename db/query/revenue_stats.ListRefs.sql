@@ -0,0 +1,11 @@
+select
+	refs.ref                 as name,
+	revenue_stats.currency   as currency,
+	sum(revenue_stats.count) as count,
+	sum(revenue_stats.total) as total
+from revenue_stats
+join refs using (ref_id)
+where revenue_stats.site_id = :site and day >= :start and day <= :end and :filter
+group by refs.ref, revenue_stats.currency
+order by total desc, name asc
+limit :limit offset :offset
@@ -0,0 +1,10 @@
+select
+	currency              as name,
+	currency,
+	sum(count)            as count,
+	sum(total)            as total
+from revenue_stats
+where site_id = :site and day >= :start and day <= :end and :filter
+group by currency
+order by total desc, currency asc
+limit :limit offset :offset
@@ -0,0 +1,19 @@
+with x as (
+	select
+		query,
+		sum(clicks)       as clicks,
+		sum(impressions)  as impressions,
+		sum(position_sum) as position_sum
+	from search_queries
+	where site_id = :site and day >= :start and day <= :end and :filter
+	group by query
+	order by clicks desc, impressions desc, query asc
+	limit :limit offset :offset
+)
+select
+	query,
+	clicks,
+	impressions,
+	case when impressions > 0 then position_sum / impressions else 0 end as position
+from x
+order by clicks desc, impressions desc, query asc
@@ -0,0 +1,124 @@
+package goatcounter
+
+import (
+	"context"
+	"strings"
+
+	"zgo.at/gadget"
+	"zgo.at/isbot"
+	"zgo.at/z18n"
+)
+
+// Device types.
+const (
+	DeviceDesktop = "desktop"
+	DeviceMobile  = "mobile"
+	DeviceTablet  = "tablet"
+	DeviceTV      = "tv"  // Smart TVs, media players, and game consoles.
+	DeviceBot     = "bot" // Detected as a bot, or no OS.
+)
+
+// DeviceName gets the human-readable name for a device type.
+func DeviceName(ctx context.Context, d string) string {
+	switch d {
+	case DeviceDesktop:
+		return z18n.T(ctx, "device/desktop|Desktop")
+	case DeviceMobile:
+		return z18n.T(ctx, "device/mobile|Mobile")
+	case DeviceTablet:
+		return z18n.T(ctx, "device/tablet|Tablet")
+	case DeviceTV:
+		return z18n.T(ctx, "device/tv|TV")
+	case DeviceBot:
+		return z18n.T(ctx, "device/bot|Bot-like")
+	}
+	return d
+}
+
+// Browser engines.
+const (
+	EngineBlink    = "Blink"
+	EngineGecko    = "Gecko"
+	EngineWebKit   = "WebKit"
+	EngineGoanna   = "Goanna"
+	EngineTrident  = "Trident"
+	EngineEdgeHTML = "EdgeHTML"
+	EnginePresto   = "Presto"
+)
+
+var (
+	tvTokens = []string{"SmartTV", "SMART-TV", "Smart-TV", "SmartTv", "GoogleTV",
+		"Android TV", "AndroidTV", "AppleTV", "Apple TV", "CrKey", "HbbTV", "NetCast",
+		"BRAVIA", "Roku", "Web0S", "webOS.TV", "Viera", "AFTB", "AFTM", "AFTS", "AFTT",
+		"PlayStation", "Xbox", "Nintendo"}
+	tabletTokens = []string{"iPad", "Tablet", "Kindle", "Silk/", "PlayBook"}
+	mobileTokens = []string{"Mobile", "iPhone", "iPod", "Windows Phone", "Opera Mini",
+		"BlackBerry", "BB10", "KAIOS", "J2ME", "Symbian"}
+)
+
+// deviceType gets the device type from a User-Agent header.
+//
+// iPads since iPadOS 13 send the same User-Agent as Safari on macOS by default,
+// so these are reported as desktop.
+func deviceType(uaHeader string, ua gadget.UserAgent, hints ClientHints, bot isbot.Result) string {
+	if isbot.Is(bot) {
+		return DeviceBot
+	}
+	for _, t := range tvTokens {
+		if strings.Contains(uaHeader, t) {
+			return DeviceTV
+		}
+	}
+	// Pretty much every browser sends the OS; things without one are almost
+	// always scripts, libraries, and the like.
+	if ua.OSName == "" {
+		return DeviceBot
+	}
+	if hints.IsMobile() {
+		return DeviceMobile
+	}
+	for _, t := range tabletTokens {
+		if strings.Contains(uaHeader, t) {
+			return DeviceTablet
+		}
+	}
+	// Android tablets don't have "Mobile".
+	if ua.OSName == "Android" && !strings.Contains(uaHeader, "Mobile") {
+		return DeviceTablet
+	}
+	for _, t := range mobileTokens {
+		if strings.Contains(uaHeader, t) {
+			return DeviceMobile
+		}
+	}
+	switch ua.OSName {
+	case "iOS", "Android", "Windows Phone", "KaiOS", "Java ME", "MAUI Runtime", "Sailfish", "Tizen":
+		return DeviceMobile
+	}
+	return DeviceDesktop
+}
+
+// browserEngine gets the rendering engine from a User-Agent header, or "" if
+// it's not known.
+func browserEngine(uaHeader string, ua gadget.UserAgent) string {
+	switch {
+	case strings.Contains(uaHeader, "Trident/") || strings.Contains(uaHeader, "MSIE "):
+		return EngineTrident
+	case ua.BrowserName == "Edge" && strings.Contains(uaHeader, "Edge/"):
+		return EngineEdgeHTML
+	case strings.Contains(uaHeader, "Presto/"):
+		return EnginePresto
+	// All browsers on iOS use WebKit, even if they identify as something else.
+	case ua.OSName == "iOS" && strings.Contains(uaHeader, "AppleWebKit/"):
+		return EngineWebKit
+	case strings.Contains(uaHeader, "Chrome/") || strings.Contains(uaHeader, "Chromium/"):
+		return EngineBlink
+	case strings.Contains(uaHeader, "Goanna/"):
+		return EngineGoanna
+	case strings.Contains(uaHeader, "Gecko/"):
+		return EngineGecko
+	case strings.Contains(uaHeader, "AppleWebKit/"):
+		return EngineWebKit
+	}
+	return ""
+}
@@ -158,7 +158,7 @@ func (e *Export) RunJSON(ctx context.Context, fp *os.File, mailUser bool) {
 			return queryToJSON[System](ctx, w, `select * from systems`)
 		}},
 		{"locations", func(w io.Writer) error {
-			return queryToJSON[Location](ctx, w, `select location_id, country, region, country_name, region_name from locations`)
+			return queryToJSON[Location](ctx, w, `select location_id, country, region, city, country_name, region_name, city_name from locations`)
 		}},
 		{"languages", func(w io.Writer) error {
 			return queryToJSON[ExportLanguage](ctx, w, `select * from languages`)
@@ -530,14 +530,14 @@ func (imp *importerJSON) data(ctx context.Context, zipf *zip.ReadCloser, dataFil
 				table:    "locations",
 				idcol:    "location_id",
 				conflict: "iso_3166_2",
-				cols:     []string{"country", "region", "country_name", "region_name"},
+				cols:     []string{"country", "region", "city", "country_name", "region_name", "city_name"},
 				values: func(b *zdb.BulkInsert, line []byte) (int64, error) {
 					var v Location
 					err := json.Unmarshal(line, &v)
 					if err != nil {
 						return 0, err
 					}
-					b.Values(v.Country, v.Region, v.CountryName, v.RegionName)
+					b.Values(v.Country, v.Region, v.City, v.CountryName, v.RegionName, v.CityName)
 					return int64(v.ID), nil
 				},
 			},
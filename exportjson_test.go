@@ -89,10 +89,10 @@ func TestJSONExport(t *testing.T) {
 			1          Linux
 			2
 
-			location_id  iso_3166_2  country  region  country_name  region_name
-			1                                         (unknown)
-			2            ID          ID               Indonesia
-			3            IE          IE               Ireland
+			location_id  country  region  country_name  region_name  city  city_name  iso_3166_2
+			1                             (unknown)
+			2            ID               Indonesia                                   ID
+			3            IE               Ireland                                     IE
 		`, firstPathID, firstPathID+1, firstPathID+2)
 		if d := ztest.Diff(haveData.String(), wantData, ztest.DiffNormalizeWhitespace); d != "" {
 			t.Fatal(d)
@@ -102,8 +102,42 @@ func (f Filter) Append(ctx context.Context, id PathID) error {
 	return errors.Wrap(err, "Filter.Append")
 }
 
+// Replace all paths for this filter.
+func (f *Filter) Replace(ctx context.Context, paths []PathID, invert bool) error {
+	if f.FilterID == 0 {
+		return errors.New("Filter.Replace: id is 0")
+	}
+	f.Matches, f.Invert = len(paths), invert
+	err := zdb.TX(ctx, func(ctx context.Context) error {
+		err := zdb.Exec(ctx, `delete from filter_paths where filter_id = ?`, f.FilterID)
+		if err != nil {
+			return err
+		}
+		err = zdb.Exec(ctx, `update filters set matches = ?, invert = ?, last_used_at = ? where filter_id = ?`,
+			f.Matches, f.Invert, ztime.Now(ctx), f.FilterID)
+		if err != nil {
+			return err
+		}
+
+		b, err := zdb.NewBulkInsert(ctx, "filter_paths", []string{"filter_id", "path_id"})
+		if err != nil {
+			return err
+		}
+		for _, p := range paths {
+			b.Values(f.FilterID, p)
+		}
+		return b.Finish()
+	})
+	return errors.Wrap(err, "Filter.Replace")
+}
+
+// Match reports if the path matches this filter.
+//
+// The channel:name keyword is ignored, as that depends on the referrers rather
+// than the path.
 func (f Filter) Match(path, title string, event bool) bool {
-	like, kw := findFilter(f.Query,
+	query, _ := findChannel(f.Query)
+	like, kw := findFilter(query,
 		"at:start", "at:end", "is:event", "is:pageview", "in:path", "in:title", ":not")
 	like = strings.ToLower(regexp.QuoteMeta(like))
 	var matchPath, matchTitle, not bool
@@ -183,68 +217,18 @@ func (p PathFilter) SQL(ctx context.Context) (zdb.SQL, map[string]any) {
 		map[string]any{"paths": db2.Array(ctx, p.ids)}
 }
 
+// IsZero reports if this filter matches all paths.
+func (p PathFilter) IsZero() bool {
+	return p.filterID == 0 && len(p.ids) == 0
+}
+
 func PathFilterFromIDs(ids []PathID) PathFilter {
 	return PathFilter{ids: ids}
 }
 
 func PathFilterFromQuery(ctx context.Context, query string) (PathFilter, error) {
-	like, kw := findFilter(strings.ReplaceAll(query, "%", "%%"),
-		"at:start", "at:end", "is:event", "is:pageview", "in:path", "in:title", ":not")
-	var (
-		onlyEvent, onlyPageview, matchPath, matchTitle, atStart, atEnd bool
-		not, or                                                        zdb.SQL
-	)
-	for _, f := range kw {
-		switch f {
-		case "at:start":
-			atStart = true
-		case "at:end":
-			atEnd = true
-		case "is:event":
-			onlyEvent = true
-		case "is:pageview":
-			onlyPageview = true
-		case "in:path":
-			matchPath = true
-		case "in:title":
-			matchTitle = true
-		case ":not":
-			not = "not"
-		}
-	}
-	if !matchPath && !matchTitle {
-		matchPath, matchTitle = true, true
-	}
-	if like == "" {
-		matchPath, matchTitle = false, false
-	}
-	if matchPath && matchTitle {
-		or = "or"
-	}
-	if !atEnd {
-		like = like + "%"
-	}
-	if !atStart {
-		like = "%" + like
-	}
-
-	getPathIDs := func(scan any, invert bool) error {
-		return zdb.Select(ctx, scan, "load:paths.PathFilter", map[string]any{
-			"site":          MustGetSite(ctx).ID,
-			"like":          like,
-			"match_title":   matchTitle,
-			"match_path":    matchPath,
-			"have_like":     matchTitle || matchPath,
-			"only_event":    onlyEvent,
-			"only_pageview": onlyPageview,
-			"or":            or,
-			"not":           not,
-			"invert":        invert,
-		})
-	}
-
 	var pathIDs []PathID
-	err := getPathIDs(&pathIDs, false)
+	err := selectFilterPaths(ctx, &pathIDs, query, false)
 	if err != nil {
 		return PathFilter{}, errors.Wrap(err, "PathFilter")
 	}
@@ -259,7 +243,7 @@ func PathFilterFromQuery(ctx context.Context, query string) (PathFilter, error)
 	// two queries.
 	if len(pathIDs) > 100_000 {
 		var invertIDs []PathID
-		err := getPathIDs(&invertIDs, true)
+		err := selectFilterPaths(ctx, &invertIDs, query, true)
 		if err != nil {
 			return PathFilter{}, errors.Wrap(err, "PathFilter")
 		}
@@ -289,6 +273,12 @@ func PathFilterFromQuery(ctx context.Context, query string) (PathFilter, error)
 				}
 				return err
 			}
+			// Paths are never added to filters with a channel when they're
+			// created, as that depends on the referrers; just replace the
+			// list if it's changed.
+			if _, ch := findChannel(query); ch != "" && (filter.Matches != len(pathIDs) || filter.Invert != invert) {
+				return filter.Replace(ctx, pathIDs, invert)
+			}
 			return filter.Touch(ctx)
 		})
 		if err != nil {
@@ -300,6 +290,86 @@ func PathFilterFromQuery(ctx context.Context, query string) (PathFilter, error)
 	return PathFilter{filterID: filter.FilterID, ids: pathIDs, invert: invert}, nil
 }
 
+// FilterPathIDs gets the IDs of all paths that match the filter query.
+func FilterPathIDs(ctx context.Context, query string) ([]PathID, error) {
+	pathIDs := []PathID{}
+	err := selectFilterPaths(ctx, &pathIDs, query, false)
+	return pathIDs, errors.Wrap(err, "FilterPathIDs")
+}
+
+// selectFilterPaths selects the path IDs matching the filter query in to scan,
+// or the path IDs that don't match if invert is set.
+func selectFilterPaths(ctx context.Context, scan any, query string, invert bool) error {
+	query, channel := findChannel(query)
+	var refs []RefID
+	if channel != "" {
+		var err error
+		refs, err = channelRefIDs(ctx, channel)
+		if err != nil {
+			return err
+		}
+		if len(refs) == 0 {
+			refs = []RefID{0} // Never exists; an empty list is an error with SQLite.
+		}
+	}
+
+	like, kw := findFilter(strings.ReplaceAll(query, "%", "%%"),
+		"at:start", "at:end", "is:event", "is:pageview", "in:path", "in:title", ":not")
+	var (
+		onlyEvent, onlyPageview, matchPath, matchTitle, atStart, atEnd bool
+		not, or                                                        zdb.SQL
+	)
+	for _, f := range kw {
+		switch f {
+		case "at:start":
+			atStart = true
+		case "at:end":
+			atEnd = true
+		case "is:event":
+			onlyEvent = true
+		case "is:pageview":
+			onlyPageview = true
+		case "in:path":
+			matchPath = true
+		case "in:title":
+			matchTitle = true
+		case ":not":
+			not = "not"
+		}
+	}
+	if !matchPath && !matchTitle {
+		matchPath, matchTitle = true, true
+	}
+	if like == "" {
+		matchPath, matchTitle = false, false
+	}
+	if matchPath && matchTitle {
+		or = "or"
+	}
+	if !atEnd {
+		like = like + "%"
+	}
+	if !atStart {
+		like = "%" + like
+	}
+
+	return zdb.Select(ctx, scan, "load:paths.PathFilter", map[string]any{
+		"site":          MustGetSite(ctx).ID,
+		"like":          like,
+		"match_title":   matchTitle,
+		"match_path":    matchPath,
+		"have_like":     matchTitle || matchPath,
+		"only_event":    onlyEvent,
+		"only_pageview": onlyPageview,
+		"or":            or,
+		"not":           not,
+		"invert":        invert,
+		"channel":       channel != "",
+		"refs":          db2.Array(ctx, refs),
+		"ref_in":        db2.In(ctx),
+	})
+}
+
 func findFilter(filter string, find ...string) (string, []string) {
 	found := make([]string, 0, 2)
 	for _, f := range find {
@@ -310,3 +380,14 @@ func findFilter(filter string, find ...string) (string, []string) {
 	}
 	return filter, found
 }
+
+// findChannel finds the channel:name keyword, returning the filter without the
+// keyword and the channel name.
+func findChannel(filter string) (string, string) {
+	i := strings.Index(filter, "channel:")
+	if i == -1 {
+		return filter, ""
+	}
+	name, rest, _ := strings.Cut(filter[i+len("channel:"):], " ")
+	return strings.TrimSpace(filter[:i] + rest), strings.ToLower(name)
+}
@@ -19,6 +19,8 @@ func TestFilterMatch(t *testing.T) {
 		{"/hello in:path at:start at:end", "/hello", "Hello, world", false, true},
 
 		{"HELLO", "/hello", "Hello, world", false, true},
+		{"/h channel:search", "/hello", "Hello, world", false, true},
+		{"channel:search /x", "/hello", "Hello, world", false, false},
 	}
 
 	for _, tt := range tests {
@@ -10,6 +10,7 @@ require (
 	github.com/rivo/uniseg v0.4.7 // indirect
 	github.com/russross/blackfriday/v2 v2.1.0
 	github.com/sethvargo/go-limiter v1.2.0
+	go.yaml.in/yaml/v3 v3.0.5
 )
 
 // Things I maintain
@@ -121,18 +121,46 @@ func (h api) mount(r chi.Router, db zdb.DB, ratelimits Ratelimits) {
 
 	a.Get("/api/v0/paths", zhttp.Wrap(h.paths))
 	a.Get("/api/v0/stats/total", zhttp.Wrap(h.countTotal))
+	a.Get("/api/v0/report", zhttp.Wrap(h.report))
 	a.Get("/api/v0/stats/hits", zhttp.Wrap(h.hits))
 	a.Get("/api/v0/stats/hits/{path_id}", zhttp.Wrap(h.refs))
+	a.Get("/api/v0/stats/revenue", zhttp.Wrap(h.revenue))
 	a.Get("/api/v0/stats/{page}", zhttp.Wrap(h.stats))
 	a.Get("/api/v0/stats/{page}/{id}", zhttp.Wrap(h.statsDetail))
 
-	// Note: DELETE not supported for sites and users intentionally, since it's
-	// such a dangerous operation.
+	// DELETE for sites and users needs a separate permission, since it's such
+	// a dangerous operation.
 	a.Get("/api/v0/sites", zhttp.Wrap(h.siteList))
 	a.Put("/api/v0/sites", zhttp.Wrap(h.siteCreate))
 	a.Get("/api/v0/sites/{id}", zhttp.Wrap(h.siteGet))
 	a.Post("/api/v0/sites/{id}", zhttp.Wrap(h.siteUpdate))  // Update all
 	a.Patch("/api/v0/sites/{id}", zhttp.Wrap(h.siteUpdate)) // Update just fields given
+	a.Delete("/api/v0/sites/{id}", zhttp.Wrap(h.siteDelete))
+
+	a.Get("/api/v0/users", zhttp.Wrap(h.userList))
+	a.Put("/api/v0/users", zhttp.Wrap(h.userCreate))
+	a.Get("/api/v0/users/{id}", zhttp.Wrap(h.userGet))
+	a.Post("/api/v0/users/{id}", zhttp.Wrap(h.userUpdate))
+	a.Patch("/api/v0/users/{id}", zhttp.Wrap(h.userUpdate))
+	a.Delete("/api/v0/users/{id}", zhttp.Wrap(h.userDelete))
+
+	a.Get("/api/v0/tokens", zhttp.Wrap(h.tokenList))
+	a.Put("/api/v0/tokens", zhttp.Wrap(h.tokenCreate))
+	a.Post("/api/v0/tokens/{id}/rotate", zhttp.Wrap(h.tokenRotate))
+	a.Delete("/api/v0/tokens/{id}", zhttp.Wrap(h.tokenDelete))
+
+	a.Get("/api/v0/audit-log", zhttp.Wrap(h.auditLog))
+
+	a.Get("/api/v0/annotations", zhttp.Wrap(h.annotationList))
+	a.Post("/api/v0/annotations", zhttp.Wrap(h.annotationCreate))
+	a.Delete("/api/v0/annotations/{id}", zhttp.Wrap(h.annotationDelete))
+
+	a.HandleFunc("/api/v1/*", h.v1(h.v1NotFound))
+	a.Get("/api/v1/paths", h.v1(h.v1Paths))
+	a.Get("/api/v1/hits", h.v1(h.v1Hits))
+	a.Get("/api/v1/hits/{path_id}/refs", h.v1(h.v1Refs))
+	a.Get("/api/v1/stats/{page}", h.v1(h.v1Stats))
+	a.Get("/api/v1/stats/{page}/{id}", h.v1(h.v1StatsDetail))
 }
 
 func tokenFromHeader(r *http.Request, w http.ResponseWriter) (string, error) {
@@ -180,13 +208,13 @@ func (h api) auth(r *http.Request, w http.ResponseWriter, require zint.Bitflag64
 	if err != nil {
 		return err
 	}
-
-	// Update once a day at the most.
-	if token.LastUsedAt == nil || token.LastUsedAt.Before(ztime.Now(r.Context()).Add(-24*time.Hour)) {
-		err := token.Touch(r.Context())
-		if err != nil {
-			log.Error(r.Context(), err)
-		}
+	if token.Expired(r.Context()) {
+		w.Header().Set("WWW-Authenticate", "Basic realm=GoatCounter")
+		return guru.Errorf(http.StatusUnauthorized, "token expired at %s",
+			token.ExpiresAt.UTC().Format(time.RFC3339))
+	}
+	if !token.AllowIP(r.RemoteAddr) {
+		return guru.Errorf(http.StatusForbidden, "token can't be used from IP address %s", r.RemoteAddr)
 	}
 
 	var user goatcounter.User
@@ -196,20 +224,22 @@ func (h api) auth(r *http.Request, w http.ResponseWriter, require zint.Bitflag64
 	}
 
 	// API is only for admins at the moment; other users shouldn't be able to
-	// create an API key, but just in case.
+	// create an API key, but the user's access may have been changed since.
 	if !user.AccessAdmin() {
 		return guru.New(401, "only admins can create and use API keys")
 	}
 
-	*r = *r.WithContext(goatcounter.WithUser(r.Context(), &user))
+	ctx := goatcounter.WithUser(r.Context(), &user)
+	ctx = goatcounter.WithAPIToken(ctx, &token)
+	ctx = goatcounter.WithAuditActor(ctx, goatcounter.AuditActor{IP: r.RemoteAddr, APITokenID: &token.ID})
+	*r = *r.WithContext(ctx)
 
-	if require == 0 {
-		return nil
-	}
-	if !token.Permissions.Has(require) {
+	if require != 0 && !token.Permissions.Has(require) {
 		return guru.Errorf(http.StatusForbidden, "requires %s permissions",
 			goatcounter.APIToken{Permissions: require}.FormatPermissions())
 	}
+
+	token.Use(r.Context(), r.Method, r.URL.Path, r.RemoteAddr)
 	return nil
 }
 
@@ -287,12 +317,7 @@ func (h api) me(w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 
-	key, err := tokenFromHeader(r, w)
-	if err != nil {
-		return err
-	}
-	var token goatcounter.APIToken
-	err = token.ByToken(r.Context(), key)
+	token, err := h.currentToken(r, w)
 	if err != nil {
 		return err
 	}
@@ -329,7 +354,9 @@ func (h api) export(w http.ResponseWriter, r *http.Request) error {
 		if !req.StartFromDay.IsZero() {
 			return guru.New(400, "start_from_day is only valid for JSON exports")
 		}
-		fp, err := export.CreateCSV(r.Context(), req.StartFromHitID)
+		fp, err := createExport(r.Context(), &export, func(ctx context.Context) (*os.File, error) {
+			return export.CreateCSV(ctx, req.StartFromHitID)
+		})
 		if err != nil {
 			return err
 		}
@@ -339,7 +366,9 @@ func (h api) export(w http.ResponseWriter, r *http.Request) error {
 		if req.StartFromHitID != 0 {
 			return guru.New(400, "start_from_hit_id is only valid for CSV exports")
 		}
-		fp, err := export.CreateJSON(r.Context(), req.StartFromDay)
+		fp, err := createExport(r.Context(), &export, func(ctx context.Context) (*os.File, error) {
+			return export.CreateJSON(ctx, req.StartFromDay)
+		})
 		if err != nil {
 			return err
 		}
@@ -484,6 +513,19 @@ type APICountRequestHit struct {
 	// Query parameters for this pageview, used to get campaign parameters.
 	Query string `json:"query" query:"q"`
 
+	// Custom properties as key/value pairs, e.g. {"plan": "pro"}. Numbers and
+	// booleans are converted to strings. At most 10 properties; keys can be
+	// up to 64 characters and values up to 256 characters.
+	Props goatcounter.Props `json:"props" query:"pr"`
+
+	// Value for this event or pageview, such as the amount of a purchase; can
+	// be a number or string, and is rounded to two decimals.
+	Value goatcounter.Money `json:"value" query:"v"`
+
+	// Currency for the value, as an ISO 4217 code (e.g. EUR, USD, etc.);
+	// required if value is set.
+	Currency string `json:"currency" query:"cu"`
+
 	// Hint if this should be considered a bot; should be one of the JSBot*`
 	// constants from isbot; note the backend may override this if it
 	// detects a bot using another method.
@@ -527,8 +569,8 @@ type APICountRequestHit struct {
 
 func (h APICountRequestHit) String() string {
 	return fmt.Sprintf(
-		`{Path: %q, Title: %q, Event: %t, Ref: %q, Size: "%s", Query: %q, Bot: %d, UserAgent: %q, Location: %q, Language: %q, IP: %q, CreatedAt: %q, Session: %q, Host: %q}`,
-		h.Path, h.Title, h.Event, h.Ref, h.Size, h.Query, h.Bot, h.UserAgent, h.Location, h.Language, h.IP, h.CreatedAt, h.Session, h.Host)
+		`{Path: %q, Title: %q, Event: %t, Ref: %q, Size: "%s", Query: %q, Props: %v, Value: "%s", Currency: %q, Bot: %d, UserAgent: %q, Location: %q, Language: %q, IP: %q, CreatedAt: %q, Session: %q, Host: %q}`,
+		h.Path, h.Title, h.Event, h.Ref, h.Size, h.Query, h.Props, h.Value, h.Currency, h.Bot, h.UserAgent, h.Location, h.Language, h.IP, h.CreatedAt, h.Session, h.Host)
 }
 
 // POST /api/v0/count count
@@ -611,6 +653,9 @@ func (h api) count(w http.ResponseWriter, r *http.Request) error {
 			Event:           a.Event,
 			Size:            a.Size,
 			Query:           a.Query,
+			Props:           a.Props,
+			Value:           a.Value,
+			Currency:        a.Currency,
 			Bot:             a.Bot,
 			CreatedAt:       a.CreatedAt.UTC(),
 			UserAgentHeader: a.UserAgent,
@@ -618,6 +663,9 @@ func (h api) count(w http.ResponseWriter, r *http.Request) error {
 			Language:        lang,
 			RemoteAddr:      a.IP,
 		}
+		if a.IP != "" && site.Settings.Collect.Has(goatcounter.CollectNetwork) {
+			hit.ASN = goatcounter.Network{}.LookupIP(r.Context(), a.IP)
+		}
 
 		if a.UserAgent != "" {
 			if b := isbot.UserAgent(a.UserAgent); isbot.Is(b) {
@@ -702,7 +750,7 @@ func (h api) siteList(w http.ResponseWriter, r *http.Request) error {
 	return zhttp.JSON(w, apiSitesResponse{sites})
 }
 
-func (h api) siteFind(r *http.Request) (*goatcounter.Site, error) {
+func (h api) siteFind(r *http.Request, need goatcounter.UserAccess) (*goatcounter.Site, error) {
 	v := goatcounter.NewValidate(r.Context())
 	id := goatcounter.SiteID(v.Integer32("id", chi.URLParam(r, "id")))
 	if v.HasErrors() {
@@ -719,6 +767,9 @@ func (h api) siteFind(r *http.Request) (*goatcounter.Site, error) {
 	if !(site.ID == siteID || (site.Parent != nil && *site.Parent == siteID)) {
 		return nil, guru.New(404, "")
 	}
+	if !User(r.Context()).HasAccess(site.ID, need) {
+		return nil, guru.New(404, "")
+	}
 
 	return &site, nil
 }
@@ -735,7 +786,7 @@ func (h api) siteGet(w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 
-	site, err := h.siteFind(r)
+	site, err := h.siteFind(r, goatcounter.AccessReadOnly)
 	if err != nil {
 		return err
 	}
@@ -760,7 +811,13 @@ func (h api) siteCreate(w http.ResponseWriter, r *http.Request) error {
 	}
 
 	site.Parent = &Site(r.Context()).ID
-	err = site.Insert(r.Context())
+	err = zdb.TX(r.Context(), func(ctx context.Context) error {
+		err := site.Insert(ctx)
+		if err != nil {
+			return err
+		}
+		return goatcounter.Audit(goatcounter.WithSite(ctx, &site), goatcounter.AuditSiteCreate, nil, site)
+	})
 	if err != nil {
 		return err
 	}
@@ -790,7 +847,7 @@ func (h api) siteUpdate(w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 
-	site, err := h.siteFind(r)
+	site, err := h.siteFind(r, goatcounter.AccessSettings)
 	if err != nil {
 		return err
 	}
@@ -807,10 +864,17 @@ func (h api) siteUpdate(w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 
+	before := *site
 	site.LinkDomain = args.LinkDomain
 	site.Cname = args.Cname
 	site.Settings = args.Settings
-	err = site.Update(r.Context())
+	err = zdb.TX(r.Context(), func(ctx context.Context) error {
+		err := site.Update(ctx)
+		if err != nil {
+			return err
+		}
+		return goatcounter.Audit(goatcounter.WithSite(ctx, site), goatcounter.AuditSiteUpdate, before, site)
+	})
 	if err != nil {
 		return err
 	}
@@ -818,229 +882,951 @@ func (h api) siteUpdate(w http.ResponseWriter, r *http.Request) error {
 	return zhttp.JSON(w, site)
 }
 
-type (
-	apiPathsRequest struct {
-		// Limit number of returned results {range: 1-200, default: 20}
-		Limit int `json:"limit"`
-
-		// Only select paths after this ID, for pagination.
-		After goatcounter.PathID `json:"after"`
-	}
-	apiPathsResponse struct {
-		// List of paths, sorted by ID.
-		Paths goatcounter.Paths `json:"paths"`
+type apiSiteDeleteRequest struct {
+	// Code of the site to delete, to confirm the site is the correct one.
+	Confirm string `json:"confirm" query:"confirm"`
+}
 
-		// True if there are more paths.
-		More bool `json:"more"`
+// DELETE /api/v0/sites/{id} sites
+// Delete a site.
+//
+// The site code must be sent in the confirm parameter, to make sure that the
+// correct site is deleted. Deleted sites can't be used any more, and their
+// pageviews are removed in the background.
+//
+// The account's main site can't be deleted with the API; use "Delete account"
+// in the settings for this.
+//
+// Query: apiSiteDeleteRequest
+// Response 204: {empty}
+func (h api) siteDelete(w http.ResponseWriter, r *http.Request) error {
+	err := h.auth(r, w, goatcounter.APIPermSiteDelete)
+	if err != nil {
+		return err
 	}
-)
 
-// GET /api/v0/paths paths
-// Get an overview of paths on this site (without statistics).
-//
-// Query: apiPathsRequest
-// Response 200: apiPathsResponse
-func (h api) paths(w http.ResponseWriter, r *http.Request) error {
-	err := h.auth(r, w, goatcounter.APIPermStats)
+	site, err := h.siteFind(r, goatcounter.AccessAdmin)
 	if err != nil {
 		return err
 	}
 
-	args := apiPathsRequest{Limit: 20}
-	if _, err := h.dec.Decode(r, &args); err != nil {
+	var args apiSiteDeleteRequest
+	_, err = h.dec.Decode(r, &args)
+	if err != nil {
 		return err
 	}
 
-	if h.apiMaxPaths > 0 && args.Limit > h.apiMaxPaths {
-		args.Limit = h.apiMaxPaths
+	if site.Parent == nil {
+		return guru.New(400, "can't delete the account's main site with the API")
 	}
-	if args.Limit < 1 {
-		args.Limit = 1
+	if args.Confirm != site.Code {
+		v := goatcounter.NewValidate(r.Context())
+		v.Append("confirm", "must be the site code")
+		return v
 	}
 
-	var p goatcounter.Paths
-	more, err := p.List(r.Context(), goatcounter.MustGetSite(r.Context()).ID, args.After, args.Limit)
+	err = zdb.TX(r.Context(), func(ctx context.Context) error {
+		err := goatcounter.Audit(goatcounter.WithSite(ctx, site), goatcounter.AuditSiteDelete, site, nil)
+		if err != nil {
+			return err
+		}
+		return site.Delete(ctx, false)
+	})
 	if err != nil {
 		return err
 	}
-	return zhttp.JSON(w, apiPathsResponse{Paths: p, More: more})
+	w.WriteHeader(http.StatusNoContent)
+	return nil
 }
 
 type (
-	apiHitsRequest struct {
-		// Start time, should be rounded to the hour {datetime, default: one week ago}.
-		Start time.Time `json:"start" query:"start"`
-
-		// End time, should be rounded to the hour {datetime, default: current time}.
-		End time.Time `json:"end" query:"end"`
-
-		// Deprecated: identical to group=day and will be removed in the future.
-		Daily bool `json:"daily" query:"daily"`
+	apiUsersResponse struct {
+		Users goatcounter.Users `json:"users"`
+	}
+	apiUserRequest struct {
+		// Email address; required.
+		Email string `json:"email"`
 
-		// Set Max value in the response to the highest daily, weekly, or
-		// monthly value, instead of hourly.
-		//
-		// The Hourly, Daily, Weekly, and Monthly values are always included in
-		// the response – this only affects the Max value, which is useful if
-		// you want to draw charts like the GoatCounter dashboard: you need to
-		// know the maximum Y-axis value of the chart to draw it.
-		//
-		// {enum: hour day week month}
-		Group goatcounter.Group `json:"group" query:"group"`
+		// Password for the new user. If this is blank the user will be sent
+		// an email to set a password.
+		Password string `json:"password"`
 
-		// Include only these path IDs; default is to include everything.
+		// Access for the user, e.g. {"all": "a"} for an admin. {required}
 		//
-		// If path_by_name is set, it will look up paths by name instead of ID.
-		IncludePaths goatcounter.Strings `json:"include_paths" query:"include_paths"`
-
-		// Exclude these path IDs, for pagination.
+		//   -   No access.
+		//   r   Read only.
+		//   s   Settings: can also change settings.
+		//   a   Admin: can also change users and sites, and use the API.
 		//
-		// If path_by_name is set, it will look up paths by name instead of ID.
-		ExcludePaths goatcounter.Strings `json:"exclude_paths" query:"exclude_paths"`
-
-		// Get values for include_paths and exclude_paths by path name, rather
-		// than path ID. This is more convenient in some cases, but also a bit
-		// slower.
-		PathByName bool `json:"path_by_name" query:"path_by_name"`
-
-		// Maximum number of pages to get {range: 1-100, default: 20}.
-		Limit int `json:"limit" query:"limit"`
-	}
-	apiHitsResponse struct {
-		// Sorted list of paths with their visitor and pageview count.
-		Hits goatcounter.HitLists `json:"hits"`
-
-		// Total number of visitors in the returned result.
-		Total int `json:"total"`
-
-		// More hits after this?
-		More bool `json:"more"`
+		// Use a site ID as the key to set a different access for just that
+		// site, e.g. {"all": "r", "42": "s"}. This can't be admin.
+		Access goatcounter.UserAccesses `json:"access"`
 	}
 )
 
-// GET /api/v0/stats/hits stats
-// Get an overview of pageviews.
+// GET /api/v0/users users
+// List all users.
 //
-// Query: apiHitsRequest
-// Response 200: apiHitsResponse
-func (h api) hits(w http.ResponseWriter, r *http.Request) error {
-	err := h.auth(r, w, goatcounter.APIPermStats)
+// Response 200: apiUsersResponse
+func (h api) userList(w http.ResponseWriter, r *http.Request) error {
+	err := h.auth(r, w, goatcounter.APIPermUserRead)
 	if err != nil {
 		return err
 	}
 
-	args := apiHitsRequest{Limit: 20}
-	if _, err := h.dec.Decode(r, &args); err != nil {
+	var users goatcounter.Users
+	err = users.List(r.Context(), Account(r.Context()).ID)
+	if err != nil {
 		return err
 	}
-	if h.apiMax > 0 && args.Limit > h.apiMax {
-		args.Limit = h.apiMax
-	}
-	if args.Limit < 1 {
-		args.Limit = 1
+	return zhttp.JSON(w, apiUsersResponse{users})
+}
+
+func (h api) userFind(r *http.Request) (*goatcounter.User, error) {
+	v := goatcounter.NewValidate(r.Context())
+	id := goatcounter.UserID(v.Integer32("id", chi.URLParam(r, "id")))
+	if v.HasErrors() {
+		return nil, v
 	}
-	if args.Start.IsZero() {
-		args.Start = ztime.StartOf(ztime.AddPeriod(ztime.Now(r.Context()), -7, ztime.Day), ztime.Day)
+
+	var user goatcounter.User
+	err := user.ByID(r.Context(), id)
+	if err != nil {
+		return nil, err
 	}
-	if args.End.IsZero() {
-		args.End = ztime.EndOf(ztime.Now(r.Context()), ztime.Day)
+	if user.Site != Account(r.Context()).ID {
+		return nil, guru.New(404, "")
 	}
+	return &user, nil
+}
 
-	includeIDs, excludeIDs, err := findPaths(r.Context(), args.PathByName, args.IncludePaths, args.ExcludePaths)
+// GET /api/v0/users/{id} users
+// Get information about a user.
+//
+// Response 200: goatcounter.User
+func (h api) userGet(w http.ResponseWriter, r *http.Request) error {
+	err := h.auth(r, w, goatcounter.APIPermUserRead)
 	if err != nil {
 		return err
 	}
 
-	// TODO: backwards compat; remove eventually.
-	if args.Daily && args.Group == goatcounter.GroupHourly {
-		args.Group = goatcounter.GroupDaily
-	}
-
-	var pages goatcounter.HitLists
-	tdu, more, err := pages.List(r.Context(), ztime.NewRange(args.Start).To(args.End),
-		includeIDs, excludeIDs, args.Limit, args.Group)
+	user, err := h.userFind(r)
 	if err != nil {
 		return err
 	}
-
-	return zhttp.JSON(w, apiHitsResponse{
-		Total: tdu,
-		Hits:  pages,
-		More:  more,
-	})
+	return zhttp.JSON(w, user)
 }
 
-type (
-	apiRefsRequest struct {
-		// Start time, should be rounded to the hour {datetime, default: one week ago}.
-		Start time.Time `json:"start" query:"start"`
-
-		// End time, should be rounded to the hour {datetime, default: current time}.
-		End time.Time `json:"end" query:"end"`
+// PUT /api/v0/users users
+// Invite a new user.
+//
+// The user will get an email that an account was created for them.
+//
+// Request body: apiUserRequest
+// Response 200: goatcounter.User
+func (h api) userCreate(w http.ResponseWriter, r *http.Request) error {
+	err := h.auth(r, w, goatcounter.APIPermUserManage)
+	if err != nil {
+		return err
+	}
 
-		// Maximum number of pages to get {range: 1-100, default: 20}.
-		Limit int `json:"limit" query:"limit"`
+	var args apiUserRequest
+	_, err = h.dec.Decode(r, &args)
+	if err != nil {
+		return err
+	}
+	if args.Access["all"] == goatcounter.AccessSuperuser && !User(r.Context()).AccessSuperuser() {
+		return guru.New(400, "can't set 'superuser' if you're not a superuser yourself.")
+	}
 
-		// Offset for pagination.
-		Offset int `json:"offset" query:"offset"`
+	account := Account(r.Context())
+	newUser := goatcounter.User{
+		Email:         args.Email,
+		Site:          account.ID,
+		Access:        args.Access,
+		EmailVerified: zbool.Bool(!goatcounter.Config(r.Context()).GoatcounterCom),
 	}
-	apiRefsResponse struct {
-		Refs []goatcounter.HitStat `json:"refs"`
-		More bool                  `json:"more"`
+	if args.Password != "" {
+		newUser.Password = []byte(args.Password)
 	}
-)
 
-// GET /api/v0/stats/hits/{path_id} stats
-// Get an overview of referral information for a path.
-//
-// Query: apiRefsRequest
-// Response 200: apiRefsResponse
-func (h api) refs(w http.ResponseWriter, r *http.Request) error {
-	err := h.auth(r, w, goatcounter.APIPermStats)
+	err = zdb.TX(r.Context(), func(ctx context.Context) error {
+		err := newUser.Insert(ctx, args.Password == "")
+		if err != nil {
+			return err
+		}
+		if args.Password == "" {
+			err = newUser.InviteToken(ctx)
+			if err != nil {
+				return err
+			}
+		}
+		return goatcounter.Audit(ctx, goatcounter.AuditUserCreate, nil, newUser)
+	})
 	if err != nil {
 		return err
 	}
 
-	v := zvalidate.New()
-	path := goatcounter.PathID(v.Integer32("path_id", chi.URLParam(r, "path_id")))
-	if v.HasErrors() {
-		return v
-	}
+	sendAddUserEmail(r.Context(), account, newUser)
+	return zhttp.JSON(w, newUser)
+}
 
-	args := apiRefsRequest{Limit: 20}
-	if _, err := h.dec.Decode(r, &args); err != nil {
+// POST /api/v0/users/{id} users
+// PATCH /api/v0/users/{id} users
+// Update a user.
+//
+// A POST request will *replace* the email and access with what's sent. A PATCH
+// request will only update the fields that are sent. The password is only
+// changed if it's sent.
+//
+// Request body: apiUserRequest
+// Response 200: goatcounter.User
+func (h api) userUpdate(w http.ResponseWriter, r *http.Request) error {
+	err := h.auth(r, w, goatcounter.APIPermUserManage)
+	if err != nil {
 		return err
 	}
-	if h.apiMax > 0 && args.Limit > h.apiMax {
-		args.Limit = h.apiMax
+
+	user, err := h.userFind(r)
+	if err != nil {
+		return err
 	}
-	if args.Limit < 1 {
-		args.Limit = 1
+
+	var args apiUserRequest
+	if r.Method == http.MethodPatch {
+		args.Email = user.Email
+		args.Access = user.Access
 	}
-	if args.Start.IsZero() {
-		args.Start = ztime.StartOf(ztime.AddPeriod(ztime.Now(r.Context()), -7, ztime.Day), ztime.Day)
+	_, err = h.dec.Decode(r, &args)
+	if err != nil {
+		return err
 	}
-	if args.End.IsZero() {
-		args.End = ztime.EndOf(ztime.Now(r.Context()), ztime.Day)
+	if args.Access["all"] == goatcounter.AccessSuperuser && !User(r.Context()).AccessSuperuser() {
+		return guru.New(400, "can't set 'superuser' if you're not a superuser yourself.")
 	}
 
-	var refs goatcounter.HitStats
-	err = refs.ListRefsByPathID(r.Context(), path, ztime.NewRange(args.Start).To(args.End),
-		args.Limit, args.Offset)
+	before := *user
+	emailChanged := user.Email != args.Email
+	user.Email = args.Email
+	user.Access = args.Access
+	err = zdb.TX(r.Context(), func(ctx context.Context) error {
+		err := user.Update(ctx, emailChanged)
+		if err != nil {
+			return err
+		}
+		if args.Password != "" {
+			err = user.UpdatePassword(ctx, args.Password)
+			if err != nil {
+				return err
+			}
+		}
+		return goatcounter.Audit(ctx, goatcounter.AuditUserUpdate, before, user)
+	})
 	if err != nil {
 		return err
 	}
-
-	return zhttp.JSON(w, apiRefsResponse{
-		Refs: refs.Stats,
-		More: refs.More,
-	})
+	return zhttp.JSON(w, user)
 }
 
-type (
-	apiCountTotalRequest struct {
-		// Start time, should be rounded to the hour {datetime, default: one week ago}.
-		Start time.Time `json:"start" query:"start"`
+// DELETE /api/v0/users/{id} users
+// Remove a user.
+//
+// The last admin can't be removed.
+//
+// Response 204: {empty}
+func (h api) userDelete(w http.ResponseWriter, r *http.Request) error {
+	err := h.auth(r, w, goatcounter.APIPermUserManage)
+	if err != nil {
+		return err
+	}
+
+	user, err := h.userFind(r)
+	if err != nil {
+		return err
+	}
+	if user.ID == User(r.Context()).ID {
+		return guru.New(400, "can't remove the user for this API token")
+	}
+
+	err = zdb.TX(r.Context(), func(ctx context.Context) error {
+		err := user.Delete(ctx, false)
+		if err != nil {
+			return err
+		}
+		return goatcounter.Audit(ctx, goatcounter.AuditUserDelete, user, nil)
+	})
+	if err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+type (
+	apiTokensResponse struct {
+		Tokens []apiToken `json:"tokens"`
+	}
+	apiToken struct {
+		ID          goatcounter.APITokenID `json:"id"`
+		Name        string                 `json:"name"`
+		Permissions zint.Bitflag64         `json:"permissions"`
+		Sites       goatcounter.SiteIDs    `json:"sites"`
+		AllowedIPs  goatcounter.Strings    `json:"allowed_ips"`
+		Filter      string                 `json:"filter"`
+		ExpiresAt   *time.Time             `json:"expires_at"`
+		UsageCount  int                    `json:"usage_count"`
+		CreatedAt   time.Time              `json:"created_at"`
+		LastUsedAt  *time.Time             `json:"last_used_at"`
+
+		// The secret token; only sent when creating or rotating a token.
+		Token string `json:"token,omitempty"`
+	}
+	apiTokenRequest struct {
+		// Token name; required.
+		Name string `json:"name"`
+
+		// Permissions as a bitmask; this can't include permissions the token
+		// used for the request doesn't have. {required}
+		//
+		//   2     Record pageviews
+		//   4     Export
+		//   8     Read sites
+		//   16    Create sites
+		//   32    Update sites
+		//   64    Read statistics
+		//   128   Read users
+		//   256   Manage users
+		//   512   Manage API tokens
+		//   1024  Delete sites
+		//   2048  Read audit log
+		//   4096  Manage annotations
+		Permissions zint.Bitflag64 `json:"permissions"`
+
+		// Sites this token can be used for; -1 means all sites. {required}
+		Sites goatcounter.SiteIDs `json:"sites"`
+
+		// IP addresses or CIDR ranges this token can be used from; default is
+		// to allow all addresses, or the same addresses as the token used for
+		// the request.
+		AllowedIPs goatcounter.Strings `json:"allowed_ips"`
+
+		// Restrict statistics to paths matching this filter, using the same
+		// syntax as the dashboard filter. Tokens with a filter can only have
+		// read permissions.
+		Filter string `json:"filter"`
+
+		// Time this token expires; default is to never expire. This can't be
+		// later than the expiry of the token used for the request.
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+)
+
+func newAPIToken(t goatcounter.APIToken, withToken bool) apiToken {
+	tt := apiToken{ID: t.ID, Name: t.Name, Permissions: t.Permissions,
+		Sites: t.Sites, AllowedIPs: t.AllowedIPs, Filter: t.Filter, ExpiresAt: t.ExpiresAt,
+		UsageCount: t.UsageCount, CreatedAt: t.CreatedAt, LastUsedAt: t.LastUsedAt}
+	if withToken {
+		tt.Token = t.Token
+	}
+	return tt
+}
+
+// currentToken gets the API token used for this request.
+func (h api) currentToken(r *http.Request, w http.ResponseWriter) (goatcounter.APIToken, error) {
+	var token goatcounter.APIToken
+	key, err := tokenFromHeader(r, w)
+	if err != nil {
+		return token, err
+	}
+	err = token.ByToken(r.Context(), key)
+	return token, err
+}
+
+func (h api) tokenFind(r *http.Request) (*goatcounter.APIToken, error) {
+	v := goatcounter.NewValidate(r.Context())
+	id := goatcounter.APITokenID(v.Integer32("id", chi.URLParam(r, "id")))
+	if v.HasErrors() {
+		return nil, v
+	}
+
+	var token goatcounter.APIToken
+	err := token.ByID(r.Context(), id)
+	if err != nil {
+		return nil, err
+	}
+	if token.UserID != User(r.Context()).ID {
+		return nil, guru.New(404, "")
+	}
+	return &token, nil
+}
+
+// GET /api/v0/tokens tokens
+// List all API tokens for the current user.
+//
+// The secret tokens are not included.
+//
+// Response 200: apiTokensResponse
+func (h api) tokenList(w http.ResponseWriter, r *http.Request) error {
+	err := h.auth(r, w, goatcounter.APIPermTokenManage)
+	if err != nil {
+		return err
+	}
+
+	var tokens goatcounter.APITokens
+	err = tokens.List(r.Context())
+	if err != nil {
+		return err
+	}
+
+	resp := apiTokensResponse{Tokens: make([]apiToken, 0, len(tokens))}
+	for _, t := range tokens {
+		resp.Tokens = append(resp.Tokens, newAPIToken(t, false))
+	}
+	return zhttp.JSON(w, resp)
+}
+
+// PUT /api/v0/tokens tokens
+// Create a new API token for the current user.
+//
+// Request body: apiTokenRequest
+// Response 200: apiToken
+func (h api) tokenCreate(w http.ResponseWriter, r *http.Request) error {
+	err := h.auth(r, w, goatcounter.APIPermTokenManage)
+	if err != nil {
+		return err
+	}
+	current, err := h.currentToken(r, w)
+	if err != nil {
+		return err
+	}
+
+	var args apiTokenRequest
+	_, err = h.dec.Decode(r, &args)
+	if err != nil {
+		return err
+	}
+
+	if extra := args.Permissions &^ goatcounter.APIPermNothing &^ current.Permissions; extra != 0 {
+		return guru.Errorf(http.StatusForbidden, "can't create a token with %s permissions, as this token doesn't have them",
+			goatcounter.APIToken{Permissions: extra}.FormatPermissions())
+	}
+
+	if current.ExpiresAt != nil && (args.ExpiresAt == nil || args.ExpiresAt.After(*current.ExpiresAt)) {
+		return guru.Errorf(http.StatusForbidden, "can't create a token that expires after this token (%s)",
+			current.ExpiresAt.UTC().Format(time.RFC3339))
+	}
+	if !current.Sites.All() {
+		for _, id := range args.Sites {
+			if id == -1 {
+				return guru.New(http.StatusForbidden, "can't create a token for all sites, as this token doesn't have access to all sites")
+			}
+			if !slices.Contains(current.Sites, id) {
+				return guru.Errorf(http.StatusForbidden, "can't create a token for site %d, as this token doesn't have access to it", id)
+			}
+		}
+	}
+	if current.Filter != "" && args.Filter != current.Filter {
+		return guru.New(http.StatusForbidden, "can't create a token with a different filter than this token")
+	}
+	if len(args.AllowedIPs) == 0 {
+		args.AllowedIPs = current.AllowedIPs
+	} else if len(current.AllowedIPs) > 0 {
+		for _, ip := range args.AllowedIPs {
+			if !slices.Contains(current.AllowedIPs, ip) {
+				return guru.Errorf(http.StatusForbidden, "can't allow %q as this token isn't allowed to be used from it", ip)
+			}
+		}
+	}
+
+	token := goatcounter.APIToken{
+		Name:        args.Name,
+		Permissions: args.Permissions | goatcounter.APIPermNothing,
+		Sites:       args.Sites,
+		AllowedIPs:  args.AllowedIPs,
+		Filter:      args.Filter,
+		ExpiresAt:   args.ExpiresAt,
+	}
+	err = zdb.TX(r.Context(), func(ctx context.Context) error {
+		err := token.Insert(ctx)
+		if err != nil {
+			return err
+		}
+		return goatcounter.Audit(ctx, goatcounter.AuditAPITokenCreate, nil, token)
+	})
+	if err != nil {
+		return err
+	}
+	return zhttp.JSON(w, newAPIToken(token, true))
+}
+
+// POST /api/v0/tokens/{id}/rotate tokens
+// Rotate an API token.
+//
+// This generates a new secret token; the previous one can no longer be used.
+//
+// Response 200: apiToken
+func (h api) tokenRotate(w http.ResponseWriter, r *http.Request) error {
+	err := h.auth(r, w, goatcounter.APIPermTokenManage)
+	if err != nil {
+		return err
+	}
+
+	token, err := h.tokenFind(r)
+	if err != nil {
+		return err
+	}
+
+	err = zdb.TX(r.Context(), func(ctx context.Context) error {
+		err := token.Rotate(ctx)
+		if err != nil {
+			return err
+		}
+		return goatcounter.Audit(ctx, goatcounter.AuditAPITokenRotate, nil, token)
+	})
+	if err != nil {
+		return err
+	}
+	return zhttp.JSON(w, newAPIToken(*token, true))
+}
+
+// DELETE /api/v0/tokens/{id} tokens
+// Revoke an API token.
+//
+// Response 204: {empty}
+func (h api) tokenDelete(w http.ResponseWriter, r *http.Request) error {
+	err := h.auth(r, w, goatcounter.APIPermTokenManage)
+	if err != nil {
+		return err
+	}
+
+	token, err := h.tokenFind(r)
+	if err != nil {
+		return err
+	}
+
+	err = zdb.TX(r.Context(), func(ctx context.Context) error {
+		err := token.Delete(ctx)
+		if err != nil {
+			return err
+		}
+		return goatcounter.Audit(ctx, goatcounter.AuditAPITokenDelete, token, nil)
+	})
+	if err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+type (
+	apiAuditLogRequest struct {
+		// Only get entries for this action, such as "site.update" or
+		// "user.delete".
+		Action string `json:"action"`
+
+		// Only get entries for changes made by this user.
+		UserID goatcounter.UserID `json:"user_id"`
+
+		// Only get entries for changes to this site.
+		SiteID goatcounter.SiteID `json:"site_id"`
+
+		// Only get entries created on or after this time {datetime}.
+		Start time.Time `json:"start"`
+
+		// Only get entries created before this time {datetime}.
+		End time.Time `json:"end"`
+
+		// Only get entries with an ID lower than this, for pagination.
+		Before goatcounter.AuditEntryID `json:"before"`
+
+		// Limit number of returned results {range: 1-200, default: 50}
+		Limit int `json:"limit"`
+	}
+	apiAuditLogResponse struct {
+		// Audit log entries, newest first.
+		Entries goatcounter.AuditEntries `json:"entries"`
+
+		// True if there are more entries.
+		More bool `json:"more"`
+	}
+)
+
+// GET /api/v0/audit-log audit-log
+// Get the audit log for this account.
+//
+// This lists changes to settings, sites, users, API tokens, and data for all
+// sites in this account.
+//
+// Query: apiAuditLogRequest
+// Response 200: apiAuditLogResponse
+func (h api) auditLog(w http.ResponseWriter, r *http.Request) error {
+	err := h.auth(r, w, goatcounter.APIPermAuditRead)
+	if err != nil {
+		return err
+	}
+
+	args := apiAuditLogRequest{Limit: 50}
+	if _, err := h.dec.Decode(r, &args); err != nil {
+		return err
+	}
+	if args.Action != "" && !slices.Contains(goatcounter.AuditActions, args.Action) {
+		return guru.Errorf(400, "unknown action: %q", args.Action)
+	}
+	args.Limit = max(1, min(args.Limit, 200))
+
+	var entries goatcounter.AuditEntries
+	more, err := entries.List(r.Context(), goatcounter.AuditFilter{
+		Action: args.Action,
+		UserID: args.UserID,
+		SiteID: args.SiteID,
+		Start:  args.Start,
+		End:    args.End,
+		Before: args.Before,
+	}, args.Limit)
+	if err != nil {
+		return err
+	}
+	return zhttp.JSON(w, apiAuditLogResponse{Entries: entries, More: more})
+}
+
+type (
+	apiAnnotationsResponse struct {
+		// Annotations, newest first.
+		Annotations goatcounter.Annotations `json:"annotations"`
+	}
+	apiAnnotationRequest struct {
+		// Time of the event {datetime}; default is the current time.
+		At time.Time `json:"at"`
+
+		// Label to display, for example "v2.3 released". {required}
+		Label string `json:"label"`
+
+		// Only display the annotation on the charts for paths matching this
+		// filter, using the same syntax as the dashboard filter. Default is to
+		// display it on all charts.
+		Filter string `json:"filter"`
+	}
+)
+
+// GET /api/v0/annotations annotations
+// List all annotations for this site.
+//
+// Response 200: apiAnnotationsResponse
+func (h api) annotationList(w http.ResponseWriter, r *http.Request) error {
+	err := h.auth(r, w, goatcounter.APIPermStats)
+	if err != nil {
+		return err
+	}
+
+	var annotations goatcounter.Annotations
+	err = annotations.List(r.Context())
+	if err != nil {
+		return err
+	}
+	return zhttp.JSON(w, apiAnnotationsResponse{Annotations: annotations})
+}
+
+// POST /api/v0/annotations annotations
+// Add an annotation to the dashboard charts.
+//
+// This can be used to mark deploys, releases, or campaigns; for example from a
+// CI job.
+//
+// Request body: apiAnnotationRequest
+// Response 200: goatcounter.Annotation
+func (h api) annotationCreate(w http.ResponseWriter, r *http.Request) error {
+	err := h.auth(r, w, goatcounter.APIPermAnnotations)
+	if err != nil {
+		return err
+	}
+
+	var args apiAnnotationRequest
+	_, err = h.dec.Decode(r, &args)
+	if err != nil {
+		return err
+	}
+
+	a := goatcounter.Annotation{At: args.At, Label: args.Label, Filter: args.Filter}
+	err = zdb.TX(r.Context(), func(ctx context.Context) error {
+		err := a.Insert(ctx)
+		if err != nil {
+			return err
+		}
+		return goatcounter.Audit(ctx, goatcounter.AuditAnnotationCreate, nil, a)
+	})
+	if err != nil {
+		return err
+	}
+	return zhttp.JSON(w, a)
+}
+
+// DELETE /api/v0/annotations/{id} annotations
+// Remove an annotation.
+//
+// Response 204: {empty}
+func (h api) annotationDelete(w http.ResponseWriter, r *http.Request) error {
+	err := h.auth(r, w, goatcounter.APIPermAnnotations)
+	if err != nil {
+		return err
+	}
+
+	v := goatcounter.NewValidate(r.Context())
+	id := goatcounter.AnnotationID(v.Integer32("id", chi.URLParam(r, "id")))
+	if v.HasErrors() {
+		return v
+	}
+
+	var a goatcounter.Annotation
+	err = a.ByID(r.Context(), id)
+	if err != nil {
+		return err
+	}
+	err = zdb.TX(r.Context(), func(ctx context.Context) error {
+		err := a.Delete(ctx)
+		if err != nil {
+			return err
+		}
+		return goatcounter.Audit(ctx, goatcounter.AuditAnnotationDelete, a, nil)
+	})
+	if err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+type (
+	apiPathsRequest struct {
+		// Limit number of returned results {range: 1-200, default: 20}
+		Limit int `json:"limit"`
+
+		// Only select paths after this ID, for pagination.
+		After goatcounter.PathID `json:"after"`
+	}
+	apiPathsResponse struct {
+		// List of paths, sorted by ID.
+		Paths goatcounter.Paths `json:"paths"`
+
+		// True if there are more paths.
+		More bool `json:"more"`
+	}
+)
+
+// GET /api/v0/paths paths
+// Get an overview of paths on this site (without statistics).
+//
+// Query: apiPathsRequest
+// Response 200: apiPathsResponse
+func (h api) paths(w http.ResponseWriter, r *http.Request) error {
+	err := h.auth(r, w, goatcounter.APIPermStats)
+	if err != nil {
+		return err
+	}
+
+	args := apiPathsRequest{Limit: 20}
+	if _, err := h.dec.Decode(r, &args); err != nil {
+		return err
+	}
+
+	if h.apiMaxPaths > 0 && args.Limit > h.apiMaxPaths {
+		args.Limit = h.apiMaxPaths
+	}
+	if args.Limit < 1 {
+		args.Limit = 1
+	}
+
+	scope, err := tokenScope(r.Context())
+	if err != nil {
+		return err
+	}
+
+	var p goatcounter.Paths
+	more, err := p.List(r.Context(), goatcounter.MustGetSite(r.Context()).ID, args.After, scope, args.Limit)
+	if err != nil {
+		return err
+	}
+	return zhttp.JSON(w, apiPathsResponse{Paths: p, More: more})
+}
+
+type (
+	apiHitsRequest struct {
+		// Start time, should be rounded to the hour {datetime, default: one week ago}.
+		Start time.Time `json:"start" query:"start"`
+
+		// End time, should be rounded to the hour {datetime, default: current time}.
+		End time.Time `json:"end" query:"end"`
+
+		// Deprecated: identical to group=day and will be removed in the future.
+		Daily bool `json:"daily" query:"daily"`
+
+		// Set Max value in the response to the highest daily, weekly, or
+		// monthly value, instead of hourly.
+		//
+		// The Hourly, Daily, Weekly, and Monthly values are always included in
+		// the response – this only affects the Max value, which is useful if
+		// you want to draw charts like the GoatCounter dashboard: you need to
+		// know the maximum Y-axis value of the chart to draw it.
+		//
+		// {enum: hour day week month}
+		Group goatcounter.Group `json:"group" query:"group"`
+
+		// Include only these path IDs; default is to include everything.
+		//
+		// If path_by_name is set, it will look up paths by name instead of ID.
+		IncludePaths goatcounter.Strings `json:"include_paths" query:"include_paths"`
+
+		// Exclude these path IDs, for pagination.
+		//
+		// If path_by_name is set, it will look up paths by name instead of ID.
+		ExcludePaths goatcounter.Strings `json:"exclude_paths" query:"exclude_paths"`
+
+		// Get values for include_paths and exclude_paths by path name, rather
+		// than path ID. This is more convenient in some cases, but also a bit
+		// slower.
+		PathByName bool `json:"path_by_name" query:"path_by_name"`
+
+		// Maximum number of pages to get {range: 1-100, default: 20}.
+		Limit int `json:"limit" query:"limit"`
+	}
+	apiHitsResponse struct {
+		// Sorted list of paths with their visitor and pageview count.
+		Hits goatcounter.HitLists `json:"hits"`
+
+		// Total number of visitors in the returned result.
+		Total int `json:"total"`
+
+		// More hits after this?
+		More bool `json:"more"`
+	}
+)
+
+// GET /api/v0/stats/hits stats
+// Get an overview of pageviews.
+//
+// Query: apiHitsRequest
+// Response 200: apiHitsResponse
+func (h api) hits(w http.ResponseWriter, r *http.Request) error {
+	err := h.auth(r, w, goatcounter.APIPermStats)
+	if err != nil {
+		return err
+	}
+
+	args := apiHitsRequest{Limit: 20}
+	if _, err := h.dec.Decode(r, &args); err != nil {
+		return err
+	}
+	if h.apiMax > 0 && args.Limit > h.apiMax {
+		args.Limit = h.apiMax
+	}
+	if args.Limit < 1 {
+		args.Limit = 1
+	}
+	if args.Start.IsZero() {
+		args.Start = ztime.StartOf(ztime.AddPeriod(ztime.Now(r.Context()), -7, ztime.Day), ztime.Day)
+	}
+	if args.End.IsZero() {
+		args.End = ztime.EndOf(ztime.Now(r.Context()), ztime.Day)
+	}
+
+	includeIDs, excludeIDs, err := findPaths(r.Context(), args.PathByName, args.IncludePaths, args.ExcludePaths)
+	if err != nil {
+		return err
+	}
+
+	// TODO: backwards compat; remove eventually.
+	if args.Daily && args.Group == goatcounter.GroupHourly {
+		args.Group = goatcounter.GroupDaily
+	}
+
+	var pages goatcounter.HitLists
+	tdu, more, err := pages.List(r.Context(), ztime.NewRange(args.Start).To(args.End),
+		includeIDs, excludeIDs, args.Limit, args.Group)
+	if err != nil {
+		return err
+	}
+
+	return zhttp.JSON(w, apiHitsResponse{
+		Total: tdu,
+		Hits:  pages,
+		More:  more,
+	})
+}
+
+type (
+	apiRefsRequest struct {
+		// Start time, should be rounded to the hour {datetime, default: one week ago}.
+		Start time.Time `json:"start" query:"start"`
+
+		// End time, should be rounded to the hour {datetime, default: current time}.
+		End time.Time `json:"end" query:"end"`
+
+		// Maximum number of pages to get {range: 1-100, default: 20}.
+		Limit int `json:"limit" query:"limit"`
+
+		// Offset for pagination.
+		Offset int `json:"offset" query:"offset"`
+	}
+	apiRefsResponse struct {
+		Refs []goatcounter.HitStat `json:"refs"`
+		More bool                  `json:"more"`
+	}
+)
+
+// GET /api/v0/stats/hits/{path_id} stats
+// Get an overview of referral information for a path.
+//
+// Query: apiRefsRequest
+// Response 200: apiRefsResponse
+func (h api) refs(w http.ResponseWriter, r *http.Request) error {
+	err := h.auth(r, w, goatcounter.APIPermStats)
+	if err != nil {
+		return err
+	}
+
+	v := zvalidate.New()
+	path := goatcounter.PathID(v.Integer32("path_id", chi.URLParam(r, "path_id")))
+	if v.HasErrors() {
+		return v
+	}
+	err = checkScope(r.Context(), path)
+	if err != nil {
+		return err
+	}
+
+	args := apiRefsRequest{Limit: 20}
+	if _, err := h.dec.Decode(r, &args); err != nil {
+		return err
+	}
+	if h.apiMax > 0 && args.Limit > h.apiMax {
+		args.Limit = h.apiMax
+	}
+	if args.Limit < 1 {
+		args.Limit = 1
+	}
+	if args.Start.IsZero() {
+		args.Start = ztime.StartOf(ztime.AddPeriod(ztime.Now(r.Context()), -7, ztime.Day), ztime.Day)
+	}
+	if args.End.IsZero() {
+		args.End = ztime.EndOf(ztime.Now(r.Context()), ztime.Day)
+	}
+
+	var refs goatcounter.HitStats
+	err = refs.ListRefsByPathID(r.Context(), path, ztime.NewRange(args.Start).To(args.End),
+		args.Limit, args.Offset)
+	if err != nil {
+		return err
+	}
+
+	return zhttp.JSON(w, apiRefsResponse{
+		Refs: refs.Stats,
+		More: refs.More,
+	})
+}
+
+type (
+	apiCountTotalRequest struct {
+		// Start time, should be rounded to the hour {datetime, default: one week ago}.
+		Start time.Time `json:"start" query:"start"`
 
 		// End time, should be rounded to the hour {datetime, default: current time}.
 		End time.Time `json:"end" query:"end"`
@@ -1131,6 +1917,76 @@ func (h api) countTotal(w http.ResponseWriter, r *http.Request) error {
 	return zhttp.JSON(w, apiCountTotalResponse{tc.Total, tc.TotalEvents, tc.TotalUTC, total.Stats})
 }
 
+type apiReportRequest struct {
+	// Format of the report {enum: html text, default: html}.
+	Format string `json:"format" query:"format"`
+
+	// Start time {datetime, default: start of the previous report period}.
+	Start time.Time `json:"start" query:"start"`
+
+	// End time {datetime, default: end of the previous report period}.
+	End time.Time `json:"end" query:"end"`
+}
+
+// GET /api/v0/report stats
+// Render the email report.
+//
+// This renders the email report for all sites in the account, with the contents
+// as configured in the preferences of the API token's user. Charts are
+// included as data: URLs in the HTML version.
+//
+// This returns a 204 status code if there were no visitors, and a 403 if the
+// token is restricted to some paths.
+//
+// Query: apiReportRequest
+// Response 200 (text/html): {data}
+// Response 204: {empty}
+func (h api) report(w http.ResponseWriter, r *http.Request) error {
+	err := h.auth(r, w, goatcounter.APIPermStats)
+	if err != nil {
+		return err
+	}
+	// The report includes all paths (and sites), so don't bother trying to
+	// filter it.
+	if t := goatcounter.GetAPIToken(r.Context()); t != nil && t.Filter != "" {
+		return guru.New(403, "this token is restricted to some paths and can't be used for the report")
+	}
+
+	var args apiReportRequest
+	if _, err := h.dec.Decode(r, &args); err != nil {
+		return err
+	}
+	if args.Format != "" && args.Format != "html" && args.Format != "text" {
+		return guru.Errorf(400, "unknown format: %q", args.Format)
+	}
+
+	user := User(r.Context())
+	rng := user.PreviousEmailReportRange(r.Context()).UTC()
+	if !args.Start.IsZero() {
+		rng.Start = args.Start
+	}
+	if !args.End.IsZero() {
+		rng.End = args.End
+	}
+	if rng.End.Before(rng.Start) {
+		return guru.New(400, "end is before start")
+	}
+
+	report, err := cron.RenderReport(r.Context(), *Account(r.Context()), *user, rng)
+	if err != nil {
+		return err
+	}
+	if report == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	if args.Format == "text" {
+		return zhttp.Text(w, string(report.Text))
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	return zhttp.Bytes(w, report.HTMLInline())
+}
+
 type (
 	apiStatsRequest struct {
 		// Start time, should be rounded to the hour {datetime, default: one week ago}.
@@ -1165,15 +2021,14 @@ type (
 // GET /api/v0/stats/{page} stats
 // Get browser/system/etc. stats.
 //
-// Page can be: browsers, systems, locations, languages, sizes, campaigns,
-// toprefs.
+// Page can be: browsers, systems, locations, languages, networks, devices,
+// engines, props, sizes, campaigns, channels, toprefs.
 //
 // Query: apiStatsRequest
 // Response 200: apiStatsResponse
 func (h api) stats(w http.ResponseWriter, r *http.Request) error {
 	v := goatcounter.NewValidate(r.Context())
-	page := v.Include("page", chi.URLParam(r, "page"), []string{
-		"browsers", "systems", "locations", "languages", "sizes", "campaigns", "toprefs"})
+	page := v.Include("page", chi.URLParam(r, "page"), statsPages)
 	if v.HasErrors() {
 		return v
 	}
@@ -1202,26 +2057,8 @@ func (h api) stats(w http.ResponseWriter, r *http.Request) error {
 
 	var (
 		stats goatcounter.HitStats
-		f     func(ctx context.Context, rng ztime.Range, pathFilter goatcounter.PathFilter, limit, offset int) error
+		f     = statsList(&stats, page.(string))
 	)
-	switch page {
-	case "browsers":
-		f = stats.ListBrowsers
-	case "systems":
-		f = stats.ListSystems
-	case "locations":
-		f = stats.ListLocations
-	case "languages":
-		f = stats.ListLanguages
-	case "sizes":
-		f = func(ctx context.Context, rng ztime.Range, pathFilter goatcounter.PathFilter, _, _ int) error {
-			return stats.ListSizes(ctx, rng, pathFilter, false)
-		}
-	case "campaigns":
-		f = stats.ListCampaigns
-	case "toprefs":
-		f = stats.ListTopRefs
-	}
 	includeIDs, _, err := findPaths(r.Context(), args.PathByName, args.IncludePaths, nil)
 	if err != nil {
 		return err
@@ -1245,17 +2082,124 @@ func (h api) stats(w http.ResponseWriter, r *http.Request) error {
 	})
 }
 
+type (
+	apiRevenueRequest struct {
+		// Start time, should be rounded to the hour {datetime, default: one week ago}.
+		Start time.Time `json:"start" query:"start"`
+
+		// End time, should be rounded to the hour {datetime, default: current time}.
+		End time.Time `json:"end" query:"end"`
+
+		// Include only these path IDs; default is to include everything.
+		//
+		// If path_by_name is set, it will look up paths by name instead of ID.
+		IncludePaths goatcounter.Strings `json:"include_paths" query:"include_paths"`
+
+		// Get values for include_paths and exclude_paths by path name, rather
+		// than path ID. This is more convenient in some cases, but also a bit
+		// slower.
+		PathByName bool `json:"path_by_name" query:"path_by_name"`
+
+		// Group the revenue by {enum: total paths refs campaigns days, default: total}.
+		//
+		//   total       Total per currency.
+		//   paths       Per path or event.
+		//   refs        Per referrer of the first pageview in the session.
+		//   campaigns   Per campaign of the first pageview in the session.
+		//   days        Per day.
+		Group string `json:"group" query:"group"`
+
+		// Maximum number of rows to get {range: 1-100, default: 20}.
+		Limit int `json:"limit" query:"limit"`
+
+		// Offset for pagination.
+		Offset int `json:"offset" query:"offset"`
+	}
+	apiRevenueResponse struct {
+		// Sorted list of revenue per currency; a group will have more than one
+		// row if there are values in different currencies.
+		Stats []goatcounter.RevenueStat `json:"stats"`
+		More  bool                      `json:"more"`
+	}
+)
+
+// GET /api/v0/stats/revenue stats
+// Get the revenue (sum and average of values) for events and pageviews.
+//
+// Query: apiRevenueRequest
+// Response 200: apiRevenueResponse
+func (h api) revenue(w http.ResponseWriter, r *http.Request) error {
+	err := h.auth(r, w, goatcounter.APIPermStats)
+	if err != nil {
+		return err
+	}
+
+	args := apiRevenueRequest{Limit: 20, Group: "total"}
+	if _, err := h.dec.Decode(r, &args); err != nil {
+		return err
+	}
+
+	v := goatcounter.NewValidate(r.Context())
+	v.Include("group", args.Group, []string{"total", "paths", "refs", "campaigns", "days"})
+	if v.HasErrors() {
+		return v
+	}
+
+	if h.apiMax > 0 && args.Limit > h.apiMax {
+		args.Limit = h.apiMax
+	}
+	if args.Limit < 1 {
+		args.Limit = 1
+	}
+	if args.Start.IsZero() {
+		args.Start = ztime.StartOf(ztime.AddPeriod(ztime.Now(r.Context()), -7, ztime.Day), ztime.Day)
+	}
+	if args.End.IsZero() {
+		args.End = ztime.EndOf(ztime.Now(r.Context()), ztime.Day)
+	}
+
+	includeIDs, _, err := findPaths(r.Context(), args.PathByName, args.IncludePaths, nil)
+	if err != nil {
+		return err
+	}
+
+	var (
+		stats goatcounter.RevenueStats
+		rng   = ztime.NewRange(args.Start).To(args.End)
+	)
+	switch args.Group {
+	case "total":
+		err = stats.ListTotals(r.Context(), rng, includeIDs, args.Limit, args.Offset)
+	case "paths":
+		err = stats.ListPaths(r.Context(), rng, includeIDs, args.Limit, args.Offset)
+	case "refs":
+		err = stats.ListRefs(r.Context(), rng, includeIDs, args.Limit, args.Offset)
+	case "campaigns":
+		err = stats.ListCampaigns(r.Context(), rng, includeIDs, args.Limit, args.Offset)
+	case "days":
+		err = stats.ListDays(r.Context(), rng, includeIDs, args.Limit, args.Offset)
+	}
+	if err != nil {
+		return err
+	}
+
+	return zhttp.JSON(w, apiRevenueResponse{
+		Stats: stats.Stats,
+		More:  stats.More,
+	})
+}
+
 // GET /api/v0/stats/{page}/{id} stats
 // Get detailed stats for an ID.
 //
-// Page can be: browsers, systems, locations, sizes, campaigns, toprefs.
+// Page can be: browsers, systems, locations, props, sizes, campaigns,
+// channels, toprefs.
 //
 // Query: apiStatsRequest
 // Response 200: apiStatsResponse
 func (h api) statsDetail(w http.ResponseWriter, r *http.Request) error {
 	v := goatcounter.NewValidate(r.Context())
-	page := v.Include("page", chi.URLParam(r, "page"), []string{
-		"browsers", "systems", "locations", "sizes", "campaigns", "toprefs"})
+	page := v.Include("page", chi.URLParam(r, "page"), statsDetailPages)
 	if v.HasErrors() {
 		return v
 	}
@@ -1284,21 +2228,82 @@ func (h api) statsDetail(w http.ResponseWriter, r *http.Request) error {
 
 	var (
 		stats goatcounter.HitStats
-		f     func(ctx context.Context, id string, rng ztime.Range, pathFilter goatcounter.PathFilter, limit, offset int) error
+		f     = statsDetailList(&stats, page.(string))
 	)
+	includeIDs, _, err := findPaths(r.Context(), args.PathByName, args.IncludePaths, nil)
+	if err != nil {
+		return err
+	}
+	err = f(r.Context(), chi.URLParam(r, "id"), ztime.NewRange(args.Start).To(args.End),
+		includeIDs, args.Limit, args.Offset)
+	if err != nil {
+		return err
+	}
+
+	return zhttp.JSON(w, apiStatsResponse{
+		Stats: stats.Stats,
+		More:  stats.More,
+	})
+}
+
+var (
+	statsPages       = []string{"browsers", "systems", "locations", "languages", "networks", "devices", "engines", "props", "sizes", "campaigns", "channels", "toprefs"}
+	statsDetailPages = []string{"browsers", "systems", "locations", "props", "sizes", "campaigns", "channels", "toprefs"}
+)
+
+// statsList gets the function to list the stats for a page in statsPages.
+func statsList(stats *goatcounter.HitStats, page string) func(ctx context.Context, rng ztime.Range, pathFilter goatcounter.PathFilter, limit, offset int) error {
+	switch page {
+	case "browsers":
+		return stats.ListBrowsers
+	case "systems":
+		return stats.ListSystems
+	case "locations":
+		return stats.ListLocations
+	case "languages":
+		return stats.ListLanguages
+	case "networks":
+		return stats.ListNetworks
+	case "devices":
+		return stats.ListDevices
+	case "engines":
+		return stats.ListEngines
+	case "props":
+		return stats.ListProps
+	case "sizes":
+		return func(ctx context.Context, rng ztime.Range, pathFilter goatcounter.PathFilter, _, _ int) error {
+			return stats.ListSizes(ctx, rng, pathFilter, false)
+		}
+	case "campaigns":
+		return stats.ListCampaigns
+	case "channels":
+		return stats.ListChannels
+	case "toprefs":
+		return stats.ListTopRefs
+	}
+	panic(fmt.Sprintf("statsList: unknown page %q", page))
+}
+
+// statsDetailList gets the function to list the detailed stats for a page in
+// statsDetailPages.
+func statsDetailList(stats *goatcounter.HitStats, page string) func(ctx context.Context, id string, rng ztime.Range, pathFilter goatcounter.PathFilter, limit, offset int) error {
 	switch page {
 	case "browsers":
-		f = stats.ListBrowser
+		return stats.ListBrowser
 	case "systems":
-		f = stats.ListSystem
+		return stats.ListSystem
 	case "locations":
-		f = stats.ListLocation
+		return stats.ListLocation
+	case "props":
+		return stats.ListProp
 	case "sizes":
-		f = stats.ListSize
+		return stats.ListSize
 	case "toprefs":
-		f = stats.ListTopRef
+		return stats.ListTopRef
+	case "channels":
+		return stats.ListChannel
 	case "campaigns":
-		f = func(ctx context.Context, id string, rng ztime.Range, pathFilter goatcounter.PathFilter, limit, offset int) error {
+		return func(ctx context.Context, id string, rng ztime.Range, pathFilter goatcounter.PathFilter, limit, offset int) error {
 			n, err := zstrconv.ParseInt[goatcounter.CampaignID](id, 0)
 			if err != nil {
 				return err
@@ -1306,23 +2311,65 @@ func (h api) statsDetail(w http.ResponseWriter, r *http.Request) error {
 			return stats.ListCampaign(ctx, n, rng, pathFilter, limit, offset)
 		}
 	}
-	includeIDs, _, err := findPaths(r.Context(), args.PathByName, args.IncludePaths, nil)
+	panic(fmt.Sprintf("statsDetailList: unknown page %q", page))
+}
+
+func findPaths(ctx context.Context, byName bool, includePaths, excludePaths goatcounter.Strings) (goatcounter.PathFilter, []goatcounter.PathID, error) {
+	includeIDs, excludeIDs, err := findPathIDs(ctx, byName, includePaths, excludePaths)
 	if err != nil {
-		return err
+		return goatcounter.PathFilter{}, nil, err
 	}
-	err = f(r.Context(), chi.URLParam(r, "id"), ztime.NewRange(args.Start).To(args.End),
-		includeIDs, args.Limit, args.Offset)
+
+	scope, err := tokenScope(ctx)
 	if err != nil {
-		return err
+		return goatcounter.PathFilter{}, nil, err
+	}
+	if scope != nil {
+		if len(includeIDs) == 0 {
+			includeIDs = scope
+		} else {
+			includeIDs = slices.DeleteFunc(includeIDs, func(id goatcounter.PathID) bool {
+				return !slices.Contains(scope, id)
+			})
+			if len(includeIDs) == 0 {
+				includeIDs = append(includeIDs, -1)
+			}
+		}
 	}
+	return goatcounter.PathFilterFromIDs(includeIDs), excludeIDs, nil
+}
 
-	return zhttp.JSON(w, apiStatsResponse{
-		Stats: stats.Stats,
-		More:  stats.More,
-	})
+// tokenScope gets the paths the API token for this request is restricted to,
+// or nil if there is no restriction.
+func tokenScope(ctx context.Context) ([]goatcounter.PathID, error) {
+	token := goatcounter.GetAPIToken(ctx)
+	if token == nil {
+		return nil, nil
+	}
+	scope, err := token.ScopePaths(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if scope != nil && len(scope) == 0 {
+		scope = append(scope, -1)
+	}
+	return scope, nil
 }
 
-func findPaths(ctx context.Context, byName bool, includePaths, excludePaths goatcounter.Strings) (goatcounter.PathFilter, []goatcounter.PathID, error) {
+// checkScope returns a 404 if the API token for this request can't access the
+// path.
+func checkScope(ctx context.Context, path goatcounter.PathID) error {
+	scope, err := tokenScope(ctx)
+	if err != nil {
+		return err
+	}
+	if scope != nil && !slices.Contains(scope, path) {
+		return guru.New(404, "")
+	}
+	return nil
+}
+
+func findPathIDs(ctx context.Context, byName bool, includePaths, excludePaths goatcounter.Strings) ([]goatcounter.PathID, []goatcounter.PathID, error) {
 	var (
 		includeIDs = make([]goatcounter.PathID, 0, len(includePaths))
 		excludeIDs = make([]goatcounter.PathID, 0, len(excludePaths))
@@ -1332,7 +2379,7 @@ func findPaths(ctx context.Context, byName bool, includePaths, excludePaths goat
 		if len(includePaths) > 0 {
 			includeIDs, err = goatcounter.FindPathIDs(ctx, includePaths)
 			if err != nil {
-				return goatcounter.PathFilter{}, nil, err
+				return nil, nil, err
 			}
 			if len(includeIDs) == 0 {
 				includeIDs = append(includeIDs, -1)
@@ -1341,28 +2388,28 @@ func findPaths(ctx context.Context, byName bool, includePaths, excludePaths goat
 		if len(excludePaths) > 0 {
 			excludeIDs, err = goatcounter.FindPathIDs(ctx, excludePaths)
 			if err != nil {
-				return goatcounter.PathFilter{}, nil, err
+				return nil, nil, err
 			}
 			if len(excludeIDs) == 0 {
 				excludeIDs = append(excludeIDs, -1)
 			}
 		}
-		return goatcounter.PathFilterFromIDs(includeIDs), excludeIDs, nil
+		return includeIDs, excludeIDs, nil
 	}
 
 	for _, s := range includePaths {
 		n, err := zstrconv.ParseInt[goatcounter.PathID](s, 10)
 		if err != nil {
-			return goatcounter.PathFilter{}, nil, guru.Errorf(400, "invalid number in include_paths: %w", err)
+			return nil, nil, guru.Errorf(400, "invalid number in include_paths: %w", err)
 		}
 		includeIDs = append(includeIDs, n)
 	}
 	for _, s := range excludePaths {
 		n, err := zstrconv.ParseInt[goatcounter.PathID](s, 10)
 		if err != nil {
-			return goatcounter.PathFilter{}, nil, guru.Errorf(400, "invalid number in exclude_paths: %w", err)
+			return nil, nil, guru.Errorf(400, "invalid number in exclude_paths: %w", err)
 		}
 		excludeIDs = append(excludeIDs, n)
 	}
-	return goatcounter.PathFilterFromIDs(includeIDs), excludeIDs, nil
+	return includeIDs, excludeIDs, nil
 }
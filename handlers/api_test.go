@@ -95,6 +95,24 @@ func TestAPIBasics(t *testing.T) {
 			}
 		})
 
+		t.Run("not-admin", func(t *testing.T) {
+			ctx := gctest.DB(t)
+			r, rr := newAPITest(ctx, t, "GET", "/api/v0/test", nil, 0)
+
+			err := zdb.Exec(ctx, `update users set access=? where user_id=?`,
+				goatcounter.UserAccesses{"all": goatcounter.AccessReadOnly}, User(ctx).ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			newBackend(ctx).ServeHTTP(rr, r)
+			ztest.Code(t, rr, 401)
+
+			want := `{"error":"only admins can create and use API keys"}`
+			if rr.Body.String() != want {
+				t.Errorf("\nwant: %s\ngot:  %s\n", want, rr.Body.String())
+			}
+		})
+
 		t.Run("404", func(t *testing.T) {
 			ctx := gctest.DB(t)
 			r, rr := newAPITest(ctx, t, "POST", "/api/v0/doesnt-exist", nil, 0)
@@ -670,6 +688,328 @@ func TestAPISitesUpdate(t *testing.T) {
 	}
 }
 
+func TestAPITokens(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		wantCode int
+		wantErr  string
+	}{
+		{"works", `{"name":"new","permissions":64,"sites":[-1]}`, 200, ""},
+		{"more perms", `{"name":"new","permissions":68,"sites":[-1]}`, 403, "export permissions"},
+	}
+
+	perm := goatcounter.APIPermTokenManage | goatcounter.APIPermStats
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := gctest.DB(t)
+
+			r, rr := newAPITest(ctx, t, "PUT", "/api/v0/tokens", strings.NewReader(tt.body), perm)
+			newBackend(ctx).ServeHTTP(rr, r)
+			ztest.Code(t, rr, tt.wantCode)
+			if tt.wantErr != "" {
+				if !strings.Contains(rr.Body.String(), tt.wantErr) {
+					t.Errorf("wrong error: %s", rr.Body.String())
+				}
+				return
+			}
+
+			var created apiToken
+			if err := json.Unmarshal(rr.Body.Bytes(), &created); err != nil {
+				t.Fatal(err)
+			}
+			if created.Token == "" {
+				t.Fatal("token is empty")
+			}
+
+			r, rr = newAPITest(ctx, t, "POST", fmt.Sprintf("/api/v0/tokens/%d/rotate", created.ID), nil, perm)
+			newBackend(ctx).ServeHTTP(rr, r)
+			ztest.Code(t, rr, 200)
+			var rotated apiToken
+			if err := json.Unmarshal(rr.Body.Bytes(), &rotated); err != nil {
+				t.Fatal(err)
+			}
+			if rotated.Token == "" || rotated.Token == created.Token {
+				t.Errorf("token not rotated: %q", rotated.Token)
+			}
+
+			r, rr = newAPITest(ctx, t, "DELETE", fmt.Sprintf("/api/v0/tokens/%d", created.ID), nil, perm)
+			newBackend(ctx).ServeHTTP(rr, r)
+			ztest.Code(t, rr, 204)
+
+			var tok goatcounter.APIToken
+			if err := tok.ByID(ctx, created.ID); !zdb.ErrNoRows(err) {
+				t.Errorf("token not deleted: %v", err)
+			}
+		})
+	}
+}
+
+func TestAPITokenLimits(t *testing.T) {
+	ctx := gctest.DB(t)
+	for _, p := range []goatcounter.Path{
+		{Site: 1, Path: "/a", Title: "Hello"},
+		{Site: 1, Path: "/b", Title: "Hello"},
+	} {
+		err := p.GetOrInsert(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	newToken := func(t *testing.T, tok goatcounter.APIToken) goatcounter.APIToken {
+		t.Helper()
+		tok.Name, tok.Sites = "test", goatcounter.SiteIDs{-1}
+		tok.Permissions |= goatcounter.APIPermNothing | goatcounter.APIPermStats
+		err := tok.Insert(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tok
+	}
+	get := func(t *testing.T, tok goatcounter.APIToken, path string, wantCode int) string {
+		t.Helper()
+		r, rr := newTest(ctx, "GET", path, nil)
+		r.Header.Set("Authorization", "Bearer "+tok.Token)
+		newBackend(ctx).ServeHTTP(rr, r)
+		ztest.Code(t, rr, wantCode)
+		return rr.Body.String()
+	}
+
+	t.Run("expired", func(t *testing.T) {
+		tok := newToken(t, goatcounter.APIToken{ExpiresAt: new(ztime.Now(ctx).Add(time.Hour))})
+		get(t, tok, "/api/v0/paths", 200)
+
+		err := zdb.Exec(ctx, `update api_tokens set expires_at = ? where api_token_id = ?`,
+			ztime.Now(ctx).Add(-time.Hour), tok.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if have := get(t, tok, "/api/v0/paths", 401); !strings.Contains(have, "token expired") {
+			t.Errorf("wrong error: %s", have)
+		}
+	})
+
+	t.Run("allowed ips", func(t *testing.T) {
+		tok := newToken(t, goatcounter.APIToken{AllowedIPs: goatcounter.Strings{"192.0.2.0/24"}})
+		get(t, tok, "/api/v0/paths", 200)
+
+		tok = newToken(t, goatcounter.APIToken{AllowedIPs: goatcounter.Strings{"10.0.0.0/8", "2001:db8::1"}})
+		if have := get(t, tok, "/api/v0/paths", 403); !strings.Contains(have, "IP address") {
+			t.Errorf("wrong error: %s", have)
+		}
+	})
+
+	t.Run("filter", func(t *testing.T) {
+		tok := newToken(t, goatcounter.APIToken{Filter: "/a"})
+		have := get(t, tok, "/api/v0/paths", 200)
+		if !strings.Contains(have, `"/a"`) || strings.Contains(have, `"/b"`) {
+			t.Errorf("wrong paths: %s", have)
+		}
+		get(t, tok, "/api/v0/stats/hits/2", 404)
+		get(t, tok, "/api/v0/stats/hits/1", 200)
+	})
+
+	t.Run("usage", func(t *testing.T) {
+		tok := newToken(t, goatcounter.APIToken{})
+		get(t, tok, "/api/v0/paths", 200)
+		get(t, tok, "/api/v0/paths?limit=1", 200)
+		// Failed requests aren't counted.
+		get(t, tok, "/api/v0/sites", 403)
+
+		err := goatcounter.PersistAPITokenUse(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = tok.ByID(ctx, tok.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tok.UsageCount != 2 || tok.LastUsedAt == nil {
+			t.Errorf("usage_count=%d; last_used_at=%v", tok.UsageCount, tok.LastUsedAt)
+		}
+
+		var log goatcounter.APITokenLogs
+		err = log.List(ctx, tok.ID, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(log) != 2 || log[0].Method != "GET" || log[0].Path != "/api/v0/paths" {
+			t.Errorf("wrong log: %#v", log)
+		}
+	})
+
+	t.Run("create", func(t *testing.T) {
+		tok := newToken(t, goatcounter.APIToken{
+			Permissions: goatcounter.APIPermTokenManage,
+			ExpiresAt:   new(ztime.Now(ctx).Add(24 * time.Hour)),
+			AllowedIPs:  goatcounter.Strings{"192.0.2.0/24"},
+		})
+		put := func(body string, wantCode int) string {
+			t.Helper()
+			r, rr := newTest(ctx, "PUT", "/api/v0/tokens", strings.NewReader(body))
+			r.Header.Set("Authorization", "Bearer "+tok.Token)
+			newBackend(ctx).ServeHTTP(rr, r)
+			ztest.Code(t, rr, wantCode)
+			return rr.Body.String()
+		}
+
+		if have := put(`{"name":"new","permissions":64,"sites":[-1]}`, 403); !strings.Contains(have, "expires after") {
+			t.Errorf("wrong error: %s", have)
+		}
+		if have := put(fmt.Sprintf(`{"name":"new","permissions":64,"sites":[-1],"expires_at":%q,"allowed_ips":["10.0.0.1"]}`,
+			ztime.Now(ctx).Add(time.Hour).Format(time.RFC3339)), 403); !strings.Contains(have, "10.0.0.1") {
+			t.Errorf("wrong error: %s", have)
+		}
+
+		have := put(fmt.Sprintf(`{"name":"new","permissions":64,"sites":[-1],"expires_at":%q}`,
+			ztime.Now(ctx).Add(time.Hour).Format(time.RFC3339)), 200)
+		var created apiToken
+		if err := json.Unmarshal([]byte(have), &created); err != nil {
+			t.Fatal(err)
+		}
+		if created.ExpiresAt == nil || len(created.AllowedIPs) != 1 || created.AllowedIPs[0] != "192.0.2.0/24" {
+			t.Errorf("wrong token: %s", have)
+		}
+	})
+
+	t.Run("create sites", func(t *testing.T) {
+		tok := goatcounter.APIToken{
+			Name:        "test",
+			Permissions: goatcounter.APIPermNothing | goatcounter.APIPermTokenManage | goatcounter.APIPermStats,
+			Sites:       goatcounter.SiteIDs{1},
+		}
+		err := tok.Insert(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		put := func(body string, wantCode int) string {
+			t.Helper()
+			r, rr := newTest(ctx, "PUT", "/api/v0/tokens", strings.NewReader(body))
+			r.Header.Set("Authorization", "Bearer "+tok.Token)
+			newBackend(ctx).ServeHTTP(rr, r)
+			ztest.Code(t, rr, wantCode)
+			return rr.Body.String()
+		}
+
+		if have := put(`{"name":"new","permissions":64,"sites":[-1]}`, 403); !strings.Contains(have, "all sites") {
+			t.Errorf("wrong error: %s", have)
+		}
+		if have := put(`{"name":"new","permissions":64,"sites":[1,2]}`, 403); !strings.Contains(have, "site 2") {
+			t.Errorf("wrong error: %s", have)
+		}
+		put(`{"name":"new","permissions":64,"sites":[1]}`, 200)
+	})
+}
+
+func TestAPIUsers(t *testing.T) {
+	ctx := gctest.DB(t)
+	perm := goatcounter.APIPermUserRead | goatcounter.APIPermUserManage
+
+	r, rr := newAPITest(ctx, t, "PUT", "/api/v0/users",
+		strings.NewReader(`{"email":"new@example.com","access":{"all":"r"}}`), perm)
+	newBackend(ctx).ServeHTTP(rr, r)
+	ztest.Code(t, rr, 200)
+
+	r, rr = newAPITest(ctx, t, "GET", "/api/v0/users", nil, perm)
+	newBackend(ctx).ServeHTTP(rr, r)
+	ztest.Code(t, rr, 200)
+	if !strings.Contains(rr.Body.String(), `"new@example.com"`) {
+		t.Errorf("new user not in list: %s", rr.Body.String())
+	}
+
+	r, rr = newAPITest(ctx, t, "DELETE", fmt.Sprintf("/api/v0/users/%d", User(ctx).ID), nil, perm)
+	newBackend(ctx).ServeHTTP(rr, r)
+	ztest.Code(t, rr, 400)
+}
+
+func TestAPIAuditLog(t *testing.T) {
+	ctx := gctest.DB(t)
+
+	r, rr := newAPITest(ctx, t, "PUT", "/api/v0/users",
+		strings.NewReader(`{"email":"new@example.com","access":{"all":"r"}}`), goatcounter.APIPermUserManage)
+	newBackend(ctx).ServeHTTP(rr, r)
+	ztest.Code(t, rr, 200)
+
+	r, rr = newAPITest(ctx, t, "GET", "/api/v0/audit-log", nil, goatcounter.APIPermUserRead)
+	newBackend(ctx).ServeHTTP(rr, r)
+	ztest.Code(t, rr, 403)
+
+	r, rr = newAPITest(ctx, t, "GET", "/api/v0/audit-log?action=user.create", nil, goatcounter.APIPermAuditRead)
+	newBackend(ctx).ServeHTTP(rr, r)
+	ztest.Code(t, rr, 200)
+
+	var resp apiAuditLogResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Entries) != 1 || resp.More {
+		t.Fatalf("wrong response: %s", rr.Body.String())
+	}
+	e := resp.Entries[0]
+	if e.Action != goatcounter.AuditUserCreate || e.Actor != "test@gctest.localhost" || e.APITokenID == nil {
+		t.Errorf("wrong entry: %s", rr.Body.String())
+	}
+	if !strings.Contains(string(e.After), `"new@example.com"`) {
+		t.Errorf("wrong after: %s", e.After)
+	}
+
+	r, rr = newAPITest(ctx, t, "GET", "/api/v0/audit-log?action=nope", nil, goatcounter.APIPermAuditRead)
+	newBackend(ctx).ServeHTTP(rr, r)
+	ztest.Code(t, rr, 400)
+}
+
+func TestAPIAnnotations(t *testing.T) {
+	ctx := gctest.DB(t)
+
+	r, rr := newAPITest(ctx, t, "POST", "/api/v0/annotations",
+		strings.NewReader(`{"label":"v2.3 released"}`), goatcounter.APIPermStats)
+	newBackend(ctx).ServeHTTP(rr, r)
+	ztest.Code(t, rr, 403)
+
+	r, rr = newAPITest(ctx, t, "POST", "/api/v0/annotations",
+		strings.NewReader(`{"label":""}`), goatcounter.APIPermAnnotations)
+	newBackend(ctx).ServeHTTP(rr, r)
+	ztest.Code(t, rr, 400)
+
+	r, rr = newAPITest(ctx, t, "POST", "/api/v0/annotations",
+		strings.NewReader(`{"label":"v2.3 released","at":"2026-10-01T14:30:00Z","filter":"/blog"}`), goatcounter.APIPermAnnotations)
+	newBackend(ctx).ServeHTTP(rr, r)
+	ztest.Code(t, rr, 200)
+
+	var a goatcounter.Annotation
+	if err := json.Unmarshal(rr.Body.Bytes(), &a); err != nil {
+		t.Fatal(err)
+	}
+	if a.ID == 0 || a.Label != "v2.3 released" || a.Filter != "/blog" || !a.At.Equal(time.Date(2026, 10, 1, 14, 30, 0, 0, time.UTC)) {
+		t.Errorf("wrong annotation: %s", rr.Body.String())
+	}
+
+	r, rr = newAPITest(ctx, t, "GET", "/api/v0/annotations", nil, goatcounter.APIPermStats)
+	newBackend(ctx).ServeHTTP(rr, r)
+	ztest.Code(t, rr, 200)
+	var resp apiAnnotationsResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Annotations) != 1 || resp.Annotations[0].ID != a.ID {
+		t.Fatalf("wrong response: %s", rr.Body.String())
+	}
+
+	r, rr = newAPITest(ctx, t, "DELETE", fmt.Sprintf("/api/v0/annotations/%d", a.ID), nil, goatcounter.APIPermAnnotations)
+	newBackend(ctx).ServeHTTP(rr, r)
+	ztest.Code(t, rr, 204)
+
+	var list goatcounter.Annotations
+	err := list.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 0 {
+		t.Errorf("not deleted: %v", list)
+	}
+}
+
 func TestAPIPaths(t *testing.T) {
 	many := func(ctx context.Context, t *testing.T) {
 		p := make(goatcounter.Paths, 50)
@@ -976,3 +1316,175 @@ func TestAPIStatsDetail(t *testing.T) {
 		})
 	}
 }
+
+func TestAPIReport(t *testing.T) {
+	ctx := gctest.DB(t)
+
+	r, rr := newAPITest(ctx, t, "GET", "/api/v0/report", nil, goatcounter.APIPermStats)
+	err := zdb.Exec(ctx, `update api_tokens set filter='/a'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newBackend(ctx).ServeHTTP(rr, r)
+	ztest.Code(t, rr, 403)
+	if !strings.Contains(rr.Body.String(), "restricted to some paths") {
+		t.Error(rr.Body.String())
+	}
+}
+
+func TestAPIV1(t *testing.T) {
+	perm := goatcounter.APIPermStats
+
+	t.Run("errors", func(t *testing.T) {
+		tests := []struct {
+			path     string
+			perm     zint.Bitflag64
+			wantCode int
+			want     string
+		}{
+			{"/api/v1/doesnt-exist", perm, 404,
+				`{"error": {"code": "not_found", "message": "not found"}}`},
+			{"/api/v1/paths", goatcounter.APIPermCount, 403,
+				`{"error": {"code": "forbidden", "message": "requires 'stats' permissions"}}`},
+			{"/api/v1/paths?cursor=xxx&limit=1000", perm, 400, `{"error": {
+				"code":    "validation",
+				"message": "invalid parameters",
+				"fields":  {"cursor": ["invalid cursor"], "limit": ["must be 200 or lower"]}}}`},
+			{"/api/v1/hits?start=yesterday&tz=Nowhere/Nothing", perm, 400, `{"error": {
+				"code":    "validation",
+				"message": "invalid parameters",
+				"fields":  {
+					"start": ["must be a date (2006-01-02) or RFC 3339 timestamp (2006-01-02T15:04:05Z)"],
+					"tz":    ["unknown timezone"]}}}`},
+			{"/api/v1/stats/xxx", perm, 400, `{"error": {
+				"code":    "validation",
+				"message": "invalid parameters",
+				"fields":  {"page": ["must be one of ‘browsers, systems, locations, languages, networks, devices, engines, props, sizes, campaigns, channels, toprefs’"]}}}`},
+			{"/api/v1/stats/sizes?cursor=" + apiV1Cursor{Endpoint: "stats/sizes", Offset: 20}.String(), perm, 400, `{"error": {
+				"code":    "validation",
+				"message": "invalid parameters",
+				"fields":  {"cursor": ["not supported for sizes"]}}}`},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.path, func(t *testing.T) {
+				ctx := gctest.DB(t)
+				r, rr := newAPITest(ctx, t, "GET", tt.path, nil, tt.perm)
+				newBackend(ctx).ServeHTTP(rr, r)
+				ztest.Code(t, rr, tt.wantCode)
+
+				if d := ztest.Diff(rr.Body.String(), tt.want, ztest.DiffJSON); d != "" {
+					t.Error(d)
+				}
+			})
+		}
+	})
+
+	t.Run("cursor", func(t *testing.T) {
+		ctx := gctest.DB(t)
+		ctx = ztime.WithNow(ctx, ztime.FromString("2020-06-18 12:13:14"))
+		h := make(goatcounter.Hits, 5)
+		for i := range h {
+			h[i].Path = "/" + strconv.Itoa(i+1)
+			h[i].FirstVisit = true
+		}
+		// Some paths with the same count and some with a different one, to
+		// make sure the hits cursor works with both.
+		h = append(h,
+			goatcounter.Hit{Path: "/2", FirstVisit: true},
+			goatcounter.Hit{Path: "/3", FirstVisit: true})
+		gctest.StoreHits(ctx, t, false, h...)
+
+		for _, endpoint := range []string{"paths", "hits"} {
+			t.Run(endpoint, func(t *testing.T) {
+				var (
+					cursor string
+					seen   = make(map[int64]bool)
+				)
+				for range 10 {
+					r, rr := newAPITest(ctx, t, "GET", "/api/v1/"+endpoint+"?limit=2&cursor="+cursor, nil, perm)
+					newBackend(ctx).ServeHTTP(rr, r)
+					ztest.Code(t, rr, 200)
+
+					var resp struct {
+						Data []struct {
+							ID     int64 `json:"id"`
+							PathID int64 `json:"path_id"`
+						} `json:"data"`
+						NextCursor string `json:"next_cursor"`
+					}
+					if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+						t.Fatal(err)
+					}
+					for _, d := range resp.Data {
+						id := max(d.ID, d.PathID)
+						if seen[id] {
+							t.Errorf("path %d returned twice", id)
+						}
+						seen[id] = true
+					}
+					cursor = resp.NextCursor
+					if cursor == "" {
+						break
+					}
+				}
+
+				if len(seen) != 5 {
+					t.Errorf("got %d results; want 5", len(seen))
+				}
+			})
+		}
+	})
+
+	t.Run("tz", func(t *testing.T) {
+		ctx := gctest.DB(t)
+		ctx = ztime.WithNow(ctx, ztime.FromString("2020-06-18 12:13:14"))
+		gctest.StoreHits(ctx, t, false, goatcounter.Hit{
+			Path: "/a", FirstVisit: true, CreatedAt: ztime.FromString("2020-06-17 23:30:00")})
+
+		for _, tt := range []struct {
+			query string
+			want  int
+		}{
+			{"start=2020-06-17&end=2020-06-17", 1},
+			{"start=2020-06-17&end=2020-06-17&tz=Asia/Tokyo", 0},
+			{"start=2020-06-18&end=2020-06-18&tz=Asia/Tokyo", 1},
+			{"start=2020-06-17T23:00:00Z&end=2020-06-17T23:59:59Z&tz=Asia/Tokyo", 1},
+		} {
+			t.Run(tt.query, func(t *testing.T) {
+				r, rr := newAPITest(ctx, t, "GET", "/api/v1/hits?"+tt.query, nil, perm)
+				newBackend(ctx).ServeHTTP(rr, r)
+				ztest.Code(t, rr, 200)
+
+				var resp struct {
+					Total int `json:"total"`
+				}
+				if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+					t.Fatal(err)
+				}
+				if resp.Total != tt.want {
+					t.Errorf("total %d; want %d", resp.Total, tt.want)
+				}
+			})
+		}
+	})
+
+	t.Run("spec", func(t *testing.T) {
+		spec := apiV1Spec("https://example.com/api/v1")
+		j, err := json.Marshal(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range []string{
+			`"openapi":"3.1.0"`,
+			`"#/components/schemas/PathsResponse"`,
+			`"#/components/schemas/HitList"`,
+			`"#/components/parameters/cursor"`,
+			`"#/components/responses/Error"`,
+		} {
+			if !strings.Contains(string(j), want) {
+				t.Errorf("%q not in spec", want)
+			}
+		}
+	})
+}
@@ -0,0 +1,501 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"zgo.at/errors"
+	"zgo.at/goatcounter/v2"
+	"zgo.at/guru"
+	"zgo.at/json"
+	"zgo.at/tz"
+	"zgo.at/zhttp"
+	"zgo.at/zstd/ztime"
+	"zgo.at/zvalidate"
+)
+
+// The v1 API differs from v0 in that:
+//
+//   - All errors are reported as {"error": {"code": .., "message": ..}}.
+//   - All lists are paginated with an opaque cursor, rather than a mix of
+//     "after", "exclude_paths", and "offset".
+//   - The start, end, and tz parameters are the same for all endpoints.
+//
+// The OpenAPI spec is generated from the apiV1Endpoints list in
+// api_v1_spec.go, so be sure to update that when adding or changing endpoints.
+
+// Error codes for the v1 API.
+const (
+	apiV1CodeBadRequest       = "bad_request"
+	apiV1CodeValidation       = "validation"
+	apiV1CodeUnauthorized     = "unauthorized"
+	apiV1CodeForbidden        = "forbidden"
+	apiV1CodeNotFound         = "not_found"
+	apiV1CodeMethodNotAllowed = "method_not_allowed"
+	apiV1CodeUnsupportedType  = "unsupported_media_type"
+	apiV1CodeRateLimited      = "rate_limited"
+	apiV1CodeTimeout          = "timeout"
+	apiV1CodeInternal         = "internal"
+)
+
+var apiV1Codes = []string{apiV1CodeBadRequest, apiV1CodeValidation,
+	apiV1CodeUnauthorized, apiV1CodeForbidden, apiV1CodeNotFound,
+	apiV1CodeMethodNotAllowed, apiV1CodeUnsupportedType, apiV1CodeRateLimited,
+	apiV1CodeTimeout, apiV1CodeInternal}
+
+type (
+	apiV1Error struct {
+		Error apiV1ErrorBody `json:"error"`
+	}
+	apiV1ErrorBody struct {
+		Code    string              `json:"code"`
+		Message string              `json:"message"`
+		Fields  map[string][]string `json:"fields,omitempty"`
+	}
+)
+
+func apiV1ErrorCode(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return apiV1CodeBadRequest
+	case http.StatusUnauthorized:
+		return apiV1CodeUnauthorized
+	case http.StatusForbidden:
+		return apiV1CodeForbidden
+	case http.StatusNotFound:
+		return apiV1CodeNotFound
+	case http.StatusMethodNotAllowed:
+		return apiV1CodeMethodNotAllowed
+	case http.StatusUnsupportedMediaType:
+		return apiV1CodeUnsupportedType
+	case http.StatusTooManyRequests:
+		return apiV1CodeRateLimited
+	case http.StatusGatewayTimeout:
+		return apiV1CodeTimeout
+	}
+	if status >= 500 {
+		return apiV1CodeInternal
+	}
+	return apiV1CodeBadRequest
+}
+
+// v1 wraps a handler to report errors with the v1 error envelope.
+func (h api) v1(fn zhttp.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		apiV1ErrPage(w, r, fn(w, r))
+	}
+}
+
+// apiV1ErrPage is like ErrPage, but always writes the v1 error envelope.
+func apiV1ErrPage(w http.ResponseWriter, r *http.Request, reported error) {
+	if reported == nil {
+		return
+	}
+
+	status, userErr := zhttp.UserError(reported)
+	if status >= 500 {
+		logServerError(r, reported)
+	}
+
+	body := apiV1ErrorBody{Code: apiV1ErrorCode(status), Message: userErr.Error()}
+	var (
+		vErr  zvalidate.Validator
+		vpErr *zvalidate.Validator
+	)
+	switch {
+	case errors.As(reported, &vpErr):
+		body.Code, body.Message, body.Fields = apiV1CodeValidation, "invalid parameters", vpErr.Errors
+	case errors.As(reported, &vErr):
+		body.Code, body.Message, body.Fields = apiV1CodeValidation, "invalid parameters", vErr.Errors
+	}
+
+	j, err := json.Marshal(apiV1Error{Error: body})
+	if err != nil {
+		logServerError(r, err)
+	}
+	if ww, ok := w.(statusWriter); !ok || ww.Status() == 0 {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(status)
+	}
+	w.Write(j)
+}
+
+// apiV1Cursor is the pagination cursor. This is sent to clients as base64
+// encoded JSON, and clients shouldn't depend on the contents.
+type apiV1Cursor struct {
+	// Endpoint this cursor is for, so that passing a cursor for a different
+	// endpoint gives an error rather than a confusing result.
+	Endpoint string `json:"e"`
+
+	After  goatcounter.PathID `json:"a,omitempty"` // paths, hits
+	Count  int                `json:"c,omitempty"` // hits
+	Offset int                `json:"o,omitempty"` // refs, stats
+}
+
+func (c apiV1Cursor) String() string {
+	j, err := json.Marshal(c)
+	if err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(j)
+}
+
+func parseAPIV1Cursor(v *zvalidate.Validator, cursor, endpoint string) apiV1Cursor {
+	c := apiV1Cursor{Endpoint: endpoint}
+	if cursor == "" {
+		return c
+	}
+
+	j, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err == nil {
+		err = json.Unmarshal(j, &c)
+	}
+	if err != nil || c.Endpoint != endpoint {
+		v.Append("cursor", "invalid cursor")
+	}
+	return c
+}
+
+// parseAPIV1Limit validates the limit, which defaults to 20.
+func parseAPIV1Limit(v *zvalidate.Validator, limit, maxLimit int) int {
+	if limit == 0 {
+		return 20
+	}
+	v.Range("limit", int64(limit), 1, int64(maxLimit))
+	return limit
+}
+
+// parseAPIV1Range parses the start, end, and tz parameters.
+//
+// If the tz parameter is given this returns a copy of the request with the user
+// on the context set to that timezone, so that everything uses this timezone
+// rather than the one from the user's settings.
+func parseAPIV1Range(v *zvalidate.Validator, r *http.Request, start, end, zone string) (ztime.Range, *http.Request) {
+	user := goatcounter.MustGetUser(r.Context())
+	loc := user.Settings.Timezone.Loc()
+	if zone != "" {
+		z, err := tz.New("", zone)
+		if err != nil {
+			v.Append("tz", "unknown timezone")
+		} else {
+			u := *user
+			u.Settings.Timezone = z
+			r = r.WithContext(goatcounter.WithUser(r.Context(), &u))
+			loc = z.Loc()
+		}
+	}
+
+	rng := ztime.NewRange(ztime.Now(r.Context()).In(loc)).Current(ztime.Day)
+	rng.Start = ztime.AddPeriod(rng.Start, -7, ztime.Day)
+	if start != "" {
+		rng.Start = parseAPIV1Time(v, "start", start, loc, false)
+	}
+	if end != "" {
+		rng.End = parseAPIV1Time(v, "end", end, loc, true)
+	}
+	if !v.HasErrors() && rng.End.Before(rng.Start) {
+		v.Append("end", "must be after start")
+	}
+	return rng.UTC(), r
+}
+
+// parseAPIV1Time parses a date or RFC 3339 timestamp. Dates are in the given
+// location and inclusive: the end is the end of the day.
+func parseAPIV1Time(v *zvalidate.Validator, key, value string, loc *time.Location, end bool) time.Time {
+	if t, err := time.ParseInLocation("2006-01-02", value, loc); err == nil {
+		if end {
+			return ztime.EndOf(t, ztime.Day)
+		}
+		return t
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		v.Append(key, "must be a date (2006-01-02) or RFC 3339 timestamp (2006-01-02T15:04:05Z)")
+	}
+	return t
+}
+
+func (h api) v1NotFound(w http.ResponseWriter, r *http.Request) error {
+	if r.Method == "OPTIONS" {
+		return nil
+	}
+
+	// Same as the catch-all for /api/*; make sure we return a 405 if we have a
+	// handler with a different verb.
+	rctx := chi.RouteContext(r.Context())
+	for _, m := range []string{"GET", "POST", "PATCH", "DELETE"} {
+		if rctx.Routes.Find(rctx, m, r.URL.Path) != "/api/v1/*" {
+			return guru.New(405, "method not allowed")
+		}
+	}
+	return guru.New(404, "not found")
+}
+
+type (
+	apiV1PathsRequest struct {
+		Limit  int    `json:"limit"`
+		Cursor string `json:"cursor"`
+	}
+	apiV1PathsResponse struct {
+		// List of paths, sorted by ID.
+		Data goatcounter.Paths `json:"data"`
+
+		// Cursor for the next page; empty if there are no more results.
+		NextCursor string `json:"next_cursor"`
+	}
+)
+
+// Get an overview of paths on this site (without statistics).
+func (h api) v1Paths(w http.ResponseWriter, r *http.Request) error {
+	err := h.auth(r, w, goatcounter.APIPermStats)
+	if err != nil {
+		return err
+	}
+
+	var args apiV1PathsRequest
+	if _, err := h.dec.Decode(r, &args); err != nil {
+		return err
+	}
+	v := goatcounter.NewValidate(r.Context())
+	var (
+		limit  = parseAPIV1Limit(&v, args.Limit, h.apiMaxPaths)
+		cursor = parseAPIV1Cursor(&v, args.Cursor, "paths")
+	)
+	if v.HasErrors() {
+		return v
+	}
+
+	scope, err := tokenScope(r.Context())
+	if err != nil {
+		return err
+	}
+
+	var p goatcounter.Paths
+	more, err := p.List(r.Context(), goatcounter.MustGetSite(r.Context()).ID, cursor.After, scope, limit)
+	if err != nil {
+		return err
+	}
+
+	resp := apiV1PathsResponse{Data: p}
+	if more && len(p) > 0 {
+		cursor.After = p[len(p)-1].ID
+		resp.NextCursor = cursor.String()
+	}
+	return zhttp.JSON(w, resp)
+}
+
+type (
+	apiV1HitsRequest struct {
+		Start        string              `json:"start"`
+		End          string              `json:"end"`
+		TZ           string              `json:"tz"`
+		Group        goatcounter.Group   `json:"group"`
+		IncludePaths goatcounter.Strings `json:"include_paths"`
+		PathByName   bool                `json:"path_by_name"`
+		Limit        int                 `json:"limit"`
+		Cursor       string              `json:"cursor"`
+	}
+	apiV1HitsResponse struct {
+		// Sorted list of paths with their visitor count.
+		Data goatcounter.HitLists `json:"data"`
+
+		// Total number of visitors in the returned result.
+		Total int `json:"total"`
+
+		// Cursor for the next page; empty if there are no more results.
+		NextCursor string `json:"next_cursor"`
+	}
+)
+
+// Get an overview of pageviews, sorted by the number of visitors.
+func (h api) v1Hits(w http.ResponseWriter, r *http.Request) error {
+	err := h.auth(r, w, goatcounter.APIPermStats)
+	if err != nil {
+		return err
+	}
+
+	var args apiV1HitsRequest
+	if _, err := h.dec.Decode(r, &args); err != nil {
+		return err
+	}
+	v := goatcounter.NewValidate(r.Context())
+	var (
+		limit  = parseAPIV1Limit(&v, args.Limit, h.apiMax)
+		cursor = parseAPIV1Cursor(&v, args.Cursor, "hits")
+	)
+	rng, r := parseAPIV1Range(&v, r, args.Start, args.End, args.TZ)
+	if v.HasErrors() {
+		return v
+	}
+
+	includeIDs, _, err := findPaths(r.Context(), args.PathByName, args.IncludePaths, nil)
+	if err != nil {
+		return err
+	}
+
+	var pages goatcounter.HitLists
+	tdu, more, err := pages.ListAfter(r.Context(), rng, includeIDs, cursor.Count, cursor.After, limit, args.Group)
+	if err != nil {
+		return err
+	}
+
+	resp := apiV1HitsResponse{Data: pages, Total: tdu}
+	if more && len(pages) > 0 {
+		last := pages[len(pages)-1]
+		cursor.Count, cursor.After = last.Count, last.PathID
+		resp.NextCursor = cursor.String()
+	}
+	return zhttp.JSON(w, resp)
+}
+
+type (
+	apiV1StatsRequest struct {
+		Start        string              `json:"start"`
+		End          string              `json:"end"`
+		TZ           string              `json:"tz"`
+		IncludePaths goatcounter.Strings `json:"include_paths"`
+		PathByName   bool                `json:"path_by_name"`
+		Limit        int                 `json:"limit"`
+		Cursor       string              `json:"cursor"`
+	}
+	apiV1RefsRequest struct {
+		Start  string `json:"start"`
+		End    string `json:"end"`
+		TZ     string `json:"tz"`
+		Limit  int    `json:"limit"`
+		Cursor string `json:"cursor"`
+	}
+	apiV1StatsResponse struct {
+		// Sorted list of entries with their visitor count.
+		Data []goatcounter.HitStat `json:"data"`
+
+		// Cursor for the next page; empty if there are no more results.
+		NextCursor string `json:"next_cursor"`
+	}
+)
+
+func (r apiV1StatsResponse) withCursor(more bool, c apiV1Cursor) apiV1StatsResponse {
+	if more {
+		c.Offset += len(r.Data)
+		r.NextCursor = c.String()
+	}
+	return r
+}
+
+// Get an overview of referrers for a path.
+func (h api) v1Refs(w http.ResponseWriter, r *http.Request) error {
+	err := h.auth(r, w, goatcounter.APIPermStats)
+	if err != nil {
+		return err
+	}
+
+	var args apiV1RefsRequest
+	if _, err := h.dec.Decode(r, &args); err != nil {
+		return err
+	}
+	v := goatcounter.NewValidate(r.Context())
+	var (
+		path   = goatcounter.PathID(v.Integer32("path_id", chi.URLParam(r, "path_id")))
+		limit  = parseAPIV1Limit(&v, args.Limit, h.apiMax)
+		cursor = parseAPIV1Cursor(&v, args.Cursor, "refs")
+	)
+	rng, r := parseAPIV1Range(&v, r, args.Start, args.End, args.TZ)
+	if v.HasErrors() {
+		return v
+	}
+	err = checkScope(r.Context(), path)
+	if err != nil {
+		return err
+	}
+
+	var refs goatcounter.HitStats
+	err = refs.ListRefsByPathID(r.Context(), path, rng, limit, cursor.Offset)
+	if err != nil {
+		return err
+	}
+	return zhttp.JSON(w, apiV1StatsResponse{Data: refs.Stats}.withCursor(refs.More, cursor))
+}
+
+// Get browser, system, location, etc. stats.
+func (h api) v1Stats(w http.ResponseWriter, r *http.Request) error {
+	err := h.auth(r, w, goatcounter.APIPermStats)
+	if err != nil {
+		return err
+	}
+
+	var args apiV1StatsRequest
+	if _, err := h.dec.Decode(r, &args); err != nil {
+		return err
+	}
+	v := goatcounter.NewValidate(r.Context())
+	var (
+		page   = v.Include("page", chi.URLParam(r, "page"), statsPages)
+		limit  = parseAPIV1Limit(&v, args.Limit, h.apiMax)
+		cursor = parseAPIV1Cursor(&v, args.Cursor, "stats/"+chi.URLParam(r, "page"))
+	)
+	rng, r := parseAPIV1Range(&v, r, args.Start, args.End, args.TZ)
+	if page == "sizes" && args.Cursor != "" { // Always returns all sizes.
+		v.Append("cursor", "not supported for sizes")
+	}
+	if v.HasErrors() {
+		return v
+	}
+
+	includeIDs, _, err := findPaths(r.Context(), args.PathByName, args.IncludePaths, nil)
+	if err != nil {
+		return err
+	}
+
+	var stats goatcounter.HitStats
+	err = statsList(&stats, page.(string))(r.Context(), rng, includeIDs, limit, cursor.Offset)
+	if err != nil {
+		return err
+	}
+	for i := range stats.Stats {
+		if stats.Stats[i].ID == "" {
+			stats.Stats[i].ID = stats.Stats[i].Name
+		}
+	}
+	return zhttp.JSON(w, apiV1StatsResponse{Data: stats.Stats}.withCursor(stats.More, cursor))
+}
+
+// Get detailed stats for an entry from the stats endpoint.
+func (h api) v1StatsDetail(w http.ResponseWriter, r *http.Request) error {
+	err := h.auth(r, w, goatcounter.APIPermStats)
+	if err != nil {
+		return err
+	}
+
+	var args apiV1StatsRequest
+	if _, err := h.dec.Decode(r, &args); err != nil {
+		return err
+	}
+	v := goatcounter.NewValidate(r.Context())
+	var (
+		page   = v.Include("page", chi.URLParam(r, "page"), statsDetailPages)
+		id     = chi.URLParam(r, "id")
+		limit  = parseAPIV1Limit(&v, args.Limit, h.apiMax)
+		cursor = parseAPIV1Cursor(&v, args.Cursor, "stats/"+chi.URLParam(r, "page")+"/"+id)
+	)
+	rng, r := parseAPIV1Range(&v, r, args.Start, args.End, args.TZ)
+	if page == "campaigns" {
+		v.Integer32("id", id)
+	}
+	if v.HasErrors() {
+		return v
+	}
+
+	includeIDs, _, err := findPaths(r.Context(), args.PathByName, args.IncludePaths, nil)
+	if err != nil {
+		return err
+	}
+
+	var stats goatcounter.HitStats
+	err = statsDetailList(&stats, page.(string))(r.Context(), id, rng, includeIDs, limit, cursor.Offset)
+	if err != nil {
+		return err
+	}
+	return zhttp.JSON(w, apiV1StatsResponse{Data: stats.Stats}.withCursor(stats.More, cursor))
+}
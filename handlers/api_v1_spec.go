@@ -0,0 +1,236 @@
+package handlers
+
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"zgo.at/zstd/zbool"
+)
+
+type apiV1Endpoint struct {
+	Method      string
+	Path        string
+	Summary     string
+	Description string
+	PathParams  []string // Keys in apiV1Parameters.
+	Query       any      // Query parameters; every field must be in apiV1Parameters.
+	Response    any
+}
+
+// Endpoints for the OpenAPI spec.
+var apiV1Endpoints = []apiV1Endpoint{
+	{"GET", "/paths", "List paths",
+		"Get an overview of paths on this site (without statistics).",
+		nil, apiV1PathsRequest{}, apiV1PathsResponse{}},
+	{"GET", "/hits", "List pageviews",
+		"Get an overview of pageviews, sorted by the number of visitors.",
+		nil, apiV1HitsRequest{}, apiV1HitsResponse{}},
+	{"GET", "/hits/{path_id}/refs", "List referrers",
+		"Get an overview of referrers for a path.",
+		[]string{"path_id"}, apiV1RefsRequest{}, apiV1StatsResponse{}},
+	{"GET", "/stats/{page}", "List stats",
+		"Get browser, system, location, etc. stats.",
+		[]string{"page"}, apiV1StatsRequest{}, apiV1StatsResponse{}},
+	{"GET", "/stats/{page}/{id}", "Get stats detail",
+		"Get detailed stats for an entry from the stats endpoint, for example all versions of a browser.",
+		[]string{"detail_page", "id"}, apiV1StatsRequest{}, apiV1StatsResponse{}},
+}
+
+// Parameters for the OpenAPI spec; these are always the same across endpoints.
+var apiV1Parameters = map[string]map[string]any{
+	"start": {"name": "start", "in": "query", "schema": map[string]any{"type": "string"},
+		"description": "Start of the time range, as a date (2006-01-02) or RFC 3339 timestamp. " +
+			"Dates are in the timezone from the tz parameter. Defaults to 7 days ago."},
+	"end": {"name": "end", "in": "query", "schema": map[string]any{"type": "string"},
+		"description": "End of the time range, as a date (2006-01-02) or RFC 3339 timestamp. " +
+			"Dates are inclusive: the end of the day in the timezone from the tz parameter is used. Defaults to today."},
+	"tz": {"name": "tz", "in": "query", "schema": map[string]any{"type": "string"},
+		"description": "IANA timezone name (e.g. Europe/Amsterdam) to use for dates and to group the statistics by day. " +
+			"Defaults to the timezone in the user's settings."},
+	"limit": {"name": "limit", "in": "query", "schema": map[string]any{"type": "integer", "minimum": 1, "default": 20},
+		"description": "Maximum number of results to return. The maximum value depends on the endpoint and server configuration."},
+	"cursor": {"name": "cursor", "in": "query", "schema": map[string]any{"type": "string"},
+		"description": "Cursor to get the next page, from next_cursor in the previous response. " +
+			"The other parameters should be identical to the previous request."},
+	"group": {"name": "group", "in": "query", "schema": map[string]any{"type": "string", "enum": []string{"hour", "day", "week", "month"}, "default": "hour"},
+		"description": "Set max in the response to the highest hourly, daily, weekly, or monthly value."},
+	"include_paths": {"name": "include_paths", "in": "query", "schema": map[string]any{"type": "string"},
+		"description": "Comma-separated list of path IDs to include; default is to include everything."},
+	"path_by_name": {"name": "path_by_name", "in": "query", "schema": map[string]any{"type": "boolean"},
+		"description": "Look up include_paths by path name, rather than by ID."},
+
+	"path_id": {"name": "path_id", "in": "path", "required": true, "schema": map[string]any{"type": "integer"},
+		"description": "Path ID."},
+	"page": {"name": "page", "in": "path", "required": true, "schema": map[string]any{"type": "string", "enum": statsPages},
+		"description": "Statistics to get."},
+	"detail_page": {"name": "page", "in": "path", "required": true, "schema": map[string]any{"type": "string", "enum": statsDetailPages},
+		"description": "Statistics to get."},
+	"id": {"name": "id", "in": "path", "required": true, "schema": map[string]any{"type": "string"},
+		"description": "The id from the stats endpoint."},
+}
+
+// apiV1Spec generates the OpenAPI 3.1 spec for the v1 API.
+func apiV1Spec(serverURL string) map[string]any {
+	var (
+		schemas = make(apiV1Schemas)
+		paths   = make(map[string]any)
+	)
+	for _, e := range apiV1Endpoints {
+		params := make([]any, 0, 8)
+		for _, p := range e.PathParams {
+			params = append(params, apiV1ParamRef(p))
+		}
+		if e.Query != nil {
+			for _, f := range reflect.VisibleFields(reflect.TypeOf(e.Query)) {
+				name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+				params = append(params, apiV1ParamRef(name))
+			}
+		}
+
+		p, ok := paths[e.Path].(map[string]any)
+		if !ok {
+			p = make(map[string]any)
+			paths[e.Path] = p
+		}
+		p[strings.ToLower(e.Method)] = map[string]any{
+			"summary":     e.Summary,
+			"description": e.Description,
+			"parameters":  params,
+			"responses": map[string]any{
+				"200": map[string]any{
+					"description": "Success",
+					"content": map[string]any{
+						"application/json": map[string]any{"schema": schemas.schema(reflect.TypeOf(e.Response))},
+					},
+				},
+				"default": map[string]any{"$ref": "#/components/responses/Error"},
+			},
+		}
+	}
+
+	errSchema := schemas.schema(reflect.TypeFor[apiV1Error]())
+	schemas["ErrorBody"].(map[string]any)["properties"].(map[string]any)["code"] = map[string]any{
+		"type": "string", "enum": apiV1Codes,
+		"description": `Error code; "validation" errors have the errors for every parameter in fields.`,
+	}
+
+	return map[string]any{
+		"openapi": "3.1.0",
+		"info": map[string]any{
+			"title":       "GoatCounter",
+			"version":     "1",
+			"description": "Reference documentation for the GoatCounter API.",
+		},
+		"servers":  []any{map[string]any{"url": serverURL}},
+		"security": []any{map[string]any{"bearer": []string{}}, map[string]any{"basic": []string{}}},
+		"paths":    paths,
+		"components": map[string]any{
+			"schemas":    schemas,
+			"parameters": apiV1Parameters,
+			"responses": map[string]any{
+				"Error": map[string]any{
+					"description": "Error",
+					"content":     map[string]any{"application/json": map[string]any{"schema": errSchema}},
+				},
+			},
+			"securitySchemes": map[string]any{
+				"bearer": map[string]any{"type": "http", "scheme": "bearer",
+					"description": "API token from the user settings."},
+				"basic": map[string]any{"type": "http", "scheme": "basic",
+					"description": "API token from the user settings as the password; the username is ignored."},
+			},
+		},
+	}
+}
+
+func apiV1ParamRef(name string) map[string]any {
+	if _, ok := apiV1Parameters[name]; !ok {
+		panic(fmt.Sprintf("apiV1Spec: no parameter %q in apiV1Parameters", name))
+	}
+	return map[string]any{"$ref": "#/components/parameters/" + name}
+}
+
+// apiV1Schemas are the JSON schemas for named struct types.
+type apiV1Schemas map[string]any
+
+var (
+	typeTime          = reflect.TypeFor[time.Time]()
+	typeBool          = reflect.TypeFor[zbool.Bool]()
+	typeTextMarshaler = reflect.TypeFor[encoding.TextMarshaler]()
+)
+
+// schema gets the JSON schema for t, adding named structs to the list of
+// schemas and returning a reference to it.
+func (s apiV1Schemas) schema(t reflect.Type) map[string]any {
+	switch {
+	case t == typeTime:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case t == typeBool:
+		return map[string]any{"type": "boolean"}
+	case t.Implements(typeTextMarshaler):
+		return map[string]any{"type": "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		sch := s.schema(t.Elem())
+		if typ, ok := sch["type"].(string); ok {
+			sch["type"] = []string{typ, "null"}
+			return sch
+		}
+		return map[string]any{"oneOf": []any{sch, map[string]any{"type": "null"}}}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]any{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "contentEncoding": "base64"}
+		}
+		return map[string]any{"type": "array", "items": s.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": s.schema(t.Elem())}
+	case reflect.Struct:
+		name := strings.TrimPrefix(t.Name(), "apiV1")
+		if _, ok := s[name]; !ok {
+			s[name] = nil // Prevent infinite recursion on recursive types.
+			s[name] = s.object(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + name}
+	}
+	panic(fmt.Sprintf("apiV1Schemas.schema: unsupported type %s", t))
+}
+
+func (s apiV1Schemas) object(t reflect.Type) map[string]any {
+	var (
+		props    = make(map[string]any)
+		required = make([]string, 0, t.NumField())
+	)
+	for _, f := range reflect.VisibleFields(t) {
+		if !f.IsExported() || f.Anonymous {
+			continue
+		}
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = f.Name
+		}
+		props[name] = s.schema(f.Type)
+		if !strings.Contains(opts, "omitempty") && !strings.Contains(opts, "omitzero") {
+			required = append(required, name)
+		}
+	}
+	return map[string]any{"type": "object", "properties": props, "required": required}
+}
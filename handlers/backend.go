@@ -131,6 +131,12 @@ func (h backend) Mount(r chi.Router, db zdb.DB, dev, saas bool, domainStatic str
 			ap.Get("/loader", zhttp.Wrap(h.loader))
 			ap.Get("/load-widget", zhttp.Wrap(h.loadWidget))
 		}
+		{
+			a.Get("/share/{token}", zhttp.Wrap(h.share))
+			a.With(Ratelimit(false, func(*http.Request) ([]limiter.Store, string) {
+				return []limiter.Store{ratelimits.Login}, ""
+			})).Post("/share/{token}", zhttp.Wrap(h.share))
+		}
 		{
 			af := a.With(loggedIn, addz18n())
 			settings{}.mount(af, ratelimits)
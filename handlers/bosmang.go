@@ -202,6 +202,7 @@ func (h bosmang) geoip(w http.ResponseWriter, r *http.Request) error {
 		Type        string
 		Description string
 		Nodes       uint
+		Status      geo.Status
 	}
 	return zhttp.Template(w, "bosmang_geoip.gohtml", struct {
 		Globals
@@ -219,6 +220,7 @@ func (h bosmang) geoip(w http.ResponseWriter, r *http.Request) error {
 			md.DatabaseType,
 			md.Description["en"],
 			md.NodeCount,
+			geo.GetStatus(r.Context()),
 		},
 	})
 }
@@ -1,12 +1,15 @@
 package handlers
 
 import (
+	"cmp"
 	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/monoculum/formam/v3"
 	"golang.org/x/text/language"
 	"zgo.at/goatcounter/v2"
+	"zgo.at/goatcounter/v2/pkg/log"
 	"zgo.at/goatcounter/v2/pkg/metrics"
 	"zgo.at/isbot"
 	"zgo.at/zhttp"
@@ -26,6 +29,7 @@ func (h backend) count(w http.ResponseWriter, r *http.Request) error {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Content-Type", "image/gif")
 	w.Header().Set("Cross-Origin-Resource-Policy", "cross-origin")
+	w.Header().Set("Accept-CH", strings.Join(goatcounter.ClientHintsHeaders, ", "))
 
 	// Note this works in both HTTP/1.1 and HTTP/2, as the Go HTTP/2 server
 	// picks up on this and sends the GOAWAY frame.
@@ -59,6 +63,9 @@ func (h backend) count(w http.ResponseWriter, r *http.Request) error {
 		var l goatcounter.Location
 		hit.Location = l.LookupIP(r.Context(), r.RemoteAddr)
 	}
+	if site.Settings.Collect.Has(goatcounter.CollectNetwork) {
+		hit.ASN = goatcounter.Network{}.LookupIP(r.Context(), r.RemoteAddr)
+	}
 
 	if site.Settings.Collect.Has(goatcounter.CollectLanguage) {
 		tags, _, _ := language.ParseAcceptLanguage(r.Header.Get("Accept-Language"))
@@ -80,6 +87,16 @@ func (h backend) count(w http.ResponseWriter, r *http.Request) error {
 		w.WriteHeader(400)
 		return zhttp.Bytes(w, gif)
 	}
+	// Prefer the headers, but browsers often don't send them to third-party
+	// domains, so count.js also sends them from navigator.userAgentData.
+	q := r.URL.Query()
+	hit.Hints = goatcounter.ClientHints{
+		UA:              cmp.Or(r.Header.Get("Sec-CH-UA"), q.Get("ch")),
+		Platform:        cmp.Or(r.Header.Get("Sec-CH-UA-Platform"), q.Get("chp")),
+		PlatformVersion: cmp.Or(r.Header.Get("Sec-CH-UA-Platform-Version"), q.Get("chpv")),
+		Mobile:          cmp.Or(r.Header.Get("Sec-CH-UA-Mobile"), q.Get("chm")),
+	}
+
 	if hit.Bot > 0 && hit.Bot < 150 {
 		w.Header().Add("X-Goatcounter", fmt.Sprintf("wrong value: b=%d", hit.Bot))
 		w.WriteHeader(400)
@@ -94,6 +111,20 @@ func (h backend) count(w http.ResponseWriter, r *http.Request) error {
 	if isbot.Is(bot) { // Prefer the backend detection.
 		hit.Bot = int(bot)
 	}
+	if hit.Bot == 0 {
+		var rules goatcounter.BotRules
+		err := rules.ForSite(r.Context(), site.ID)
+		if err != nil {
+			log.Error(r.Context(), err)
+		}
+		asn := hit.ASN
+		if asn == 0 && rules.HasASN() {
+			asn = goatcounter.Network{}.LookupIP(r.Context(), hit.RemoteAddr)
+		}
+		if rules.Match(hit.UserAgentHeader, hit.RemoteAddr, asn) {
+			hit.Bot = goatcounter.BotCustom
+		}
+	}
 
 	err = hit.Validate(r.Context(), true)
 	if err != nil {
@@ -119,6 +119,21 @@ func TestBackendCount(t *testing.T) {
 			Path: "/foo.html",
 		}},
 
+		{"props", url.Values{"p": {"/a"}, "pr": {`{"plan":"pro","n":1}`}}, nil, 200, goatcounter.Hit{
+			Path: "/a",
+		}},
+		{"invalid props", url.Values{"p": {"/a"}, "pr": {`{"plan":`}}, nil, 400, goatcounter.Hit{}},
+		{"invalid props value", url.Values{"p": {"/a"}, "pr": {`{"plan":["pro"]}`}}, nil, 400, goatcounter.Hit{}},
+
+		{"value", url.Values{"p": {"/a"}, "v": {"49.00"}, "cu": {"eur"}}, nil, 200, goatcounter.Hit{
+			Path:     "/a",
+			Value:    4900,
+			Currency: "EUR",
+		}},
+		{"value without currency", url.Values{"p": {"/a"}, "v": {"49"}}, nil, 400, goatcounter.Hit{}},
+		{"invalid currency", url.Values{"p": {"/a"}, "v": {"49"}, "cu": {"euro"}}, nil, 400, goatcounter.Hit{}},
+		{"invalid value", url.Values{"p": {"/a"}, "v": {"xx"}, "cu": {"EUR"}}, nil, 400, goatcounter.Hit{}},
+
 		{"long path", url.Values{"p": []string{"/" + strings.Repeat("a", 2047)}}, nil, 200, goatcounter.Hit{
 			Path: "/" + strings.Repeat("a", 2047),
 		}},
@@ -343,3 +358,44 @@ func TestBackendCountSessions(t *testing.T) {
 	want = []int{1, 1, 2, 3, 3, 1, 2, 1, 3, 4, 5}
 	checkSess(append(hits1, hits2...), want)
 }
+
+func TestBackendCountClientHints(t *testing.T) {
+	ctx := gctest.DB(t)
+
+	var site goatcounter.Site
+	site.Defaults(ctx)
+	site.Settings.Collect.Set(goatcounter.CollectHits)
+	ctx = gctest.Site(ctx, t, &site, nil)
+
+	// Header takes precedence over the query parameter, but the query parameter
+	// is still used for headers that aren't sent.
+	query := url.Values{"p": {"/x"}, "chp": {"macOS"}, "chpv": {"15.0.0"}}
+	r, rr := newTest(ctx, "GET", "/count?"+query.Encode(), nil)
+	r.Host = site.Code + "." + goatcounter.Config(ctx).Domain
+	r.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36")
+	r.Header.Set("Sec-CH-UA", `"Chromium";v="124", "Google Chrome";v="124", "Not-A.Brand";v="99"`)
+	r.Header.Set("Sec-CH-UA-Platform", `"Windows"`)
+	newBackend(ctx).ServeHTTP(rr, r)
+	ztest.Code(t, rr, 200)
+	if h := rr.Header().Get("Accept-CH"); !strings.Contains(h, "Sec-CH-UA-Platform-Version") {
+		t.Errorf("Accept-CH: %q", h)
+	}
+
+	_, err := goatcounter.Memstore.Persist(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var have string
+	err = zdb.Get(ctx, &have, `
+		select browsers.name || ' ' || browsers.version || ' / ' || systems.name || ' ' || systems.version
+		from hits
+		join browsers using (browser_id)
+		join systems using (system_id)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Chrome 124 / Windows 11"; have != want {
+		t.Errorf("\nhave: %s\nwant: %s", have, want)
+	}
+}
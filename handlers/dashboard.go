@@ -11,6 +11,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/lib/pq"
 	"github.com/lib/pq/pqerror"
 	"zgo.at/errors"
@@ -20,6 +21,7 @@ import (
 	"zgo.at/goatcounter/v2/widgets"
 	"zgo.at/guru"
 	"zgo.at/z18n"
+	"zgo.at/zdb"
 	"zgo.at/zhttp"
 	"zgo.at/zstd/zint"
 	"zgo.at/zstd/zstrconv"
@@ -33,8 +35,12 @@ func (h backend) dashboard(w http.ResponseWriter, r *http.Request) error {
 	var (
 		site       = Site(r.Context())
 		user       = User(r.Context())
+		share      = goatcounter.GetShareLink(r.Context())
 		publicView = site.Settings.IsPublic() && User(r.Context()).ID == 0
 	)
+	if share != nil {
+		user, publicView = shareUser(r.Context(), share), false
+	}
 
 	k := r.Host
 	if publicView {
@@ -57,6 +63,9 @@ func (h backend) dashboard(w http.ResponseWriter, r *http.Request) error {
 	view.Period = strings.TrimSuffix(view.Period, "-cur")
 
 	rng, err := getPeriod(w, r, site, user)
+	if share != nil {
+		rng, err = sharePeriod(r.Context(), share, user), nil
+	}
 	if err != nil {
 		zhttp.FlashError(w, r, err.Error())
 	}
@@ -74,14 +83,17 @@ func (h backend) dashboard(w http.ResponseWriter, r *http.Request) error {
 			y, m, d := c.In(user.Settings.Timezone.Loc()).Date()
 			rng.Start = time.Date(y, m, d, 0, 0, 0, 0, user.Settings.Timezone.Loc()).UTC()
 		}
-	} else {
+	} else if share == nil {
 		view.Period = strings.TrimSuffix(q.Get("hl-period"), "-cur")
 	}
 
 	showRefs, _ := zstrconv.ParseInt[goatcounter.PathID](q.Get("showrefs"), 10)
-	if _, ok := q["filter"]; ok {
+	if _, ok := q["filter"]; ok && share == nil {
 		view.Filter = q.Get("filter")
 	}
+	if share != nil && showRefs > 0 && !shareAllowPath(r.Context(), share, showRefs) {
+		showRefs = 0
+	}
 	var allowGroups goatcounter.Groups
 	view.Group, allowGroups = getGroup(r, view.Group, rng)
 
@@ -268,6 +280,7 @@ func (h backend) dashboard(w http.ResponseWriter, r *http.Request) error {
 
 	return zhttp.Template(w, "dashboard.gohtml", struct {
 		Globals
+		ShareLink   *goatcounter.ShareLink
 		CountDomain string
 		SubSites    []string
 		ShowRefs    goatcounter.PathID
@@ -279,17 +292,59 @@ func (h backend) dashboard(w http.ResponseWriter, r *http.Request) error {
 		Total       int
 		TotalUTC    int
 		ConnectID   zint.Uint128
-	}{newGlobals(w, r), cd, subs, showRefs, rng,
+	}{newGlobals(w, r), share, cd, subs, showRefs, rng,
 		args.PathFilter, allowGroups, wid, view, shared.Total, shared.TotalUTC,
 		connectID})
 }
 
+// Open a share link; this sets a cookie for the link and redirects to the
+// dashboard, which is then limited to what the share link allows.
+func (h backend) share(w http.ResponseWriter, r *http.Request) error {
+	var share goatcounter.ShareLink
+	err := share.ByToken(r.Context(), chi.URLParam(r, "token"))
+	if err != nil {
+		if zdb.ErrNoRows(err) {
+			return guru.New(404, T(r.Context(), "error/share-link-not-found|Could not find this share link; perhaps it was removed?"))
+		}
+		return err
+	}
+	if share.Expired(r.Context()) {
+		return guru.New(http.StatusGone, T(r.Context(), "error/share-link-expired|This share link has expired."))
+	}
+
+	post := r.Method == http.MethodPost
+	if len(share.Password) > 0 && !(post && share.CorrectPassword(r.FormValue("password"))) {
+		return zhttp.Template(w, "share_password.gohtml", struct {
+			Globals
+			ShareLink goatcounter.ShareLink
+			Wrong     bool
+		}{newGlobals(w, r), share, post})
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     "share-link",
+		Value:    share.CookieValue(),
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   zhttp.IsSecure(r),
+		SameSite: zhttp.CookieSameSiteHelper(r),
+	})
+	return zhttp.SeeOther(w, "/")
+}
+
 func (h backend) loadWidget(w http.ResponseWriter, r *http.Request) error {
 	user := User(r.Context())
+	share := goatcounter.GetShareLink(r.Context())
+	if share != nil {
+		user = shareUser(r.Context(), share)
+	}
 	rng, err := getPeriod(w, r, Site(r.Context()), user)
 	if err != nil {
 		return err
 	}
+	if share != nil {
+		rng = sharePeriod(r.Context(), share, user)
+	}
 
 	v := goatcounter.NewValidate(r.Context())
 	var (
@@ -303,9 +358,19 @@ func (h backend) loadWidget(w http.ResponseWriter, r *http.Request) error {
 		return v
 	}
 
+	if widget < 0 || widget >= len(user.Settings.Widgets) {
+		return guru.Errorf(400, "no widget %d", widget)
+	}
+	if share != nil && key != "" && user.Settings.Widgets[widget].Name() == "pages" {
+		id, _ := zstrconv.ParseInt[goatcounter.PathID](key, 10)
+		if !shareAllowPath(r.Context(), share, id) {
+			return guru.New(404, "")
+		}
+	}
+
 	args := widgets.SharedData{
 		Site:     Site(r.Context()),
-		User:     User(r.Context()),
+		User:     user,
 		TotalUTC: total,
 		Total:    total,
 		RowsOnly: key != "" || offset > 0,
@@ -484,6 +549,9 @@ func getGroup(r *http.Request, g goatcounter.Group, rng ztime.Range) (goatcounte
 
 func getPathFilter(v *zvalidate.Validator, r *http.Request) goatcounter.PathFilter {
 	f := r.URL.Query().Get("filter")
+	if share := goatcounter.GetShareLink(r.Context()); share != nil {
+		f = share.Filter
+	}
 	if f == "" {
 		return goatcounter.PathFilter{}
 	}
@@ -494,3 +562,34 @@ func getPathFilter(v *zvalidate.Validator, r *http.Request) goatcounter.PathFilt
 	}
 	return filter
 }
+
+// shareUser gets the user to view the dashboard with a share link: the site's
+// default settings with the widgets and view from the link.
+func shareUser(ctx context.Context, share *goatcounter.ShareLink) *goatcounter.User {
+	return &goatcounter.User{Settings: share.UserSettings(ctx, Site(ctx).UserDefaults)}
+}
+
+// sharePeriod gets the time range for a share link; the fixed dates are
+// interpreted in the user's timezone.
+func sharePeriod(ctx context.Context, share *goatcounter.ShareLink, user *goatcounter.User) ztime.Range {
+	loc := user.Settings.Timezone.Loc()
+	if share.PeriodStart == nil || share.PeriodEnd == nil {
+		return timeRange(ctx, share.Period, loc, bool(user.Settings.SundayStartsWeek))
+	}
+	s, e := share.PeriodStart.UTC(), share.PeriodEnd.UTC()
+	return ztime.NewRange(time.Date(s.Year(), s.Month(), s.Day(), 0, 0, 0, 0, loc)).
+		To(time.Date(e.Year(), e.Month(), e.Day(), 23, 59, 59, 0, loc)).UTC()
+}
+
+// shareAllowPath reports if the path can be viewed with the share link.
+func shareAllowPath(ctx context.Context, share *goatcounter.ShareLink, id goatcounter.PathID) bool {
+	if share.Filter == "" {
+		return true
+	}
+	ids, err := goatcounter.FilterPathIDs(ctx, share.Filter)
+	if err != nil {
+		log.Error(ctx, err)
+		return false
+	}
+	return slices.Contains(ids, id)
+}
@@ -2,12 +2,18 @@ package handlers
 
 import (
 	"context"
+	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"slices"
+	"strings"
 	"testing"
 	"time"
 
 	"zgo.at/goatcounter/v2"
+	"zgo.at/goatcounter/v2/gctest"
+	"zgo.at/zdb"
+	"zgo.at/zstd/ztest"
 	"zgo.at/zstd/ztime"
 )
 
@@ -27,6 +33,84 @@ func TestDashboard(t *testing.T) {
 	}
 }
 
+func TestShareLink(t *testing.T) {
+	ctx := gctest.DB(t)
+	gctest.StoreHits(ctx, t, false, []goatcounter.Hit{
+		{FirstVisit: true, Site: 1, Path: "/share-yes", Title: "AAA"},
+		{FirstVisit: true, Site: 1, Path: "/share-no", Title: "BBB"},
+	}...)
+
+	do := func(t *testing.T, method, path string, form url.Values, cookies []*http.Cookie, wantCode int) *httptest.ResponseRecorder {
+		t.Helper()
+		r, rr := newTest(ctx, method, path, strings.NewReader(form.Encode()))
+		if method == "POST" {
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		for _, c := range cookies {
+			r.AddCookie(c)
+		}
+		newBackend(ctx).ServeHTTP(rr, r)
+		ztest.Code(t, rr, wantCode)
+		return rr
+	}
+	newLink := func(t *testing.T, pwd string) goatcounter.ShareLink {
+		t.Helper()
+		l := goatcounter.ShareLink{Name: "Shared stats", Widgets: goatcounter.Strings{"pages"},
+			Filter: "share-yes", Period: "week"}
+		err := l.SetPassword(ctx, pwd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = l.Insert(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return l
+	}
+
+	t.Run("view", func(t *testing.T) {
+		l := newLink(t, "")
+		do(t, "GET", "/share/nonexistent", nil, nil, 404)
+		cookies := do(t, "GET", "/share/"+l.Token, nil, nil, 303).Result().Cookies()
+
+		body := do(t, "GET", "/", nil, cookies, 200).Body.String()
+		if !strings.Contains(body, "Shared stats") {
+			t.Error("no name in body")
+		}
+		if !strings.Contains(body, "/share-yes") || strings.Contains(body, "/share-no") {
+			t.Errorf("wrong paths in body:\n%s", body)
+		}
+
+		// Only the total count and pages widgets.
+		do(t, "GET", "/load-widget?widget=1", nil, cookies, 200)
+		do(t, "GET", "/load-widget?widget=2", nil, cookies, 400)
+
+		err := zdb.Exec(ctx, `update share_links set expires_at = ? where share_link_id = ?`,
+			ztime.Now(ctx).Add(-time.Hour), l.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		do(t, "GET", "/share/"+l.Token, nil, nil, 410)
+		do(t, "GET", "/", nil, cookies, 303)
+	})
+
+	t.Run("password", func(t *testing.T) {
+		l := newLink(t, "hunter2")
+		if have := do(t, "GET", "/share/"+l.Token, nil, nil, 200).Body.String(); !strings.Contains(have, `type="password"`) {
+			t.Errorf("no password form:\n%s", have)
+		}
+		if have := do(t, "POST", "/share/"+l.Token, url.Values{"password": {"wrong"}}, nil, 200).Body.String(); !strings.Contains(have, "Wrong password") {
+			t.Errorf("no error:\n%s", have)
+		}
+
+		// Cookie from a link without password doesn't work.
+		do(t, "GET", "/", nil, []*http.Cookie{{Name: "share-link", Value: l.Token}}, 303)
+
+		cookies := do(t, "POST", "/share/"+l.Token, url.Values{"password": {"hunter2"}}, nil, 303).Result().Cookies()
+		do(t, "GET", "/", nil, cookies, 200)
+	})
+}
+
 func TestGetGroup(t *testing.T) {
 	tests := []struct {
 		days      int
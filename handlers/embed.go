@@ -0,0 +1,289 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"zgo.at/goatcounter/v2"
+	"zgo.at/goatcounter/v2/widgets"
+	"zgo.at/guru"
+	"zgo.at/json"
+	"zgo.at/z18n"
+	"zgo.at/zstd/zfilepath"
+	"zgo.at/zstd/ztime"
+	"zgo.at/ztpl"
+)
+
+// Widgets that can be embedded with /embed/{widget}.
+var embedWidgets = []string{"pages", "toprefs", "browsers", "systems", "sizes",
+	"locations", "languages", "campaigns", "channels", "sparkline"}
+
+type (
+	embedRow struct {
+		Name  string `json:"name"`
+		Title string `json:"title,omitempty"`
+		Count int    `json:"count"`
+	}
+	embedDay struct {
+		Day   string `json:"day"`
+		Count int    `json:"count"`
+	}
+	embedData struct {
+		Widget string     `json:"widget"`
+		Label  string     `json:"label"`
+		Start  time.Time  `json:"start"`
+		End    time.Time  `json:"end"`
+		Rows   []embedRow `json:"rows,omitempty"`
+		Days   []embedDay `json:"days,omitempty"`
+	}
+)
+
+// Maximum date range for embedded widgets.
+const embedMaxRange = 366 * 24 * time.Hour
+
+// embed renders a dashboard widget as HTML (for an iframe), SVG, or JSON.
+//
+// This is public, so it's only available if the site explicitly enabled
+// EmbedWidgets; AllowEmbed on its own only allows framing the dashboard (which
+// requires login). The HTML can only be framed on the domains listed in
+// AllowEmbed, and JSON can only be fetched from those domains with JavaScript.
+func (h vcounter) embed(w http.ResponseWriter, r *http.Request) error {
+	site := Site(r.Context())
+	if !site.Settings.EmbedWidgets {
+		return guru.New(http.StatusForbidden, "Need to enable the ‘allow embedding widgets’ setting")
+	}
+	if len(site.Settings.AllowEmbed) == 0 {
+		return guru.New(http.StatusForbidden, "Need to add a domain in the ‘sites that can embed GoatCounter’ setting")
+	}
+
+	var (
+		q           = r.URL.Query()
+		widget, ext = zfilepath.SplitExt(chi.URLParam(r, "widget"))
+	)
+	if !slices.Contains(embedWidgets, widget) {
+		return guru.Errorf(404, "unknown widget: %q", widget)
+	}
+	switch ext {
+	default:
+		return guru.Errorf(400, "unknown extension: %q", ext)
+	case "html", "svg":
+	case "json":
+		if o := r.Header.Get("Origin"); o != "" && embedAllowOrigin(site.Settings.AllowEmbed, o) {
+			w.Header().Set("Access-Control-Allow-Origin", o)
+			w.Header().Add("Vary", "Origin")
+		}
+	}
+
+	limit := 10
+	if l := q.Get("limit"); l != "" {
+		var err error
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit < 1 || limit > 100 {
+			return guru.New(400, "limit must be between 1 and 100")
+		}
+	}
+
+	cachekey := "embed-" + strconv.FormatInt(int64(site.ID), 10) + "-" + widget + "." + ext + "-" +
+		q.Get("start") + "-" + q.Get("end") + "-" + strconv.Itoa(limit) + "-" + q.Get("style") + "-" + q.Get("no_branding")
+
+	return h.serveCached(w, r, cachekey, func() (bool, []byte, string, error) {
+		return h.getEmbed(r.Context(), widget, ext, q, limit)
+	})
+}
+
+func (h vcounter) getEmbed(ctx context.Context, widget, ext string, q url.Values, limit int) (bool, []byte, string, error) {
+	rng, err := vcounterRange(ctx, q)
+	if err != nil {
+		return false, nil, "", err
+	}
+	now := ztime.Now(ctx)
+	if rng.Start.IsZero() {
+		rng.Start = now.Add(-30 * 24 * time.Hour)
+	}
+	if rng.End.IsZero() || rng.End.After(now) {
+		rng.End = now
+	} else {
+		rng.End = ztime.EndOf(rng.End, ztime.Day)
+	}
+	if rng.End.Before(rng.Start) {
+		return false, nil, "", guru.New(400, "end is before start")
+	}
+	if rng.End.Sub(rng.Start) > embedMaxRange {
+		rng.Start = rng.End.Add(-embedMaxRange)
+	}
+
+	data, err := embedGet(ctx, widget, rng, limit)
+	if err != nil {
+		return false, nil, "", err
+	}
+
+	switch ext {
+	case "json":
+		j, err := json.Marshal(data)
+		return false, j, "application/json", err
+	case "svg":
+		out, err := ztpl.ExecuteBytes("embed.gohtml", embedTplData(ctx, data, q, true))
+		return false, out, "image/svg+xml", err
+	default:
+		out, err := ztpl.ExecuteBytes("embed.gohtml", embedTplData(ctx, data, q, false))
+		return false, out, "text/html;charset=utf-8", err
+	}
+}
+
+func embedGet(ctx context.Context, widget string, rng ztime.Range, limit int) (embedData, error) {
+	data := embedData{Widget: widget, Start: rng.Start, End: rng.End}
+	if widget == "sparkline" {
+		data.Label = z18n.T(ctx, "label/visitors|Visitors")
+
+		var hl goatcounter.HitList
+		err := hl.Totals(ctx, rng, goatcounter.PathFilter{}, goatcounter.GroupDaily, true)
+		if err != nil {
+			return data, err
+		}
+		data.Days = make([]embedDay, 0, len(hl.Stats))
+		for _, s := range hl.Stats {
+			data.Days = append(data.Days, embedDay{Day: s.Day, Count: s.Daily})
+		}
+		return data, nil
+	}
+
+	data.Label = widgets.NewWidget(ctx, widget, 0).Label(ctx)
+
+	if widget == "pages" {
+		var pages goatcounter.HitLists
+		_, _, err := pages.List(ctx, rng, goatcounter.PathFilter{}, nil, limit, goatcounter.GroupDaily)
+		if err != nil {
+			return data, err
+		}
+		data.Rows = make([]embedRow, 0, len(pages))
+		for _, p := range pages {
+			data.Rows = append(data.Rows, embedRow{Name: p.Path, Title: p.Title, Count: p.Count})
+		}
+		return data, nil
+	}
+
+	var (
+		stats goatcounter.HitStats
+		pf    goatcounter.PathFilter
+		err   error
+	)
+	switch widget {
+	case "toprefs":
+		err = stats.ListTopRefs(ctx, rng, pf, limit, 0)
+	case "browsers":
+		err = stats.ListBrowsers(ctx, rng, pf, limit, 0)
+	case "systems":
+		err = stats.ListSystems(ctx, rng, pf, limit, 0)
+	case "sizes":
+		err = stats.ListSizes(ctx, rng, pf, true)
+	case "locations":
+		err = stats.ListLocations(ctx, rng, pf, limit, 0)
+	case "languages":
+		err = stats.ListLanguages(ctx, rng, pf, limit, 0)
+	case "campaigns":
+		err = stats.ListCampaigns(ctx, rng, pf, limit, 0)
+	case "channels":
+		err = stats.ListChannels(ctx, rng, pf, limit, 0)
+	}
+	if err != nil {
+		return data, err
+	}
+	data.Rows = make([]embedRow, 0, min(len(stats.Stats), limit))
+	for _, s := range stats.Stats[:min(len(stats.Stats), limit)] {
+		data.Rows = append(data.Rows, embedRow{Name: s.Name, Count: s.Count})
+	}
+	return data, nil
+}
+
+type embedRowTpl struct {
+	embedRow
+	Y, BarY int
+	Width   float64 // Percentage of the highest count.
+}
+
+func embedTplData(ctx context.Context, data embedData, q url.Values, svg bool) any {
+	var (
+		rows   = make([]embedRowTpl, 0, len(data.Rows))
+		maxRow = 1
+	)
+	for _, r := range data.Rows {
+		maxRow = max(maxRow, r.Count)
+	}
+	for i, r := range data.Rows {
+		rows = append(rows, embedRowTpl{embedRow: r, Y: 40 + i*24, BarY: 24 + i*24, Width: float64(r.Count) / float64(maxRow) * 100})
+	}
+
+	height := 40 + len(rows)*24
+	if data.Widget == "sparkline" {
+		height = 100
+	}
+
+	return struct {
+		Context    context.Context
+		User       goatcounter.User
+		SVG        bool
+		NoBranding bool
+		Style      template.CSS
+		Data       embedData
+		Rows       []embedRowTpl
+		Height     int
+		Sparkline  string
+	}{ctx, goatcounter.User{Settings: Site(ctx).UserDefaults}, svg, q.Get("no_branding") != "",
+		template.CSS(strings.ReplaceAll(q.Get("style"), "<", "")), data, rows, height, sparkline(data.Days, 300, 60)}
+}
+
+// sparkline gets the points for a SVG polyline of width×height.
+func sparkline(days []embedDay, width, height int) string {
+	if len(days) == 0 {
+		return ""
+	}
+	maxDay := 1
+	for _, d := range days {
+		maxDay = max(maxDay, d.Count)
+	}
+
+	var (
+		b    strings.Builder
+		step = float64(width)
+	)
+	if len(days) > 1 {
+		step = float64(width) / float64(len(days)-1)
+	}
+	for i, d := range days {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		fmt.Fprintf(&b, "%.1f,%.1f", float64(i)*step, float64(height)-float64(d.Count)/float64(maxDay)*float64(height))
+	}
+	return b.String()
+}
+
+// embedAllowOrigin reports if the origin is in the list of sites that can embed
+// GoatCounter.
+func embedAllowOrigin(allow goatcounter.Strings, origin string) bool {
+	o, err := url.Parse(origin)
+	if err != nil || o.Host == "" {
+		return false
+	}
+	for _, a := range allow {
+		if !strings.Contains(a, "://") {
+			a = "//" + a
+		}
+		u, err := url.Parse(a)
+		if err != nil {
+			continue
+		}
+		if u.Host == o.Host && (u.Scheme == "" || u.Scheme == o.Scheme) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+
+	"zgo.at/goatcounter/v2"
+	"zgo.at/goatcounter/v2/gctest"
+	"zgo.at/zstd/ztest"
+	"zgo.at/zstd/ztime"
+)
+
+func TestEmbed(t *testing.T) {
+	ctx := gctest.DB(t)
+	vcounterCache.Reset()
+	t.Cleanup(vcounterCache.Reset)
+
+	gctest.StoreHits(ctx, t, false, []goatcounter.Hit{
+		{FirstVisit: true, Path: "/popular", Title: "Popular post"},
+		{FirstVisit: true, Path: "/popular", Title: "Popular post"},
+		{FirstVisit: true, Path: "/other"},
+	}...)
+
+	get := func(t *testing.T, path, origin string, wantCode int) (string, string) {
+		t.Helper()
+		r, rr := newTest(ctx, "GET", path, nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		newBackend(ctx).ServeHTTP(rr, r)
+		ztest.Code(t, rr, wantCode)
+		return rr.Body.String(), rr.Header().Get("Access-Control-Allow-Origin")
+	}
+
+	get(t, "/embed/pages.json", "", 403)
+
+	// Only allowing to frame the dashboard shouldn't make the widgets public.
+	site := Site(ctx)
+	site.Settings.AllowEmbed = goatcounter.Strings{"https://blog.example.com"}
+	err := site.Update(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	get(t, "/embed/pages.json", "", 403)
+
+	site.Settings.EmbedWidgets = true
+	err = site.Update(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get(t, "/embed/nonexistent.json", "", 404)
+	get(t, "/embed/pages.xml", "", 400)
+	get(t, "/embed/pages.json?limit=1000", "", 400)
+
+	body, cors := get(t, "/embed/pages.json?limit=1", "https://blog.example.com", 200)
+	if cors != "https://blog.example.com" {
+		t.Errorf("wrong CORS header: %q", cors)
+	}
+	if !strings.Contains(body, `"name":"/popular","title":"Popular post","count":2`) || strings.Contains(body, "/other") {
+		t.Errorf("wrong body: %s", body)
+	}
+
+	if _, cors := get(t, "/embed/pages.json?limit=1", "https://evil.example.com", 200); cors != "" {
+		t.Errorf("CORS header for other origin: %q", cors)
+	}
+
+	if body, _ := get(t, "/embed/pages.html", "", 200); !strings.Contains(body, "<span>/popular</span>") {
+		t.Errorf("wrong body: %s", body)
+	}
+	if body, _ := get(t, "/embed/sparkline.svg", "", 200); !strings.Contains(body, "<polyline points=") {
+		t.Errorf("wrong body: %s", body)
+	}
+
+	body, _ = get(t, "/embed/sparkline.json?start=2000-01-01", "", 200)
+	if !strings.Contains(body, `"start":"`+ztime.Now(ctx).Add(-embedMaxRange).Format("2006-01-02")) {
+		t.Errorf("range not clamped: %s", body)
+	}
+}
+
+func TestEmbedAllowOrigin(t *testing.T) {
+	tests := []struct {
+		allow  goatcounter.Strings
+		origin string
+		want   bool
+	}{
+		{goatcounter.Strings{"example.com"}, "https://example.com", true},
+		{goatcounter.Strings{"example.com"}, "http://example.com", true},
+		{goatcounter.Strings{"example.com"}, "https://www.example.com", false},
+		{goatcounter.Strings{"https://example.com"}, "http://example.com", false},
+		{goatcounter.Strings{"https://example.com:8000"}, "https://example.com:8000", true},
+		{goatcounter.Strings{"https://example.com:8000"}, "https://example.com", false},
+		{goatcounter.Strings{"example.com/path"}, "https://example.com", true},
+		{goatcounter.Strings{"x.org", "example.com"}, "https://example.com", true},
+		{goatcounter.Strings{"example.com"}, "null", false},
+	}
+
+	for _, tt := range tests {
+		t.Run("", func(t *testing.T) {
+			have := embedAllowOrigin(tt.allow, tt.origin)
+			if have != tt.want {
+				t.Errorf("embedAllowOrigin(%q, %q) = %t; want %t", tt.allow, tt.origin, have, tt.want)
+			}
+		})
+	}
+}
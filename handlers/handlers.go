@@ -146,6 +146,9 @@ func newGlobals(w http.ResponseWriter, r *http.Request) Globals {
 			"notify/saved":                T(ctx, "notify/saved|Saved!"),
 			"dashboard/tooltip-event":     T(ctx, "dashboard/tooltip-event|%(unique) clicks; %(clicks) total clicks", z18n.P{"unique": "%(unique)", "clicks": "%(clicks)"}),
 			"dashboard/totals/num-visits": T(ctx, "dashboard/totals/num-visits|%(num-visits) visits", z18n.P{"num-visits": "%(num-visits)"}),
+			"dashboard/totals/revenue":    T(ctx, "dashboard/totals/revenue|Revenue: %(revenue)", z18n.P{"revenue": "%(revenue)"}),
+			"dashboard/anomaly-spike":     T(ctx, "dashboard/anomaly-spike|Unusually high: %(count) visitors, expected about %(expected)", z18n.P{"count": "%(count)", "expected": "%(expected)"}),
+			"dashboard/anomaly-drop":      T(ctx, "dashboard/anomaly-drop|Unusually low: %(count) visitors, expected about %(expected)", z18n.P{"count": "%(count)", "expected": "%(expected)"}),
 			"datepicker/keyboard":         T(ctx, "datepicker/keyboard|Use the arrow keys to pick a date"),
 			"datepicker/month-prev":       T(ctx, "datepicker/month-prev|Previous month"),
 			"datepicker/month-next":       T(ctx, "datepicker/month-next|Next month"),
@@ -214,15 +217,7 @@ func ErrPage(w http.ResponseWriter, r *http.Request, reported error) {
 
 	code, userErr := zhttp.UserError(reported)
 	if code >= 500 {
-		l := log.Module("http-500")
-		l = l.With("code", zhttp.UserErrorCode(reported))
-
-		sErr := new(interface{ StackTrace() string })
-		if errors.As(reported, sErr) {
-			reported = errors.Unwrap(reported)
-			l = l.With("stacktrace", "\n"+(*sErr).StackTrace())
-		}
-		l.Error(r.Context(), reported, log.AttrHTTP(r))
+		logServerError(r, reported)
 	}
 
 	ct := strings.ToLower(r.Header.Get("Content-Type"))
@@ -284,6 +279,20 @@ func ErrPage(w http.ResponseWriter, r *http.Request, reported error) {
 	}
 }
 
+// logServerError logs an unexpected error, with the same code that's reported
+// to the user.
+func logServerError(r *http.Request, reported error) {
+	l := log.Module("http-500")
+	l = l.With("code", zhttp.UserErrorCode(reported))
+
+	sErr := new(interface{ StackTrace() string })
+	if errors.As(reported, sErr) {
+		reported = errors.Unwrap(reported)
+		l = l.With("stacktrace", "\n"+(*sErr).StackTrace())
+	}
+	l.Error(r.Context(), reported, log.AttrHTTP(r))
+}
+
 // Set SameSite=None to allow embedding GoatCounter in a frame and allowing
 // login; there is no way to make this work with Lax or Strict as far as I can
 // find (there is no way to add exceptions for trusted sites).
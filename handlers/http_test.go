@@ -72,7 +72,7 @@ func TestMain(m *testing.M) {
 		"email_import_done.gotxt", "email_import_error.gotxt",
 		"email_password_reset.gotxt", "email_verify.gotxt",
 		"email_adduser.gotxt", "_email_bottom.gohtml", "email_report.gohtml",
-		"email_report.gotxt",
+		"email_report.gotxt", "email_report_recipient.gotxt", "email_api_token_expiry.gotxt",
 
 		// TODO
 		"_dashboard_pages_refs.gohtml",
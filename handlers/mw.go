@@ -53,15 +53,18 @@ var (
 	})
 
 	loggedInOrPublic = auth.Filter(func(w http.ResponseWriter, r *http.Request) error {
+		s := Site(r.Context())
 		u := goatcounter.GetUser(r.Context())
-		if u != nil && u.ID > 0 {
+		loggedIn := u != nil && u.ID > 0
+		if loggedIn {
 			err := u.UpdateOpenAt(r.Context())
 			if err != nil {
 				log.Error(r.Context(), err)
 			}
-			return nil
+			if u.HasAccess(s.ID, goatcounter.AccessReadOnly) {
+				return nil
+			}
 		}
-		s := Site(r.Context())
 		if s.Settings.IsPublic() {
 			return nil
 		}
@@ -85,14 +88,24 @@ var (
 		if c, err := r.Cookie("access-token"); err == nil && s.Settings.CanView(c.Value) {
 			return nil
 		}
+		if c, err := r.Cookie("share-link"); err == nil {
+			var share goatcounter.ShareLink
+			if err := share.ByCookie(r.Context(), c.Value); err == nil && !share.Expired(r.Context()) {
+				*r = *r.WithContext(goatcounter.WithShareLink(r.Context(), &share))
+				return nil
+			}
+		}
 
+		if loggedIn {
+			return guru.New(403, "you don't have access to this site")
+		}
 		return redirect(w, r)
 	})
 
 	requireAccess = func(atLeast goatcounter.UserAccess) func(http.Handler) http.Handler {
 		return auth.Filter(func(w http.ResponseWriter, r *http.Request) error {
 			u := goatcounter.GetUser(r.Context())
-			if u != nil && u.ID > 0 && u.HasAccess(atLeast) {
+			if u != nil && u.ID > 0 && u.HasAccess(Site(r.Context()).ID, atLeast) {
 				return nil
 			}
 			return guru.Errorf(401, "Not allowed to view this page")
@@ -218,6 +231,7 @@ func addctx(db zdb.DB, dev, saas, loadSite bool, dashTimeout int) func(http.Hand
 			// Make sure there's always a z18n object; will get overriden by
 			// addz18n() later for endpoints where it matters.
 			ctx = z18n.With(ctx, goatcounter.DefaultLocale)
+			ctx = goatcounter.WithAuditActor(ctx, goatcounter.AuditActor{IP: r.RemoteAddr})
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
@@ -467,12 +481,17 @@ func Ratelimit(withUA bool, getStore func(r *http.Request) ([]limiter.Store, str
 
 			if !ok {
 				w.Header().Set("Retry-After", retryAfter)
+				if strings.HasPrefix(r.URL.Path, "/api/v1/") {
+					w.Header().Set("Content-Type", "application/json; charset=utf-8")
+				}
 				w.WriteHeader(http.StatusTooManyRequests)
 
 				if msg == "" {
 					msg = fmt.Sprintf("rate limited exceeded; try again in %s", exp)
 				}
-				if strings.HasPrefix(strings.ToLower(r.Header.Get("Content-Type")), "application/json") {
+				if strings.HasPrefix(r.URL.Path, "/api/v1/") {
+					fmt.Fprintf(w, `{"error": {"code": %q, "message": %q}}`, apiV1CodeRateLimited, msg)
+				} else if strings.HasPrefix(strings.ToLower(r.Header.Get("Content-Type")), "application/json") {
 					fmt.Fprintf(w, `{"error": %q}`, msg)
 				} else {
 					fmt.Fprintf(w, "%s\n", msg)
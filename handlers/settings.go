@@ -6,10 +6,12 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"runtime"
 	"slices"
+	"strconv"
 	"strings"
 	"time"
 
@@ -24,7 +26,10 @@ import (
 	"zgo.at/goatcounter/v2/pkg/bgrun"
 	"zgo.at/goatcounter/v2/pkg/geo"
 	"zgo.at/goatcounter/v2/pkg/log"
+	"zgo.at/goatcounter/v2/widgets"
 	"zgo.at/guru"
+	"zgo.at/z18n"
+	"zgo.at/zcache/v2"
 	"zgo.at/zdb"
 	"zgo.at/zhttp"
 	"zgo.at/zhttp/header"
@@ -45,6 +50,7 @@ func (h settings) mount(r chi.Router, ratelimits Ratelimits) {
 
 		r.Get("/user/pref", zhttp.Wrap(h.userPref(nil)))
 		r.Post("/user/pref", zhttp.Wrap(h.userPrefSave))
+		r.Post("/user/pref/report-preview", zhttp.Wrap(h.userPrefReportPreview))
 
 		r.Get("/user/dashboard", zhttp.Wrap(h.userDashboard(nil)))
 		r.Get("/user/dashboard/widget/{name}", zhttp.Wrap(h.userDashboardWidget))
@@ -53,7 +59,8 @@ func (h settings) mount(r chi.Router, ratelimits Ratelimits) {
 		r.Post("/user/dashboard", zhttp.Wrap(h.userDashboardSave))
 		r.Post("/user/view", zhttp.Wrap(h.userViewSave))
 
-		r.Get("/user/auth", zhttp.Wrap(h.userAuth(nil)))
+		r.Get("/user/auth", zhttp.Wrap(h.userAuth(nil, nil)))
+		r.Post("/user/recovery-codes", zhttp.Wrap(h.recoveryCodes))
 	}
 
 	{ // Site settings.
@@ -77,12 +84,53 @@ func (h settings) mount(r chi.Router, ratelimits Ratelimits) {
 		set.Get("/settings/batchpurge", zhttp.Wrap(h.batchpurge))
 		set.Post("/settings/batchpurge", zhttp.Wrap(h.batchpurge))
 
+		set.Get("/settings/bots", zhttp.Wrap(func(w http.ResponseWriter, r *http.Request) error {
+			return h.bots(nil, goatcounter.BotRule{})(w, r)
+		}))
+		set.Post("/settings/bots/add", zhttp.Wrap(h.botsAdd))
+		set.Post("/settings/bots/remove/{id}", zhttp.Wrap(h.botsRemove))
+		set.Post("/settings/bots/recover", zhttp.Wrap(h.botsRecover))
+
+		set.Get("/settings/refspam", zhttp.Wrap(func(w http.ResponseWriter, r *http.Request) error {
+			return h.refspam(nil, goatcounter.Refspam{})(w, r)
+		}))
+		set.Post("/settings/refspam/add", zhttp.Wrap(h.refspamAdd))
+		set.Post("/settings/refspam/import", zhttp.Wrap(h.refspamImport))
+		set.Post("/settings/refspam/remove/{id}", zhttp.Wrap(h.refspamRemove))
+		set.Post("/settings/refspam/review/{id}", zhttp.Wrap(h.refspamReview))
+
+		set.Get("/settings/channels", zhttp.Wrap(func(w http.ResponseWriter, r *http.Request) error {
+			return h.channels(nil, goatcounter.ChannelRule{})(w, r)
+		}))
+		set.Post("/settings/channels/add", zhttp.Wrap(h.channelsAdd))
+		set.Post("/settings/channels/remove/{id}", zhttp.Wrap(h.channelsRemove))
+
+		set.Get("/settings/counter", zhttp.Wrap(func(w http.ResponseWriter, r *http.Request) error {
+			theme, _ := Site(r.Context()).Settings.CounterThemes.Get(r.URL.Query().Get("edit"))
+			return h.counterThemes(nil, theme)(w, r)
+		}))
+		set.Post("/settings/counter/save", zhttp.Wrap(h.counterThemesSave))
+		set.Post("/settings/counter/remove/{name}", zhttp.Wrap(h.counterThemesRemove))
+
+		set.Get("/settings/share", zhttp.Wrap(func(w http.ResponseWriter, r *http.Request) error {
+			return h.shareLinks(nil, goatcounter.ShareLink{})(w, r)
+		}))
+		set.Post("/settings/share/add", zhttp.Wrap(h.shareLinksAdd))
+		set.Post("/settings/share/remove/{id}", zhttp.Wrap(h.shareLinksRemove))
+
+		set.Get("/settings/annotations", zhttp.Wrap(func(w http.ResponseWriter, r *http.Request) error {
+			return h.annotations(nil, goatcounter.Annotation{})(w, r)
+		}))
+		set.Post("/settings/annotations/add", zhttp.Wrap(h.annotationsAdd))
+		set.Post("/settings/annotations/remove/{id}", zhttp.Wrap(h.annotationsRemove))
+
 		set.Get("/settings/export", zhttp.Wrap(func(w http.ResponseWriter, r *http.Request) error {
 			return h.export(nil)(w, r)
 		}))
 		set.Get("/settings/export/{id}", zhttp.Wrap(h.exportDownload))
 		set.Post("/settings/export/import", zhttp.Wrap(h.exportImport))
 		set.Post("/settings/export/import-ga", zhttp.Wrap(h.exportImportGA))
+		set.Post("/settings/export/import-search", zhttp.Wrap(h.exportImportSearch))
 		set.With(Ratelimit(false, func(*http.Request) ([]limiter.Store, string) {
 			// TODO(i18n): this should be translated.
 			return []limiter.Store{ratelimits.Export}, "you can request only one export per hour"
@@ -97,6 +145,7 @@ func (h settings) mount(r chi.Router, ratelimits Ratelimits) {
 		}))
 		admin.Post("/user/api-token", zhttp.Wrap(h.newAPIToken))
 		admin.Post("/user/api-token/remove/{id}", zhttp.Wrap(h.deleteAPIToken))
+		admin.Get("/user/api-token/{id}/log", zhttp.Wrap(h.apiTokenLog))
 
 		admin.Get("/settings/sites", zhttp.Wrap(func(w http.ResponseWriter, r *http.Request) error {
 			return h.sites(nil, goatcounter.Site{})(w, r)
@@ -120,6 +169,15 @@ func (h settings) mount(r chi.Router, ratelimits Ratelimits) {
 		admin.Post("/settings/users/{id}", zhttp.Wrap(h.usersEdit))
 		admin.Post("/settings/users/remove/{id}", zhttp.Wrap(h.usersRemove))
 
+		admin.Get("/settings/sso", zhttp.Wrap(func(w http.ResponseWriter, r *http.Request) error {
+			return h.sso(nil, nil)(w, r)
+		}))
+		admin.Post("/settings/sso", zhttp.Wrap(h.ssoSave))
+		admin.Post("/settings/sso/remove", zhttp.Wrap(h.ssoRemove))
+		admin.Post("/settings/sso/server", zhttp.Wrap(h.ssoServer))
+
+		admin.Get("/settings/audit-log", zhttp.Wrap(h.auditLog))
+
 		admin.Get("/settings/delete-account", zhttp.Wrap(func(w http.ResponseWriter, r *http.Request) error {
 			return h.delete(nil)(w, r)
 		}))
@@ -178,6 +236,9 @@ func (h settings) mainSave(w http.ResponseWriter, r *http.Request) error {
 	}
 
 	site := Site(r.Context())
+	before := *site
+	args.Settings.CounterThemes = site.Settings.CounterThemes // Not in the form; set from /settings/counter.
+	args.Settings.ServerSSO = site.Settings.ServerSSO         // Not in the form; set from /settings/sso.
 	site.Settings = args.Settings
 	site.LinkDomain = args.LinkDomain
 
@@ -191,7 +252,13 @@ func (h settings) mainSave(w http.ResponseWriter, r *http.Request) error {
 		site.Cname = &args.Cname
 	}
 
-	err = site.Update(r.Context())
+	err = zdb.TX(r.Context(), func(ctx context.Context) error {
+		err := site.Update(ctx)
+		if err != nil {
+			return err
+		}
+		return goatcounter.Audit(ctx, goatcounter.AuditSettingsUpdate, before, site)
+	})
 	if err != nil {
 		var vErr *zvalidate.Validator
 		if !errors.As(err, &vErr) {
@@ -246,7 +313,15 @@ func (h settings) changeCode(w http.ResponseWriter, r *http.Request) error {
 	}
 
 	site := Site(r.Context())
-	err = site.UpdateCode(r.Context(), args.Code)
+	before := site.Code
+	err = zdb.TX(r.Context(), func(ctx context.Context) error {
+		err := site.UpdateCode(ctx, args.Code)
+		if err != nil {
+			return err
+		}
+		return goatcounter.Audit(ctx, goatcounter.AuditSiteUpdate,
+			map[string]string{"code": before}, map[string]string{"code": site.Code})
+	})
 	if err != nil {
 		return err
 	}
@@ -311,7 +386,13 @@ func (h settings) sitesAdd(w http.ResponseWriter, r *http.Request) error {
 			return guru.New(400, T(r.Context(), "error/address-exists|%(addr) already exists", addr))
 		}
 
-		err = newSite.Undelete(r.Context(), newSite.ID)
+		err = zdb.TX(r.Context(), func(ctx context.Context) error {
+			err := newSite.Undelete(ctx, newSite.ID)
+			if err != nil {
+				return err
+			}
+			return goatcounter.Audit(goatcounter.WithSite(ctx, &newSite), goatcounter.AuditSiteCreate, nil, newSite)
+		})
 		if err != nil {
 			return err
 		}
@@ -332,9 +413,12 @@ func (h settings) sitesAdd(w http.ResponseWriter, r *http.Request) error {
 		}
 		if !goatcounter.Config(r.Context()).GoatcounterCom {
 			newSite.CnameSetupAt = new(ztime.Now(ctx))
-			return newSite.UpdateCnameSetupAt(ctx)
+			err = newSite.UpdateCnameSetupAt(ctx)
+			if err != nil {
+				return err
+			}
 		}
-		return nil
+		return goatcounter.Audit(goatcounter.WithSite(ctx, &newSite), goatcounter.AuditSiteCreate, nil, newSite)
 	})
 	var vErr *zvalidate.Validator
 	if errors.As(err, &vErr) {
@@ -402,7 +486,13 @@ func (h settings) sitesRemove(w http.ResponseWriter, r *http.Request) error {
 	}
 
 	sID := s.ID
-	err = s.Delete(r.Context(), false)
+	err = zdb.TX(r.Context(), func(ctx context.Context) error {
+		err := goatcounter.Audit(goatcounter.WithSite(ctx, s), goatcounter.AuditSiteDelete, s, nil)
+		if err != nil {
+			return err
+		}
+		return s.Delete(ctx, false)
+	})
 	if err != nil {
 		return err
 	}
@@ -454,12 +544,23 @@ func (h settings) sitesCopySettings(w http.ResponseWriter, r *http.Request) erro
 		}
 	}
 
-	for _, c := range copies {
-		c.Settings = master.Settings
-		err := c.Update(r.Context())
-		if err != nil {
-			return err
+	err = zdb.TX(r.Context(), func(ctx context.Context) error {
+		for _, c := range copies {
+			before := c
+			c.Settings = master.Settings
+			err := c.Update(ctx)
+			if err != nil {
+				return err
+			}
+			err = goatcounter.Audit(goatcounter.WithSite(ctx, &c), goatcounter.AuditSiteUpdate, before, c)
+			if err != nil {
+				return err
+			}
 		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
 	zhttp.Flash(w, r, T(r.Context(), "notify/settings-copied-to-site|Settings copied to the selected sites."))
@@ -647,7 +748,7 @@ func (h settings) purge(w http.ResponseWriter, r *http.Request) error {
 			return err
 		}
 
-		_, err = paths.List(r.Context(), goatcounter.MustGetSite(r.Context()).ID, 0, 5_000)
+		_, err = paths.List(r.Context(), goatcounter.MustGetSite(r.Context()).ID, 0, nil, 5_000)
 		if err != nil {
 			return err
 		}
@@ -668,6 +769,10 @@ func (h settings) purgeDo(w http.ResponseWriter, r *http.Request) error {
 	if err != nil {
 		return err
 	}
+	err = goatcounter.Audit(r.Context(), goatcounter.AuditPathsPurge, map[string]any{"path_ids": paths}, nil)
+	if err != nil {
+		return err
+	}
 
 	ctx := context.WithoutCancel(r.Context())
 	bgrun.RunFunction(fmt.Sprintf("purge:%d", Site(ctx).ID), func() {
@@ -711,6 +816,11 @@ func (h settings) merge(w http.ResponseWriter, r *http.Request) error {
 		}
 	}
 
+	err = goatcounter.Audit(r.Context(), goatcounter.AuditPathsMerge, merge, p)
+	if err != nil {
+		return err
+	}
+
 	ctx := context.WithoutCancel(r.Context())
 	bgrun.RunFunction(fmt.Sprintf("merge:%d", Site(ctx).ID), func() {
 		err := p.Merge(ctx, merge)
@@ -724,213 +834,938 @@ func (h settings) merge(w http.ResponseWriter, r *http.Request) error {
 	return zhttp.SeeOther(w, "/settings/purge")
 }
 
-func (h settings) export(verr *zvalidate.Validator) zhttp.HandlerFunc {
+func (h settings) bots(verr *zvalidate.Validator, newRule goatcounter.BotRule) zhttp.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) error {
-		var exports goatcounter.Exports
-		err := exports.List(r.Context())
+		var rules goatcounter.BotRules
+		err := rules.List(r.Context())
 		if err != nil {
 			return err
 		}
 
-		ch := goatcounter.MustGetSite(r.Context()).Settings.Collect.Has(goatcounter.CollectHits)
-		return zhttp.Template(w, "settings_export.gohtml", struct {
+		var byUA, byIP goatcounter.BotStats
+		err = byUA.ListByUA(r.Context(), 50)
+		if err != nil {
+			return err
+		}
+		err = byIP.ListByIP(r.Context(), 50)
+		if err != nil {
+			return err
+		}
+
+		if newRule.Kind == "" {
+			newRule.Kind = goatcounter.BotRuleUA
+		}
+
+		return zhttp.Template(w, "settings_bots.gohtml", struct {
 			Globals
-			Validate    *zvalidate.Validator
-			CollectHits bool
-			Exports     goatcounter.Exports
-		}{newGlobals(w, r), verr, ch, exports})
+			Rules    goatcounter.BotRules
+			ByUA     goatcounter.BotStats
+			ByIP     goatcounter.BotStats
+			NewRule  goatcounter.BotRule
+			Validate *zvalidate.Validator
+		}{newGlobals(w, r), rules, byUA, byIP, newRule, verr})
 	}
 }
 
-func (h settings) exportDownload(w http.ResponseWriter, r *http.Request) error {
-	v := goatcounter.NewValidate(r.Context())
-	id := goatcounter.ExportID(v.Integer32("id", chi.URLParam(r, "id")))
-	if v.HasErrors() {
-		return v
-	}
-
-	var export goatcounter.Export
-	err := export.ByID(r.Context(), id)
+func (h settings) botsAdd(w http.ResponseWriter, r *http.Request) error {
+	var rule goatcounter.BotRule
+	_, err := zhttp.Decode(r, &rule)
 	if err != nil {
 		return err
 	}
 
-	fp, err := os.Open(export.Path)
-	if err != nil {
-		if os.IsNotExist(err) {
-			zhttp.FlashError(w, r, T(r.Context(), "error/export-expired|It looks like there is no export yet or the export has expired."))
-			return zhttp.SeeOther(w, "/settings/export")
+	err = zdb.TX(r.Context(), func(ctx context.Context) error {
+		err := rule.Insert(ctx)
+		if err != nil {
+			return err
 		}
-
-		return err
-	}
-	defer fp.Close()
-
-	err = header.SetContentDisposition(w.Header(), header.DispositionArgs{
-		Type:     header.TypeAttachment,
-		Filename: filepath.Base(export.Path),
+		return goatcounter.Audit(ctx, goatcounter.AuditBotRuleCreate, nil, rule)
 	})
 	if err != nil {
+		var vErr *zvalidate.Validator
+		if errors.As(err, &vErr) {
+			return h.bots(vErr, rule)(w, r)
+		}
 		return err
 	}
 
-	w.Header().Set("Content-Type", "application/gzip")
-	return zhttp.Stream(w, fp)
+	zhttp.Flash(w, r, T(r.Context(), "notify/bot-rule-added|Bot rule added."))
+	return zhttp.SeeOther(w, "/settings/bots")
 }
 
-func (h settings) exportImport(w http.ResponseWriter, r *http.Request) error {
-	r.Body = http.MaxBytesReader(w, r.Body, 1024*1024*500)
-
+func (h settings) botsRemove(w http.ResponseWriter, r *http.Request) error {
 	v := goatcounter.NewValidate(r.Context())
-	replace := v.Boolean("replace", r.Form.Get("replace"))
+	id := goatcounter.BotRuleID(v.Integer32("id", chi.URLParam(r, "id")))
 	if v.HasErrors() {
 		return v
 	}
 
-	format := r.Form.Get("format")
-	switch format {
-	default:
-		return guru.Errorf(400, "unknown format: %q", format)
-	case "csv", "json":
-		// Okay, don't need to do anything.
+	rule := goatcounter.BotRule{ID: id}
+	err := zdb.TX(r.Context(), func(ctx context.Context) error {
+		err := rule.Delete(ctx)
+		if err != nil {
+			return err
+		}
+		return goatcounter.Audit(ctx, goatcounter.AuditBotRuleDelete, rule, nil)
+	})
+	if err != nil {
+		return err
 	}
 
-	file, head, err := r.FormFile(format)
+	zhttp.Flash(w, r, T(r.Context(), "notify/bot-rule-removed|Bot rule removed."))
+	return zhttp.SeeOther(w, "/settings/bots")
+}
+
+func (h settings) botsRecover(w http.ResponseWriter, r *http.Request) error {
+	var args struct {
+		Kind  string `json:"kind"`
+		Value string `json:"value"`
+	}
+	_, err := zhttp.Decode(r, &args)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
 
-	switch format {
-	case "json":
-		if !strings.HasSuffix(head.Filename, ".zip") {
-			return guru.New(400, "JSON import must be as a .zip file")
-		}
-		tmp, err := zhttp.SaveUpload(file, head)
+	n, err := goatcounter.RecoverBots(r.Context(), args.Kind, args.Value)
+	if err != nil {
+		return err
+	}
+
+	zhttp.Flash(w, r, T(r.Context(),
+		"notify/bots-recovered|Added %(n) pageviews back to the statistics; may take about 10-20 seconds to fully process.", n))
+	return zhttp.SeeOther(w, "/settings/bots")
+}
+
+func (h settings) refspam(verr *zvalidate.Validator, newEntry goatcounter.Refspam) zhttp.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		var (
+			ctx   = r.Context()
+			query = strings.TrimSpace(r.URL.Query().Get("q"))
+			site  goatcounter.Refspams
+		)
+		err := site.List(ctx, Site(ctx).ID, goatcounter.RefspamBlocked, "", 5_000)
 		if err != nil {
 			return err
 		}
 
-		user := User(r.Context())
-		ctx := context.WithoutCancel(r.Context())
-		bgrun.RunFunction(fmt.Sprintf("import:%d", Site(ctx).ID), func() {
-			firstHitAt, err := goatcounter.ImportJSON(ctx, tmp, replace, true)
-			if err != nil {
+		var (
+			server, review goatcounter.Refspams
+			serverCount    int
+		)
+		if User(ctx).AccessSuperuser() {
+			if query != "" {
+				err = server.List(ctx, goatcounter.RefspamServer, goatcounter.RefspamBlocked, query, 100)
 				if err != nil {
-					if e, ok := err.(*errors.StackErr); ok {
-						err = e.Unwrap()
-					}
-
-					sendErr := blackmail.Get(ctx).Send("GoatCounter import error",
-						blackmail.From("GoatCounter import", goatcounter.Config(r.Context()).EmailFrom),
-						blackmail.To(user.Email),
-						blackmail.HeadersAutoreply(),
-						blackmail.BodyMustText(goatcounter.TplEmailImportError{Context: r.Context(), Error: err}.Render))
-					if sendErr != nil {
-						log.Error(ctx, sendErr)
-					}
+					return err
 				}
 			}
-			if firstHitAt != nil && !firstHitAt.IsZero() {
-				err := Site(ctx).UpdateFirstHitAt(ctx, *firstHitAt)
-				if err != nil {
-					log.Error(ctx, err)
-				}
+			err = review.List(ctx, goatcounter.RefspamServer, goatcounter.RefspamReview, "", 500)
+			if err != nil {
+				return err
 			}
-		})
-
-	case "csv":
-		var fp io.ReadCloser = file
-		if strings.HasSuffix(head.Filename, ".gz") {
-			fp, err = gzip.NewReader(file)
+			serverCount, err = server.Count(ctx, goatcounter.RefspamServer)
 			if err != nil {
-				return guru.New(400, T(r.Context(), "error/could-not-read|Could not read as gzip: %(err)", err))
+				return err
 			}
 		}
-		defer fp.Close()
-
-		user := User(r.Context())
-		ctx := context.WithoutCancel(r.Context())
-		n := 0
-		bgrun.RunFunction(fmt.Sprintf("import:%d", Site(ctx).ID), func() {
-			firstHitAt, err := goatcounter.ImportCSV(ctx, fp, replace, true, func(hit goatcounter.Hit, final bool) {
-				if final {
-					return
-				}
-
-				goatcounter.Memstore.Append(hit)
-				n++
-
-				// Spread out the load a bit.
-				if n%5000 == 0 {
-					err := cron.TaskPersistAndStat()
-					if err != nil {
-						log.Error(ctx, err)
-					}
-					cron.WaitPersistAndStat()
-				}
-			})
-			if err != nil {
-				if e, ok := err.(*errors.StackErr); ok {
-					err = e.Unwrap()
-				}
 
-				sendErr := blackmail.Get(ctx).Send("GoatCounter import error",
-					blackmail.From("GoatCounter import", goatcounter.Config(r.Context()).EmailFrom),
-					blackmail.To(user.Email),
-					blackmail.HeadersAutoreply(),
-					blackmail.BodyMustText(goatcounter.TplEmailImportError{Context: r.Context(), Error: err}.Render))
-				if sendErr != nil {
-					log.Error(ctx, sendErr)
-				}
-			}
-			if firstHitAt != nil && !firstHitAt.IsZero() {
-				err := Site(ctx).UpdateFirstHitAt(ctx, *firstHitAt)
-				if err != nil {
-					log.Error(ctx, err)
-				}
-			}
-		})
+		return zhttp.Template(w, "settings_refspam.gohtml", struct {
+			Globals
+			SiteList    goatcounter.Refspams
+			ServerList  goatcounter.Refspams
+			Review      goatcounter.Refspams
+			ServerCount int
+			Query       string
+			NewEntry    goatcounter.Refspam
+			Validate    *zvalidate.Validator
+		}{newGlobals(w, r), site, server, review, serverCount, query, newEntry, verr})
 	}
+}
 
-	zhttp.Flash(w, r, T(r.Context(),
-		"notify/import-started-in-background|Import started in the background; you’ll get an email when it’s done."))
-	return zhttp.SeeOther(w, "/settings/export")
+// refspamSite gets the site ID to use from the "server" form field; only
+// superusers can modify the server-wide list.
+func (h settings) refspamSite(r *http.Request) (goatcounter.SiteID, error) {
+	if r.Form.Get("server") == "" {
+		return Site(r.Context()).ID, nil
+	}
+	if !User(r.Context()).AccessSuperuser() {
+		return 0, guru.New(403, T(r.Context(), "error/refspam-server-access|Only users with server management access can change the server-wide list"))
+	}
+	return goatcounter.RefspamServer, nil
 }
 
-func (h settings) exportImportGA(w http.ResponseWriter, r *http.Request) error {
-	r.Body = http.MaxBytesReader(w, r.Body, 1024*1024*500)
-	file, head, err := r.FormFile("csv")
+func (h settings) refspamAdd(w http.ResponseWriter, r *http.Request) error {
+	siteID, err := h.refspamSite(r)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
 
-	var fp io.ReadCloser = file
-	if strings.HasSuffix(head.Filename, ".gz") {
-		fp, err = gzip.NewReader(file)
+	e := goatcounter.Refspam{SiteID: siteID, Host: r.Form.Get("host")}
+	err = zdb.TX(r.Context(), func(ctx context.Context) error {
+		err := e.Insert(ctx)
 		if err != nil {
-			return guru.New(400, T(r.Context(), "error/could-not-read|Could not read as gzip: %(err)", err))
+			return err
 		}
-	}
-	defer fp.Close()
-
-	err = goatcounter.ImportGA(r.Context(), fp)
+		return goatcounter.Audit(ctx, goatcounter.AuditRefspamCreate, nil, e)
+	})
 	if err != nil {
+		var vErr *zvalidate.Validator
+		if errors.As(err, &vErr) {
+			return h.refspam(vErr, e)(w, r)
+		}
 		return err
 	}
 
-	zhttp.Flash(w, r, T(r.Context(), "notify/import-ga-okay|Data processed successfully."))
-	return zhttp.SeeOther(w, "/settings/export")
+	zhttp.Flash(w, r, T(r.Context(), "notify/refspam-added|Added %(host).", e.Host))
+	return zhttp.SeeOther(w, "/settings/refspam")
 }
 
-func (h settings) exportStart(w http.ResponseWriter, r *http.Request) error {
-	r.ParseForm()
+func (h settings) refspamImport(w http.ResponseWriter, r *http.Request) error {
+	r.Body = http.MaxBytesReader(w, r.Body, 1024*1024*10)
 
-	if !r.Form.Has("format") {
-		return guru.New(400, `"format" missing`)
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		return err
 	}
-	switch r.Form.Get("format") {
+	defer file.Close()
+
+	siteID, err := h.refspamSite(r)
+	if err != nil {
+		return err
+	}
+
+	var (
+		list goatcounter.Refspams
+		n    int
+	)
+	err = zdb.TX(r.Context(), func(ctx context.Context) error {
+		var err error
+		n, err = list.Import(ctx, siteID, goatcounter.RefspamSourceImport, file)
+		if err != nil {
+			return err
+		}
+		return goatcounter.Audit(ctx, goatcounter.AuditRefspamCreate, nil,
+			map[string]any{"site_id": siteID, "source": goatcounter.RefspamSourceImport, "imported": n})
+	})
+	if err != nil {
+		return err
+	}
+
+	zhttp.Flash(w, r, T(r.Context(), "notify/refspam-imported|Imported %(n) hosts; hosts already in the list were skipped.", n))
+	return zhttp.SeeOther(w, "/settings/refspam")
+}
+
+func (h settings) refspamRemove(w http.ResponseWriter, r *http.Request) error {
+	v := goatcounter.NewValidate(r.Context())
+	id := goatcounter.RefspamID(v.Integer32("id", chi.URLParam(r, "id")))
+	if v.HasErrors() {
+		return v
+	}
+
+	var e goatcounter.Refspam
+	err := e.ByID(r.Context(), id)
+	if err != nil {
+		return err
+	}
+	if e.SiteID == goatcounter.RefspamServer && !User(r.Context()).AccessSuperuser() {
+		return guru.New(403, T(r.Context(), "error/refspam-server-access|Only users with server management access can change the server-wide list"))
+	}
+
+	err = zdb.TX(r.Context(), func(ctx context.Context) error {
+		err := e.Delete(ctx)
+		if err != nil {
+			return err
+		}
+		return goatcounter.Audit(ctx, goatcounter.AuditRefspamDelete, e, nil)
+	})
+	if err != nil {
+		return err
+	}
+
+	zhttp.Flash(w, r, T(r.Context(), "notify/refspam-removed|Removed %(host).", e.Host))
+	return zhttp.SeeOther(w, "/settings/refspam")
+}
+
+func (h settings) refspamReview(w http.ResponseWriter, r *http.Request) error {
+	if !User(r.Context()).AccessSuperuser() {
+		return guru.New(403, T(r.Context(), "error/refspam-server-access|Only users with server management access can change the server-wide list"))
+	}
+
+	v := goatcounter.NewValidate(r.Context())
+	id := goatcounter.RefspamID(v.Integer32("id", chi.URLParam(r, "id")))
+	state := r.Form.Get("state")
+	v.Include("state", state, []string{goatcounter.RefspamBlocked, goatcounter.RefspamAllowed})
+	if v.HasErrors() {
+		return v
+	}
+
+	var e goatcounter.Refspam
+	err := e.ByID(r.Context(), id)
+	if err != nil {
+		return err
+	}
+	err = e.UpdateState(r.Context(), state)
+	if err != nil {
+		return err
+	}
+
+	if state == goatcounter.RefspamBlocked {
+		zhttp.Flash(w, r, T(r.Context(), "notify/refspam-added|Added %(host).", e.Host))
+	} else {
+		zhttp.Flash(w, r, T(r.Context(), "notify/refspam-allowed|Marked %(host) as not spam.", e.Host))
+	}
+	return zhttp.SeeOther(w, "/settings/refspam")
+}
+
+func (h settings) channels(verr *zvalidate.Validator, newRule goatcounter.ChannelRule) zhttp.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		var rules goatcounter.ChannelRules
+		err := rules.List(r.Context())
+		if err != nil {
+			return err
+		}
+
+		if newRule.Channel == "" {
+			newRule.Channel = goatcounter.ChannelReferral
+		}
+		names := make(map[string]string, len(goatcounter.Channels))
+		for _, c := range goatcounter.Channels {
+			names[c] = goatcounter.ChannelName(r.Context(), c)
+		}
+
+		return zhttp.Template(w, "settings_channels.gohtml", struct {
+			Globals
+			Rules    goatcounter.ChannelRules
+			Channels []string
+			Names    map[string]string
+			NewRule  goatcounter.ChannelRule
+			Validate *zvalidate.Validator
+		}{newGlobals(w, r), rules, goatcounter.Channels, names, newRule, verr})
+	}
+}
+
+func (h settings) channelsAdd(w http.ResponseWriter, r *http.Request) error {
+	var rule goatcounter.ChannelRule
+	_, err := zhttp.Decode(r, &rule)
+	if err != nil {
+		return err
+	}
+
+	err = zdb.TX(r.Context(), func(ctx context.Context) error {
+		err := rule.Insert(ctx)
+		if err != nil {
+			return err
+		}
+		return goatcounter.Audit(ctx, goatcounter.AuditChannelRuleCreate, nil, rule)
+	})
+	if err != nil {
+		var vErr *zvalidate.Validator
+		if errors.As(err, &vErr) {
+			return h.channels(vErr, rule)(w, r)
+		}
+		return err
+	}
+
+	zhttp.Flash(w, r, T(r.Context(), "notify/channel-rule-added|Channel rule added."))
+	return zhttp.SeeOther(w, "/settings/channels")
+}
+
+func (h settings) channelsRemove(w http.ResponseWriter, r *http.Request) error {
+	v := goatcounter.NewValidate(r.Context())
+	id := goatcounter.ChannelRuleID(v.Integer32("id", chi.URLParam(r, "id")))
+	if v.HasErrors() {
+		return v
+	}
+
+	rule := goatcounter.ChannelRule{ID: id}
+	err := zdb.TX(r.Context(), func(ctx context.Context) error {
+		err := rule.Delete(ctx)
+		if err != nil {
+			return err
+		}
+		return goatcounter.Audit(ctx, goatcounter.AuditChannelRuleDelete, rule, nil)
+	})
+	if err != nil {
+		return err
+	}
+
+	zhttp.Flash(w, r, T(r.Context(), "notify/channel-rule-removed|Channel rule removed."))
+	return zhttp.SeeOther(w, "/settings/channels")
+}
+
+func (h settings) counterThemes(verr *zvalidate.Validator, edit goatcounter.CounterTheme) zhttp.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		return zhttp.Template(w, "settings_counter.gohtml", struct {
+			Globals
+			Themes   goatcounter.CounterThemes
+			Edit     goatcounter.CounterTheme
+			Fonts    []string
+			Validate *zvalidate.Validator
+		}{newGlobals(w, r), Site(r.Context()).Settings.CounterThemes, edit,
+			goatcounter.CounterFonts, verr})
+	}
+}
+
+func (h settings) counterThemesSave(w http.ResponseWriter, r *http.Request) error {
+	var theme goatcounter.CounterTheme
+	_, err := zhttp.Decode(r, &theme)
+	if err != nil {
+		return err
+	}
+
+	err = theme.Validate(r.Context())
+	if err != nil {
+		var vErr *zvalidate.Validator
+		if errors.As(err, &vErr) {
+			return h.counterThemes(vErr, theme)(w, r)
+		}
+		return err
+	}
+
+	site := Site(r.Context())
+	before := *site
+	site.Settings.CounterThemes = slices.Clone(site.Settings.CounterThemes)
+	site.Settings.CounterThemes.Set(theme)
+	err = h.updateCounterThemes(r.Context(), before, site)
+	if err != nil {
+		return err
+	}
+
+	zhttp.Flash(w, r, T(r.Context(), "notify/counter-theme-saved|Theme %(name) saved.", theme.Name))
+	return zhttp.SeeOther(w, "/settings/counter")
+}
+
+func (h settings) counterThemesRemove(w http.ResponseWriter, r *http.Request) error {
+	name := chi.URLParam(r, "name")
+	site := Site(r.Context())
+	if _, ok := site.Settings.CounterThemes.Get(name); !ok {
+		return guru.Errorf(404, "no theme %q", name)
+	}
+
+	before := *site
+	site.Settings.CounterThemes = slices.Clone(site.Settings.CounterThemes)
+	site.Settings.CounterThemes.Remove(name)
+	err := h.updateCounterThemes(r.Context(), before, site)
+	if err != nil {
+		return err
+	}
+
+	zhttp.Flash(w, r, T(r.Context(), "notify/counter-theme-removed|Theme %(name) removed.", name))
+	return zhttp.SeeOther(w, "/settings/counter")
+}
+
+func (h settings) updateCounterThemes(ctx context.Context, before goatcounter.Site, site *goatcounter.Site) error {
+	err := zdb.TX(ctx, func(ctx context.Context) error {
+		err := site.Update(ctx)
+		if err != nil {
+			return err
+		}
+		return goatcounter.Audit(ctx, goatcounter.AuditSettingsUpdate, before, site)
+	})
+	if err != nil {
+		return err
+	}
+
+	// Make sure the new theme is used right away.
+	prefix := strconv.FormatInt(int64(site.ID), 10) + "-"
+	vcounterCache.DeleteFunc(func(k string, _ zcache.Item[vcache]) (bool, bool) {
+		return strings.HasPrefix(k, prefix), false
+	})
+	return nil
+}
+
+func (h settings) shareLinks(verr *zvalidate.Validator, newLink goatcounter.ShareLink) zhttp.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		var links goatcounter.ShareLinks
+		err := links.List(r.Context())
+		if err != nil {
+			return err
+		}
+
+		if newLink.Period == "" && newLink.PeriodStart == nil {
+			newLink.Period = "week"
+		}
+		if len(newLink.Widgets) == 0 {
+			newLink.Widgets = goatcounter.Strings{"pages"}
+		}
+
+		return zhttp.Template(w, "settings_share.gohtml", struct {
+			Globals
+			Links      goatcounter.ShareLinks
+			NewLink    goatcounter.ShareLink
+			AllWidgets widgets.List
+			Periods    []string
+			Validate   *zvalidate.Validator
+		}{newGlobals(w, r), links, newLink, widgets.ListAllWidgets(),
+			goatcounter.ShareLinkPeriods, verr})
+	}
+}
+
+func (h settings) shareLinksAdd(w http.ResponseWriter, r *http.Request) error {
+	var args struct {
+		Name        string   `json:"name"`
+		Widgets     []string `json:"widgets"`
+		Filter      string   `json:"filter"`
+		Period      string   `json:"period"`
+		PeriodStart string   `json:"period_start"`
+		PeriodEnd   string   `json:"period_end"`
+		ExpiresAt   string   `json:"expires_at"`
+		Password    string   `json:"password"`
+	}
+	_, err := zhttp.Decode(r, &args)
+	if err != nil {
+		return err
+	}
+
+	link := goatcounter.ShareLink{
+		Name:    args.Name,
+		Widgets: args.Widgets,
+		Filter:  args.Filter,
+		Period:  args.Period,
+	}
+	v := goatcounter.NewValidate(r.Context())
+	if args.Period == "fixed" {
+		start := v.Date("period_start", args.PeriodStart, "2006-01-02")
+		end := v.Date("period_end", args.PeriodEnd, "2006-01-02")
+		if !start.IsZero() {
+			link.PeriodStart = &start
+		}
+		if !end.IsZero() {
+			link.PeriodEnd = &end
+		}
+	}
+	if args.ExpiresAt != "" {
+		exp := v.Date("expires_at", args.ExpiresAt, "2006-01-02")
+		link.ExpiresAt = new(ztime.EndOf(exp, ztime.Day))
+	}
+	if v.HasErrors() {
+		return h.shareLinks(&v, link)(w, r)
+	}
+	err = link.SetPassword(r.Context(), args.Password)
+	if err != nil {
+		return err
+	}
+
+	err = zdb.TX(r.Context(), func(ctx context.Context) error {
+		err := link.Insert(ctx)
+		if err != nil {
+			return err
+		}
+		return goatcounter.Audit(ctx, goatcounter.AuditShareLinkCreate, nil, link)
+	})
+	if err != nil {
+		var vErr *zvalidate.Validator
+		if errors.As(err, &vErr) {
+			return h.shareLinks(vErr, link)(w, r)
+		}
+		return err
+	}
+
+	zhttp.Flash(w, r, T(r.Context(), "notify/share-link-added|Share link added."))
+	return zhttp.SeeOther(w, "/settings/share")
+}
+
+func (h settings) shareLinksRemove(w http.ResponseWriter, r *http.Request) error {
+	v := goatcounter.NewValidate(r.Context())
+	id := goatcounter.ShareLinkID(v.Integer32("id", chi.URLParam(r, "id")))
+	if v.HasErrors() {
+		return v
+	}
+
+	var link goatcounter.ShareLink
+	err := link.ByID(r.Context(), id)
+	if err != nil {
+		return err
+	}
+	err = zdb.TX(r.Context(), func(ctx context.Context) error {
+		err := link.Delete(ctx)
+		if err != nil {
+			return err
+		}
+		return goatcounter.Audit(ctx, goatcounter.AuditShareLinkDelete, link, nil)
+	})
+	if err != nil {
+		return err
+	}
+
+	zhttp.Flash(w, r, T(r.Context(), "notify/share-link-removed|Share link removed."))
+	return zhttp.SeeOther(w, "/settings/share")
+}
+
+func (h settings) annotations(verr *zvalidate.Validator, newAnnotation goatcounter.Annotation) zhttp.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		var annotations goatcounter.Annotations
+		err := annotations.List(r.Context())
+		if err != nil {
+			return err
+		}
+
+		if newAnnotation.At.IsZero() {
+			newAnnotation.At = ztime.Now(r.Context())
+		}
+		newAnnotation.At = newAnnotation.At.In(User(r.Context()).Settings.Timezone.Loc())
+
+		return zhttp.Template(w, "settings_annotations.gohtml", struct {
+			Globals
+			Annotations   goatcounter.Annotations
+			NewAnnotation goatcounter.Annotation
+			Validate      *zvalidate.Validator
+		}{newGlobals(w, r), annotations, newAnnotation, verr})
+	}
+}
+
+func (h settings) annotationsAdd(w http.ResponseWriter, r *http.Request) error {
+	var args struct {
+		At     string `json:"at"`
+		Label  string `json:"label"`
+		Filter string `json:"filter"`
+	}
+	_, err := zhttp.Decode(r, &args)
+	if err != nil {
+		return err
+	}
+
+	a := goatcounter.Annotation{Label: args.Label, Filter: args.Filter}
+	v := goatcounter.NewValidate(r.Context())
+	v.Required("at", args.At)
+	if at := v.Date("at", args.At, "2006-01-02T15:04"); !at.IsZero() {
+		a.At = time.Date(at.Year(), at.Month(), at.Day(), at.Hour(), at.Minute(), 0, 0,
+			User(r.Context()).Settings.Timezone.Loc())
+	}
+	if v.HasErrors() {
+		return h.annotations(&v, a)(w, r)
+	}
+
+	err = zdb.TX(r.Context(), func(ctx context.Context) error {
+		err := a.Insert(ctx)
+		if err != nil {
+			return err
+		}
+		return goatcounter.Audit(ctx, goatcounter.AuditAnnotationCreate, nil, a)
+	})
+	if err != nil {
+		var vErr *zvalidate.Validator
+		if errors.As(err, &vErr) {
+			return h.annotations(vErr, a)(w, r)
+		}
+		return err
+	}
+
+	zhttp.Flash(w, r, T(r.Context(), "notify/annotation-added|Annotation added."))
+	return zhttp.SeeOther(w, "/settings/annotations")
+}
+
+func (h settings) annotationsRemove(w http.ResponseWriter, r *http.Request) error {
+	v := goatcounter.NewValidate(r.Context())
+	id := goatcounter.AnnotationID(v.Integer32("id", chi.URLParam(r, "id")))
+	if v.HasErrors() {
+		return v
+	}
+
+	var a goatcounter.Annotation
+	err := a.ByID(r.Context(), id)
+	if err != nil {
+		return err
+	}
+	err = zdb.TX(r.Context(), func(ctx context.Context) error {
+		err := a.Delete(ctx)
+		if err != nil {
+			return err
+		}
+		return goatcounter.Audit(ctx, goatcounter.AuditAnnotationDelete, a, nil)
+	})
+	if err != nil {
+		return err
+	}
+
+	zhttp.Flash(w, r, T(r.Context(), "notify/annotation-removed|Annotation removed."))
+	return zhttp.SeeOther(w, "/settings/annotations")
+}
+
+func (h settings) export(verr *zvalidate.Validator) zhttp.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		var exports goatcounter.Exports
+		err := exports.List(r.Context())
+		if err != nil {
+			return err
+		}
+
+		ch := goatcounter.MustGetSite(r.Context()).Settings.Collect.Has(goatcounter.CollectHits)
+		return zhttp.Template(w, "settings_export.gohtml", struct {
+			Globals
+			Validate    *zvalidate.Validator
+			CollectHits bool
+			Exports     goatcounter.Exports
+		}{newGlobals(w, r), verr, ch, exports})
+	}
+}
+
+func (h settings) exportDownload(w http.ResponseWriter, r *http.Request) error {
+	v := goatcounter.NewValidate(r.Context())
+	id := goatcounter.ExportID(v.Integer32("id", chi.URLParam(r, "id")))
+	if v.HasErrors() {
+		return v
+	}
+
+	var export goatcounter.Export
+	err := export.ByID(r.Context(), id)
+	if err != nil {
+		return err
+	}
+
+	fp, err := os.Open(export.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			zhttp.FlashError(w, r, T(r.Context(), "error/export-expired|It looks like there is no export yet or the export has expired."))
+			return zhttp.SeeOther(w, "/settings/export")
+		}
+
+		return err
+	}
+	defer fp.Close()
+
+	err = header.SetContentDisposition(w.Header(), header.DispositionArgs{
+		Type:     header.TypeAttachment,
+		Filename: filepath.Base(export.Path),
+	})
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/gzip")
+	return zhttp.Stream(w, fp)
+}
+
+func (h settings) exportImport(w http.ResponseWriter, r *http.Request) error {
+	r.Body = http.MaxBytesReader(w, r.Body, 1024*1024*500)
+
+	v := goatcounter.NewValidate(r.Context())
+	replace := v.Boolean("replace", r.Form.Get("replace"))
+	if v.HasErrors() {
+		return v
+	}
+
+	format := r.Form.Get("format")
+	switch format {
+	default:
+		return guru.Errorf(400, "unknown format: %q", format)
+	case "csv", "json":
+		// Okay, don't need to do anything.
+	}
+
+	file, head, err := r.FormFile(format)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	err = goatcounter.Audit(r.Context(), goatcounter.AuditImport, nil,
+		map[string]any{"format": format, "file": head.Filename, "replace": replace})
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case "json":
+		if !strings.HasSuffix(head.Filename, ".zip") {
+			return guru.New(400, "JSON import must be as a .zip file")
+		}
+		tmp, err := zhttp.SaveUpload(file, head)
+		if err != nil {
+			return err
+		}
+
+		user := User(r.Context())
+		ctx := context.WithoutCancel(r.Context())
+		bgrun.RunFunction(fmt.Sprintf("import:%d", Site(ctx).ID), func() {
+			firstHitAt, err := goatcounter.ImportJSON(ctx, tmp, replace, true)
+			if err != nil {
+				if err != nil {
+					if e, ok := err.(*errors.StackErr); ok {
+						err = e.Unwrap()
+					}
+
+					sendErr := blackmail.Get(ctx).Send("GoatCounter import error",
+						blackmail.From("GoatCounter import", goatcounter.Config(r.Context()).EmailFrom),
+						blackmail.To(user.Email),
+						blackmail.HeadersAutoreply(),
+						blackmail.BodyMustText(goatcounter.TplEmailImportError{Context: r.Context(), Error: err}.Render))
+					if sendErr != nil {
+						log.Error(ctx, sendErr)
+					}
+				}
+			}
+			if firstHitAt != nil && !firstHitAt.IsZero() {
+				err := Site(ctx).UpdateFirstHitAt(ctx, *firstHitAt)
+				if err != nil {
+					log.Error(ctx, err)
+				}
+			}
+		})
+
+	case "csv":
+		var fp io.ReadCloser = file
+		if strings.HasSuffix(head.Filename, ".gz") {
+			fp, err = gzip.NewReader(file)
+			if err != nil {
+				return guru.New(400, T(r.Context(), "error/could-not-read|Could not read as gzip: %(err)", err))
+			}
+		}
+		defer fp.Close()
+
+		user := User(r.Context())
+		ctx := context.WithoutCancel(r.Context())
+		n := 0
+		bgrun.RunFunction(fmt.Sprintf("import:%d", Site(ctx).ID), func() {
+			firstHitAt, err := goatcounter.ImportCSV(ctx, fp, replace, true, func(hit goatcounter.Hit, final bool) {
+				if final {
+					return
+				}
+
+				goatcounter.Memstore.Append(hit)
+				n++
+
+				// Spread out the load a bit.
+				if n%5000 == 0 {
+					err := cron.TaskPersistAndStat()
+					if err != nil {
+						log.Error(ctx, err)
+					}
+					cron.WaitPersistAndStat()
+				}
+			})
+			if err != nil {
+				if e, ok := err.(*errors.StackErr); ok {
+					err = e.Unwrap()
+				}
+
+				sendErr := blackmail.Get(ctx).Send("GoatCounter import error",
+					blackmail.From("GoatCounter import", goatcounter.Config(r.Context()).EmailFrom),
+					blackmail.To(user.Email),
+					blackmail.HeadersAutoreply(),
+					blackmail.BodyMustText(goatcounter.TplEmailImportError{Context: r.Context(), Error: err}.Render))
+				if sendErr != nil {
+					log.Error(ctx, sendErr)
+				}
+			}
+			if firstHitAt != nil && !firstHitAt.IsZero() {
+				err := Site(ctx).UpdateFirstHitAt(ctx, *firstHitAt)
+				if err != nil {
+					log.Error(ctx, err)
+				}
+			}
+		})
+	}
+
+	zhttp.Flash(w, r, T(r.Context(),
+		"notify/import-started-in-background|Import started in the background; you’ll get an email when it’s done."))
+	return zhttp.SeeOther(w, "/settings/export")
+}
+
+func (h settings) exportImportGA(w http.ResponseWriter, r *http.Request) error {
+	r.Body = http.MaxBytesReader(w, r.Body, 1024*1024*500)
+	file, head, err := r.FormFile("csv")
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var fp io.ReadCloser = file
+	if strings.HasSuffix(head.Filename, ".gz") {
+		fp, err = gzip.NewReader(file)
+		if err != nil {
+			return guru.New(400, T(r.Context(), "error/could-not-read|Could not read as gzip: %(err)", err))
+		}
+	}
+	defer fp.Close()
+
+	err = zdb.TX(r.Context(), func(ctx context.Context) error {
+		err := goatcounter.ImportGA(ctx, fp)
+		if err != nil {
+			return err
+		}
+		return goatcounter.Audit(ctx, goatcounter.AuditImport, nil, map[string]any{"format": "ga", "file": head.Filename})
+	})
+	if err != nil {
+		return err
+	}
+
+	zhttp.Flash(w, r, T(r.Context(), "notify/import-ga-okay|Data processed successfully."))
+	return zhttp.SeeOther(w, "/settings/export")
+}
+
+func (h settings) exportImportSearch(w http.ResponseWriter, r *http.Request) error {
+	r.Body = http.MaxBytesReader(w, r.Body, 1024*1024*100)
+	file, head, err := r.FormFile("file")
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var (
+		fp     io.ReadCloser = file
+		name                 = strings.TrimSuffix(strings.ToLower(head.Filename), ".gz")
+		format               = "csv"
+	)
+	if strings.HasSuffix(name, ".json") {
+		format = "json"
+	}
+	if strings.HasSuffix(head.Filename, ".gz") {
+		fp, err = gzip.NewReader(file)
+		if err != nil {
+			return guru.New(400, T(r.Context(), "error/could-not-read|Could not read as gzip: %(err)", err))
+		}
+	}
+	defer fp.Close()
+
+	var n, skipped int
+	err = zdb.TX(r.Context(), func(ctx context.Context) error {
+		var err error
+		n, skipped, err = goatcounter.ImportSearchQueries(ctx, fp, r.Form.Get("source"), format)
+		if err != nil {
+			return err
+		}
+		return goatcounter.Audit(ctx, goatcounter.AuditImport, nil, map[string]any{
+			"format": "search-" + format, "source": r.Form.Get("source"), "file": head.Filename, "rows": n})
+	})
+	if err != nil {
+		return err
+	}
+
+	zhttp.Flash(w, r, T(r.Context(),
+		"notify/import-search-okay|Imported %(n) rows; %(skipped) rows were skipped because the page doesn’t exist in GoatCounter.",
+		z18n.P{"n": n, "skipped": skipped}))
+	return zhttp.SeeOther(w, "/settings/export")
+}
+
+// Create a new export with create() and add it to the audit log.
+func createExport(ctx context.Context, export *goatcounter.Export, create func(context.Context) (*os.File, error)) (*os.File, error) {
+	var fp *os.File
+	err := zdb.TX(ctx, func(ctx context.Context) error {
+		var err error
+		fp, err = create(ctx)
+		if err != nil {
+			return err
+		}
+		return goatcounter.Audit(ctx, goatcounter.AuditExport, nil, *export)
+	})
+	if err != nil {
+		if fp != nil {
+			fp.Close()
+			os.Remove(fp.Name())
+		}
+		return nil, err
+	}
+	return fp, nil
+}
+
+func (h settings) exportStart(w http.ResponseWriter, r *http.Request) error {
+	r.ParseForm()
+
+	if !r.Form.Has("format") {
+		return guru.New(400, `"format" missing`)
+	}
+	switch r.Form.Get("format") {
 	default:
 		return guru.Errorf(400, "unknown format: %q", r.Form.Get("format"))
 
@@ -942,7 +1777,9 @@ func (h settings) exportStart(w http.ResponseWriter, r *http.Request) error {
 		}
 
 		var export goatcounter.Export
-		fp, err := export.CreateJSON(r.Context(), periodStart)
+		fp, err := createExport(r.Context(), &export, func(ctx context.Context) (*os.File, error) {
+			return export.CreateJSON(ctx, periodStart)
+		})
 		if err != nil {
 			return err
 		}
@@ -958,7 +1795,9 @@ func (h settings) exportStart(w http.ResponseWriter, r *http.Request) error {
 		}
 
 		var export goatcounter.Export
-		fp, err := export.CreateCSV(r.Context(), startFrom)
+		fp, err := createExport(r.Context(), &export, func(ctx context.Context) (*os.File, error) {
+			return export.CreateCSV(ctx, startFrom)
+		})
 		if err != nil {
 			return err
 		}
@@ -990,7 +1829,13 @@ func (h settings) delete(verr *zvalidate.Validator) zhttp.HandlerFunc {
 
 func (h settings) deleteDo(w http.ResponseWriter, r *http.Request) error {
 	account := Account(r.Context())
-	err := account.Delete(r.Context(), true)
+	err := zdb.TX(r.Context(), func(ctx context.Context) error {
+		err := goatcounter.Audit(goatcounter.WithSite(ctx, account), goatcounter.AuditAccountDelete, account, nil)
+		if err != nil {
+			return err
+		}
+		return account.Delete(ctx, true)
+	})
 	if err != nil {
 		return err
 	}
@@ -1088,8 +1933,13 @@ func (h settings) mergeAccountDo(w http.ResponseWriter, r *http.Request) error {
 		if err != nil {
 			return err
 		}
-		return zdb.Exec(ctx, `delete from users where site_id in (?) and lower(email) = lower(?)`,
+		err = zdb.Exec(ctx, `delete from users where site_id in (?) and lower(email) = lower(?)`,
 			mergeSiteIDs, user.Email)
+		if err != nil {
+			return err
+		}
+		return goatcounter.Audit(goatcounter.WithSite(ctx, account), goatcounter.AuditAccountMerge, nil,
+			map[string]any{"account_id": mergeAccount.ID, "site_ids": mergeSiteIDs})
 	})
 	if err != nil {
 		return err
@@ -1118,11 +1968,18 @@ func (h settings) users(verr *zvalidate.Validator) zhttp.HandlerFunc {
 			return err
 		}
 
+		var sites goatcounter.Sites
+		err = sites.ForThisAccount(r.Context(), false)
+		if err != nil {
+			return err
+		}
+
 		return zhttp.Template(w, "settings_users.gohtml", struct {
 			Globals
 			Users    goatcounter.Users
+			Sites    goatcounter.Sites
 			Validate *zvalidate.Validator
-		}{newGlobals(w, r), users, verr})
+		}{newGlobals(w, r), users, sites, verr})
 	}
 }
 
@@ -1158,13 +2015,20 @@ func (h settings) usersForm(newUser *goatcounter.User, pErr error) zhttp.Handler
 			w.WriteHeader(code)
 		}
 
+		var sites goatcounter.Sites
+		err := sites.ForThisAccount(r.Context(), false)
+		if err != nil {
+			return err
+		}
+
 		return zhttp.Template(w, "settings_users_form.gohtml", struct {
 			Globals
 			NewUser  goatcounter.User
+			Sites    goatcounter.Sites
 			Validate *zvalidate.Validator
 			Error    error
 			Edit     bool
-		}{newGlobals(w, r), *newUser, vErr, pErr, edit})
+		}{newGlobals(w, r), *newUser, sites, vErr, pErr, edit})
 	}
 }
 
@@ -1199,19 +2063,30 @@ func (h settings) usersAdd(w http.ResponseWriter, r *http.Request) error {
 			return err
 		}
 		if args.Password == "" {
-			return newUser.InviteToken(ctx)
+			err = newUser.InviteToken(ctx)
+			if err != nil {
+				return err
+			}
 		}
-		return nil
+		return goatcounter.Audit(ctx, goatcounter.AuditUserCreate, nil, newUser)
 	})
 	if err != nil {
 		return h.usersForm(&newUser, err)(w, r)
 	}
 
-	ctx := context.WithoutCancel(r.Context())
+	sendAddUserEmail(r.Context(), account, newUser)
+	zhttp.Flash(w, r, T(r.Context(), "notify/user-added|User ‘%(email)’ added.", newUser.Email))
+	return zhttp.SeeOther(w, "/settings/users")
+}
+
+// sendAddUserEmail notifies a new user that an account was created for them,
+// in the background.
+func sendAddUserEmail(ctx context.Context, account *goatcounter.Site, newUser goatcounter.User) {
+	ctx = context.WithoutCancel(ctx)
 	bgrun.RunFunction(fmt.Sprintf("adduser:%d", newUser.ID), func() {
 		err := blackmail.Get(ctx).Send(
 			fmt.Sprintf("A GoatCounter account was created for you at %s", account.Display(ctx)),
-			blackmail.From("GoatCounter", goatcounter.Config(r.Context()).EmailFrom),
+			blackmail.From("GoatCounter", goatcounter.Config(ctx).EmailFrom),
 			blackmail.To(newUser.Email),
 			blackmail.Headers("Reply-To", goatcounter.MustGetUser(ctx).Email),
 			blackmail.BodyMustText(goatcounter.TplEmailAddUser{
@@ -1225,9 +2100,6 @@ func (h settings) usersAdd(w http.ResponseWriter, r *http.Request) error {
 			log.Errorf(ctx, ": %s", err)
 		}
 	})
-
-	zhttp.Flash(w, r, T(r.Context(), "notify/user-added|User ‘%(email)’ added.", newUser.Email))
-	return zhttp.SeeOther(w, "/settings/users")
 }
 
 func (h settings) usersEdit(w http.ResponseWriter, r *http.Request) error {
@@ -1262,6 +2134,7 @@ func (h settings) usersEdit(w http.ResponseWriter, r *http.Request) error {
 		return guru.New(404, T(r.Context(), "notify/not-found|Not Found"))
 	}
 
+	before := editUser
 	emailChanged := editUser.Email != args.Email
 	editUser.Email = args.Email
 	editUser.Access = args.Access
@@ -1278,7 +2151,7 @@ func (h settings) usersEdit(w http.ResponseWriter, r *http.Request) error {
 				return err
 			}
 		}
-		return nil
+		return goatcounter.Audit(ctx, goatcounter.AuditUserUpdate, before, editUser)
 	})
 	if err != nil {
 		return h.usersForm(&editUser, err)(w, r)
@@ -1307,7 +2180,13 @@ func (h settings) usersRemove(w http.ResponseWriter, r *http.Request) error {
 		return guru.New(404, T(r.Context(), "error/not-found|Not Found"))
 	}
 
-	err = user.Delete(r.Context(), false)
+	err = zdb.TX(r.Context(), func(ctx context.Context) error {
+		err := user.Delete(ctx, false)
+		if err != nil {
+			return err
+		}
+		return goatcounter.Audit(ctx, goatcounter.AuditUserDelete, user, nil)
+	})
 	if err != nil {
 		return err
 	}
@@ -1316,6 +2195,240 @@ func (h settings) usersRemove(w http.ResponseWriter, r *http.Request) error {
 	return zhttp.SeeOther(w, "/settings/users")
 }
 
+func (h settings) sso(verr *zvalidate.Validator, edit *goatcounter.OIDC) zhttp.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		ctx := r.Context()
+
+		var account, server goatcounter.OIDC
+		err := account.BySite(ctx, Account(ctx).ID)
+		if err != nil && !zdb.ErrNoRows(err) {
+			return err
+		}
+		err = server.BySite(ctx, goatcounter.OIDCServer)
+		if err != nil && !zdb.ErrNoRows(err) {
+			return err
+		}
+		hasServer := server.ID > 0
+		if !User(ctx).AccessSuperuser() {
+			server = goatcounter.OIDC{}
+		}
+		var serverVerr *zvalidate.Validator
+		if edit != nil {
+			if edit.SiteID == goatcounter.OIDCServer {
+				server, serverVerr, verr = *edit, verr, nil
+			} else {
+				account = *edit
+			}
+		}
+
+		return zhttp.Template(w, "settings_sso.gohtml", struct {
+			Globals
+			Account        goatcounter.OIDC
+			Server         goatcounter.OIDC
+			HasServer      bool
+			ServerSSO      bool
+			RedirectURI    string
+			Validate       *zvalidate.Validator
+			ServerValidate *zvalidate.Validator
+		}{newGlobals(w, r), account, server, hasServer, Account(ctx).Settings.ServerSSO,
+			oidcRedirectURI(ctx), verr, serverVerr})
+	}
+}
+
+// ssoSite gets the site ID to use from the "server" form field; only
+// superusers can modify the server-wide configuration.
+func (h settings) ssoSite(r *http.Request) (goatcounter.SiteID, error) {
+	if r.Form.Get("server") == "" {
+		return Account(r.Context()).ID, nil
+	}
+	if !User(r.Context()).AccessSuperuser() {
+		return 0, guru.New(403, T(r.Context(), "error/sso-server-access|Only users with server management access can change the server-wide configuration"))
+	}
+	return goatcounter.OIDCServer, nil
+}
+
+func (h settings) ssoSave(w http.ResponseWriter, r *http.Request) error {
+	var args struct {
+		Issuer        string                 `json:"issuer"`
+		ClientID      string                 `json:"client_id"`
+		ClientSecret  string                 `json:"client_secret"`
+		DefaultAccess goatcounter.UserAccess `json:"default_access"`
+		GroupsClaim   string                 `json:"groups_claim"`
+		Groups        string                 `json:"groups"`
+	}
+	_, err := zhttp.Decode(r, &args)
+	if err != nil {
+		return err
+	}
+	siteID, err := h.ssoSite(r)
+	if err != nil {
+		return err
+	}
+
+	var o goatcounter.OIDC
+	err = o.BySite(r.Context(), siteID)
+	if err != nil && !zdb.ErrNoRows(err) {
+		return err
+	}
+	var before any
+	if o.ID > 0 {
+		before = o
+	}
+	o.SiteID = siteID
+	o.Issuer, o.ClientID = args.Issuer, args.ClientID
+	o.DefaultAccess, o.GroupsClaim = args.DefaultAccess, args.GroupsClaim
+	if args.ClientSecret != "" { // Keep existing secret if blank.
+		o.ClientSecret = args.ClientSecret
+	}
+	o.Groups, err = goatcounter.ParseOIDCGroups(args.Groups)
+	if err != nil {
+		v := goatcounter.NewValidate(r.Context())
+		v.Append("groups", err.Error())
+		return h.sso(&v, &o)(w, r)
+	}
+
+	err = zdb.TX(r.Context(), func(ctx context.Context) error {
+		var err error
+		if o.ID == 0 {
+			err = o.Insert(ctx)
+		} else {
+			err = o.Update(ctx)
+		}
+		if err != nil {
+			return err
+		}
+		return goatcounter.Audit(ctx, goatcounter.AuditSSOUpdate, before, o)
+	})
+	if err != nil {
+		var vErr *zvalidate.Validator
+		if errors.As(err, &vErr) {
+			return h.sso(vErr, &o)(w, r)
+		}
+		return err
+	}
+
+	zhttp.Flash(w, r, T(r.Context(), "notify/saved|Saved!"))
+	return zhttp.SeeOther(w, "/settings/sso")
+}
+
+func (h settings) ssoRemove(w http.ResponseWriter, r *http.Request) error {
+	siteID, err := h.ssoSite(r)
+	if err != nil {
+		return err
+	}
+
+	var o goatcounter.OIDC
+	err = o.BySite(r.Context(), siteID)
+	if err != nil {
+		return err
+	}
+	err = zdb.TX(r.Context(), func(ctx context.Context) error {
+		err := o.Delete(ctx)
+		if err != nil {
+			return err
+		}
+		return goatcounter.Audit(ctx, goatcounter.AuditSSODelete, o, nil)
+	})
+	if err != nil {
+		return err
+	}
+
+	zhttp.Flash(w, r, T(r.Context(), "notify/sso-removed|Single sign-on removed."))
+	return zhttp.SeeOther(w, "/settings/sso")
+}
+
+// ssoServer sets if the account uses the server-wide configuration. This is
+// never done automatically, as it allows anyone who can sign in to the identity
+// provider to create a user in the account (if DefaultAccess is set), and
+// prevents non-admins from signing in with a password.
+func (h settings) ssoServer(w http.ResponseWriter, r *http.Request) error {
+	var args struct {
+		ServerSSO bool `json:"server_sso"`
+	}
+	_, err := zhttp.Decode(r, &args)
+	if err != nil {
+		return err
+	}
+
+	account := Account(r.Context())
+	before := account.Settings.ServerSSO
+	account.Settings.ServerSSO = args.ServerSSO
+	err = zdb.TX(r.Context(), func(ctx context.Context) error {
+		err := account.Update(ctx)
+		if err != nil {
+			return err
+		}
+		return goatcounter.Audit(ctx, goatcounter.AuditSSOUpdate,
+			map[string]bool{"server_sso": before}, map[string]bool{"server_sso": args.ServerSSO})
+	})
+	if err != nil {
+		account.Settings.ServerSSO = before
+		return err
+	}
+
+	zhttp.Flash(w, r, T(r.Context(), "notify/saved|Saved!"))
+	return zhttp.SeeOther(w, "/settings/sso")
+}
+
+func (h settings) auditLog(w http.ResponseWriter, r *http.Request) error {
+	var (
+		ctx = r.Context()
+		q   = r.URL.Query()
+		v   = goatcounter.NewValidate(ctx)
+		f   = goatcounter.AuditFilter{
+			Action: q.Get("action"),
+			UserID: goatcounter.UserID(v.Integer32("user", q.Get("user"))),
+			SiteID: goatcounter.SiteID(v.Integer32("site", q.Get("site"))),
+			Start:  v.Date("start", q.Get("start"), "2006-01-02"),
+			End:    v.Date("end", q.Get("end"), "2006-01-02"),
+			Before: goatcounter.AuditEntryID(v.Integer("before", q.Get("before"))),
+		}
+	)
+	if f.Action != "" {
+		v.Include("action", f.Action, goatcounter.AuditActions)
+	}
+	if v.HasErrors() {
+		return v
+	}
+	if !f.End.IsZero() { // Include the entire end day.
+		f.End = f.End.AddDate(0, 0, 1)
+	}
+
+	var entries goatcounter.AuditEntries
+	more, err := entries.List(ctx, f, 100)
+	if err != nil {
+		return err
+	}
+
+	var users goatcounter.Users
+	err = users.List(ctx, Account(ctx).ID)
+	if err != nil {
+		return err
+	}
+	var sites goatcounter.Sites
+	err = sites.ForThisAccount(ctx, false)
+	if err != nil {
+		return err
+	}
+
+	var moreURL string
+	if more {
+		q.Set("before", fmt.Sprint(entries[len(entries)-1].ID))
+		moreURL = "/settings/audit-log?" + q.Encode()
+	}
+
+	return zhttp.Template(w, "settings_audit_log.gohtml", struct {
+		Globals
+		Entries goatcounter.AuditEntries
+		MoreURL string
+		Actions []string
+		Users   goatcounter.Users
+		Sites   goatcounter.Sites
+		Filter  goatcounter.AuditFilter
+		Query   url.Values
+	}{newGlobals(w, r), entries, moreURL, goatcounter.AuditActions, users, sites, f, q})
+}
+
 func (h settings) bosmang(w http.ResponseWriter, r *http.Request) error {
 	info, _ := zdb.Info(r.Context())
 	return zhttp.Template(w, "settings_server.gohtml", struct {
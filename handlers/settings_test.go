@@ -51,6 +51,244 @@ func TestSettingsTpl(t *testing.T) {
 			wantCode: 200,
 			wantBody: "Are you sure you want to remove the site",
 		},
+
+		{
+			router:   newBackend,
+			path:     "/settings/sso",
+			auth:     true,
+			wantCode: 200,
+			wantBody: "/user/oidc/callback",
+		},
+
+		{
+			setup: func(ctx context.Context, t *testing.T) {
+				err := goatcounter.Audit(ctx, goatcounter.AuditSiteUpdate, nil, map[string]any{"code": "new"})
+				if err != nil {
+					t.Fatal(err)
+				}
+			},
+			router:   newBackend,
+			path:     "/settings/audit-log?action=site.update",
+			auth:     true,
+			wantCode: 200,
+			wantBody: "&#34;code&#34;: &#34;new&#34;",
+		},
+
+		{
+			setup: func(ctx context.Context, t *testing.T) {
+				tok := goatcounter.APIToken{Name: "x", Permissions: goatcounter.APIPermStats}
+				err := tok.Insert(ctx)
+				if err != nil {
+					t.Fatal(err)
+				}
+				tok.Use(ctx, "GET", "/api/v0/paths", "192.0.2.1")
+				err = goatcounter.PersistAPITokenUse(ctx)
+				if err != nil {
+					t.Fatal(err)
+				}
+			},
+			router:   newBackend,
+			path:     "/user/api-token/1/log",
+			auth:     true,
+			wantCode: 200,
+			wantBody: "GET /api/v0/paths",
+		},
+
+		{
+			setup: func(ctx context.Context, t *testing.T) {
+				l := goatcounter.ShareLink{Name: "x", Widgets: goatcounter.Strings{"pages"}, Period: "week"}
+				err := l.Insert(ctx)
+				if err != nil {
+					t.Fatal(err)
+				}
+			},
+			router:   newBackend,
+			path:     "/settings/share",
+			auth:     true,
+			wantCode: 200,
+			wantBody: "/share/",
+		},
+	}
+
+	for _, tt := range tests {
+		runTest(t, tt, nil)
+	}
+}
+
+func TestSettingsSSO(t *testing.T) {
+	tests := []handlerTest{
+		{
+			name:   "add",
+			router: newBackend,
+			path:   "/settings/sso",
+			method: "POST",
+			auth:   true,
+			body: map[string]string{
+				"issuer":         "https://login.example.com",
+				"client_id":      "goatcounter",
+				"client_secret":  "secret",
+				"default_access": "r",
+				"groups":         "admins: admin\nurn:example:staff: settings",
+			},
+			wantFormCode: 303,
+		},
+		{
+			name:   "internal issuer",
+			router: newBackend,
+			path:   "/settings/sso",
+			method: "POST",
+			auth:   true,
+			body: map[string]string{
+				"issuer":        "http://127.0.0.1:8080",
+				"client_id":     "goatcounter",
+				"client_secret": "secret",
+			},
+			wantFormCode: 200,
+			wantFormBody: "must be a https:// URL",
+		},
+		{
+			name:   "use server",
+			router: newBackend,
+			path:   "/settings/sso/server",
+			method: "POST",
+			auth:   true,
+			body: map[string]string{
+				"server_sso": "on",
+			},
+			wantFormCode: 303,
+		},
+		{
+			name:   "validate",
+			router: newBackend,
+			path:   "/settings/sso",
+			method: "POST",
+			auth:   true,
+			body: map[string]string{
+				"issuer":         "https://login.example.com",
+				"client_id":      "goatcounter",
+				"default_access": "*",
+			},
+			wantFormCode: 200,
+			wantFormBody: "must be set",
+		},
+		{
+			name:   "server",
+			router: newBackend,
+			path:   "/settings/sso",
+			method: "POST",
+			auth:   true,
+			body: map[string]string{
+				"server":        "1",
+				"issuer":        "https://login.example.com",
+				"client_id":     "goatcounter",
+				"client_secret": "secret",
+			},
+			wantFormCode: 403,
+		},
+	}
+
+	for _, tt := range tests {
+		runTest(t, tt, func(t *testing.T, rr *httptest.ResponseRecorder, r *http.Request) {
+			if tt.name != "add" {
+				return
+			}
+			var o goatcounter.OIDC
+			err := o.BySite(r.Context(), 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := goatcounter.OIDCGroups{"admins": goatcounter.AccessAdmin, "urn:example:staff": goatcounter.AccessSettings}
+			if o.ClientSecret != "secret" || o.GroupsClaim != "groups" || len(o.Groups) != 2 ||
+				o.Groups["admins"] != want["admins"] || o.Groups["urn:example:staff"] != want["urn:example:staff"] {
+				t.Errorf("%#v", o)
+			}
+		})
+	}
+}
+
+func TestSettingsUserPrefRecipients(t *testing.T) {
+	tests := []handlerTest{
+		{
+			name:   "save",
+			router: newBackend,
+			path:   "/user/pref",
+			method: "POST",
+			auth:   true,
+			body: map[string]string{
+				"user.email":        "test@gctest.localhost",
+				"report_recipients": "test@gctest.localhost, other@example.com",
+			},
+			wantFormCode: 303,
+		},
+		{
+			name:   "invalid",
+			router: newBackend,
+			path:   "/user/pref",
+			method: "POST",
+			auth:   true,
+			body: map[string]string{
+				"user.email":        "test@gctest.localhost",
+				"report_recipients": "test@gctest.localhost, other",
+			},
+			wantFormCode: 200,
+			wantFormBody: "must be a valid email address",
+		},
+	}
+
+	for _, tt := range tests {
+		runTest(t, tt, func(t *testing.T, rr *httptest.ResponseRecorder, r *http.Request) {
+			if rr.Code != 303 {
+				return
+			}
+			have := zdb.DumpString(r.Context(), `select email, confirmed_at from report_recipients order by email`)
+			want := `
+				email                  confirmed_at
+				other@example.com      NULL
+				test@gctest.localhost  NULL`
+			if d := zdb.Diff(have, want); d != "" {
+				t.Error(d)
+			}
+		})
+	}
+
+	t.Run("confirm", func(t *testing.T) {
+		ctx := gctest.DB(t)
+
+		var rcpt goatcounter.ReportRecipients
+		added, err := rcpt.Update(ctx, *User(ctx), []string{"other@example.com"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r, rr := newTest(ctx, "GET", "/report/confirm/"+added[0].Token, nil)
+		newBackend(ctx).ServeHTTP(rr, r)
+		ztest.Code(t, rr, 303)
+
+		err = rcpt.List(ctx, User(ctx).ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if have := rcpt.Confirmed(); len(have) != 1 || have[0] != "other@example.com" {
+			t.Errorf("confirmed: %v", have)
+		}
+
+		r, rr = newTest(ctx, "GET", "/report/confirm/nope", nil)
+		newBackend(ctx).ServeHTTP(rr, r)
+		ztest.Code(t, rr, 400)
+	})
+}
+
+func TestSettingsUsersRemove(t *testing.T) {
+	tests := []handlerTest{
+		{
+			name:         "last admin",
+			router:       newBackend,
+			path:         "/settings/users/remove/1",
+			method:       "POST",
+			auth:         true,
+			wantFormCode: 400,
+			wantFormBody: "delete the last admin user",
+		},
 	}
 
 	for _, tt := range tests {
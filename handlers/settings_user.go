@@ -5,10 +5,14 @@ import (
 	"fmt"
 	"net/http"
 	"sort"
+	"strings"
 
 	"github.com/go-chi/chi/v5"
 	"zgo.at/errors"
 	"zgo.at/goatcounter/v2"
+	"zgo.at/goatcounter/v2/cron"
+	"zgo.at/goatcounter/v2/pkg/bgrun"
+	"zgo.at/goatcounter/v2/pkg/log"
 	"zgo.at/goatcounter/v2/widgets"
 	"zgo.at/guru"
 	"zgo.at/tz"
@@ -20,13 +24,38 @@ import (
 
 func (h settings) userPref(verr *zvalidate.Validator) zhttp.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) error {
+		reportWidgets := make([][2]string, 0, len(goatcounter.EmailReportWidgets))
+		for _, w := range goatcounter.EmailReportWidgets {
+			reportWidgets = append(reportWidgets, [2]string{w, widgets.NewWidget(r.Context(), w, 0).Label(r.Context())})
+		}
+
+		user := goatcounter.MustGetUser(r.Context())
+		reportOpts := user.Settings.EmailReportOptions
+		reportOpts.Defaults()
+
+		var rcpt goatcounter.ReportRecipients
+		err := rcpt.List(r.Context(), user.ID)
+		if err != nil {
+			return err
+		}
+		var pending []string
+		for _, e := range reportOpts.Recipients {
+			if rr := rcpt.Get(e); rr == nil || !rr.Confirmed() {
+				pending = append(pending, e)
+			}
+		}
+
 		return zhttp.Template(w, "user_pref.gohtml", struct {
 			Globals
 			Validate           *zvalidate.Validator
 			Timezones          []*tz.Zone
 			FewerNumbersLocked bool
+			ReportWidgets      [][2]string
+			ReportOptions      goatcounter.EmailReportOptions
+			PendingRecipients  []string
 		}{newGlobals(w, r), verr, tz.Zones,
-			goatcounter.MustGetUser(r.Context()).Settings.FewerNumbersLockUntil.After(ztime.Now(r.Context()))})
+			user.Settings.FewerNumbersLockUntil.After(ztime.Now(r.Context())),
+			reportWidgets, reportOpts, pending})
 	}
 }
 
@@ -36,7 +65,9 @@ func (h settings) userPrefSave(w http.ResponseWriter, r *http.Request) error {
 		SetSite          bool             `json:"set_site"`
 		FewerNumbersLock string           `json:"fewer_numbers_lock"`
 		Theme            string           `json:"theme"`
-	}{*User(r.Context()), false, "", ""}
+		ReportWidgets    []string         `json:"report_widgets"`
+		ReportRecipients string           `json:"report_recipients"`
+	}{*User(r.Context()), false, "", "", nil, ""}
 	var (
 		oldEmail     = args.User.Email
 		oldReports   = args.User.Settings.EmailReports
@@ -48,6 +79,18 @@ func (h settings) userPrefSave(w http.ResponseWriter, r *http.Request) error {
 	}
 
 	args.User.Settings.Theme = args.Theme
+	args.User.Settings.EmailReportOptions.Widgets = append(goatcounter.Strings{}, args.ReportWidgets...)
+
+	// Only admins can send reports to other people.
+	args.User.Settings.EmailReportOptions.Recipients = nil
+	if args.User.AccessAdmin() {
+		err := args.User.Settings.EmailReportOptions.Recipients.Scan(strings.ReplaceAll(args.ReportRecipients, "\n", " "))
+		if err != nil {
+			v := goatcounter.NewValidate(r.Context())
+			v.Append("settings.email_report_options.recipients", err.Error())
+			return h.userPref(&v)(w, r)
+		}
+	}
 
 	if oldFewerNums && !args.User.Settings.FewerNumbers && args.User.Settings.FewerNumbersLockUntil.After(ztime.Now(r.Context())) {
 		zhttp.FlashError(w, r, "Nice try")
@@ -70,12 +113,18 @@ func (h settings) userPrefSave(w http.ResponseWriter, r *http.Request) error {
 		args.User.LastReportAt = ztime.Now(r.Context())
 	}
 
+	var newRcpt goatcounter.ReportRecipients
 	err = zdb.TX(r.Context(), func(ctx context.Context) error {
 		err = args.User.Update(ctx, emailChanged)
 		if err != nil {
 			return err
 		}
-		if args.User.AccessSettings() && args.SetSite {
+		var rcpt goatcounter.ReportRecipients
+		newRcpt, err = rcpt.Update(ctx, args.User, args.User.Settings.EmailReportOptions.Recipients)
+		if err != nil {
+			return err
+		}
+		if args.User.HasAccess(Site(ctx).ID, goatcounter.AccessSettings) && args.SetSite {
 			s := Site(ctx)
 			s.UserDefaults = args.User.Settings
 			return s.Update(ctx)
@@ -93,11 +142,38 @@ func (h settings) userPrefSave(w http.ResponseWriter, r *http.Request) error {
 	if emailChanged {
 		sendEmailVerify(r.Context(), Site(r.Context()), &args.User, goatcounter.Config(r.Context()).EmailFrom)
 	}
+	for _, rr := range newRcpt {
+		sendReportRecipientConfirm(r.Context(), Site(r.Context()), args.User, rr, goatcounter.Config(r.Context()).EmailFrom)
+	}
 
 	zhttp.Flash(w, r, T(r.Context(), "notify/saved|Saved!"))
 	return zhttp.SeeOther(w, "/user/pref")
 }
 
+func (h settings) userPrefReportPreview(w http.ResponseWriter, r *http.Request) error {
+	user := *User(r.Context())
+	report, err := cron.RenderReport(r.Context(), *Account(r.Context()), user,
+		user.PreviousEmailReportRange(r.Context()).UTC())
+	if err != nil {
+		return err
+	}
+	if report == nil {
+		zhttp.FlashError(w, r, T(r.Context(), "notify/report-preview-empty|There were no visitors in the previous period, so there is nothing to report."))
+		return zhttp.SeeOther(w, "/user/pref#section-email-reports")
+	}
+
+	ctx := context.WithoutCancel(r.Context())
+	bgrun.RunFunction("email:report-preview", func() {
+		err := cron.SendReport(ctx, user, report, false)
+		if err != nil {
+			log.Error(ctx, err)
+		}
+	})
+
+	zhttp.Flash(w, r, T(r.Context(), "notify/report-preview-sent|Sent a preview of the report to %(email).", user.Email))
+	return zhttp.SeeOther(w, "/user/pref#section-email-reports")
+}
+
 func (h settings) userDashboardWidget(w http.ResponseWriter, r *http.Request) error {
 	return zhttp.Template(w, "_user_dashboard_widgets.gohtml", struct {
 		Globals
@@ -237,7 +313,7 @@ func (h settings) userDashboardSave(w http.ResponseWriter, r *http.Request) erro
 		if err != nil {
 			return err
 		}
-		if user.AccessSettings() && args.SetSite {
+		if user.HasAccess(Site(ctx).ID, goatcounter.AccessSettings) && args.SetSite {
 			s := Site(ctx)
 			s.UserDefaults = user.Settings
 			return s.Update(ctx)
@@ -256,15 +332,38 @@ func (h settings) userDashboardSave(w http.ResponseWriter, r *http.Request) erro
 	return zhttp.SeeOther(w, "/user/dashboard")
 }
 
-func (h settings) userAuth(verr *zvalidate.Validator) zhttp.HandlerFunc {
+func (h settings) userAuth(verr *zvalidate.Validator, newCodes []string) zhttp.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) error {
+		u := User(r.Context())
+
+		var creds goatcounter.WebAuthnCredentials
+		err := creds.List(r.Context(), u.ID)
+		if err != nil {
+			return err
+		}
+		codes, err := u.RecoveryCodes(r.Context())
+		if err != nil {
+			return err
+		}
+
 		return zhttp.Template(w, "user_auth.gohtml", struct {
 			Globals
-			Validate *zvalidate.Validator
-		}{newGlobals(w, r), verr})
+			Validate      *zvalidate.Validator
+			Credentials   goatcounter.WebAuthnCredentials
+			RecoveryCodes int
+			NewCodes      []string
+		}{newGlobals(w, r), verr, creds, codes, newCodes})
 	}
 }
 
+func (h settings) recoveryCodes(w http.ResponseWriter, r *http.Request) error {
+	codes, err := User(r.Context()).GenerateRecoveryCodes(r.Context())
+	if err != nil {
+		return err
+	}
+	return h.userAuth(nil, codes)(w, r)
+}
+
 func (h settings) userAPI(verr *zvalidate.Validator, newToken goatcounter.APIToken) zhttp.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) error {
 		var tokens goatcounter.APITokens
@@ -301,8 +400,22 @@ func (h settings) newAPIToken(w http.ResponseWriter, r *http.Request) error {
 	if err != nil {
 		return err
 	}
+	if e := r.Form.Get("expires_at"); e != "" {
+		v := goatcounter.NewValidate(r.Context())
+		exp := v.Date("expires_at", e, "2006-01-02")
+		if v.HasErrors() {
+			return h.userAPI(&v, token)(w, r)
+		}
+		token.ExpiresAt = new(ztime.EndOf(exp, ztime.Day))
+	}
 
-	err = token.Insert(r.Context())
+	err = zdb.TX(r.Context(), func(ctx context.Context) error {
+		err := token.Insert(ctx)
+		if err != nil {
+			return err
+		}
+		return goatcounter.Audit(ctx, goatcounter.AuditAPITokenCreate, nil, token)
+	})
 	if err != nil {
 		var vErr *zvalidate.Validator
 		if errors.As(err, &vErr) {
@@ -315,6 +428,32 @@ func (h settings) newAPIToken(w http.ResponseWriter, r *http.Request) error {
 	return zhttp.SeeOther(w, "/user/api")
 }
 
+func (h settings) apiTokenLog(w http.ResponseWriter, r *http.Request) error {
+	v := goatcounter.NewValidate(r.Context())
+	id := goatcounter.APITokenID(v.Integer32("id", chi.URLParam(r, "id")))
+	if v.HasErrors() {
+		return v
+	}
+
+	var token goatcounter.APIToken
+	err := token.ByID(r.Context(), id)
+	if err != nil {
+		return err
+	}
+
+	var log goatcounter.APITokenLogs
+	err = log.List(r.Context(), token.ID, 500)
+	if err != nil {
+		return err
+	}
+
+	return zhttp.Template(w, "user_api_log.gohtml", struct {
+		Globals
+		Token goatcounter.APIToken
+		Log   goatcounter.APITokenLogs
+	}{newGlobals(w, r), token, log})
+}
+
 func (h settings) deleteAPIToken(w http.ResponseWriter, r *http.Request) error {
 	v := goatcounter.NewValidate(r.Context())
 	id := goatcounter.APITokenID(v.Integer32("id", chi.URLParam(r, "id")))
@@ -328,7 +467,13 @@ func (h settings) deleteAPIToken(w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 
-	err = token.Delete(r.Context())
+	err = zdb.TX(r.Context(), func(ctx context.Context) error {
+		err := token.Delete(ctx)
+		if err != nil {
+			return err
+		}
+		return goatcounter.Audit(ctx, goatcounter.AuditAPITokenDelete, token, nil)
+	})
 	if err != nil {
 		return err
 	}
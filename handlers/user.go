@@ -1,14 +1,17 @@
 package handlers
 
 import (
+	"cmp"
 	"context"
 	"crypto/sha1"
+	"crypto/subtle"
 	"errors"
 	"fmt"
 	"net/http"
 	"net/mail"
 	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
@@ -24,6 +27,7 @@ import (
 	"zgo.at/zdb"
 	"zgo.at/zhttp"
 	"zgo.at/zhttp/auth"
+	"zgo.at/zstd/zcrypto"
 	"zgo.at/zvalidate"
 )
 
@@ -51,8 +55,13 @@ func (h user) mount(r chi.Router, ratelimits Ratelimits) {
 		zhttp.SeeOther(w, "/user/new")
 	}))
 	rate.Post("/user/totplogin", zhttp.Wrap(h.totpLogin))
+	rate.Post("/user/webauthn/login-begin", zhttp.Wrap(h.webauthnLoginBegin))
+	rate.Post("/user/webauthn/login", zhttp.Wrap(h.webauthnLogin))
+	rate.Get("/user/oidc", zhttp.Wrap(h.oidcLogin))
+	rate.Get("/user/oidc/callback", zhttp.Wrap(h.oidcCallback))
 	rate.Get("/user/reset/{key}", zhttp.Wrap(h.reset))
 	rate.Get("/user/verify/{key}", zhttp.Wrap(h.verify))
+	rate.Get("/report/confirm/{key}", zhttp.Wrap(h.confirmReportRecipient))
 	rate.Post("/user/reset/{key}", zhttp.Wrap(h.doReset))
 
 	auth := r.With(loggedIn, addz18n())
@@ -60,6 +69,9 @@ func (h user) mount(r chi.Router, ratelimits Ratelimits) {
 	auth.Post("/user/change-password", zhttp.Wrap(h.changePassword))
 	auth.Post("/user/disable-totp", zhttp.Wrap(h.disableTOTP))
 	auth.Post("/user/enable-totp", zhttp.Wrap(h.enableTOTP))
+	auth.Post("/user/webauthn/register-begin", zhttp.Wrap(h.webauthnRegisterBegin))
+	auth.Post("/user/webauthn/register", zhttp.Wrap(h.webauthnRegister))
+	auth.Post("/user/webauthn/remove/{id}", zhttp.Wrap(h.webauthnRemove))
 	auth.Post("/user/resend-verify", zhttp.Wrap(h.resendVerify))
 }
 
@@ -69,10 +81,17 @@ func (h user) login(w http.ResponseWriter, r *http.Request) error {
 		return zhttp.SeeOther(w, "/")
 	}
 
+	var o goatcounter.OIDC
+	err := o.ForAccount(r.Context(), Account(r.Context()))
+	if err != nil && !zdb.ErrNoRows(err) {
+		return err
+	}
+
 	return zhttp.Template(w, "user.gohtml", struct {
 		Globals
 		Email string
-	}{newGlobals(w, r), r.URL.Query().Get("email")})
+		SSO   bool
+	}{newGlobals(w, r), r.URL.Query().Get("email"), o.ID > 0})
 }
 
 func (h user) forgot(w http.ResponseWriter, r *http.Request) error {
@@ -161,6 +180,15 @@ func (h user) requestLogin(w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 
+	sso, err := ssoRequired(r.Context(), &user)
+	if err != nil {
+		return err
+	}
+	if sso {
+		zhttp.FlashError(w, r, T(r.Context(), "error/login-use-sso|Use single sign-on to sign in"))
+		return zhttp.SeeOther(w, "/user/new")
+	}
+
 	if len(user.Password) == 0 {
 		zhttp.FlashError(w, r, T(r.Context(), "error/login-no-password|There is no password set for %(email); please reset it", args.Email))
 		return zhttp.SeeOther(w, "/user/forgot?email="+url.QueryEscape(args.Email))
@@ -182,8 +210,13 @@ func (h user) requestLogin(w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 
-	if user.TOTPEnabled {
-		return h.totpForm(w, r, *user.LoginToken,
+	var creds goatcounter.WebAuthnCredentials
+	err = creds.List(r.Context(), user.ID)
+	if err != nil {
+		return err
+	}
+	if user.TOTPEnabled || len(creds) > 0 {
+		return h.totpForm(w, r, &user,
 			xsrftoken.Generate(*user.LoginToken, strconv.Itoa(int(user.ID)), actionTOTP))
 	}
 
@@ -196,6 +229,7 @@ func (h user) totpLogin(w http.ResponseWriter, r *http.Request) error {
 		LoginMAC       string `json:"loginmac"`
 		UserLoginToken string `json:"user_logintoken"`
 		Token          string `json:"totp_token"`
+		RecoveryCode   string `json:"recovery_code"`
 	}{}
 	_, err := zhttp.Decode(r, &args)
 	if err != nil {
@@ -217,11 +251,24 @@ func (h user) totpLogin(w http.ResponseWriter, r *http.Request) error {
 		return zhttp.SeeOther(w, "/user/new")
 	}
 
-	if !testTOTP {
+	switch {
+	case args.RecoveryCode != "":
+		ok, err := u.UseRecoveryCode(r.Context(), args.RecoveryCode)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			zhttp.FlashError(w, r, T(r.Context(), "error/recovery-code-invalid|Invalid recovery code."))
+			return h.totpForm(w, r, &u, args.LoginMAC)
+		}
+	case !u.TOTPEnabled:
+		zhttp.Flash(w, r, T(r.Context(), "error/login-invalid|Invalid login"))
+		return zhttp.SeeOther(w, "/user/new")
+	case !testTOTP:
 		o := otp.New(u.TOTPSecret, 6, sha1.New, otp.TOTP(30*time.Second, time.Now))
 		if !o.Verify(args.Token, 1) {
 			zhttp.FlashError(w, r, mfaError)
-			return h.totpForm(w, r, *u.LoginToken, args.LoginMAC)
+			return h.totpForm(w, r, &u, args.LoginMAC)
 		}
 	}
 
@@ -229,12 +276,150 @@ func (h user) totpLogin(w http.ResponseWriter, r *http.Request) error {
 	return zhttp.SeeOther(w, "/")
 }
 
-func (h user) totpForm(w http.ResponseWriter, r *http.Request, loginToken, loginMAC string) error {
+const oidcCookie = "oidc"
+
+// Only admins can log in without single sign-on if it's set up, so they can
+// still log in if there's a problem with the identity provider.
+func ssoRequired(ctx context.Context, u *goatcounter.User) (bool, error) {
+	if u.AccessAdmin() {
+		return false, nil
+	}
+	var account goatcounter.Site
+	err := account.ByID(ctx, u.Site)
+	if err != nil {
+		return false, err
+	}
+	var o goatcounter.OIDC
+	err = o.ForAccount(ctx, &account)
+	if zdb.ErrNoRows(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func oidcRedirectURI(ctx context.Context) string {
+	return Site(ctx).URL(ctx) + goatcounter.Config(ctx).BasePath + "/user/oidc/callback"
+}
+
+// Errors from the identity provider may contain internal details (and aren't
+// very useful for users anyway), so log them and show a generic error.
+func oidcError(ctx context.Context, err error) error {
+	log.Module("oidc").Error(ctx, err)
+	return guru.New(http.StatusBadRequest, T(ctx,
+		"error/oidc-provider|Couldn’t sign in with the identity provider; try again later, or ask an administrator to check the single sign-on settings"))
+}
+
+// Send the user to the identity provider to log in; they will be redirected
+// back to oidcCallback.
+func (h user) oidcLogin(w http.ResponseWriter, r *http.Request) error {
+	var o goatcounter.OIDC
+	err := o.ForAccount(r.Context(), Account(r.Context()))
+	if err != nil {
+		if zdb.ErrNoRows(err) {
+			return guru.New(404, T(r.Context(), "error/oidc-not-setup|Single sign-on is not set up"))
+		}
+		return err
+	}
+	p, err := o.Provider(r.Context())
+	if err != nil {
+		return oidcError(r.Context(), err)
+	}
+
+	state, nonce, verifier := zcrypto.Secret256(), zcrypto.Secret256(), zcrypto.Secret256()
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcCookie,
+		Value:    state + "." + nonce + "." + verifier,
+		Path:     goatcounter.Config(r.Context()).BasePath + "/user/oidc",
+		MaxAge:   600,
+		HttpOnly: true,
+		Secure:   zhttp.IsSecure(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+	return zhttp.SeeOther(w, p.AuthURL(o.ClientID, oidcRedirectURI(r.Context()), state, nonce, verifier))
+}
+
+func (h user) oidcCallback(w http.ResponseWriter, r *http.Request) error {
+	c, err := r.Cookie(oidcCookie)
+	if err != nil {
+		zhttp.FlashError(w, r, T(r.Context(), "error/login-invalid|Invalid login"))
+		return zhttp.SeeOther(w, "/user/new")
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:   oidcCookie,
+		Path:   goatcounter.Config(r.Context()).BasePath + "/user/oidc",
+		MaxAge: -1,
+	})
+
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		log.Module("oidc").Infof(r.Context(), "error from identity provider: %s: %s", e, q.Get("error_description"))
+		zhttp.FlashError(w, r, T(r.Context(), "error/oidc-error|The identity provider returned an error: %(error)",
+			cmp.Or(q.Get("error_description"), e)))
+		return zhttp.SeeOther(w, "/user/new")
+	}
+
+	var state, nonce, verifier string
+	if p := strings.Split(c.Value, "."); len(p) == 3 {
+		state, nonce, verifier = p[0], p[1], p[2]
+	}
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(q.Get("state"))) != 1 {
+		zhttp.FlashError(w, r, T(r.Context(), "error/login-invalid|Invalid login"))
+		return zhttp.SeeOther(w, "/user/new")
+	}
+
+	account := Account(r.Context())
+	var o goatcounter.OIDC
+	err = o.ForAccount(r.Context(), account)
+	if err != nil {
+		if zdb.ErrNoRows(err) {
+			return guru.New(404, T(r.Context(), "error/oidc-not-setup|Single sign-on is not set up"))
+		}
+		return err
+	}
+	p, err := o.Provider(r.Context())
+	if err != nil {
+		return oidcError(r.Context(), err)
+	}
+	tok, err := p.Exchange(r.Context(), o.ClientID, o.ClientSecret, oidcRedirectURI(r.Context()), q.Get("code"), verifier)
+	if err != nil {
+		return oidcError(r.Context(), err)
+	}
+	claims, err := p.Verify(r.Context(), tok, o.ClientID, nonce)
+	if err != nil {
+		return guru.WithCode(http.StatusForbidden, err)
+	}
+
+	var user *goatcounter.User
+	err = zdb.TX(r.Context(), func(ctx context.Context) error {
+		var err error
+		user, err = o.LoginUser(ctx, account, claims)
+		if err != nil {
+			return err
+		}
+		return user.Login(ctx)
+	})
+	if err != nil {
+		return err
+	}
+
+	auth.SetCookie(w, r, *user.LoginToken, cookieDomain(Site(r.Context()), r))
+	return zhttp.SeeOther(w, "/")
+}
+
+func (h user) totpForm(w http.ResponseWriter, r *http.Request, u *goatcounter.User, loginMAC string) error {
+	var creds goatcounter.WebAuthnCredentials
+	err := creds.List(r.Context(), u.ID)
+	if err != nil {
+		return err
+	}
+
 	return zhttp.Template(w, "totp.gohtml", struct {
 		Globals
 		LoginToken string
 		LoginMAC   string
-	}{newGlobals(w, r), loginToken, loginMAC})
+		TOTP       bool
+		WebAuthn   bool
+	}{newGlobals(w, r), *u.LoginToken, loginMAC, bool(u.TOTPEnabled), len(creds) > 0})
 }
 
 func (h user) reset(w http.ResponseWriter, r *http.Request) error {
@@ -473,6 +658,37 @@ func (h user) verify(w http.ResponseWriter, r *http.Request) error {
 	return zhttp.SeeOther(w, "/")
 }
 
+func sendReportRecipientConfirm(ctx context.Context, site *goatcounter.Site, user goatcounter.User, rcpt goatcounter.ReportRecipient, emailFrom string) {
+	ctx = context.WithoutCancel(ctx)
+	bgrun.RunFunction("email:report-recipient", func() {
+		err := blackmail.Get(ctx).Send("Confirm GoatCounter reports",
+			mail.Address{Name: "GoatCounter", Address: emailFrom},
+			blackmail.To(rcpt.Email),
+			blackmail.BodyMustText(goatcounter.TplEmailReportRecipient{Context: ctx, Site: *site, User: user, Recipient: rcpt}.Render))
+		if err != nil {
+			log.Errorf(ctx, "blackmail: %s", err)
+		}
+	})
+}
+
+func (h user) confirmReportRecipient(w http.ResponseWriter, r *http.Request) error {
+	var rcpt goatcounter.ReportRecipient
+	err := rcpt.ByToken(r.Context(), chi.URLParam(r, "key"))
+	if err != nil {
+		if zdb.ErrNoRows(err) {
+			return guru.New(400, T(r.Context(), "error/unknown-report-recipient-token|Unknown confirmation token; perhaps the address was removed from the recipients?"))
+		}
+		return err
+	}
+
+	err = rcpt.Confirm(r.Context())
+	if err != nil {
+		return err
+	}
+	zhttp.Flash(w, r, T(r.Context(), "notify/report-recipient-confirmed|%(email) will now receive the GoatCounter reports", rcpt.Email))
+	return zhttp.SeeOther(w, "/")
+}
+
 // Make sure to use the correct cookie, since both "custom.example.com" and
 // "example.goatcounter.com" will work if you're using a custom domain.
 func cookieDomain(site *goatcounter.Site, r *http.Request) string {
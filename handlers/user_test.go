@@ -2,16 +2,21 @@ package handlers
 
 import (
 	"context"
+	"encoding/base64"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"regexp"
 	"strings"
 	"testing"
 
 	"zgo.at/goatcounter/v2"
 	"zgo.at/goatcounter/v2/gctest"
+	"zgo.at/goatcounter/v2/pkg/oidc/oidctest"
+	"zgo.at/goatcounter/v2/pkg/webauthn/webauthntest"
 	"zgo.at/zhttp"
+	"zgo.at/zstd/zjson"
 	"zgo.at/zstd/ztest"
 	"zgo.at/zstd/ztime"
 )
@@ -228,6 +233,299 @@ func TestUserLoginMFA(t *testing.T) {
 	}
 }
 
+func TestUserLoginOIDC(t *testing.T) {
+	ctx := gctest.DB(t)
+	srv := oidctest.New(t)
+	srv.Claims["groups"] = []string{"staff", "goatcounter-admins"}
+
+	// Use the server-wide configuration, as accounts can only use public https
+	// URLs.
+	o := goatcounter.OIDC{
+		SiteID:        goatcounter.OIDCServer,
+		Issuer:        srv.URL,
+		ClientID:      srv.ClientID,
+		ClientSecret:  srv.ClientSecret,
+		DefaultAccess: goatcounter.AccessReadOnly,
+		Groups:        goatcounter.OIDCGroups{"goatcounter-admins": goatcounter.AccessAdmin},
+	}
+	err := o.Insert(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	{ // Accounts need to opt in to the server-wide configuration.
+		r, rr := newTest(ctx, "GET", "/user/oidc", nil)
+		newBackend(ctx).ServeHTTP(rr, r)
+		ztest.Code(t, rr, 404)
+	}
+	site := Site(ctx)
+	site.Settings.ServerSSO = true
+	err = site.Update(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	{ // Users without admin access can't use their password.
+		u := goatcounter.User{Site: Site(ctx).ID, Email: "pw@example.com", Password: []byte("coconuts"),
+			Access: goatcounter.UserAccesses{"all": goatcounter.AccessSettings}}
+		err := u.Insert(ctx, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r, rr := newTest(ctx, "POST", "/user/requestlogin", nil)
+		body, ct, err := ztest.MultipartForm(map[string]string{"email": u.Email, "password": "coconuts"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Content-Type", ct)
+		r.Body = io.NopCloser(body)
+		newBackend(ctx).ServeHTTP(rr, r)
+		ztest.Code(t, rr, 303)
+		if l := rr.Header().Get("Location"); l != "/user/new" {
+			t.Error(l)
+		}
+	}
+
+	r, rr := newTest(ctx, "GET", "/user/oidc", nil)
+	newBackend(ctx).ServeHTTP(rr, r)
+	ztest.Code(t, rr, 303)
+	cookies := rr.Result().Cookies()
+
+	c := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := c.Get(rr.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	{ // Wrong state.
+		r, rr := newTest(ctx, "GET", callback.Path+"?code=x&state=wrong", nil)
+		for _, c := range cookies {
+			r.AddCookie(c)
+		}
+		newBackend(ctx).ServeHTTP(rr, r)
+		ztest.Code(t, rr, 303)
+		if c := rr.Header().Get("Set-Cookie"); strings.HasPrefix(c, "key=") {
+			t.Error(c)
+		}
+	}
+
+	r, rr = newTest(ctx, "GET", callback.RequestURI(), nil)
+	for _, c := range cookies {
+		r.AddCookie(c)
+	}
+	newBackend(ctx).ServeHTTP(rr, r)
+	ztest.Code(t, rr, 303)
+	if f := zhttp.ReadFlash(rr, r); f != nil {
+		t.Errorf("flash: %#v", f)
+	}
+	if l := rr.Header().Get("Location"); l != "/" {
+		t.Error(l)
+	}
+	if c := rr.Header().Get("Set-Cookie"); !strings.Contains(c, "key="+ztime.Now(ctx).Format("20060102")+"-") {
+		t.Error(c)
+	}
+
+	var u goatcounter.User
+	err = u.BySiteAndEmail(ctx, Site(ctx).ID, "oidc@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !u.EmailVerified || u.Access["all"] != goatcounter.AccessAdmin {
+		t.Errorf("%t %v", u.EmailVerified, u.Access)
+	}
+}
+
+func TestUserWebAuthn(t *testing.T) {
+	ctx := gctest.DB(t)
+	var (
+		rp    = webauthnRP(ctx)
+		authn = webauthntest.New(true)
+		b64   = base64.RawURLEncoding.EncodeToString
+	)
+
+	post := func(t *testing.T, path string, form url.Values, cookies []*http.Cookie, auth bool) *httptest.ResponseRecorder {
+		t.Helper()
+		r, rr := newTest(ctx, "POST", path, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if auth {
+			login(t, r)
+		}
+		for _, c := range cookies {
+			r.AddCookie(c)
+		}
+		newBackend(ctx).ServeHTTP(rr, r)
+		return rr
+	}
+	begin := func(t *testing.T, path string, form url.Values, auth bool) (string, []*http.Cookie) {
+		t.Helper()
+		rr := post(t, path, form, nil, auth)
+		ztest.Code(t, rr, 200)
+		var opts struct {
+			Challenge string `json:"challenge"`
+		}
+		zjson.MustUnmarshal(rr.Body.Bytes(), &opts)
+		return opts.Challenge, rr.Result().Cookies()
+	}
+
+	{ // Register.
+		challenge, cookies := begin(t, "/user/webauthn/register-begin", nil, true)
+		clientData, att := authn.Create(rp.ID, rp.Origin, challenge)
+		form := url.Values{
+			"name":               {"My key"},
+			"client_data":        {b64(clientData)},
+			"attestation_object": {b64(att)},
+		}
+		rr := post(t, "/user/webauthn/register", form, cookies, true)
+		ztest.Code(t, rr, 200)
+
+		// Replaying the same response with the same cookie fails.
+		rr = post(t, "/user/webauthn/register", form, cookies, true)
+		ztest.Code(t, rr, 403)
+
+		var creds goatcounter.WebAuthnCredentials
+		err := creds.List(ctx, User(ctx).ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(creds) != 1 || creds[0].Name != "My key" || creds[0].CredentialID != b64(authn.ID) {
+			t.Fatalf("%#v", creds)
+		}
+	}
+
+	{ // Can't reuse the challenge.
+		rr := post(t, "/user/webauthn/login", url.Values{
+			"id":                 {b64(authn.ID)},
+			"client_data":        {"AA"},
+			"authenticator_data": {"AA"},
+			"signature":          {"AA"},
+		}, nil, false)
+		ztest.Code(t, rr, 403)
+	}
+
+	// Passwordless login requires user verification.
+	for _, uv := range []bool{false, true} {
+		challenge, cookies := begin(t, "/user/webauthn/login-begin", nil, false)
+		clientData, authData, sig := authn.Get(rp.ID, rp.Origin, challenge, uv)
+		form := url.Values{
+			"id":                 {b64(authn.ID)},
+			"client_data":        {b64(clientData)},
+			"authenticator_data": {b64(authData)},
+			"signature":          {b64(sig)},
+		}
+		rr := post(t, "/user/webauthn/login", form, cookies, false)
+		if !uv {
+			ztest.Code(t, rr, 403)
+			continue
+		}
+		ztest.Code(t, rr, 200)
+		if c := rr.Header().Get("Set-Cookie"); !strings.Contains(c, "key=") {
+			t.Error(c)
+		}
+
+		rr = post(t, "/user/webauthn/login", form, cookies, false)
+		ztest.Code(t, rr, 403)
+		if !strings.Contains(rr.Body.String(), "expired") {
+			t.Error(rr.Body.String())
+		}
+	}
+
+	{ // Password login now asks for the second factor.
+		r, rr := newTest(ctx, "POST", "/user/requestlogin", nil)
+		body, ct, err := ztest.MultipartForm(map[string]string{
+			"email":    "test@gctest.localhost",
+			"password": "coconuts",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Content-Type", ct)
+		r.Body = io.NopCloser(body)
+		newBackend(ctx).ServeHTTP(rr, r)
+		ztest.Code(t, rr, 200)
+		if !strings.Contains(rr.Body.String(), `class="webauthn-login"`) {
+			t.Error(rr.Body.String())
+		}
+	}
+}
+
+func TestUserRecoveryCode(t *testing.T) {
+	ctx := gctest.DB(t)
+
+	user := User(ctx)
+	err := user.EnableTOTP(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	codes, err := user.GenerateRecoveryCodes(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, rr := newTest(ctx, "POST", "/user/requestlogin", nil)
+	body, ct, err := ztest.MultipartForm(map[string]string{
+		"email":    "test@gctest.localhost",
+		"password": "coconuts",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Content-Type", ct)
+	r.Body = io.NopCloser(body)
+	newBackend(ctx).ServeHTTP(rr, r)
+	ztest.Code(t, rr, 200)
+
+	var mac, logintoken string
+	{
+		m := regexp.MustCompile(`<input type="hidden" name="loginmac" value="([^"]+)">`).FindStringSubmatch(rr.Body.String())
+		if len(m) != 2 {
+			t.Fatal(rr.Body.String())
+		}
+		mac = m[1]
+	}
+	{
+		m := regexp.MustCompile(`<input type="hidden" name="user_logintoken" value="([^"]+)">`).FindStringSubmatch(rr.Body.String())
+		if len(m) != 2 {
+			t.Fatal(rr.Body.String())
+		}
+		logintoken = m[1]
+	}
+
+	login := func(code string) *httptest.ResponseRecorder {
+		r, rr := newTest(ctx, "POST", "/user/totplogin", nil)
+		body, ct, err := ztest.MultipartForm(map[string]string{
+			"loginmac":        mac,
+			"user_logintoken": logintoken,
+			"recovery_code":   code,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Content-Type", ct)
+		r.Body = io.NopCloser(body)
+		newBackend(ctx).ServeHTTP(rr, r)
+		return rr
+	}
+
+	rr = login("wrong-code")
+	ztest.Code(t, rr, 200)
+
+	rr = login(strings.ToUpper(codes[0]))
+	ztest.Code(t, rr, 303)
+	if c := rr.Header().Get("Set-Cookie"); !strings.HasPrefix(c, "key=") {
+		t.Error(c)
+	}
+
+	// Can only use once.
+	rr = login(codes[0])
+	ztest.Code(t, rr, 200)
+}
+
 func TestUserLogout(t *testing.T) {
 	tests := []handlerTest{
 		{
@@ -0,0 +1,346 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"golang.org/x/net/xsrftoken"
+	"zgo.at/goatcounter/v2"
+	"zgo.at/goatcounter/v2/pkg/webauthn"
+	"zgo.at/guru"
+	"zgo.at/zdb"
+	"zgo.at/zhttp"
+	"zgo.at/zhttp/auth"
+	"zgo.at/zstd/znet"
+	"zgo.at/zvalidate"
+)
+
+const webauthnCookie = "webauthn"
+
+// The relying party is the main domain rather than the site's subdomain, so
+// that keys work on all sites. Sites with a custom domain use that.
+func webauthnRP(ctx context.Context) webauthn.RelyingParty {
+	site := Site(ctx)
+	return webauthn.RelyingParty{
+		ID:     znet.RemovePort(site.Domain(ctx)),
+		Origin: strings.TrimSuffix(site.URL(ctx), goatcounter.Config(ctx).BasePath),
+	}
+}
+
+// Create a new challenge and send it in a cookie, so it can be checked after
+// the browser sends back the response. The challenge is also stored in the
+// database, as clearing the cookie doesn't prevent re-using it.
+func setWebauthnChallenge(w http.ResponseWriter, r *http.Request) (string, error) {
+	challenge, err := goatcounter.NewWebAuthnChallenge(r.Context())
+	if err != nil {
+		return "", err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     webauthnCookie,
+		Value:    challenge,
+		Path:     goatcounter.Config(r.Context()).BasePath + "/user/webauthn",
+		MaxAge:   int(goatcounter.WebAuthnChallengeExpiry.Seconds()),
+		HttpOnly: true,
+		Secure:   zhttp.IsSecure(r),
+		SameSite: http.SameSiteStrictMode,
+	})
+	return challenge, nil
+}
+
+// Get the challenge and clear the cookie; the challenge is removed from the
+// database so it can only be used once.
+func getWebauthnChallenge(w http.ResponseWriter, r *http.Request) (string, error) {
+	c, err := r.Cookie(webauthnCookie)
+	if err != nil {
+		return "", guru.New(403, T(r.Context(), "error/webauthn-challenge|The security key request expired; try again"))
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:   webauthnCookie,
+		Path:   goatcounter.Config(r.Context()).BasePath + "/user/webauthn",
+		MaxAge: -1,
+	})
+
+	ok, err := goatcounter.UseWebAuthnChallenge(r.Context(), c.Value)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", guru.New(403, T(r.Context(), "error/webauthn-challenge|The security key request expired; try again"))
+	}
+	return c.Value, nil
+}
+
+func webauthnDecode(v *zvalidate.Validator, k, s string) []byte {
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil || len(b) == 0 {
+		v.Append(k, "must be base64url")
+	}
+	return b
+}
+
+// Options for navigator.credentials.create().
+func (h user) webauthnRegisterBegin(w http.ResponseWriter, r *http.Request) error {
+	u := User(r.Context())
+
+	var creds goatcounter.WebAuthnCredentials
+	err := creds.List(r.Context(), u.ID)
+	if err != nil {
+		return err
+	}
+	exclude := make([]map[string]string, 0, len(creds))
+	for _, c := range creds {
+		exclude = append(exclude, map[string]string{"type": "public-key", "id": c.CredentialID})
+	}
+	params := make([]map[string]any, 0, len(webauthn.Algorithms))
+	for _, a := range webauthn.Algorithms {
+		params = append(params, map[string]any{"type": "public-key", "alg": a})
+	}
+
+	challenge, err := setWebauthnChallenge(w, r)
+	if err != nil {
+		return err
+	}
+	rp := webauthnRP(r.Context())
+	return zhttp.JSON(w, map[string]any{
+		"challenge": challenge,
+		"rp":        map[string]string{"id": rp.ID, "name": "GoatCounter"},
+		"user": map[string]string{
+			"id":          base64.RawURLEncoding.EncodeToString(binary.BigEndian.AppendUint32(nil, uint32(u.ID))),
+			"name":        u.Email,
+			"displayName": u.Email,
+		},
+		"pubKeyCredParams":   params,
+		"excludeCredentials": exclude,
+		"authenticatorSelection": map[string]string{
+			"residentKey":      "preferred",
+			"userVerification": "preferred",
+		},
+		"attestation": "none",
+	})
+}
+
+func (h user) webauthnRegister(w http.ResponseWriter, r *http.Request) error {
+	var args struct {
+		Name              string `json:"name"`
+		ClientData        string `json:"client_data"`
+		AttestationObject string `json:"attestation_object"`
+	}
+	_, err := zhttp.Decode(r, &args)
+	if err != nil {
+		return err
+	}
+
+	v := goatcounter.NewValidate(r.Context())
+	clientData := webauthnDecode(&v, "client_data", args.ClientData)
+	att := webauthnDecode(&v, "attestation_object", args.AttestationObject)
+	if v.HasErrors() {
+		return v
+	}
+
+	challenge, err := getWebauthnChallenge(w, r)
+	if err != nil {
+		return err
+	}
+	cred, err := webauthnRP(r.Context()).Register(challenge, clientData, att)
+	if err != nil {
+		return guru.WithCode(400, err)
+	}
+
+	c := goatcounter.WebAuthnCredential{
+		UserID:       User(r.Context()).ID,
+		Name:         args.Name,
+		CredentialID: base64.RawURLEncoding.EncodeToString(cred.ID),
+		PublicKey:    cred.PublicKey,
+		SignCount:    int64(cred.SignCount),
+	}
+	err = c.Insert(r.Context())
+	if err != nil {
+		if zdb.ErrUnique(err) {
+			return guru.New(400, T(r.Context(), "error/webauthn-exists|This security key is already registered"))
+		}
+		return err
+	}
+
+	zhttp.Flash(w, r, T(r.Context(), "notify/webauthn-added|Security key ‘%(name)’ added.", c.Name))
+	return zhttp.JSON(w, map[string]any{"id": c.ID})
+}
+
+func (h user) webauthnRemove(w http.ResponseWriter, r *http.Request) error {
+	v := goatcounter.NewValidate(r.Context())
+	id := goatcounter.WebAuthnCredentialID(v.Integer32("id", chi.URLParam(r, "id")))
+	if v.HasErrors() {
+		return v
+	}
+
+	var c goatcounter.WebAuthnCredential
+	err := c.ByID(r.Context(), id)
+	if err != nil {
+		return err
+	}
+	err = c.Delete(r.Context())
+	if err != nil {
+		return err
+	}
+
+	zhttp.Flash(w, r, T(r.Context(), "notify/webauthn-removed|Security key ‘%(name)’ removed.", c.Name))
+	return zhttp.SeeOther(w, "/user/auth")
+}
+
+// Get the user for the second factor from the form sent after the password
+// was verified, or nil if this is a passwordless login.
+func (h user) webauthnMFAUser(r *http.Request, loginMAC, loginToken string) (*goatcounter.User, error) {
+	if loginMAC == "" && loginToken == "" {
+		return nil, nil
+	}
+
+	var u goatcounter.User
+	err := u.ByTokenAndSite(r.Context(), loginToken)
+	if err != nil {
+		if zdb.ErrNoRows(err) {
+			return nil, guru.New(403, T(r.Context(), "error/login-invalid|Invalid login"))
+		}
+		return nil, err
+	}
+	if !xsrftoken.Valid(loginMAC, *u.LoginToken, strconv.Itoa(int(u.ID)), actionTOTP) {
+		return nil, guru.New(403, T(r.Context(), "error/login-invalid|Invalid login"))
+	}
+	return &u, nil
+}
+
+// Options for navigator.credentials.get().
+//
+// This is used both as a second factor, in which case the loginmac and
+// user_logintoken from the MFA form are sent, and for passwordless login.
+func (h user) webauthnLoginBegin(w http.ResponseWriter, r *http.Request) error {
+	var args struct {
+		LoginMAC       string `json:"loginmac"`
+		UserLoginToken string `json:"user_logintoken"`
+	}
+	_, err := zhttp.Decode(r, &args)
+	if err != nil {
+		return err
+	}
+	u, err := h.webauthnMFAUser(r, args.LoginMAC, args.UserLoginToken)
+	if err != nil {
+		return err
+	}
+
+	var (
+		allow = make([]map[string]string, 0, 4)
+		uv    = "required"
+	)
+	if u != nil {
+		var creds goatcounter.WebAuthnCredentials
+		err := creds.List(r.Context(), u.ID)
+		if err != nil {
+			return err
+		}
+		for _, c := range creds {
+			allow = append(allow, map[string]string{"type": "public-key", "id": c.CredentialID})
+		}
+		uv = "discouraged"
+	}
+
+	challenge, err := setWebauthnChallenge(w, r)
+	if err != nil {
+		return err
+	}
+	return zhttp.JSON(w, map[string]any{
+		"challenge":        challenge,
+		"rpId":             webauthnRP(r.Context()).ID,
+		"allowCredentials": allow,
+		"userVerification": uv,
+	})
+}
+
+func (h user) webauthnLogin(w http.ResponseWriter, r *http.Request) error {
+	var args struct {
+		ID                string `json:"id"`
+		ClientData        string `json:"client_data"`
+		AuthenticatorData string `json:"authenticator_data"`
+		Signature         string `json:"signature"`
+		LoginMAC          string `json:"loginmac"`
+		UserLoginToken    string `json:"user_logintoken"`
+	}
+	_, err := zhttp.Decode(r, &args)
+	if err != nil {
+		return err
+	}
+
+	v := goatcounter.NewValidate(r.Context())
+	webauthnDecode(&v, "id", args.ID)
+	clientData := webauthnDecode(&v, "client_data", args.ClientData)
+	authData := webauthnDecode(&v, "authenticator_data", args.AuthenticatorData)
+	sig := webauthnDecode(&v, "signature", args.Signature)
+	if v.HasErrors() {
+		return v
+	}
+	challenge, err := getWebauthnChallenge(w, r)
+	if err != nil {
+		return err
+	}
+
+	mfaUser, err := h.webauthnMFAUser(r, args.LoginMAC, args.UserLoginToken)
+	if err != nil {
+		return err
+	}
+
+	var c goatcounter.WebAuthnCredential
+	err = c.ByCredentialID(r.Context(), strings.TrimRight(args.ID, "="))
+	if err != nil {
+		if zdb.ErrNoRows(err) {
+			return guru.New(403, T(r.Context(), "error/webauthn-unknown|Unknown security key"))
+		}
+		return err
+	}
+	if mfaUser != nil && mfaUser.ID != c.UserID {
+		return guru.New(403, T(r.Context(), "error/webauthn-unknown|Unknown security key"))
+	}
+
+	a, err := webauthnRP(r.Context()).Login(challenge, c.PublicKey, clientData, authData, sig)
+	if err != nil {
+		return guru.WithCode(403, err)
+	}
+	// Without a password the key must also verify the user (e.g. with a PIN
+	// or fingerprint), as it's the only factor.
+	if mfaUser == nil && !a.UserVerified {
+		return guru.New(403, T(r.Context(), "error/webauthn-no-uv|This security key didn't verify the user; use a password to sign in"))
+	}
+
+	var u goatcounter.User
+	err = u.ByID(r.Context(), c.UserID)
+	if err != nil {
+		return err
+	}
+	if mfaUser == nil {
+		sso, err := ssoRequired(r.Context(), &u)
+		if err != nil {
+			return err
+		}
+		if sso {
+			return guru.New(403, T(r.Context(), "error/login-use-sso|Use single sign-on to sign in"))
+		}
+	}
+
+	err = zdb.TX(r.Context(), func(ctx context.Context) error {
+		err := c.Use(ctx, a.SignCount)
+		if err != nil {
+			return err
+		}
+		if mfaUser != nil { // Already logged in after the password check.
+			return nil
+		}
+		return u.Login(ctx)
+	})
+	if err != nil {
+		return err
+	}
+
+	auth.SetCookie(w, r, *u.LoginToken, cookieDomain(Site(r.Context()), r))
+	return zhttp.JSON(w, map[string]string{"redirect": goatcounter.Config(r.Context()).BasePath + "/"})
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"html/template"
 	"image"
 	"image/color"
 	"image/png"
@@ -45,6 +46,7 @@ func (h vcounter) mount(r chi.Router) {
 	})
 
 	c.Get("/counter/*", zhttp.Wrap(h.counter))
+	c.Get("/embed/{widget}", zhttp.Wrap(h.embed))
 }
 
 var (
@@ -113,13 +115,8 @@ var (
 	vcounterUpdate = zcache.New[string, struct{}](zcache.NoExpiration, zcache.NoExpiration)
 )
 
-func (h vcounter) get(ctx context.Context, path, ext string, q url.Values, total bool) (bool, []byte, string, error) {
-	var (
-		site       = Site(ctx)
-		noBranding = q.Get("no_branding") != ""
-		style      = q.Get("style")
-	)
-
+// vcounterRange gets the date range from the start and end query parameters.
+func vcounterRange(ctx context.Context, q url.Values) (ztime.Range, error) {
 	var (
 		rng      ztime.Range
 		err      error
@@ -137,14 +134,93 @@ func (h vcounter) get(ctx context.Context, path, ext string, q url.Values, total
 			rng.Start, err = time.Parse("2006-01-02", startArg)
 		}
 		if err != nil {
-			return false, nil, "", guru.WithCode(400, err)
+			return rng, guru.WithCode(400, err)
 		}
 	}
 	if s := q.Get("end"); s != "" {
 		rng.End, err = time.Parse("2006-01-02", s)
 		if err != nil {
-			return false, nil, "", guru.WithCode(400, err)
+			return rng, guru.WithCode(400, err)
+		}
+	}
+	return rng, nil
+}
+
+// vcounterTheme gets the theme to use from the style parameter.
+//
+// If style is the name of a theme then that's used, otherwise the "default"
+// theme is used (if it exists) and style is added as extra CSS.
+func vcounterTheme(site *goatcounter.Site, style string) (goatcounter.CounterTheme, string, bool) {
+	if t, ok := site.Settings.CounterThemes.Get(style); ok {
+		return t, "", true
+	}
+	t, ok := site.Settings.CounterThemes.Get("default")
+	return t, style, ok
+}
+
+// vcounterThemeCSS gets the CSS to override the built-in styles.
+func vcounterThemeCSS(theme goatcounter.CounterTheme, svg bool) string {
+	var b strings.Builder
+	if svg {
+		if theme.Color != "" || theme.Background != "" {
+			b.WriteString("#gcvc-border {")
+			if theme.Color != "" {
+				b.WriteString(" stroke: " + theme.Color + ";")
+			}
+			if theme.Background != "" {
+				b.WriteString(" fill: " + theme.Background + ";")
+			}
+			b.WriteString(" }\n")
+		}
+		if theme.Color != "" || theme.Font != "" {
+			b.WriteString("#gcvc {")
+			if theme.Color != "" {
+				b.WriteString(" fill: " + theme.Color + ";")
+			}
+			if theme.Font != "" {
+				b.WriteString(" font-family: " + theme.CSSFont() + ";")
+			}
+			b.WriteString(" }\n")
 		}
+		return b.String()
+	}
+
+	if theme.Background != "" {
+		b.WriteString("body { background-color: " + theme.Background + "; }\n")
+	}
+	if theme.Color != "" || theme.Background != "" || theme.Font != "" {
+		b.WriteString("div {")
+		if theme.Color != "" {
+			b.WriteString(" color: " + theme.Color + "; border-color: " + theme.Color + ";")
+		}
+		if theme.Background != "" {
+			b.WriteString(" background-color: " + theme.Background + ";")
+		}
+		if theme.Font != "" {
+			b.WriteString(" font-family: " + theme.CSSFont() + ";")
+		}
+		b.WriteString(" }\n")
+	}
+	return b.String()
+}
+
+func (h vcounter) get(ctx context.Context, path, ext string, q url.Values, total bool) (bool, []byte, string, error) {
+	var (
+		site                   = Site(ctx)
+		noBranding             = q.Get("no_branding") != ""
+		theme, style, hasTheme = vcounterTheme(site, q.Get("style"))
+		label                  = "Views for this page:"
+	)
+	if total {
+		label = "Views for this site:"
+	}
+	if hasTheme && theme.Label != "" {
+		label = theme.Label
+	}
+
+	rng, err := vcounterRange(ctx, q)
+	if err != nil {
+		return false, nil, "", err
 	}
 
 	var hl goatcounter.HitList
@@ -162,29 +238,53 @@ func (h vcounter) get(ctx context.Context, path, ext string, q url.Values, total
 	}
 
 	count := tplfunc.Number(hl.Count, site.UserDefaults.NumberFormat)
+
+	if hasTheme && (ext == "html" || ext == "svg") {
+		tpl, err := theme.Template(ext == "svg")
+		if err != nil {
+			return false, nil, "", err
+		}
+		if tpl != nil {
+			buf := new(bytes.Buffer)
+			err := tpl.Execute(buf, goatcounter.CounterTemplateData{
+				Count: count,
+				Path:  path,
+				Label: label,
+				Total: total,
+			})
+			if err != nil {
+				return false, nil, "", err
+			}
+			ct := "text/html;charset=utf-8"
+			if ext == "svg" {
+				ct = "image/svg+xml"
+			}
+			return notfound, buf.Bytes(), ct, nil
+		}
+	}
+
 	switch ext {
 	default:
 		return false, nil, "", guru.Errorf(400, "unknown extension: %q", ext)
 	case "json":
 		return notfound, []byte(`{"count_unique":"` + count + `", "count":"` + count + `"}`), "application/json", nil
-	case "html":
-		s := html
-		if noBranding {
+	case "html", "svg":
+		s, ct := html, "text/html;charset=utf-8"
+		switch {
+		case ext == "html" && noBranding:
 			s = htmlNoBranding
+		case ext == "svg" && noBranding:
+			s, ct = svgNoBranding, "image/svg+xml"
+		case ext == "svg":
+			s, ct = svg, "image/svg+xml"
 		}
-		if total {
-			s = strings.Replace(s, "page", "site", 1)
-		}
-		return notfound, fmt.Appendf(nil, s, style, count), "text/html;charset=utf-8", nil
-	case "svg":
-		s := svg
-		if noBranding {
-			s = svgNoBranding
+		if hasTheme {
+			style = vcounterThemeCSS(theme, ext == "svg") + style
 		}
-		if total {
-			s = strings.Replace(s, "page", "site", 1)
-		}
-		return notfound, fmt.Appendf(nil, s, style, count), "image/svg+xml", nil
+
+		out := strings.Replace(fmt.Sprintf(s, style, count),
+			">Views for this page:<", ">"+template.HTMLEscapeString(label)+"<", 1)
+		return notfound, []byte(out), ct, nil
 	case "png":
 		src := pngImg
 		if total {
@@ -209,12 +309,21 @@ func (h vcounter) get(ctx context.Context, path, ext string, q url.Values, total
 			}
 		}
 
+		// The text in the PNG is part of the image, so only the colours can be
+		// changed.
+		textColor := fontColor
+		if hasTheme && (theme.Color != "" || theme.Background != "") {
+			fg, bg := theme.RGB()
+			recolor(img, fg, bg)
+			textColor = image.NewUniform(fg)
+		}
+
 		// Draw to temporary image first, so we know the size of the result.
 		// Then copy that in the destination.
 		tmp := image.NewRGBA(bounds)
 		drw := font.Drawer{
 			Dst:  tmp,
-			Src:  fontColor,
+			Src:  textColor,
 			Face: fontFace,
 			Dot:  fixed.P(0, 22),
 		}
@@ -244,6 +353,31 @@ func (h vcounter) get(ctx context.Context, path, ext string, q url.Values, total
 	}
 }
 
+// recolor the built-in PNG, which is purple text on a white background, to use
+// the fg and bg colours.
+func recolor(img *image.RGBA, fg, bg color.RGBA) {
+	mix := func(a, b uint8, t float64) uint8 { return uint8(float64(a) + (float64(b)-float64(a))*t + 0.5) }
+
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := img.RGBAAt(x, y)
+			if c.A == 0 {
+				continue
+			}
+			// How much of the text colour there is in this pixel; the green
+			// channel goes from 0xff (white) to 0x15 (purple).
+			t := min(1, max(0, float64(0xff-int(c.G))/float64(0xff-0x15)))
+			img.SetRGBA(x, y, color.RGBA{
+				R: mix(bg.R, fg.R, t),
+				G: mix(bg.G, fg.G, t),
+				B: mix(bg.B, fg.B, t),
+				A: c.A,
+			})
+		}
+	}
+}
+
 func (h vcounter) counter(w http.ResponseWriter, r *http.Request) error {
 	loadVCFilesOnce.Do(func() { loadVCFiles(h.files) })
 
@@ -269,12 +403,28 @@ func (h vcounter) counter(w http.ResponseWriter, r *http.Request) error {
 		}
 	}
 
-	// Don't need to take options in to account for cache. Some people are using
-	// "cache buster" URL params so can't use all of r.URL.
-	cachekey := strconv.FormatInt(int64(site.ID), 10) + "-" + path + "." + ext + "-" + q.Get("start") + "-" + q.Get("end")
+	// Don't need to take most options in to account for cache. Some people are
+	// using "cache buster" URL params so can't use all of r.URL.
+	cachekey := strconv.FormatInt(int64(site.ID), 10) + "-" + path + "." + ext + "-" +
+		q.Get("start") + "-" + q.Get("end") + "-" + q.Get("style")
 
+	if ext == "json" {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	}
+	return h.serveCached(w, r, cachekey, func() (bool, []byte, string, error) {
+		return h.get(r.Context(), path, ext, q, total)
+	})
+}
+
+// serveCached serves the response from vcounterCache, calling get() to fill
+// it if it's not in the cache yet.
+//
+// Expired entries are still served, and updated in the background.
+func (h vcounter) serveCached(w http.ResponseWriter, r *http.Request, cachekey string,
+	get func() (bool, []byte, string, error),
+) error {
 	getcount := func() (vcache, error) {
-		notfound, out, ct, err := h.get(r.Context(), path, ext, q, total)
+		notfound, out, ct, err := get()
 		if err != nil {
 			return vcache{}, err
 		}
@@ -286,9 +436,6 @@ func (h vcounter) counter(w http.ResponseWriter, r *http.Request) error {
 	}
 	send := func(c vcache) error {
 		w.Header().Set("Content-Type", c.ct)
-		if c.ct == "application/json" {
-			w.Header().Set("Access-Control-Allow-Origin", "*")
-		}
 		w.Header().Set("Age", strconv.Itoa(int(time.Since(c.created).Seconds())))
 		if c.notfound {
 			w.WriteHeader(404)
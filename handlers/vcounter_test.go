@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+
+	"zgo.at/goatcounter/v2"
+	"zgo.at/goatcounter/v2/gctest"
+	"zgo.at/zstd/ztest"
+)
+
+func TestCounterTheme(t *testing.T) {
+	ctx := gctest.DB(t)
+	vcounterCache.Reset()
+	t.Cleanup(vcounterCache.Reset)
+
+	gctest.StoreHits(ctx, t, false, goatcounter.Hit{FirstVisit: true, Path: "/a"})
+
+	site := Site(ctx)
+	site.Settings.AllowCounter = true
+	site.Settings.CounterThemes = goatcounter.CounterThemes{
+		{Name: "default", Color: "#00ff00"},
+		{Name: "dark", Color: "#ffffff", Background: "#222222", Label: "Visitors <3"},
+		{Name: "custom", HTML: `<b>{{.Count}} {{.Path}}</b>`},
+	}
+	err := site.Update(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path string
+		want []string
+	}{
+		{"/counter//a.html", []string{"div { color: #00ff00; border-color: #00ff00; }", "Views for this page:"}},
+		{"/counter//a.html?style=span{color:red}", []string{"color: #00ff00;", "span{color:red}"}},
+		{"/counter//a.html?style=dark", []string{"background-color: #222222;", ">Visitors &lt;3<"}},
+		{"/counter//a.svg?style=dark", []string{"#gcvc-border { stroke: #ffffff; fill: #222222; }", ">Visitors &lt;3<"}},
+		{"/counter/TOTAL.html", []string{"Views for this site:"}},
+		{"/counter//a.html?style=custom", []string{"<b>1 /a</b>"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			r, rr := newTest(ctx, "GET", tt.path, nil)
+			newBackend(ctx).ServeHTTP(rr, r)
+			ztest.Code(t, rr, 200)
+			for _, w := range tt.want {
+				if !strings.Contains(rr.Body.String(), w) {
+					t.Errorf("%q not in body:\n%s", w, rr.Body.String())
+				}
+			}
+		})
+	}
+}
@@ -101,6 +101,7 @@ func (h website) MountShared(r chi.Router) {
 	r.Get("/api.json", zhttp.Wrap(h.openAPI))
 	r.Get("/api.html", zhttp.Wrap(h.openAPI))
 	r.Get("/api2.html", zhttp.Wrap(h.openAPI))
+	r.Get("/api-v1.json", zhttp.Wrap(h.openAPIv1))
 	r.Post("/contact", zhttp.Wrap(h.contact))
 
 	r.Get("/contact", zhttp.Wrap(h.tpl))
@@ -229,6 +230,14 @@ func (h website) openAPI(w http.ResponseWriter, r *http.Request) error {
 	return zhttp.Bytes(w, d)
 }
 
+func (h website) openAPIv1(w http.ResponseWriter, r *http.Request) error {
+	url := "https://www.goatcounter.com"
+	if s := goatcounter.GetSite(r.Context()); s != nil {
+		url = s.URL(r.Context())
+	}
+	return zhttp.JSON(w, apiV1Spec(url+"/api/v1"))
+}
+
 func (h website) tpl(w http.ResponseWriter, r *http.Request) error {
 	t := strings.Trim(r.URL.Path, "/")
 	if t == "" || t == "." {
@@ -325,7 +334,7 @@ func (h website) doSignup(w http.ResponseWriter, r *http.Request) error {
 	lookup := (goatcounter.Location{}).LookupIP(r.Context(), r.RemoteAddr)
 	var cc string
 	if len(lookup) > 0 {
-		cc = strings.Split(lookup, "-")[0]
+		cc = strings.FieldsFunc(lookup, func(r rune) bool { return r == '-' || r == ':' })[0]
 	}
 	tz, err := tz.New(cc, args.Timezone)
 	if err != nil {
@@ -28,6 +28,8 @@ type Hit struct {
 	CampaignID *CampaignID  `db:"campaign" json:"-"`
 	Session    zint.Uint128 `db:"session" json:"-"`
 	Width      *int16       `db:"width" json:"width"`
+	Value      Money        `db:"value" json:"v,omitempty"`
+	Currency   string       `db:"currency" json:"cu,omitempty"`
 
 	Path      string     `db:"-" json:"p,omitempty"`
 	Title     string     `db:"-" json:"t,omitempty"`
@@ -37,11 +39,16 @@ type Hit struct {
 	Query     string     `db:"-" json:"q,omitempty"`
 	Bot       int        `db:"-" json:"b,omitempty"`
 	NoSession zbool.Bool `db:"-" json:"ns,omitempty"`
+	Props     Props      `db:"-" json:"pr,omitempty"`
+	PropIDs   []PropID   `db:"-" json:"-"`
 
 	RefScheme       string     `db:"ref_scheme" json:"-"`
 	UserAgentHeader string     `db:"-" json:"-"`
 	Location        string     `db:"location" json:"-"`
 	Language        *string    `db:"language" json:"-"`
+	ASN             ASN        `db:"asn" json:"-"`
+	Device          string     `db:"device" json:"-"`
+	Engine          string     `db:"engine" json:"-"`
 	FirstVisit      zbool.Bool `db:"first_visit" json:"-"`
 	CreatedAt       time.Time  `db:"created_at" json:"-"`
 
@@ -49,11 +56,18 @@ type Hit struct {
 	Random string   `db:"-" json:"rnd"` // Browser cache buster, as they don't always listen to Cache-Control
 
 	// Some values we need to pass from the HTTP handler to memstore
-	RemoteAddr    string `db:"-" json:"-"`
-	UserSessionID string `db:"-" json:"-"`
-
-	NoStore   bool `db:"-" json:"-"` // Don't store in hits (still store in stats).
-	noProcess bool `db:"-" json:"-"` // Don't process in memstore; for merging paths.
+	RemoteAddr    string      `db:"-" json:"-"`
+	UserSessionID string      `db:"-" json:"-"`
+	Hints         ClientHints `db:"-" json:"-"` // User-Agent Client Hints
+
+	// Referrer and campaign to attribute the value to; this is the first
+	// pageview in the session, as events usually don't have one.
+	RevenueRefID      RefID       `db:"-" json:"-"`
+	RevenueCampaignID *CampaignID `db:"-" json:"-"`
+
+	NoStore    bool `db:"-" json:"-"` // Don't store in hits (still store in stats).
+	noProcess  bool `db:"-" json:"-"` // Don't process in memstore; for merging paths.
+	noBotRules bool `db:"-" json:"-"` // Don't apply bot rules; for recovering bots.
 }
 
 func (Hit) Table() string { return "hits" }
@@ -258,6 +272,11 @@ func (h *Hit) Defaults(ctx context.Context, initial bool) error {
 		h.RefScheme = RefSchemeOther
 	}
 
+	h.Currency = strings.ToUpper(strings.TrimSpace(h.Currency))
+	if h.Value == 0 {
+		h.Currency = ""
+	}
+
 	if initial {
 		return nil
 	}
@@ -281,15 +300,23 @@ func (h *Hit) Defaults(ctx context.Context, initial bool) error {
 		}
 		h.RefID = ref.ID
 
+		// Get or insert props.
+		h.PropIDs, err = getOrInsertProps(ctx, h.Props)
+		if err != nil {
+			return errors.Wrap(err, "Hit.Defaults")
+		}
+
 		// Get or insert browser and system.
 		if site.Settings.Collect.Has(CollectUserAgent) {
-			ua := UserAgent{UserAgent: h.UserAgentHeader}
+			ua := UserAgent{UserAgent: h.UserAgentHeader, Hints: h.Hints}
 			err = ua.GetOrInsert(ctx)
 			if err != nil {
 				return errors.Wrap(err, "Hit.Defaults")
 			}
 			h.BrowserID = ua.BrowserID
 			h.SystemID = ua.SystemID
+			h.Device = ua.Device
+			h.Engine = ua.Engine
 		}
 	}
 
@@ -305,6 +332,7 @@ func (h *Hit) Validate(ctx context.Context, initial bool) error {
 	v.Required("created_at", h.CreatedAt)
 	v.UTF8("ref", h.Ref)
 	v.Len("ref", h.Ref, 0, 2048)
+	validateRevenue(&v, h.Value, h.Currency)
 
 	// Small margin as client's clocks may not be 100% accurate.
 	if h.CreatedAt.After(ztime.Now(ctx).Add(5 * time.Second)) {
@@ -319,6 +347,9 @@ func (h *Hit) Validate(ctx context.Context, initial bool) error {
 		v.Len("path", h.Path, 1, 2048)
 		v.Len("title", h.Title, 0, 1024)
 		v.Len("user_agent_header", h.UserAgentHeader, 0, 512)
+		v.UTF8("client_hints", h.Hints.String())
+		v.Len("client_hints", h.Hints.String(), 0, 1024)
+		h.Props.validate(&v)
 		for _, s := range h.Size {
 			if s > math.MaxInt32 {
 				v.Append("size", fmt.Sprintf("screen size %v is out of range of int32", s))
@@ -391,7 +422,7 @@ func (h *Hits) TestList(ctx context.Context, siteOnly bool) error {
 func (h *Hits) Purge(ctx context.Context, pathIDs []PathID) error {
 	return zdb.TX(ctx, func(ctx context.Context) error {
 		siteID := MustGetSite(ctx).ID
-		for _, t := range append(statTables, "hit_counts", "ref_counts", "hits", "paths") {
+		for _, t := range append(statTables, "search_queries", "anomalies", "hit_counts", "ref_counts", "hits", "paths") {
 			err := zdb.Exec(ctx, `/* Hits.Purge */
 				delete from :tbl where site_id=:site_id and path_id :in (:paths)`,
 				map[string]any{
@@ -245,7 +245,21 @@ func (h *HitLists) ListPathsLike(ctx context.Context, search string, matchTitle,
 func (h *HitLists) List(
 	ctx context.Context, rng ztime.Range, pathFilter PathFilter, exclude []PathID, limit int, group Group,
 ) (int, bool, error) {
+	return h.list(ctx, rng, pathFilter, exclude, 0, 0, limit, group)
+}
 
+// ListAfter is like List, but only lists paths that sort after the path with
+// the given count and ID, for keyset pagination.
+func (h *HitLists) ListAfter(
+	ctx context.Context, rng ztime.Range, pathFilter PathFilter, afterCount int, afterPath PathID, limit int, group Group,
+) (int, bool, error) {
+	return h.list(ctx, rng, pathFilter, nil, afterCount, afterPath, limit, group)
+}
+
+func (h *HitLists) list(
+	ctx context.Context, rng ztime.Range, pathFilter PathFilter, exclude []PathID,
+	afterCount int, afterPath PathID, limit int, group Group,
+) (int, bool, error) {
 	var (
 		site                    = MustGetSite(ctx)
 		user                    = MustGetUser(ctx)
@@ -260,6 +274,9 @@ func (h *HitLists) List(
 			"filter":  filterSQL,
 			"exclude": db2.Array(ctx, exclude),
 			"in":      db2.In(ctx),
+			"after":   afterPath > 0,
+			"a_count": afterCount,
+			"a_path":  afterPath,
 			"limit":   limit + 1,
 			"offset":  user.Settings.Timezone.Offset(),
 			"offset2": fmt.Sprintf("%d minutes", user.Settings.Timezone.Offset()),
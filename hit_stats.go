@@ -22,6 +22,10 @@ type tbl struct {
 	Constraint string
 	Update     string
 
+	// Number of columns at the end that are added together when merging paths;
+	// defaults to 1.
+	SumColumns int
+
 	onConflict string
 }
 
@@ -51,6 +55,9 @@ var Tables = struct {
 	HitCounts, RefCounts                        tbl
 	BrowserStats, SystemStats, SizeStats        tbl
 	LocationStats, LanguageStats, CampaignStats tbl
+	NetworkStats, DeviceStats, EngineStats      tbl
+	PropStats, RevenueStats                     tbl
+	SearchQueries                               tbl
 }{
 	HitCounts: tbl{
 		Table:      "hit_counts",
@@ -94,16 +101,57 @@ var Tables = struct {
 		Constraint: "site_id#path_id#day#language",
 		Update:     `count = language_stats.count + excluded.count`,
 	},
+	NetworkStats: tbl{
+		Table:      "network_stats",
+		Columns:    []string{"site_id", "path_id", "day", "asn", "count"},
+		Constraint: "site_id#path_id#day#asn",
+		Update:     `count = network_stats.count + excluded.count`,
+	},
+	DeviceStats: tbl{
+		Table:      "device_stats",
+		Columns:    []string{"site_id", "path_id", "day", "device", "count"},
+		Constraint: "site_id#path_id#day#device",
+		Update:     `count = device_stats.count + excluded.count`,
+	},
+	EngineStats: tbl{
+		Table:      "engine_stats",
+		Columns:    []string{"site_id", "path_id", "day", "engine", "count"},
+		Constraint: "site_id#path_id#day#engine",
+		Update:     `count = engine_stats.count + excluded.count`,
+	},
+	PropStats: tbl{
+		Table:      "prop_stats",
+		Columns:    []string{"site_id", "path_id", "day", "prop_id", "count"},
+		Constraint: "site_id#path_id#day#prop_id",
+		Update:     `count = prop_stats.count + excluded.count`,
+	},
+	RevenueStats: tbl{
+		Table:      "revenue_stats",
+		Columns:    []string{"site_id", "path_id", "day", "ref_id", "campaign_id", "currency", "count", "total"},
+		Constraint: "site_id#path_id#day#ref_id#campaign_id#currency",
+		Update: `count = revenue_stats.count + excluded.count,
+	total = revenue_stats.total + excluded.total`,
+		SumColumns: 2,
+	},
 	CampaignStats: tbl{
 		Table:      "campaign_stats",
 		Columns:    []string{"site_id", "path_id", "day", "campaign_id", "ref", "count"},
 		Constraint: "site_id#path_id#campaign_id#ref#day",
 		Update:     `count = campaign_stats.count + excluded.count`,
 	},
+	SearchQueries: tbl{
+		Table:      "search_queries",
+		Columns:    []string{"site_id", "path_id", "day", "source", "query", "clicks", "impressions", "position_sum"},
+		Constraint: "site_id#path_id#source#query#day",
+		Update: `clicks = search_queries.clicks + excluded.clicks,
+	impressions = search_queries.impressions + excluded.impressions,
+	position_sum = search_queries.position_sum + excluded.position_sum`,
+		SumColumns: 3,
+	},
 }
 
 type HitStat struct {
-	// ID for selecting more details; not present in the detail view.
+	// ID for selecting more details; not present in the detail view, except for the regions of a location.
 	ID    string `db:"id" json:"id,omitempty"`
 	Name  string `db:"name" json:"name"`   // Display name.
 	Count int    `db:"count" json:"count"` // Number of visitors.
@@ -391,11 +439,13 @@ func (h *HitStats) ListLocations(ctx context.Context, rng ztime.Range, pathFilte
 	return errors.Wrap(err, "HitStats.ListLocations")
 }
 
-// ListLocation lists all divisions for a location
-func (h *HitStats) ListLocation(ctx context.Context, country string, rng ztime.Range, pathFilter PathFilter, limit, offset int) error {
+// ListLocation lists all divisions for a location: the regions for a country
+// code (e.g. "US"), or the cities for a region code (e.g. "US-TX").
+func (h *HitStats) ListLocation(ctx context.Context, code string, rng ztime.Range, pathFilter PathFilter, limit, offset int) error {
 	var (
 		user                    = MustGetUser(ctx)
 		filterSQL, filterParams = pathFilter.SQL(ctx)
+		country, region, _      = strings.Cut(code, "-")
 	)
 	err := zdb.Select(ctx, &h.Stats, "load:hit_stats.ListLocation", filterParams, map[string]any{
 		"site":    MustGetSite(ctx).ID,
@@ -403,6 +453,7 @@ func (h *HitStats) ListLocation(ctx context.Context, country string, rng ztime.R
 		"end":     asUTCDate(user, rng.End),
 		"filter":  filterSQL,
 		"country": country,
+		"region":  region,
 		"limit":   limit + 1,
 		"offset":  offset,
 	})
@@ -434,6 +485,116 @@ func (h *HitStats) ListLanguages(ctx context.Context, rng ztime.Range, pathFilte
 	return errors.Wrap(err, "HitStats.ListLanguages")
 }
 
+// ListNetworks lists all network (ASN) statistics for the given time period.
+func (h *HitStats) ListNetworks(ctx context.Context, rng ztime.Range, pathFilter PathFilter, limit, offset int) error {
+	var (
+		user                    = MustGetUser(ctx)
+		filterSQL, filterParams = pathFilter.SQL(ctx)
+	)
+	err := zdb.Select(ctx, &h.Stats, "load:hit_stats.ListNetworks", filterParams, map[string]any{
+		"site":   MustGetSite(ctx).ID,
+		"start":  asUTCDate(user, rng.Start),
+		"end":    asUTCDate(user, rng.End),
+		"filter": filterSQL,
+		"limit":  limit + 1,
+		"offset": offset,
+	})
+	if len(h.Stats) > limit {
+		h.More = true
+		h.Stats = h.Stats[:len(h.Stats)-1]
+	}
+	return errors.Wrap(err, "HitStats.ListNetworks")
+}
+
+// ListDevices lists all device type statistics for the given time period.
+func (h *HitStats) ListDevices(ctx context.Context, rng ztime.Range, pathFilter PathFilter, limit, offset int) error {
+	var (
+		user                    = MustGetUser(ctx)
+		filterSQL, filterParams = pathFilter.SQL(ctx)
+	)
+	err := zdb.Select(ctx, &h.Stats, "load:hit_stats.ListDevices", filterParams, map[string]any{
+		"site":   MustGetSite(ctx).ID,
+		"start":  asUTCDate(user, rng.Start),
+		"end":    asUTCDate(user, rng.End),
+		"filter": filterSQL,
+		"limit":  limit + 1,
+		"offset": offset,
+	})
+	if len(h.Stats) > limit {
+		h.More = true
+		h.Stats = h.Stats[:len(h.Stats)-1]
+	}
+	for i := range h.Stats {
+		h.Stats[i].Name = DeviceName(ctx, h.Stats[i].ID)
+	}
+	return errors.Wrap(err, "HitStats.ListDevices")
+}
+
+// ListEngines lists all browser engine statistics for the given time period.
+func (h *HitStats) ListEngines(ctx context.Context, rng ztime.Range, pathFilter PathFilter, limit, offset int) error {
+	var (
+		user                    = MustGetUser(ctx)
+		filterSQL, filterParams = pathFilter.SQL(ctx)
+	)
+	err := zdb.Select(ctx, &h.Stats, "load:hit_stats.ListEngines", filterParams, map[string]any{
+		"site":   MustGetSite(ctx).ID,
+		"start":  asUTCDate(user, rng.Start),
+		"end":    asUTCDate(user, rng.End),
+		"filter": filterSQL,
+		"limit":  limit + 1,
+		"offset": offset,
+	})
+	if len(h.Stats) > limit {
+		h.More = true
+		h.Stats = h.Stats[:len(h.Stats)-1]
+	}
+	return errors.Wrap(err, "HitStats.ListEngines")
+}
+
+// ListProps lists all custom property keys for the given time period.
+func (h *HitStats) ListProps(ctx context.Context, rng ztime.Range, pathFilter PathFilter, limit, offset int) error {
+	var (
+		user                    = MustGetUser(ctx)
+		filterSQL, filterParams = pathFilter.SQL(ctx)
+	)
+	err := zdb.Select(ctx, &h.Stats, "load:hit_stats.ListProps", filterParams, map[string]any{
+		"site":   MustGetSite(ctx).ID,
+		"start":  asUTCDate(user, rng.Start),
+		"end":    asUTCDate(user, rng.End),
+		"filter": filterSQL,
+		"limit":  limit + 1,
+		"offset": offset,
+	})
+	if len(h.Stats) > limit {
+		h.More = true
+		h.Stats = h.Stats[:len(h.Stats)-1]
+	}
+	return errors.Wrap(err, "HitStats.ListProps")
+}
+
+// ListProp lists all values for a custom property key for the given time
+// period.
+func (h *HitStats) ListProp(ctx context.Context, key string, rng ztime.Range, pathFilter PathFilter, limit, offset int) error {
+	var (
+		user                    = MustGetUser(ctx)
+		filterSQL, filterParams = pathFilter.SQL(ctx)
+	)
+	err := zdb.Select(ctx, &h.Stats, "load:hit_stats.ListProp", filterParams, map[string]any{
+		"site":   MustGetSite(ctx).ID,
+		"start":  asUTCDate(user, rng.Start),
+		"end":    asUTCDate(user, rng.End),
+		"filter": filterSQL,
+		"key":    key,
+		"limit":  limit + 1,
+		"offset": offset,
+	})
+	if len(h.Stats) > limit {
+		h.More = true
+		h.Stats = h.Stats[:len(h.Stats)-1]
+	}
+	return errors.Wrap(err, "HitStats.ListProp")
+}
+
 // ListCampaigns lists all campaigns statistics for the given time period.
 func (h *HitStats) ListCampaigns(ctx context.Context, rng ztime.Range, pathFilter PathFilter, limit, offset int) error {
 	var (
@@ -191,6 +191,11 @@ func TestHitStats(t *testing.T) {
 			if err != nil {
 				t.Fatal(err)
 			}
+			var getCity HitStats
+			err = getCity.ListLocation(ctx, "ID-BA", rng, PathFilterFromIDs(filter), 10, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
 
 			cmp(t, `{
 				"more": false,
@@ -210,6 +215,7 @@ func TestHitStats(t *testing.T) {
 				"more": false,
 				"stats": [
 					{
+						"id": "ID-BA",
 						"name": "",
 						"count": 1
 					}
@@ -218,11 +224,21 @@ func TestHitStats(t *testing.T) {
 				"more": false,
 				"stats": [
 					{
+						"id": "ID-BA",
 						"name": "Bali",
 						"count": 1
 					}
 				]
-			}`, list, get, getRegion)
+			}{
+				"more": false,
+				"stats": [
+					{
+						"id": "ID-BA",
+						"name": "",
+						"count": 1
+					}
+				]
+			}`, list, get, getRegion, getCity)
 		}
 	}
 }
@@ -1,15 +1,21 @@
 package goatcounter
 
 import (
+	"bytes"
 	"context"
 	"encoding/csv"
+	"fmt"
 	"io"
 	"net/url"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"zgo.at/errors"
 	"zgo.at/guru"
+	"zgo.at/json"
+	"zgo.at/zdb"
 )
 
 func ImportGA(ctx context.Context, fp io.Reader) error {
@@ -99,3 +105,290 @@ func ImportGA(ctx context.Context, fp io.Reader) error {
 	}
 	return ins.Finish()
 }
+
+// ImportSearchQueries imports search queries from a Google Search Console or
+// Bing Webmaster Tools export.
+//
+// The format can be "csv" or "json". For CSV the first row must be a header
+// with at least date, query, page, clicks, and impressions columns (position
+// is optional). For JSON it can be either an array of objects with these keys,
+// or the response from the Search Console API with the date, query, and page
+// dimensions (in that order).
+//
+// Pages are matched to existing paths by the URL's path; rows for pages that
+// don't exist are skipped. Existing data for the source in the date range of
+// the file is replaced.
+//
+// It returns the number of rows that were imported and skipped.
+func ImportSearchQueries(ctx context.Context, fp io.Reader, source, format string) (int, int, error) {
+	v := NewValidate(ctx)
+	v.Include("source", source, []string{SearchSourceGoogle, SearchSourceBing})
+	v.Include("format", format, []string{"csv", "json"})
+	if v.HasErrors() {
+		return 0, 0, v
+	}
+
+	var (
+		rows []searchQueryRow
+		err  error
+	)
+	if format == "json" {
+		rows, err = readSearchQueriesJSON(fp)
+	} else {
+		rows, err = readSearchQueriesCSV(fp)
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(rows) == 0 {
+		return 0, 0, guru.New(400, "no rows in file")
+	}
+
+	siteID := MustGetSite(ctx).ID
+	var paths []Path
+	err = zdb.Select(ctx, &paths, `select path_id, path from paths where site_id=$1 and event=0`, siteID)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "ImportSearchQueries")
+	}
+	pathIDs := make(map[string]PathID, len(paths))
+	for _, p := range paths {
+		pathIDs[strings.ToLower(p.Path)] = p.ID
+	}
+	findPath := func(page string) (PathID, bool) {
+		p := page
+		if u, err := url.Parse(page); err == nil {
+			p = u.Path
+		}
+		p = strings.ToLower(p)
+		if p == "" {
+			p = "/"
+		}
+		for _, pp := range []string{p, strings.TrimRight(p, "/"), p + "/"} {
+			if id, ok := pathIDs[pp]; ok {
+				return id, true
+			}
+		}
+		return 0, false
+	}
+
+	// Group, as several URLs may point to the same path.
+	type gt struct {
+		pathID      PathID
+		day, query  string
+		clicks      int
+		impressions int
+		position    float64
+	}
+	var (
+		grouped  = make(map[string]gt)
+		skipped  int
+		from, to string
+	)
+	for _, r := range rows {
+		pathID, ok := findPath(r.page)
+		if !ok {
+			skipped++
+			continue
+		}
+
+		if from == "" || r.day < from {
+			from = r.day
+		}
+		if r.day > to {
+			to = r.day
+		}
+
+		k := strconv.Itoa(int(pathID)) + r.day + r.query
+		g := grouped[k]
+		g.pathID, g.day, g.query = pathID, r.day, r.query
+		g.clicks += r.clicks
+		g.impressions += r.impressions
+		g.position += r.position * float64(r.impressions)
+		grouped[k] = g
+	}
+	if len(grouped) == 0 {
+		return 0, skipped, nil
+	}
+
+	err = zdb.TX(ctx, func(ctx context.Context) error {
+		err := zdb.Exec(ctx, `delete from search_queries where site_id=$1 and source=$2 and day >= $3 and day <= $4`,
+			siteID, source, from, to)
+		if err != nil {
+			return err
+		}
+
+		ins, err := Tables.SearchQueries.Bulk(ctx)
+		if err != nil {
+			return err
+		}
+		for _, g := range grouped {
+			ins.Values(siteID, g.pathID, g.day, source, g.query, g.clicks, g.impressions, g.position)
+		}
+		return ins.Finish()
+	})
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "ImportSearchQueries")
+	}
+	return len(rows) - skipped, skipped, nil
+}
+
+type searchQueryRow struct {
+	day, query, page    string
+	clicks, impressions int
+	position            float64
+}
+
+func (r *searchQueryRow) validate() error {
+	r.query, r.page = strings.TrimSpace(r.query), strings.TrimSpace(r.page)
+	if r.query == "" {
+		return errors.New("query is empty")
+	}
+	if r.page == "" {
+		return errors.New("page is empty")
+	}
+	d, err := time.Parse("2006-01-02", strings.TrimSpace(r.day))
+	if err != nil {
+		return fmt.Errorf("invalid date: %w", err)
+	}
+	r.day = d.Format("2006-01-02")
+	return nil
+}
+
+func readSearchQueriesCSV(fp io.Reader) ([]searchQueryRow, error) {
+	headers := map[string][]string{
+		"date":        {"date", "day"},
+		"query":       {"query", "queries", "top queries", "keyword", "keywords"},
+		"page":        {"page", "pages", "top pages", "url", "landing page"},
+		"clicks":      {"clicks"},
+		"impressions": {"impressions"},
+		"position":    {"position", "avg. position", "avg position", "average position"},
+	}
+
+	var (
+		r    = csv.NewReader(fp)
+		cols = make(map[string]int)
+		rows []searchQueryRow
+	)
+	r.FieldsPerRecord = -1
+	for i := 1; ; i++ {
+		row, err := r.Read()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, guru.Errorf(400, "line %d: %w", i, err)
+		}
+
+		if i == 1 { // Header.
+			for j, h := range row {
+				h = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")))
+				for k, names := range headers {
+					if slices.Contains(names, h) {
+						cols[k] = j
+					}
+				}
+			}
+			for _, k := range []string{"date", "query", "page", "clicks", "impressions"} {
+				if _, ok := cols[k]; !ok {
+					return nil, guru.Errorf(400, "line 1: no %q column in header", k)
+				}
+			}
+			continue
+		}
+
+		get := func(k string) string {
+			j, ok := cols[k]
+			if !ok || j >= len(row) {
+				return ""
+			}
+			return strings.TrimSpace(row[j])
+		}
+		atoi := func(k string) (int, error) {
+			s := strings.ReplaceAll(get(k), ",", "")
+			if s == "" {
+				return 0, nil
+			}
+			n, err := strconv.Atoi(s)
+			if err != nil {
+				return 0, fmt.Errorf("%s: %w", k, err)
+			}
+			return n, nil
+		}
+
+		sq := searchQueryRow{day: get("date"), query: get("query"), page: get("page")}
+		sq.clicks, err = atoi("clicks")
+		if err != nil {
+			return nil, guru.Errorf(400, "line %d: %w", i, err)
+		}
+		sq.impressions, err = atoi("impressions")
+		if err != nil {
+			return nil, guru.Errorf(400, "line %d: %w", i, err)
+		}
+		if p := get("position"); p != "" {
+			sq.position, err = strconv.ParseFloat(p, 64)
+			if err != nil {
+				return nil, guru.Errorf(400, "line %d: position: %w", i, err)
+			}
+		}
+		if err := sq.validate(); err != nil {
+			return nil, guru.Errorf(400, "line %d: %w", i, err)
+		}
+		rows = append(rows, sq)
+	}
+	return rows, nil
+}
+
+func readSearchQueriesJSON(fp io.Reader) ([]searchQueryRow, error) {
+	type jsonRow struct {
+		Keys        []string `json:"keys"` // Search Console API.
+		Date        string   `json:"date"`
+		Query       string   `json:"query"`
+		Page        string   `json:"page"`
+		Clicks      float64  `json:"clicks"`
+		Impressions float64  `json:"impressions"`
+		Position    float64  `json:"position"`
+	}
+
+	data, err := io.ReadAll(fp)
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimSpace(bytes.TrimPrefix(data, []byte("\ufeff")))
+
+	var jrows []jsonRow
+	if len(data) > 0 && data[0] == '{' {
+		var resp struct {
+			Rows []jsonRow `json:"rows"`
+		}
+		err = json.Unmarshal(data, &resp)
+		jrows = resp.Rows
+	} else {
+		err = json.Unmarshal(data, &jrows)
+	}
+	if err != nil {
+		return nil, guru.Errorf(400, "could not read JSON: %w", err)
+	}
+
+	rows := make([]searchQueryRow, 0, len(jrows))
+	for i, jr := range jrows {
+		if len(jr.Keys) > 0 {
+			if len(jr.Keys) != 3 {
+				return nil, guru.Errorf(400, "row %d: need 3 keys (date, query, page), but have %d", i+1, len(jr.Keys))
+			}
+			jr.Date, jr.Query, jr.Page = jr.Keys[0], jr.Keys[1], jr.Keys[2]
+		}
+		sq := searchQueryRow{
+			day:         jr.Date,
+			query:       jr.Query,
+			page:        jr.Page,
+			clicks:      int(jr.Clicks),
+			impressions: int(jr.Impressions),
+			position:    jr.Position,
+		}
+		if err := sq.validate(); err != nil {
+			return nil, guru.Errorf(400, "row %d: %w", i+1, err)
+		}
+		rows = append(rows, sq)
+	}
+	return rows, nil
+}
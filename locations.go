@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"slices"
+	"strconv"
 	"strings"
 
 	"zgo.at/errors"
@@ -19,9 +21,14 @@ type Location struct {
 
 	Country     string `db:"country" json:"country"`
 	Region      string `db:"region" json:"region"`
+	City        string `db:"city" json:"city"` // GeoNames ID.
 	CountryName string `db:"country_name" json:"country_name"`
 	RegionName  string `db:"region_name" json:"region_name"`
+	CityName    string `db:"city_name" json:"city_name"`
 
+	// ISO-3166-2 code, with the city appended as ":[geonames ID]" if there is
+	// one; e.g. "US-TX:4671654".
+	//
 	// TODO: send patch to staticcheck to deal with this better. This shouldn't
 	// errror since "ISO" is an initialism.
 	ISO3166_2 string `db:"iso_3166_2,noinsert" json:"-"` //lint:ignore ST1003 staticcheck bug
@@ -30,10 +37,12 @@ type Location struct {
 func (Location) Table() string { return "locations" }
 
 func (l Location) String() string {
-	return fmt.Sprintf("location_id=%d; country=%q; country_name=%q; region=%q; region_name=%q", l.ID, l.Country, l.CountryName, l.Region, l.RegionName)
+	return fmt.Sprintf("location_id=%d; country=%q; country_name=%q; region=%q; region_name=%q; city=%q; city_name=%q",
+		l.ID, l.Country, l.CountryName, l.Region, l.RegionName, l.City, l.CityName)
 }
 
-// ByCode gets a location by ISO-3166-2 code; e.g. "US" or "US-TX".
+// ByCode gets a location by ISO-3166-2 code; e.g. "US", "US-TX", or
+// "US-TX:4671654".
 func (l *Location) ByCode(ctx context.Context, code string) error {
 	if ll, ok := cacheLoc(ctx).Get(code); ok {
 		*l = *ll
@@ -43,8 +52,9 @@ func (l *Location) ByCode(ctx context.Context, code string) error {
 	err := zdb.Get(ctx, l, `select * from locations where iso_3166_2 = $1`, code)
 	if zdb.ErrNoRows(err) {
 		l.ISO3166_2 = code
+		code, l.City, _ = strings.Cut(code, ":")
 		l.Country, l.Region, _ = strings.Cut(code, "-")
-		l.CountryName, l.RegionName = findGeoName(ctx, l.Country, l.Region)
+		l.CountryName, l.RegionName, l.CityName = findGeoName(ctx, l.Country, l.Region, l.City)
 		err = l.insert(ctx)
 	}
 	if err != nil {
@@ -57,7 +67,8 @@ func (l *Location) ByCode(ctx context.Context, code string) error {
 
 // Lookup a location by IPv4 or IPv6 address.
 //
-// This will insert a row in the locations table if one doesn't exist yet.
+// This will insert a row in the locations table if one doesn't exist yet. The
+// city is only set if the site on the context collects cities.
 func (l *Location) Lookup(ctx context.Context, ip string) error {
 	geodb := geo.Get(ctx)
 	if geodb == nil {
@@ -73,19 +84,27 @@ func (l *Location) Lookup(ctx context.Context, ip string) error {
 	if len(loc.Subdivisions) > 0 {
 		l.Region, l.RegionName = loc.Subdivisions[0].IsoCode, loc.Subdivisions[0].Names["en"]
 	}
+	// Only look up cities if the site collects them, so we don't add a row to
+	// locations for every city we see.
+	if loc.City.GeoNameID > 0 && collectCity(ctx, l.Country) {
+		l.City, l.CityName = strconv.FormatUint(uint64(loc.City.GeoNameID), 10), loc.City.Names["en"]
+	}
 
 	l.ISO3166_2 = loc.Country.IsoCode
 	if l.Region != "" {
 		l.ISO3166_2 += "-" + l.Region
 	}
+	if l.City != "" {
+		l.ISO3166_2 += ":" + l.City
+	}
 	if ll, ok := cacheLoc(ctx).Get(l.ISO3166_2); ok {
 		*l = *ll
 		return nil
 	}
 
 	err = zdb.Get(ctx, l,
-		`select * from locations where country = $1 and region = $2`,
-		l.Country, l.Region)
+		`select * from locations where country = $1 and region = $2 and city = $3`,
+		l.Country, l.Region, l.City)
 	if zdb.ErrNoRows(err) {
 		err = l.insert(ctx)
 	}
@@ -97,6 +116,16 @@ func (l *Location) Lookup(ctx context.Context, ip string) error {
 	return nil
 }
 
+// collectCity reports if the site on the context collects cities for this
+// country.
+func collectCity(ctx context.Context, country string) bool {
+	site := GetSite(ctx)
+	if site == nil || !site.Settings.Collect.Has(CollectLocationCity) {
+		return false
+	}
+	return len(site.Settings.CollectCities) == 0 || slices.Contains(site.Settings.CollectCities, country)
+}
+
 // LookupIP is a shorthand for Lookup(); returns id 1 on errors ("unknown").
 func (l Location) LookupIP(ctx context.Context, ip string) string {
 	err := l.Lookup(ctx, ip)
@@ -112,24 +141,28 @@ func (l *Location) insert(ctx context.Context) (err error) {
 		return err
 	}
 
-	// Make sure there is an entry for the country as well.
-	if l.Region != "" {
-		err := (&Location{}).ByCode(ctx, l.Country)
-		if err != nil {
-			return err
+	// Make sure there is an entry for the region and country as well.
+	switch {
+	case l.City != "":
+		code := l.Country
+		if l.Region != "" {
+			code += "-" + l.Region
 		}
+		return (&Location{}).ByCode(ctx, code)
+	case l.Region != "":
+		return (&Location{}).ByCode(ctx, l.Country)
 	}
 	return nil
 }
 
 type Locations []Location
 
-// ListCountries lists all counties. The region code/name will always be blank.
+// ListCountries lists all counties. The region and city will always be blank.
 func (l *Locations) ListCountries(ctx context.Context) error {
 	err := zdb.Select(ctx, l, `
 		select country, country_name
         from locations
-        where country != '' and country_name != '' and region = ''
+        where country != '' and country_name != '' and region = '' and city = ''
         order by country_name`)
 	return errors.Wrap(err, "Locations.ListCountries")
 }
@@ -138,7 +171,7 @@ func (l *Locations) ListCountries(ctx context.Context) error {
 // (Countries is much faster, ~100ms) which is not a great worst case scenario,
 // but in most cases it should be (much) faster, and this should get called
 // extremely infrequently anyway, if ever.
-func findGeoName(ctx context.Context, country, region string) (string, string) {
+func findGeoName(ctx context.Context, country, region, city string) (string, string, string) {
 	geodb := geo.Get(ctx)
 	if geodb == nil {
 		panic("Location.Lookup: ")
@@ -156,24 +189,40 @@ func findGeoName(ctx context.Context, country, region string) (string, string) {
 				ISOCode string            `maxminddb:"iso_code"`
 				Names   map[string]string `maxminddb:"names"`
 			} `maxminddb:"subdivisions"`
+			City struct {
+				GeoNameID uint              `maxminddb:"geoname_id"`
+				Names     map[string]string `maxminddb:"names"`
+			} `maxminddb:"city"`
 		}
 		err := iter.Data(&r)
 		if err != nil {
 			log.Error(context.Background(), err)
-			return "", ""
+			return "", "", ""
+		}
+		if r.Country.ISOCode != country {
+			continue
 		}
 
+		var regionName string
+		if len(r.Subdivisions) > 0 {
+			regionName = r.Subdivisions[0].Names["en"]
+		}
 		switch {
 		// Country database, no region.
-		case r.Country.ISOCode == country && !hasRegions:
-			return r.Country.Names["en"], ""
-		// City database, no region requested.
-		case r.Country.ISOCode == country && region == "":
-			return r.Country.Names["en"], ""
+		case !hasRegions:
+			return r.Country.Names["en"], "", ""
+		// City database, no region or city requested.
+		case region == "" && city == "":
+			return r.Country.Names["en"], "", ""
+		// Region doesn't match.
+		case region != "" && (len(r.Subdivisions) == 0 || r.Subdivisions[0].ISOCode != region):
 		// Match region.
-		case r.Country.ISOCode == country && len(r.Subdivisions) > 0 && r.Subdivisions[0].ISOCode == region:
-			return r.Country.Names["en"], r.Subdivisions[0].Names["en"]
+		case city == "":
+			return r.Country.Names["en"], regionName, ""
+		// Match city.
+		case strconv.FormatUint(uint64(r.City.GeoNameID), 10) == city:
+			return r.Country.Names["en"], regionName, r.City.Names["en"]
 		}
 	}
-	return "", ""
+	return "", "", ""
 }
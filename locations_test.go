@@ -24,7 +24,7 @@ func TestLocations(t *testing.T) {
 			}
 
 			out := fmt.Sprintf("%#v", l)
-			want := `goatcounter.Location{ID:2, Country:"IE", Region:"", CountryName:"Ireland", RegionName:"", ISO3166_2:"IE"}`
+			want := `goatcounter.Location{ID:2, Country:"IE", Region:"", City:"", CountryName:"Ireland", RegionName:"", CityName:"", ISO3166_2:"IE"}`
 			if out != want {
 				t.Error(out)
 			}
@@ -37,7 +37,21 @@ func TestLocations(t *testing.T) {
 			}
 
 			out := fmt.Sprintf("%#v", l)
-			want := `goatcounter.Location{ID:3, Country:"US", Region:"TX", CountryName:"United States", RegionName:"", ISO3166_2:"US-TX"}`
+			want := `goatcounter.Location{ID:3, Country:"US", Region:"TX", City:"", CountryName:"United States", RegionName:"", CityName:"", ISO3166_2:"US-TX"}`
+			if out != want {
+				t.Error(out)
+			}
+		}
+
+		{
+			var l Location
+			err := l.ByCode(ctx, "US-CA:5391959")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			out := fmt.Sprintf("%#v", l)
+			want := `goatcounter.Location{ID:5, Country:"US", Region:"CA", City:"5391959", CountryName:"United States", RegionName:"", CityName:"", ISO3166_2:"US-CA:5391959"}`
 			if out != want {
 				t.Error(out)
 			}
@@ -45,11 +59,13 @@ func TestLocations(t *testing.T) {
 
 		out := zdb.DumpString(ctx, `select * from locations`)
 		want := `
-			location_id  iso_3166_2  country  region  country_name   region_name
-			1                                         (unknown)
-			2            IE          IE               Ireland
-			3            US-TX       US       TX      United States
-			4            US          US               United States`
+			location_id  country  region  country_name   region_name  city     city_name  iso_3166_2
+			1                             (unknown)
+			2            IE               Ireland                                         IE
+			3            US       TX      United States                                   US-TX
+			4            US               United States                                   US
+			5            US       CA      United States               5391959             US-CA:5391959
+			6            US       CA      United States                                   US-CA`
 		if d := ztest.Diff(out, want, ztest.DiffNormalizeWhitespace); d != "" {
 			t.Error(d)
 		}
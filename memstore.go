@@ -33,6 +33,16 @@ var (
 
 type sessionKey string
 
+type sessionRate struct {
+	start int64
+	n     int
+}
+
+type sessionRef struct {
+	RefID      RefID       `json:"r"`
+	CampaignID *CampaignID `json:"c,omitempty"`
+}
+
 type ms struct {
 	hitMu sync.RWMutex
 	hits  []Hit
@@ -42,6 +52,8 @@ type ms struct {
 	sessionHashes map[zint.Uint128]sessionKey          // sessionID → sessionKey
 	sessionPaths  map[zint.Uint128]map[PathID]struct{} // SessionID → path_id
 	sessionSeen   map[zint.Uint128]int64               // SessionID → lastseen
+	sessionRate   map[sessionKey]*sessionRate          // sessionKey → pageviews in the last minute
+	sessionRefs   map[zint.Uint128]sessionRef          // SessionID → ref of first pageview
 
 	testHook bool
 }
@@ -53,6 +65,7 @@ type storedSession struct {
 	Hashes   map[zint.Uint128]sessionKey          `json:"hashes"`
 	Paths    map[zint.Uint128]map[PathID]struct{} `json:"paths"`
 	Seen     map[zint.Uint128]int64               `json:"seen"`
+	Refs     map[zint.Uint128]sessionRef          `json:"refs"`
 }
 
 func (m *ms) Reset() {
@@ -63,6 +76,8 @@ func (m *ms) Reset() {
 	m.sessionHashes = make(map[zint.Uint128]sessionKey)
 	m.sessionPaths = make(map[zint.Uint128]map[PathID]struct{})
 	m.sessionSeen = make(map[zint.Uint128]int64)
+	m.sessionRate = make(map[sessionKey]*sessionRate)
+	m.sessionRefs = make(map[zint.Uint128]sessionRef)
 	TestSeqSession = zint.Uint128{TestSession[0], TestSession[1] + 1}
 }
 
@@ -117,6 +132,9 @@ func (m *ms) Init(db zdb.DB) error {
 	if stored.Seen != nil {
 		m.sessionSeen = stored.Seen
 	}
+	if stored.Refs != nil {
+		m.sessionRefs = stored.Refs
+	}
 	memlog.Debug(context.Background(), "restored sessions from DB",
 		"sessions", len(m.sessions),
 		"sessionHashes", len(m.sessionHashes),
@@ -134,6 +152,7 @@ func (m *ms) StoreSessions(db zdb.DB) {
 		Paths:    m.sessionPaths,
 		Seen:     m.sessionSeen,
 		Hashes:   m.sessionHashes,
+		Refs:     m.sessionRefs,
 	})
 	if err != nil {
 		memlog.Error(context.Background(), err)
@@ -172,31 +191,6 @@ func (m *ms) Len() int {
 	return len(m.hits)
 }
 
-var (
-	refspamSubdomains []string
-	refspamOnce       sync.Once
-)
-
-func isRefspam(host string) bool {
-	if _, ok := refspam[host]; ok {
-		return true
-	}
-
-	refspamOnce.Do(func() {
-		refspamSubdomains = make([]string, 0, len(refspam))
-		for v := range refspam {
-			refspamSubdomains = append(refspamSubdomains, "."+v)
-		}
-	})
-
-	for _, v := range refspamSubdomains {
-		if strings.HasSuffix(host, v) {
-			return true
-		}
-	}
-	return false
-}
-
 func (m *ms) Persist(ctx context.Context) ([]Hit, error) {
 	if m.Len() == 0 {
 		return nil, nil
@@ -208,20 +202,26 @@ func (m *ms) Persist(ctx context.Context) ([]Hit, error) {
 	m.hits = make([]Hit, 0, 16)
 	m.hitMu.Unlock()
 
-	bot, err := zdb.NewBulkInsert(ctx, "bots", []string{"site_id", "path", "bot", "user_agent", "created_at"})
+	bot, err := zdb.NewBulkInsert(ctx, "bots", []string{"site_id", "path", "bot", "user_agent", "created_at",
+		"ip_range", "ref", "location", "event"})
 	if err != nil {
 		return nil, err
 	}
 	ins, err := zdb.NewBulkInsert(ctx, "hits", []string{"site_id", "path_id", "ref_id", "browser_id", "system_id",
-		"width", "location", "language", "created_at", "session", "first_visit", "campaign"})
+		"width", "location", "language", "asn", "created_at", "session", "first_visit", "campaign", "value", "currency",
+		"device", "engine"})
 	if err != nil {
 		return nil, err
 	}
 
 	newHits := make([]Hit, 0, len(hits))
 	for _, h := range hits {
+		if h.Bot == 0 && !h.noBotRules {
+			m.botRules(ctx, &h)
+		}
 		if h.Bot > 0 {
-			bot.Values(h.Site, h.Path, h.Bot, h.UserAgentHeader, h.CreatedAt)
+			bot.Values(h.Site, h.Path, h.Bot, h.UserAgentHeader, h.CreatedAt,
+				ipRange(h.RemoteAddr), h.Ref, h.Location, h.Event)
 			continue
 		}
 		if m.processHit(ctx, &h) {
@@ -235,7 +235,8 @@ func (m *ms) Persist(ctx context.Context) ([]Hit, error) {
 					w = &h.Size[0]
 				}
 				ins.Values(h.Site, h.PathID, h.RefID, h.BrowserID, h.SystemID, w, h.Location,
-					h.Language, h.CreatedAt.Round(time.Second), h.Session, h.FirstVisit, h.CampaignID)
+					h.Language, h.ASN, h.CreatedAt.Round(time.Second), h.Session, h.FirstVisit, h.CampaignID,
+					h.Value, h.Currency, h.Device, h.Engine)
 			}
 		}
 	}
@@ -247,6 +248,53 @@ func (m *ms) Persist(ctx context.Context) ([]Hit, error) {
 	return newHits, ins.Finish()
 }
 
+// botRules sets Bot to BotCustom if the hit matches any of the site's bot
+// rules.
+func (m *ms) botRules(ctx context.Context, h *Hit) {
+	var rules BotRules
+	err := rules.ForSite(ctx, h.Site)
+	if err != nil {
+		memlog.Error(ctx, err, "hit", h)
+		return
+	}
+	if len(rules) == 0 {
+		return
+	}
+
+	asn := h.ASN
+	if asn == 0 && rules.HasASN() {
+		asn = Network{}.LookupIP(ctx, h.RemoteAddr)
+	}
+	if rules.Match(h.UserAgentHeader, h.RemoteAddr, asn) {
+		h.Bot = BotCustom
+		return
+	}
+	if rate := rules.Rate(); rate > 0 && m.rate(ctx, h) > rate {
+		h.Bot = BotCustom
+	}
+}
+
+// rate gets the number of pageviews for this session in the last minute,
+// including this one.
+func (m *ms) rate(ctx context.Context, h *Hit) int {
+	sk := sessionKey(h.UserSessionID)
+	if h.UserSessionID == "" {
+		sk = sessionKey(fmt.Sprintf("%s-%s-%d", h.UserAgentHeader, h.RemoteAddr, h.Site))
+	}
+
+	m.sessionMu.Lock()
+	defer m.sessionMu.Unlock()
+
+	now := ztime.Now(ctx).Unix()
+	r, ok := m.sessionRate[sk]
+	if !ok || now-r.start >= 60 {
+		m.sessionRate[sk] = &sessionRate{start: now, n: 1}
+		return 1
+	}
+	r.n++
+	return r.n
+}
+
 func (m *ms) processHit(ctx context.Context, h *Hit) bool {
 	defer log.Recover(ctx, func(err error) { memlog.Error(ctx, err, "hit", h) })
 
@@ -257,7 +305,7 @@ func (m *ms) processHit(ctx context.Context, h *Hit) bool {
 	// Ignore spammers.
 	h.RefURL, _ = url.Parse(h.Ref)
 	if h.RefURL != nil {
-		if isRefspam(h.RefURL.Host) {
+		if isRefspam(ctx, h.Site, h.RefURL.Host) {
 			refspamlog.Debugf(ctx, "refspam ignored: %q", h.RefURL.Host)
 			return false
 		}
@@ -299,18 +347,31 @@ func (m *ms) processHit(ctx context.Context, h *Hit) bool {
 	if !site.Settings.Collect.Has(CollectSession) || h.NoSession.Bool() {
 		h.Session, h.FirstVisit = zint.Uint128{}, true
 	}
+	m.sessionRef(h)
 	if !site.Settings.Collect.Has(CollectScreenSize) {
 		h.Size = nil
 	}
 	if !site.Settings.Collect.Has(CollectUserAgent) {
-		h.UserAgentHeader, h.BrowserID, h.SystemID = "", 0, 0
+		h.UserAgentHeader, h.Hints, h.BrowserID, h.SystemID, h.Device, h.Engine = "", ClientHints{}, 0, 0, "", ""
 	}
 	if !site.Settings.Collect.Has(CollectLanguage) {
 		h.Language = nil
 	}
+	if !site.Settings.Collect.Has(CollectNetwork) {
+		h.ASN = 0
+	}
 	if !site.Settings.Collect.Has(CollectLocation) {
 		h.Location = ""
 	}
+	if i := strings.IndexByte(h.Location, ':'); i > -1 {
+		trim := !site.Settings.Collect.Has(CollectLocationCity)
+		if !trim && len(site.Settings.CollectCities) > 0 {
+			trim = !slices.Contains(site.Settings.CollectCities, h.Location[:2])
+		}
+		if trim {
+			h.Location = h.Location[:i]
+		}
+	}
 	if strings.ContainsRune(h.Location, '-') {
 		trim := !site.Settings.Collect.Has(CollectLocationRegion)
 		if !trim && len(site.Settings.CollectRegions) > 0 {
@@ -338,6 +399,29 @@ func (m *ms) processHit(ctx context.Context, h *Hit) bool {
 	return true
 }
 
+// sessionRef sets the referrer and campaign to attribute the hit's value to.
+//
+// This is the referrer and campaign of the first pageview in the session, as
+// events don't have a campaign, and the referrer is usually just the previous
+// page.
+func (m *ms) sessionRef(h *Hit) {
+	h.RevenueRefID, h.RevenueCampaignID = h.RefID, h.CampaignID
+	if h.Session.IsZero() {
+		return
+	}
+
+	m.sessionMu.Lock()
+	defer m.sessionMu.Unlock()
+
+	r, ok := m.sessionRefs[h.Session]
+	switch {
+	case ok:
+		h.RevenueRefID, h.RevenueCampaignID = r.RefID, r.CampaignID
+	case !h.Event:
+		m.sessionRefs[h.Session] = sessionRef{RefID: h.RefID, CampaignID: h.CampaignID}
+	}
+}
+
 // SessionTime is the maximum length of sessions; exported here for tests.
 var SessionTime = 8 * time.Hour
 
@@ -349,6 +433,11 @@ func (m *ms) EvictSessions(ctx context.Context) {
 	defer m.sessionMu.Unlock()
 
 	ev := ztime.Now(ctx).Add(-SessionTime).Unix()
+	for sk, r := range m.sessionRate {
+		if r.start < ztime.Now(ctx).Add(-time.Minute).Unix() {
+			delete(m.sessionRate, sk)
+		}
+	}
 	for id, seen := range m.sessionSeen {
 		if seen > ev {
 			continue
@@ -366,6 +455,13 @@ func (m *ms) EvictSessions(ctx context.Context) {
 		delete(m.sessionSeen, id)
 		delete(m.sessionHashes, id)
 	}
+	// Also includes sessions that were set on the hit rather than created
+	// here, such as for imports.
+	for id := range m.sessionRefs {
+		if _, ok := m.sessionSeen[id]; !ok {
+			delete(m.sessionRefs, id)
+		}
+	}
 }
 
 // SessionID gets a new UUID4 session ID.
@@ -91,34 +91,51 @@ func TestMemstoreCollect(t *testing.T) {
 	tests := []struct {
 		collect        zint.Bitflag16
 		collectRegions Strings
+		collectCities  Strings
 		want           string
 	}{
-		{all, Strings{}, `
+		{all, Strings{}, nil, `
 			session                           path    ref          ref_scheme  width  location  first_visit
 			00112233445566778899aabbccddeeff  /test   example.com  h           5      NL        0
 			00112233445566778899aabbccddeeff  /other  xxx          c           5      ID-BA     1
 		`},
 
-		{CollectNothing, Strings{}, `
+		{CollectNothing, Strings{}, nil, `
 			session  path  ref  ref_scheme  width  location  first_visit
 		`},
 
-		{all ^ CollectLocationRegion, Strings{}, `
+		{all ^ CollectLocationRegion, Strings{}, nil, `
 			session                           path    ref          ref_scheme  width  location  first_visit
 			00112233445566778899aabbccddeeff  /test   example.com  h           5      NL        0
 			00112233445566778899aabbccddeeff  /other  xxx          c           5      ID        1
 		`},
 
-		{all, Strings{"US"}, `
+		{all, Strings{"US"}, nil, `
 			session                           path    ref          ref_scheme  width  location  first_visit
 			00112233445566778899aabbccddeeff  /test   example.com  h           5      NL        0
 			00112233445566778899aabbccddeeff  /other  xxx          c           5      ID        1
 		`},
-		{all, Strings{"ID"}, `
+		{all, Strings{"ID"}, nil, `
 			session                           path    ref          ref_scheme  width  location  first_visit
 			00112233445566778899aabbccddeeff  /test   example.com  h           5      NL        0
 			00112233445566778899aabbccddeeff  /other  xxx          c           5      ID-BA     1
 		`},
+
+		{all | CollectLocationCity, Strings{}, Strings{}, `
+			session                           path    ref          ref_scheme  width  location       first_visit
+			00112233445566778899aabbccddeeff  /test   example.com  h           5      NL             0
+			00112233445566778899aabbccddeeff  /other  xxx          c           5      ID-BA:1645528  1
+		`},
+		{all | CollectLocationCity, Strings{}, Strings{"US"}, `
+			session                           path    ref          ref_scheme  width  location  first_visit
+			00112233445566778899aabbccddeeff  /test   example.com  h           5      NL        0
+			00112233445566778899aabbccddeeff  /other  xxx          c           5      ID-BA     1
+		`},
+		{all | CollectLocationCity, Strings{"US"}, Strings{"ID"}, `
+			session                           path    ref          ref_scheme  width  location  first_visit
+			00112233445566778899aabbccddeeff  /test   example.com  h           5      NL        0
+			00112233445566778899aabbccddeeff  /other  xxx          c           5      ID        1
+		`},
 	}
 
 	for _, tt := range tests {
@@ -129,6 +146,7 @@ func TestMemstoreCollect(t *testing.T) {
 			site := Site{Settings: SiteSettings{
 				Collect:        tt.collect,
 				CollectRegions: tt.collectRegions,
+				CollectCities:  tt.collectCities,
 			}}
 			ctx = gctest.Site(ctx, t, &site, nil)
 
@@ -142,7 +160,7 @@ func TestMemstoreCollect(t *testing.T) {
 				Site:       site.ID,
 				Path:       "/other",
 				Query:      "ref=xxx",
-				Location:   "ID-BA",
+				Location:   "ID-BA:1645528",
 				Size:       Floats{5, 6, 7},
 				FirstVisit: true,
 			})
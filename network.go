@@ -0,0 +1,84 @@
+package goatcounter
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"zgo.at/errors"
+	"zgo.at/goatcounter/v2/pkg/geo"
+	"zgo.at/zdb"
+)
+
+// ASN is an autonomous system number; 0 is "unknown".
+type ASN int32
+
+func (a ASN) String() string { return "AS" + strconv.FormatInt(int64(a), 10) }
+
+// ParseASN parses an AS number, with or without "AS" prefix; e.g. "AS16509" or
+// "16509".
+func ParseASN(s string) (ASN, error) {
+	s = strings.TrimSpace(s)
+	if len(s) > 2 && strings.EqualFold(s[:2], "AS") {
+		s = s[2:]
+	}
+	n, err := strconv.ParseInt(s, 10, 32)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid AS number: %q", s)
+	}
+	return ASN(n), nil
+}
+
+// Network is an autonomous system, such as an ISP or hosting provider.
+type Network struct {
+	ASN  ASN    `db:"asn" json:"asn"`
+	Name string `db:"name" json:"name"` // Organisation name.
+}
+
+func (Network) Table() string { return "networks" }
+
+// Lookup a network by IPv4 or IPv6 address.
+//
+// This will insert a row in the networks table if one doesn't exist yet.
+func (n *Network) Lookup(ctx context.Context, ip string) error {
+	asndb := geo.GetASN(ctx)
+	if asndb == nil {
+		return errors.New("Network.Lookup: no ASN database on context")
+	}
+
+	asn, err := asndb.ASN(net.ParseIP(ip))
+	if err != nil {
+		return errors.Wrap(err, "Network.Lookup")
+	}
+	n.ASN, n.Name = ASN(asn.AutonomousSystemNumber), asn.AutonomousSystemOrganization
+	if n.ASN == 0 {
+		return nil
+	}
+
+	if name, ok := cacheNetworks(ctx).Get(n.ASN); ok && name == n.Name {
+		return nil
+	}
+	err = zdb.Exec(ctx, `insert into networks (asn, name) values (:asn, :name)
+		on conflict (asn) do update set name = excluded.name`,
+		map[string]any{"asn": n.ASN, "name": n.Name})
+	if err != nil {
+		return errors.Wrap(err, "Network.Lookup")
+	}
+	cacheNetworks(ctx).Set(n.ASN, n.Name)
+	return nil
+}
+
+// LookupIP is a shorthand for Lookup(); returns 0 ("unknown") on errors or if
+// there is no ASN database.
+func (n Network) LookupIP(ctx context.Context, ip string) ASN {
+	if geo.GetASN(ctx) == nil {
+		return 0
+	}
+	err := n.Lookup(ctx, ip)
+	if err != nil {
+		return 0
+	}
+	return n.ASN
+}
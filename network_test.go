@@ -0,0 +1,85 @@
+package goatcounter_test
+
+import (
+	"fmt"
+	"testing"
+
+	. "zgo.at/goatcounter/v2"
+	"zgo.at/goatcounter/v2/gctest"
+	"zgo.at/zdb"
+	"zgo.at/zstd/ztest"
+	"zgo.at/zstd/ztime"
+)
+
+func TestParseASN(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    ASN
+		wantErr string
+	}{
+		{"AS16509", 16509, ""},
+		{"as16509", 16509, ""},
+		{"16509", 16509, ""},
+		{" AS1 ", 1, ""},
+		{"", 0, "invalid AS number"},
+		{"AS", 0, "invalid AS number"},
+		{"AS0", 0, "invalid AS number"},
+		{"ASx", 0, "invalid AS number"},
+		{"-5", 0, "invalid AS number"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			have, err := ParseASN(tt.in)
+			if !ztest.ErrorContains(err, tt.wantErr) {
+				t.Fatalf("wrong error\nhave: %v\nwant: %v", err, tt.wantErr)
+			}
+			if have != tt.want {
+				t.Errorf("\nhave: %d\nwant: %d", have, tt.want)
+			}
+		})
+	}
+}
+
+func TestHitStatsListNetworks(t *testing.T) {
+	ctx := gctest.DB(t)
+
+	site := MustGetSite(ctx)
+	site.Settings.Collect.Set(CollectNetwork)
+	err := site.Update(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = zdb.Exec(ctx, `insert into networks (asn, name) values (16509, 'AMAZON-02'), (3320, 'Deutsche Telekom AG')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gctest.StoreHits(ctx, t, false,
+		Hit{FirstVisit: true, ASN: 3320},
+		Hit{FirstVisit: true, ASN: 3320},
+		Hit{FirstVisit: true, ASN: 16509},
+		Hit{FirstVisit: true})
+
+	rng := ztime.NewRange(ztime.Now(ctx)).To(ztime.Now(ctx))
+
+	var stats HitStats
+	err = stats.ListNetworks(ctx, rng, PathFilter{}, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	have := fmt.Sprintf("%v", stats.Stats)
+	want := "[{3320 Deutsche Telekom AG 2 <nil>} {0 (unknown) 1 <nil>} {16509 AMAZON-02 1 <nil>}]"
+	if have != want {
+		t.Errorf("\nhave: %s\nwant: %s", have, want)
+	}
+
+	stats = HitStats{}
+	err = stats.ListNetworks(ctx, rng, PathFilter{}, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats.Stats) != 1 || !stats.More {
+		t.Errorf("wrong limit: %v %t", stats.Stats, stats.More)
+	}
+}
@@ -0,0 +1,313 @@
+package goatcounter
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"maps"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"zgo.at/errors"
+	"zgo.at/goatcounter/v2/pkg/oidc"
+	"zgo.at/guru"
+	"zgo.at/json"
+	"zgo.at/z18n"
+	"zgo.at/zdb"
+	"zgo.at/zstd/ztime"
+)
+
+// OIDCServer is the SiteID for the server-wide OpenID Connect configuration,
+// which is used for accounts that don't have their own and enabled
+// SiteSettings.ServerSSO.
+const OIDCServer SiteID = 0
+
+type OIDCID int32
+
+// OIDC is the OpenID Connect configuration to log in with an identity provider.
+//
+// Users are matched on email address. New users are created on their first
+// login if DefaultAccess is set or if one of their groups is in Groups.
+type OIDC struct {
+	ID     OIDCID `db:"oidc_id,id" json:"-"`
+	SiteID SiteID `db:"site_id" json:"-"`
+
+	Issuer       string `db:"issuer" json:"issuer"`
+	ClientID     string `db:"client_id" json:"client_id"`
+	ClientSecret string `db:"client_secret" json:"-"`
+
+	// Access for new users; if this is blank users aren't created.
+	DefaultAccess UserAccess `db:"default_access" json:"default_access"`
+
+	// Claim with the user's groups, and the access to give to members of those
+	// groups. If a user is in more than one group they get the highest access.
+	GroupsClaim string     `db:"groups_claim" json:"groups_claim"`
+	Groups      OIDCGroups `db:"groups" json:"groups"`
+
+	CreatedAt time.Time  `db:"created_at" json:"-"`
+	UpdatedAt *time.Time `db:"updated_at" json:"-"`
+}
+
+func (OIDC) Table() string { return "oidc" }
+
+var _ zdb.Defaulter = &OIDC{}
+
+func (o *OIDC) Defaults(ctx context.Context) {
+	o.Issuer = strings.TrimSpace(o.Issuer)
+	o.ClientID = strings.TrimSpace(o.ClientID)
+	o.GroupsClaim = strings.TrimSpace(o.GroupsClaim)
+	if o.GroupsClaim == "" {
+		o.GroupsClaim = "groups"
+	}
+	if o.Groups == nil {
+		o.Groups = make(OIDCGroups)
+	}
+
+	if o.CreatedAt.IsZero() {
+		o.CreatedAt = ztime.Now(ctx)
+	} else {
+		o.UpdatedAt = new(ztime.Now(ctx))
+	}
+}
+
+var _ zdb.Validator = &OIDC{}
+
+func (o *OIDC) Validate(ctx context.Context) error {
+	v := NewValidate(ctx)
+	v.Required("issuer", o.Issuer)
+	v.URL("issuer", o.Issuer)
+	// The server fetches the issuer's configuration, so only allow public
+	// https URLs for accounts; the server-wide configuration is trusted. This
+	// is also checked when connecting, as the hostname may resolve to
+	// anything.
+	if o.SiteID != OIDCServer && o.Issuer != "" {
+		u, err := url.Parse(o.Issuer)
+		if err != nil || u.Scheme != "https" {
+			v.Append("issuer", z18n.T(ctx, "validate/oidc-https|must be a https:// URL"))
+		} else if a, err := netip.ParseAddr(u.Hostname()); (err == nil && !oidc.IsPublic(a)) || u.Hostname() == "localhost" {
+			v.Append("issuer", z18n.T(ctx, "validate/oidc-public|must be a public address"))
+		}
+	}
+	v.Required("client_id", o.ClientID)
+	v.Required("client_secret", o.ClientSecret)
+
+	// Only the server-wide configuration can give superuser access.
+	access := []string{string(AccessReadOnly), string(AccessSettings), string(AccessAdmin)}
+	if o.SiteID == OIDCServer {
+		access = append(access, string(AccessSuperuser))
+	}
+	if o.DefaultAccess != "" {
+		v.Include("default_access", string(o.DefaultAccess), access)
+	}
+	for g, a := range o.Groups {
+		if strings.TrimSpace(g) == "" {
+			v.Append("groups", "group name can't be blank")
+		}
+		v.Include("groups."+g, string(a), append([]string{string(AccessNone)}, access...))
+	}
+	return v.ErrorOrNil()
+}
+
+// Insert a new configuration.
+func (o *OIDC) Insert(ctx context.Context) error {
+	err := zdb.Insert(ctx, o)
+	return errors.Wrap(err, "OIDC.Insert")
+}
+
+// Update the configuration.
+func (o *OIDC) Update(ctx context.Context) error {
+	err := zdb.Update(ctx, o, "issuer", "client_id", "client_secret", "default_access",
+		"groups_claim", "groups", "updated_at")
+	return errors.Wrap(err, "OIDC.Update")
+}
+
+// Delete the configuration.
+func (o *OIDC) Delete(ctx context.Context) error {
+	err := zdb.Exec(ctx, `delete from oidc where oidc_id=$1 and site_id=$2`, o.ID, o.SiteID)
+	return errors.Wrap(err, "OIDC.Delete")
+}
+
+// BySite gets the configuration for an account, or OIDCServer for the
+// server-wide configuration.
+func (o *OIDC) BySite(ctx context.Context, siteID SiteID) error {
+	err := zdb.Get(ctx, o, `select * from oidc where site_id=$1`, siteID)
+	return errors.Wrapf(err, "OIDC.BySite(%d)", siteID)
+}
+
+// ForAccount gets the configuration to use for an account: the account's own
+// configuration if it has one, or the server-wide one if the account enabled
+// SiteSettings.ServerSSO.
+func (o *OIDC) ForAccount(ctx context.Context, account *Site) error {
+	ids := []SiteID{account.ID}
+	if account.Settings.ServerSSO {
+		ids = append(ids, OIDCServer)
+	}
+	err := zdb.Get(ctx, o, `select * from oidc where site_id in (:ids) order by site_id desc limit 1`,
+		map[string]any{"ids": ids})
+	return errors.Wrapf(err, "OIDC.ForAccount(%d)", account.ID)
+}
+
+// Provider gets the identity provider for this configuration.
+//
+// Only the server-wide configuration can connect to non-public addresses.
+func (o OIDC) Provider(ctx context.Context) (*oidc.Provider, error) {
+	client := oidc.PublicClient
+	if o.SiteID == OIDCServer {
+		client = oidc.Client
+	}
+	p, err := oidc.Discover(ctx, o.Issuer, client)
+	return p, errors.Wrap(err, "OIDC.Provider")
+}
+
+// Access gets the access for a user in these groups.
+func (o OIDC) Access(groups []string) UserAccess {
+	var (
+		access  UserAccess
+		matched bool
+	)
+	for _, g := range groups {
+		a, ok := o.Groups[g]
+		if !ok {
+			continue
+		}
+		if !matched || a.level() > access.level() {
+			access, matched = a, true
+		}
+	}
+	if matched {
+		return access
+	}
+	return o.DefaultAccess
+}
+
+// LoginUser gets the user for the verified claims, creating the user if it
+// doesn't exist yet.
+//
+// The access for existing users is updated from the groups if there are any
+// groups configured; access is revoked if the user is no longer in any of the
+// groups and there is no default access.
+func (o OIDC) LoginUser(ctx context.Context, account *Site, claims oidc.Claims) (*User, error) {
+	email := claims.String("email")
+	if email == "" {
+		return nil, guru.New(403, z18n.T(ctx, "error/oidc-no-email|The identity provider didn't send an email address"))
+	}
+	if v, ok := claims.Bool("email_verified"); ok && !v {
+		return nil, guru.New(403, z18n.T(ctx, "error/oidc-email-not-verified|The email address %(email) isn't verified by the identity provider", email))
+	}
+
+	var (
+		access = o.Access(claims.Strings(o.GroupsClaim))
+		u      User
+	)
+	err := u.BySiteAndEmail(ctx, account.ID, email)
+	if zdb.ErrNoRows(err) {
+		if access == "" || access == AccessNone {
+			return nil, guru.New(403, z18n.T(ctx, "error/oidc-no-user|There is no user for %(email)", email))
+		}
+		u = User{
+			Site:          account.ID,
+			Email:         email,
+			EmailVerified: true,
+			Settings:      account.UserDefaults,
+			Access:        UserAccesses{"all": access},
+		}
+		err = u.Insert(ctx, true)
+		if err != nil {
+			return nil, errors.Wrap(err, "OIDC.LoginUser")
+		}
+		return &u, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "OIDC.LoginUser")
+	}
+
+	// Superusers can only log in with the server-wide configuration; otherwise
+	// an account admin could log in as a superuser with their own IdP.
+	if o.SiteID != OIDCServer && u.AccessSuperuser() {
+		return nil, guru.New(403, z18n.T(ctx, "error/oidc-superuser|Superusers can't log in with this identity provider"))
+	}
+
+	if len(o.Groups) > 0 {
+		// Revoke access if the user is no longer in any of the groups.
+		if access == "" {
+			access = AccessNone
+		}
+		if u.Access["all"] != access {
+			u.Access["all"] = access
+			err := u.Update(ctx, false)
+			if err != nil {
+				return nil, errors.Wrap(err, "OIDC.LoginUser")
+			}
+		}
+	}
+	if u.Access["all"] == AccessNone {
+		return nil, guru.New(403, z18n.T(ctx, "error/oidc-no-access|%(email) doesn't have access", email))
+	}
+	return &u, nil
+}
+
+type OIDCGroups map[string]UserAccess
+
+// Value implements the SQL Value function to determine what to store in the DB.
+func (g OIDCGroups) Value() (driver.Value, error) { return json.Marshal(g) }
+
+// Scan converts the data returned from the DB into the struct.
+func (g *OIDCGroups) Scan(v any) error {
+	switch vv := v.(type) {
+	case []byte:
+		return json.Unmarshal(vv, g)
+	case string:
+		return json.Unmarshal([]byte(vv), g)
+	default:
+		return fmt.Errorf("OIDCGroups.Scan: unsupported type: %T", v)
+	}
+}
+
+// String formats the groups as "group: access" lines, sorted by group name.
+func (g OIDCGroups) String() string {
+	var b strings.Builder
+	for _, k := range slices.Sorted(maps.Keys(g)) {
+		a := string(g[k])
+		if g[k].level() > 0 || g[k] == AccessNone {
+			a = g[k].String()
+		}
+		fmt.Fprintf(&b, "%s: %s\n", k, a)
+	}
+	return b.String()
+}
+
+// ParseOIDCGroups parses "group: access" lines, as produced by
+// OIDCGroups.String(). The access can be the letter or name.
+func ParseOIDCGroups(s string) (OIDCGroups, error) {
+	g := make(OIDCGroups)
+	for n, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		// Group names may contain a ':' (e.g. URNs), but the access never
+		// does.
+		i := strings.LastIndexByte(line, ':')
+		if i == -1 {
+			return nil, fmt.Errorf("line %d: no ':' in %q", n+1, line)
+		}
+		name, access := strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+		a, ok := accessNames[access]
+		if !ok {
+			a = UserAccess(access)
+		}
+		g[name] = a
+	}
+	return g, nil
+}
+
+var accessNames = map[string]UserAccess{
+	AccessNone.String():      AccessNone,
+	AccessReadOnly.String():  AccessReadOnly,
+	AccessSettings.String():  AccessSettings,
+	AccessAdmin.String():     AccessAdmin,
+	AccessSuperuser.String(): AccessSuperuser,
+}
@@ -0,0 +1,215 @@
+package goatcounter_test
+
+import (
+	"fmt"
+	"testing"
+
+	"zgo.at/goatcounter/v2"
+	"zgo.at/goatcounter/v2/gctest"
+	"zgo.at/goatcounter/v2/pkg/oidc"
+	"zgo.at/zdb"
+	"zgo.at/zstd/ztest"
+)
+
+func TestOIDCAccess(t *testing.T) {
+	o := goatcounter.OIDC{
+		DefaultAccess: goatcounter.AccessReadOnly,
+		Groups: goatcounter.OIDCGroups{
+			"staff":   goatcounter.AccessSettings,
+			"admins":  goatcounter.AccessAdmin,
+			"blocked": goatcounter.AccessNone,
+		},
+	}
+
+	tests := []struct {
+		groups []string
+		want   goatcounter.UserAccess
+	}{
+		{nil, goatcounter.AccessReadOnly},
+		{[]string{"other"}, goatcounter.AccessReadOnly},
+		{[]string{"staff"}, goatcounter.AccessSettings},
+		{[]string{"staff", "admins"}, goatcounter.AccessAdmin},
+		{[]string{"admins", "staff"}, goatcounter.AccessAdmin},
+		{[]string{"blocked"}, goatcounter.AccessNone},
+		{[]string{"blocked", "staff"}, goatcounter.AccessSettings},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%v", tt.groups), func(t *testing.T) {
+			if have := o.Access(tt.groups); have != tt.want {
+				t.Errorf("\nhave: %q\nwant: %q", have, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseOIDCGroups(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr string
+	}{
+		{"", "", ""},
+		{"a: admin\n\n b :r \n", "a: admin\nb: read only\n", ""},
+		{"urn:example:group: settings", "urn:example:group: settings\n", ""},
+		{"x: no access", "x: no access\n", ""},
+		{"nope", "", "no ':'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			have, err := goatcounter.ParseOIDCGroups(tt.in)
+			if !ztest.ErrorContains(err, tt.wantErr) {
+				t.Fatalf("wrong error: %v", err)
+			}
+			if h := have.String(); h != tt.want {
+				t.Errorf("\nhave: %q\nwant: %q", h, tt.want)
+			}
+		})
+	}
+}
+
+func TestOIDCLoginUser(t *testing.T) {
+	ctx := gctest.DB(t)
+	account := goatcounter.MustGetSite(ctx)
+
+	o := goatcounter.OIDC{
+		SiteID:       account.ID,
+		Issuer:       "https://login.example.com",
+		ClientID:     "goatcounter",
+		ClientSecret: "secret",
+		Groups:       goatcounter.OIDCGroups{"admins": goatcounter.AccessAdmin, "staff": goatcounter.AccessReadOnly},
+	}
+	err := o.Insert(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	{ // Not in a group and there's no default access.
+		_, err := o.LoginUser(ctx, account, oidc.Claims{"email": "new@example.com"})
+		if !ztest.ErrorContains(err, "no user for new@example.com") {
+			t.Fatalf("wrong error: %v", err)
+		}
+	}
+	{
+		_, err := o.LoginUser(ctx, account, oidc.Claims{"email": "new@example.com", "email_verified": false})
+		if !ztest.ErrorContains(err, "isn't verified") {
+			t.Fatalf("wrong error: %v", err)
+		}
+	}
+
+	// Create.
+	u, err := o.LoginUser(ctx, account, oidc.Claims{"email": "new@example.com", "groups": []any{"staff"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.ID == 0 || !u.EmailVerified || u.Access["all"] != goatcounter.AccessReadOnly {
+		t.Errorf("%#v", u)
+	}
+
+	// Update access from groups.
+	u, err = o.LoginUser(ctx, account, oidc.Claims{"email": "NEW@example.com", "groups": []any{"staff", "admins"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got goatcounter.User
+	err = got.ByID(ctx, u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Access["all"] != goatcounter.AccessAdmin {
+		t.Errorf("access: %v", got.Access)
+	}
+
+	// No longer in any group: access is revoked.
+	_, err = o.LoginUser(ctx, account, oidc.Claims{"email": "new@example.com", "groups": []any{"other"}})
+	if !ztest.ErrorContains(err, "doesn't have access") {
+		t.Fatalf("wrong error: %v", err)
+	}
+	got = goatcounter.User{}
+	err = got.ByID(ctx, u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Access["all"] != goatcounter.AccessNone {
+		t.Errorf("access not revoked: %v", got.Access)
+	}
+
+	// Can't log in as a superuser with an account IdP.
+	super := goatcounter.User{
+		Site:   account.ID,
+		Email:  "super@example.com",
+		Access: goatcounter.UserAccesses{"all": goatcounter.AccessSuperuser},
+	}
+	err = super.Insert(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = o.LoginUser(ctx, account, oidc.Claims{"email": "super@example.com", "groups": []any{"admins"}})
+	if !ztest.ErrorContains(err, "Superusers can't log in") {
+		t.Fatalf("wrong error: %v", err)
+	}
+	o.SiteID = goatcounter.OIDCServer
+	_, err = o.LoginUser(ctx, account, oidc.Claims{"email": "super@example.com", "groups": []any{"admins"}})
+	if err != nil {
+		t.Fatalf("server IdP: %v", err)
+	}
+}
+
+func TestOIDCForAccount(t *testing.T) {
+	ctx := gctest.DB(t)
+	account := goatcounter.MustGetSite(ctx)
+
+	o := goatcounter.OIDC{
+		SiteID:        goatcounter.OIDCServer,
+		Issuer:        "http://login.internal",
+		ClientID:      "goatcounter",
+		ClientSecret:  "secret",
+		DefaultAccess: goatcounter.AccessReadOnly,
+	}
+	err := o.Insert(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var have goatcounter.OIDC
+	err = have.ForAccount(ctx, account)
+	if !zdb.ErrNoRows(err) {
+		t.Fatalf("server-wide configuration used without opting in: %v", err)
+	}
+
+	account.Settings.ServerSSO = true
+	err = have.ForAccount(ctx, account)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have.ID != o.ID {
+		t.Errorf("wrong ID: %d", have.ID)
+	}
+}
+
+func TestOIDCValidateIssuer(t *testing.T) {
+	ctx := gctest.DB(t)
+
+	tests := []struct {
+		site    goatcounter.SiteID
+		issuer  string
+		wantErr string
+	}{
+		{1, "https://login.example.com", ""},
+		{1, "http://login.example.com", "must be a https:// URL"},
+		{1, "https://127.0.0.1", "must be a public address"},
+		{1, "https://[::1]:8080", "must be a public address"},
+		{1, "https://10.1.2.3", "must be a public address"},
+		{1, "https://localhost", "must be a public address"},
+		{goatcounter.OIDCServer, "http://10.1.2.3", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.issuer, func(t *testing.T) {
+			o := goatcounter.OIDC{SiteID: tt.site, Issuer: tt.issuer, ClientID: "x", ClientSecret: "x"}
+			o.Defaults(ctx)
+			err := o.Validate(ctx)
+			if !ztest.ErrorContains(err, tt.wantErr) {
+				t.Errorf("wrong error\nhave: %v\nwant: %s", err, tt.wantErr)
+			}
+		})
+	}
+}
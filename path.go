@@ -112,6 +112,10 @@ func (p *Path) GetOrInsert(ctx context.Context) error {
 		return errors.Wrap(err, "Path.GetOrInsert insert")
 	}
 	for _, ff := range f {
+		// Filters with a channel are updated in PathFilterFromQuery.
+		if _, ch := findChannel(ff.Query); ch != "" {
+			continue
+		}
 		m := ff.Match(p.Path, p.Title, bool(p.Event))
 		if ff.Invert {
 			m = !m
@@ -186,15 +190,16 @@ func (p Path) Merge(ctx context.Context, paths Paths) error {
 				sel    = append([]string{}, t.Columns...)
 				selCTE = append([]string{}, t.Columns...)
 				group  = append([]string{}, t.Columns...)
+				n      = max(t.SumColumns, 1)
 			)
 
 			sel[i] = ":path_id"
 			selCTE = slices.Delete(selCTE, i, i+1)
-			l := len(selCTE) - 1
-
-			selCTE[l] = fmt.Sprintf("sum(%[1]s) as %[1]s", selCTE[l])
+			for l := len(selCTE) - n; l < len(selCTE); l++ {
+				selCTE[l] = fmt.Sprintf("sum(%[1]s) as %[1]s", selCTE[l])
+			}
 
-			group = append(group[i+1:len(group)-1], "site_id")
+			group = append(group[i+1:len(group)-n], "site_id")
 
 			err := zdb.Exec(ctx, `load:paths.Merge`, map[string]any{
 				"Table":      t.Table,
@@ -250,11 +255,16 @@ func (p Path) Merge(ctx context.Context, paths Paths) error {
 type Paths []Path
 
 // List all paths for a site.
-func (p *Paths) List(ctx context.Context, siteID SiteID, after PathID, limit int) (bool, error) {
+//
+// If only is not nil then only paths with those IDs are listed.
+func (p *Paths) List(ctx context.Context, siteID SiteID, after PathID, only []PathID, limit int) (bool, error) {
 	err := zdb.Select(ctx, p, "load:paths.List", map[string]any{
-		"site":  siteID,
-		"after": after,
-		"limit": limit + 1,
+		"site":      siteID,
+		"after":     after,
+		"have_only": only != nil,
+		"only":      db2.Array(ctx, only),
+		"in":        db2.In(ctx),
+		"limit":     limit + 1,
 	})
 	if err != nil {
 		return false, errors.Wrap(err, "Paths.List")
@@ -7,12 +7,16 @@ import (
 	"context"
 	"crypto/sha256"
 	_ "embed"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"zgo.at/goatcounter/v2/pkg/geo/geoip2"
@@ -22,18 +26,245 @@ import (
 //go:embed GeoLite2-Country.mmdb.gz
 var bundle []byte
 
-var ctxkey = &struct{ n string }{"geo"}
+var (
+	ctxkey = &struct{ n string }{"geo"}
+	asnkey = &struct{ n string }{"asn"}
+)
+
+// db is the database stored on the context; the reader can be swapped with
+// Update().
+type db struct {
+	reader atomic.Pointer[geoip2.Reader]
+	source string
+
+	// Only one update runs at a time; this is held during the download, so
+	// GetStatus() uses mu instead.
+	updating sync.Mutex
+	loaded   fileInfo // Protected by updating.
+
+	mu        sync.Mutex // Protects the fields below.
+	loadedAt  time.Time
+	checkedAt time.Time
+	checkErr  error
+}
 
-func With(ctx context.Context, db *geoip2.Reader) context.Context {
-	return context.WithValue(ctx, ctxkey, db)
+type fileInfo struct {
+	size    int64
+	modTime time.Time
+}
+
+func stat(path string) (fileInfo, error) {
+	st, err := os.Stat(path)
+	if err != nil {
+		return fileInfo{}, err
+	}
+	return fileInfo{size: st.Size(), modTime: st.ModTime()}, nil
+}
+
+// With sets the GeoIP database on the context; it's never updated.
+func With(ctx context.Context, reader *geoip2.Reader) context.Context {
+	return WithSource(ctx, reader, "")
+}
+
+// WithSource sets the GeoIP database on the context, and the source it was
+// loaded from as passed to Open(). The database will be reloaded from the
+// source with Update().
+func WithSource(ctx context.Context, reader *geoip2.Reader, source string) context.Context {
+	d := &db{source: source, loadedAt: time.Now()}
+	d.reader.Store(reader)
+	if source != "" {
+		if src, err := parseSource(source); err == nil {
+			d.loaded, _ = stat(src.path)
+		}
+	}
+	return context.WithValue(ctx, ctxkey, d)
+}
+
+// WithASN sets the ASN database loaded from path on the context; it will be
+// reloaded with UpdateASN() if the file changes.
+func WithASN(ctx context.Context, reader *geoip2.Reader, path string) context.Context {
+	d := &db{source: path, loadedAt: time.Now()}
+	d.reader.Store(reader)
+	d.loaded, _ = stat(path)
+	return context.WithValue(ctx, asnkey, d)
+}
+
+// Copy the GeoIP and ASN databases from src to dst, so that updates are
+// shared.
+func Copy(dst, src context.Context) context.Context {
+	for _, k := range []any{ctxkey, asnkey} {
+		if d, ok := src.Value(k).(*db); ok {
+			dst = context.WithValue(dst, k, d)
+		}
+	}
+	return dst
 }
 
 func Get(ctx context.Context) *geoip2.Reader {
-	db, ok := ctx.Value(ctxkey).(*geoip2.Reader)
+	d, ok := ctx.Value(ctxkey).(*db)
 	if !ok {
 		return nil
 	}
-	return db
+	return d.reader.Load()
+}
+
+// GetASN gets the ASN database, or nil if there isn't one.
+func GetASN(ctx context.Context) *geoip2.Reader {
+	d, ok := ctx.Value(asnkey).(*db)
+	if !ok {
+		return nil
+	}
+	return d.reader.Load()
+}
+
+// Status of the GeoIP database on the context.
+type Status struct {
+	Source    string    // Source, with the MaxMind license key removed.
+	Path      string    // Local path; empty for the built-in database.
+	LoadedAt  time.Time // Time the database was (re)loaded.
+	CheckedAt time.Time // Last time Update() ran; zero if it never ran.
+	Err       error     // Error from the last Update().
+}
+
+// GetStatus gets the status of the GeoIP database on the context.
+func GetStatus(ctx context.Context) Status {
+	d, ok := ctx.Value(ctxkey).(*db)
+	if !ok {
+		return Status{}
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	st := Status{Source: d.source, LoadedAt: d.loadedAt, CheckedAt: d.checkedAt, Err: d.checkErr}
+	if src, err := parseSource(d.source); err == nil {
+		st.Path = src.path
+		if src.kind == sourceMaxMind {
+			st.Source = "maxmind:" + src.account + ":[key]"
+		}
+	}
+	return st
+}
+
+// Update checks if there's a newer version of the GeoIP database on the
+// context, and swaps it in if there is.
+//
+// For MaxMind this downloads a new database if the checksum changed, for HTTP
+// mirrors it downloads the file if it was modified, and for local paths it
+// reloads the file if it was modified.
+//
+// The built-in database is never updated. It returns true if the database was
+// reloaded.
+func Update(ctx context.Context) (bool, error) {
+	return updateKey(ctx, ctxkey)
+}
+
+// UpdateASN reloads the ASN database on the context if the file was modified.
+// It returns true if the database was reloaded.
+func UpdateASN(ctx context.Context) (bool, error) {
+	return updateKey(ctx, asnkey)
+}
+
+func updateKey(ctx context.Context, key any) (bool, error) {
+	d, ok := ctx.Value(key).(*db)
+	if !ok || d.source == "" {
+		return false, nil
+	}
+
+	d.updating.Lock()
+	defer d.updating.Unlock()
+
+	reloaded, err := d.update(ctx)
+	d.mu.Lock()
+	d.checkedAt, d.checkErr = time.Now(), err
+	d.mu.Unlock()
+	return reloaded, err
+}
+
+func (d *db) update(ctx context.Context) (bool, error) {
+	src, err := parseSource(d.source)
+	if err != nil {
+		return false, err
+	}
+
+	switch src.kind {
+	case sourceMaxMind:
+		// Don't check if it was downloaded less than a day ago; MaxMind
+		// updates the databases twice a week.
+		if fi, err := stat(src.path); err != nil || fi.modTime.Before(time.Now().Add(-24*time.Hour)) {
+			hash, err := fetchHash(src.account, src.key)
+			if err != nil {
+				return false, err
+			}
+			if have, _ := os.ReadFile(src.path + ".sha256"); string(have) != hash {
+				log.Module("geo").Info(ctx, "downloading updated GeoDB database")
+				err := fetchDB(src.account, src.key, src.path)
+				if err != nil {
+					return false, err
+				}
+			}
+		}
+	case sourceHTTP:
+		err := fetchMirror(ctx, src.url, src.path)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	fi, err := stat(src.path)
+	if err != nil {
+		return false, err
+	}
+	if fi == d.loaded {
+		return false, nil
+	}
+
+	reader, err := geoip2.Open(src.path)
+	if err != nil {
+		return false, fmt.Errorf("reloading %q: %w", src.path, err)
+	}
+	// The previous reader isn't closed, as it may still be in use; it's
+	// unmapped by the finalizer once it's no longer referenced.
+	d.mu.Lock()
+	d.reader.Store(reader)
+	d.loaded, d.loadedAt = fi, time.Now()
+	d.mu.Unlock()
+	log.Module("geo").Infof(ctx, "reloaded GeoDB database %q", src.path)
+	return true, nil
+}
+
+// Kinds of sources.
+const (
+	sourceFile = iota
+	sourceMaxMind
+	sourceHTTP
+)
+
+type source struct {
+	kind         int
+	path         string // Local path.
+	url          string // For HTTP mirrors.
+	account, key string // For MaxMind.
+}
+
+func parseSource(s string) (source, error) {
+	switch {
+	case s == "":
+		return source{}, errors.New("no source")
+	case strings.HasPrefix(s, "maxmind:"):
+		f := strings.Split(s[8:], ":")
+		if l := len(f); l != 2 && l != 3 {
+			return source{}, fmt.Errorf("invalid format for MaxMind GeoIP update: %q", s)
+		}
+		src := source{kind: sourceMaxMind, account: f[0], key: f[1], path: "goatcounter-data/auto.mmdb"}
+		if len(f) == 3 {
+			src.path = f[2]
+		}
+		return src, nil
+	case strings.HasPrefix(s, "http://"), strings.HasPrefix(s, "https://"):
+		return source{kind: sourceHTTP, url: s, path: "goatcounter-data/auto.mmdb"}, nil
+	default:
+		return source{kind: sourceFile, path: s}, nil
+	}
 }
 
 // Open a geoDB database located at the given path.
@@ -45,6 +276,10 @@ func Get(ctx context.Context) *geoip2.Reader {
 // It will download a database if the path starts with "maxmind:". This needs to
 // be as "maxmind:accountID:licenseKey[:path]", where :path is optional and
 // detaults to goatcounter-data/auto.mmdb.
+//
+// It will download a database from a mirror to goatcounter-data/auto.mmdb if
+// the path starts with "http://" or "https://". The file can be a mmdb file,
+// a gzip'd mmdb file (.gz), or a tarball as distributed by MaxMind (.tar.gz).
 func Open(path string) (*geoip2.Reader, error) {
 	// Use built-in
 	if path == "" {
@@ -65,29 +300,47 @@ func Open(path string) (*geoip2.Reader, error) {
 
 	bundle = nil // Not using it; save some memory.
 
+	src, err := parseSource(path)
+	if err != nil {
+		return nil, err
+	}
 	// Download update
-	if strings.HasPrefix(path, "maxmind:") {
-		s := strings.Split(path[8:], ":")
-		if l := len(s); l != 2 && l != 3 {
-			return nil, fmt.Errorf("invalid format for MaxMind GeoIP update: %q", path)
-		}
-		accountID, key, dst := s[0], s[1], "goatcounter-data/auto.mmdb"
-		if len(s) == 3 {
-			dst = s[2]
-		}
-		st, err := os.Stat(dst)
+	switch src.kind {
+	case sourceMaxMind:
+		st, err := os.Stat(src.path)
 		if err != nil || st.ModTime().Before(time.Now().Add(-24*time.Hour*7)) {
 			log.Module("startup").Info(context.Background(), "downloading GeoDB database; might take a few seconds")
-			err = fetchDB(accountID, key, dst)
+			err = fetchDB(src.account, src.key, src.path)
+			if err != nil {
+				return nil, err
+			}
+		}
+	case sourceHTTP:
+		if _, err := os.Stat(src.path); err != nil {
+			log.Module("startup").Info(context.Background(), "downloading GeoDB database; might take a few seconds")
+			err = fetchMirror(context.Background(), src.url, src.path)
 			if err != nil {
 				return nil, err
 			}
 		}
-		path = dst
 	}
 
 	// From FS
-	return geoip2.Open(path)
+	return geoip2.Open(src.path)
+}
+
+// OpenASN opens an ASN database located at the given path, such as
+// GeoLite2-ASN.
+func OpenASN(path string) (*geoip2.Reader, error) {
+	db, err := geoip2.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.ASN(net.IPv4zero); errors.As(err, new(geoip2.InvalidMethodError)) {
+		db.Close()
+		return nil, fmt.Errorf("%q is not an ASN database: %w", path, err)
+	}
+	return db, nil
 }
 
 func fetch(accountID, key, p string) ([]byte, error) {
@@ -157,10 +410,26 @@ func fetchDB(accountID, key, path string) error {
 	if hh := fmt.Sprintf("%x", h.Sum(nil)); hh != hash {
 		return fmt.Errorf("hash mismatch for %q: have %s, want %s", p, hh, hash)
 	}
-	gz, err := gzip.NewReader(bytes.NewReader(b))
+	err = extractTar(b, tmp)
 	if err != nil {
 		return fmt.Errorf("reading %q: %w", p, err)
 	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("writing %q: %w", tmp.Name(), err)
+	}
+	err = os.Rename(tmp.Name(), path)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path+".sha256", []byte(hash), 0o666)
+}
+
+// extractTar writes the first .mmdb file in the gzip'd tarball to w.
+func extractTar(b []byte, w io.Writer) error {
+	gz, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
 	defer gz.Close()
 	archive := tar.NewReader(gz)
 
@@ -169,17 +438,92 @@ func fetchDB(accountID, key, path string) error {
 		if err != nil {
 			// Don't ignore io.EOF, as reaching this means we haven't seen a
 			// mmdb file
-			return fmt.Errorf("reading %q: %w", p, err)
+			return err
 		}
 		if strings.HasSuffix(h.Name, ".mmdb") {
-			_, err := io.Copy(tmp, archive)
-			if err != nil {
-				return fmt.Errorf("writing %q: %w", tmp.Name(), err)
-			}
-			if err := tmp.Close(); err != nil {
-				return fmt.Errorf("writing %q: %w", tmp.Name(), err)
-			}
-			return os.Rename(tmp.Name(), path)
+			_, err := io.Copy(w, archive)
+			return err
 		}
 	}
 }
+
+// fetchMirror downloads the database from an HTTP mirror to path, if it was
+// modified since the last download.
+func fetchMirror(ctx context.Context, url, path string) error {
+	r, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	r.Header.Add("User-Agent", "GoatCounter/1.0 (+https://github.com/arp242/goatcounter)")
+	if st, err := os.Stat(path); err == nil {
+		r.Header.Set("If-Modified-Since", st.ModTime().UTC().Format(http.TimeFormat))
+	}
+
+	c := http.Client{Timeout: 2 * time.Minute}
+	resp, err := c.Do(r)
+	if err != nil {
+		return fmt.Errorf("fetching %q: %w", url, err)
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return nil
+	case http.StatusOK:
+	default:
+		return fmt.Errorf("fetching %q: %s", url, resp.Status)
+	}
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("fetching %q: %w", url, err)
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0o777)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "update-geodb-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}()
+
+	name := strings.ToLower(resp.Request.URL.Path)
+	switch {
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		err = extractTar(b, tmp)
+	case strings.HasSuffix(name, ".gz"):
+		var gz *gzip.Reader
+		gz, err = gzip.NewReader(bytes.NewReader(b))
+		if err == nil {
+			_, err = io.Copy(tmp, gz)
+		}
+	default:
+		_, err = tmp.Write(b)
+	}
+	if err != nil {
+		return fmt.Errorf("reading %q: %w", url, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("writing %q: %w", tmp.Name(), err)
+	}
+
+	// Make sure it's a valid database before replacing the current one.
+	check, err := geoip2.Open(tmp.Name())
+	if err != nil {
+		return fmt.Errorf("reading %q: %w", url, err)
+	}
+	check.Close()
+
+	mtime := time.Now()
+	if lm, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		mtime = lm
+	}
+	err = os.Chtimes(tmp.Name(), mtime, mtime)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
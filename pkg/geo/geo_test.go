@@ -0,0 +1,151 @@
+package geo
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestUpdate(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	var (
+		mu      sync.Mutex
+		gz      = bundle
+		modTime = time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	)
+	set := func(b []byte, t time.Time) {
+		mu.Lock()
+		defer mu.Unlock()
+		gz, modTime = b, t
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		http.ServeContent(w, r, "GeoLite2-Country.mmdb.gz", modTime, bytes.NewReader(gz))
+	}))
+	defer srv.Close()
+
+	t.Run("mirror", func(t *testing.T) {
+		src := srv.URL + "/GeoLite2-Country.mmdb.gz"
+		db, err := Open(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx := WithSource(context.Background(), db, src)
+
+		reloaded, err := Update(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reloaded || Get(ctx) != db {
+			t.Error("reloaded without changes")
+		}
+
+		set(gz, modTime.Add(time.Hour))
+		reloaded, err = Update(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reloaded || Get(ctx) == db {
+			t.Error("not reloaded")
+		}
+		if Get(ctx).Metadata().DatabaseType == "" {
+			t.Error("no metadata")
+		}
+		if st := GetStatus(ctx); st.CheckedAt.IsZero() || st.Err != nil || st.Path != "goatcounter-data/auto.mmdb" {
+			t.Errorf("wrong status: %#v", st)
+		}
+	})
+
+	t.Run("path", func(t *testing.T) {
+		db, err := Open("goatcounter-data/auto.mmdb")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx := Copy(context.Background(), WithSource(context.Background(), db, "goatcounter-data/auto.mmdb"))
+
+		reloaded, err := Update(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reloaded {
+			t.Error("reloaded without changes")
+		}
+
+		now := time.Now()
+		err = os.Chtimes("goatcounter-data/auto.mmdb", now, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reloaded, err = Update(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reloaded || Get(ctx) == db {
+			t.Error("not reloaded")
+		}
+	})
+
+	t.Run("mirror error", func(t *testing.T) {
+		db, err := Open("goatcounter-data/auto.mmdb")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx := WithSource(context.Background(), db, srv.URL+"/nope.mmdb")
+		set([]byte("not a database"), time.Now().Add(time.Hour))
+
+		_, err = Update(ctx)
+		if err == nil {
+			t.Fatal("no error")
+		}
+		if Get(ctx) != db || GetStatus(ctx).Err == nil {
+			t.Error("database replaced after error")
+		}
+	})
+
+	// GetStatus() shouldn't wait for a download to finish.
+	t.Run("status during download", func(t *testing.T) {
+		var (
+			started = make(chan struct{})
+			release = make(chan struct{})
+		)
+		slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			<-release
+			w.WriteHeader(http.StatusNotModified)
+		}))
+		defer slow.Close()
+
+		db, err := Open("goatcounter-data/auto.mmdb")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx := WithSource(context.Background(), db, slow.URL+"/GeoLite2-Country.mmdb.gz")
+
+		done := make(chan error, 1)
+		go func() {
+			_, err := Update(ctx)
+			done <- err
+		}()
+		<-started
+
+		status := make(chan Status, 1)
+		go func() { status <- GetStatus(ctx) }()
+		select {
+		case <-status:
+		case <-time.After(2 * time.Second):
+			t.Error("GetStatus() blocked during download")
+		}
+
+		close(release)
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+	})
+}
@@ -0,0 +1,534 @@
+// Package oidc implements the parts of OpenID Connect needed to log in users
+// with the authorization code flow.
+//
+// This only uses the ID token; the access token and userinfo endpoint aren't
+// used.
+package oidc
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"zgo.at/errors"
+	"zgo.at/zstd/ztime"
+)
+
+// Client is the HTTP client used to talk to trusted providers, such as those
+// configured by the server administrator.
+var Client = &http.Client{Timeout: 10 * time.Second}
+
+// PublicClient is the HTTP client used to talk to untrusted providers, such as
+// those configured by account administrators. This only connects to public
+// addresses over https, so it can't be used to send requests to the internal
+// network.
+var PublicClient = &http.Client{
+	Timeout: 10 * time.Second,
+	Transport: httpsOnly{&http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 5 * time.Second,
+			Control: publicOnly,
+		}).DialContext,
+		TLSHandshakeTimeout: 5 * time.Second,
+	}},
+}
+
+// ErrNotPublic is returned by PublicClient for requests to non-public
+// addresses or over plain http.
+var ErrNotPublic = errors.New("only https requests to public addresses are allowed")
+
+type httpsOnly struct{ http.RoundTripper }
+
+func (t httpsOnly) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.URL.Scheme != "https" {
+		return nil, ErrNotPublic
+	}
+	return t.RoundTripper.RoundTrip(r)
+}
+
+// Check the address after DNS resolution, so a public hostname can't point to
+// an internal address.
+func publicOnly(network, address string, _ syscall.RawConn) error {
+	ap, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	if !IsPublic(ap.Addr()) {
+		return fmt.Errorf("%w: %s", ErrNotPublic, ap.Addr())
+	}
+	return nil
+}
+
+// Shared address space (RFC 6598), used for carrier-grade NAT and often also
+// internally.
+var sharedSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// IsPublic reports if this is a public unicast address.
+func IsPublic(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsGlobalUnicast() && !addr.IsPrivate() && !sharedSpace.Contains(addr)
+}
+
+// Scopes to request.
+var Scopes = []string{"openid", "email", "profile"}
+
+// Provider is an OpenID Connect provider.
+type Provider struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+
+	client    *http.Client
+	fetchedAt time.Time
+	mu        sync.Mutex
+	keys      map[string]crypto.PublicKey
+	keysAt    time.Time
+}
+
+type providerKey struct {
+	issuer string
+	client *http.Client
+}
+
+var (
+	providers   = make(map[providerKey]*Provider)
+	providersMu sync.Mutex
+)
+
+// Discover gets the provider configuration from the issuer's
+// /.well-known/openid-configuration. This is cached for an hour.
+//
+// The client is used for all requests to this provider; this should be
+// PublicClient unless the issuer is trusted.
+func Discover(ctx context.Context, issuer string, client *http.Client) (*Provider, error) {
+	k := providerKey{issuer, client}
+	providersMu.Lock()
+	p, ok := providers[k]
+	providersMu.Unlock()
+	if ok && time.Since(p.fetchedAt) < time.Hour {
+		return p, nil
+	}
+
+	// Don't hold the lock while fetching, as that would block logins for all
+	// providers if one is slow. The worst that can happen is that it's fetched
+	// more than once.
+	p = &Provider{client: client}
+	err := getJSON(ctx, client, strings.TrimRight(issuer, "/")+"/.well-known/openid-configuration", p)
+	if err != nil {
+		return nil, errors.Wrap(err, "oidc.Discover")
+	}
+	if p.Issuer != issuer {
+		return nil, fmt.Errorf("oidc.Discover: issuer in configuration is %q, but expected %q", p.Issuer, issuer)
+	}
+	if p.AuthorizationEndpoint == "" || p.TokenEndpoint == "" || p.JWKSURI == "" {
+		return nil, fmt.Errorf("oidc.Discover: configuration for %q is missing an endpoint", issuer)
+	}
+
+	p.fetchedAt = time.Now()
+	providersMu.Lock()
+	providers[k] = p
+	providersMu.Unlock()
+	return p, nil
+}
+
+// AuthURL gets the URL to send the user to for logging in.
+//
+// The state, nonce, and verifier should be random values that are stored (e.g.
+// in a cookie) to check the response in Exchange() and Verify().
+func (p *Provider) AuthURL(clientID, redirectURI, state, nonce, verifier string) string {
+	challenge := sha256.Sum256([]byte(verifier))
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {clientID},
+		"redirect_uri":          {redirectURI},
+		"scope":                 {strings.Join(Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(p.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return p.AuthorizationEndpoint + sep + q.Encode()
+}
+
+// Exchange the authorization code for an ID token.
+func (p *Provider) Exchange(ctx context.Context, clientID, clientSecret, redirectURI, code, verifier string) (string, error) {
+	body := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {verifier},
+	}
+	r, err := http.NewRequestWithContext(ctx, "POST", p.TokenEndpoint, strings.NewReader(body.Encode()))
+	if err != nil {
+		return "", errors.Wrap(err, "oidc.Exchange")
+	}
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("Accept", "application/json")
+	r.SetBasicAuth(url.QueryEscape(clientID), url.QueryEscape(clientSecret))
+
+	resp, err := p.client.Do(r)
+	if err != nil {
+		return "", errors.Wrap(err, "oidc.Exchange")
+	}
+	defer resp.Body.Close()
+
+	var tok struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", errors.Wrap(err, "oidc.Exchange")
+	}
+	err = json.Unmarshal(b, &tok)
+	if err != nil {
+		return "", fmt.Errorf("oidc.Exchange: %s: %w", resp.Status, err)
+	}
+	if tok.Error != "" {
+		return "", fmt.Errorf("oidc.Exchange: %s: %s", tok.Error, tok.ErrorDescription)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("oidc.Exchange: %s", resp.Status)
+	}
+	if tok.IDToken == "" {
+		return "", errors.New("oidc.Exchange: no id_token in response")
+	}
+	return tok.IDToken, nil
+}
+
+// Claims in an ID token.
+type Claims map[string]any
+
+// String gets a claim as a string, or "" if it's not a string.
+func (c Claims) String(k string) string {
+	s, _ := c[k].(string)
+	return s
+}
+
+// Bool gets a claim as a bool; the strings "true" and "false" are also
+// accepted, as some providers use that.
+func (c Claims) Bool(k string) (value, ok bool) {
+	switch v := c[k].(type) {
+	case bool:
+		return v, true
+	case string:
+		return v == "true", v == "true" || v == "false"
+	}
+	return false, false
+}
+
+// Strings gets a claim as a list of strings; a single string is also accepted.
+func (c Claims) Strings(k string) []string {
+	switch v := c[k].(type) {
+	case string:
+		return []string{v}
+	case []any:
+		l := make([]string, 0, len(v))
+		for _, vv := range v {
+			if s, ok := vv.(string); ok {
+				l = append(l, s)
+			}
+		}
+		return l
+	}
+	return nil
+}
+
+// Verify the ID token and get the claims from it.
+//
+// The signature, issuer, audience, expiry, and nonce are checked.
+func (p *Provider) Verify(ctx context.Context, token, clientID, nonce string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("oidc.Verify: malformed token")
+	}
+
+	var head struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	err := decodePart(parts[0], &head)
+	if err != nil {
+		return nil, fmt.Errorf("oidc.Verify: header: %w", err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("oidc.Verify: signature: %w", err)
+	}
+
+	key, err := p.key(ctx, head.Kid)
+	if err != nil {
+		return nil, errors.Wrap(err, "oidc.Verify")
+	}
+	err = verifySig(head.Alg, key, []byte(parts[0]+"."+parts[1]), sig)
+	if err != nil {
+		return nil, errors.Wrap(err, "oidc.Verify")
+	}
+
+	var c Claims
+	err = decodePart(parts[1], &c)
+	if err != nil {
+		return nil, fmt.Errorf("oidc.Verify: claims: %w", err)
+	}
+
+	if iss := c.String("iss"); iss != p.Issuer {
+		return nil, fmt.Errorf("oidc.Verify: wrong issuer %q", iss)
+	}
+	if !slices.Contains(c.Strings("aud"), clientID) {
+		return nil, fmt.Errorf("oidc.Verify: token is not for client %q", clientID)
+	}
+	if n := c.String("nonce"); n != nonce || n == "" {
+		return nil, errors.New("oidc.Verify: wrong nonce")
+	}
+
+	const leeway = time.Minute
+	now := ztime.Now(ctx)
+	exp, ok := c["exp"].(float64)
+	if !ok {
+		return nil, errors.New("oidc.Verify: no exp claim")
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(leeway)) {
+		return nil, errors.New("oidc.Verify: token expired")
+	}
+	if iat, ok := c["iat"].(float64); ok && time.Unix(int64(iat), 0).After(now.Add(leeway)) {
+		return nil, errors.New("oidc.Verify: token issued in the future")
+	}
+	return c, nil
+}
+
+// Get the key by ID, fetching the keys again if it's not known. There's no
+// key ID if the provider only has one key.
+func (p *Provider) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	find := func() (crypto.PublicKey, bool) {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if kid == "" && len(p.keys) == 1 {
+			for _, k := range p.keys {
+				return k, true
+			}
+		}
+		k, ok := p.keys[kid]
+		return k, ok
+	}
+
+	if k, ok := find(); ok {
+		return k, nil
+	}
+	// Keys are rotated, so fetch them again if the key isn't known, but not
+	// too often in case someone sends tokens with random key IDs. The lock
+	// isn't held while fetching, so that a slow provider doesn't block
+	// everything.
+	p.mu.Lock()
+	if time.Since(p.keysAt) < 10*time.Second {
+		p.mu.Unlock()
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	p.keysAt = time.Now()
+	p.mu.Unlock()
+
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	err := getJSON(ctx, p.client, p.JWKSURI, &set)
+	if err != nil {
+		return nil, err
+	}
+
+	// Skip keys we can't parse, rather than failing all logins because the
+	// provider added a key we don't understand.
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		pub, err := parseKey(k.Kty, k.Crv, k.N, k.E, k.X, k.Y)
+		if err == nil && pub != nil {
+			keys[k.Kid] = pub
+		}
+	}
+	p.mu.Lock()
+	p.keys = keys
+	p.mu.Unlock()
+
+	if k, ok := find(); ok {
+		return k, nil
+	}
+	return nil, fmt.Errorf("unknown key %q", kid)
+}
+
+// Parse a JWK; unknown key types are skipped.
+func parseKey(kty, crv, n, e, x, y string) (crypto.PublicKey, error) {
+	dec := base64.RawURLEncoding.DecodeString
+	switch kty {
+	case "RSA":
+		nb, err := dec(n)
+		if err != nil {
+			return nil, err
+		}
+		eb, err := dec(e)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(nb), E: int(new(big.Int).SetBytes(eb).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, nil
+		}
+		xb, err := dec(x)
+		if err != nil {
+			return nil, err
+		}
+		yb, err := dec(y)
+		if err != nil {
+			return nil, err
+		}
+		size := (curve.Params().BitSize + 7) / 8
+		if len(xb) > size || len(yb) > size {
+			return nil, errors.New("invalid EC key")
+		}
+		pt := make([]byte, 1+size*2)
+		pt[0] = 4
+		copy(pt[1+size-len(xb):], xb)
+		copy(pt[1+size*2-len(yb):], yb)
+		return ecdsa.ParseUncompressedPublicKey(curve, pt)
+	case "OKP":
+		if crv != "Ed25519" {
+			return nil, nil
+		}
+		xb, err := dec(x)
+		if err != nil {
+			return nil, err
+		}
+		if len(xb) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(xb), nil
+	}
+	return nil, nil
+}
+
+func verifySig(alg string, key crypto.PublicKey, data, sig []byte) error {
+	var h crypto.Hash
+	switch alg {
+	case "RS256", "PS256", "ES256":
+		h = crypto.SHA256
+	case "RS384", "PS384", "ES384":
+		h = crypto.SHA384
+	case "RS512", "PS512", "ES512":
+		h = crypto.SHA512
+	case "EdDSA":
+		k, ok := key.(ed25519.PublicKey)
+		if !ok || !ed25519.Verify(k, data, sig) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+
+	hh := h.New()
+	hh.Write(data)
+	sum := hh.Sum(nil)
+
+	var err error
+	switch alg[0] {
+	case 'R':
+		k, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("wrong key type for %s", alg)
+		}
+		err = rsa.VerifyPKCS1v15(k, h, sum, sig)
+	case 'P':
+		k, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("wrong key type for %s", alg)
+		}
+		err = rsa.VerifyPSS(k, h, sum, sig, nil)
+	case 'E':
+		k, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(sig)%2 != 0 {
+			return fmt.Errorf("wrong key type for %s", alg)
+		}
+		r, s := new(big.Int).SetBytes(sig[:len(sig)/2]), new(big.Int).SetBytes(sig[len(sig)/2:])
+		if !ecdsa.Verify(k, sum, r, s) {
+			err = errors.New("invalid signature")
+		}
+	}
+	if err != nil {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
+
+func decodePart(s string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	d := json.NewDecoder(bytes.NewReader(b))
+	return d.Decode(v)
+}
+
+func getJSON(ctx context.Context, client *http.Client, u string, v any) error {
+	r, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Accept", "application/json")
+	resp, err := client.Do(r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", u, resp.Status)
+	}
+	err = json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+	if err != nil {
+		return fmt.Errorf("%s: %w", u, err)
+	}
+	return nil
+}
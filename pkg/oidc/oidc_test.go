@@ -0,0 +1,164 @@
+package oidc_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"zgo.at/goatcounter/v2/pkg/oidc"
+	"zgo.at/goatcounter/v2/pkg/oidc/oidctest"
+	"zgo.at/zstd/ztest"
+)
+
+func TestLogin(t *testing.T) {
+	ctx := context.Background()
+	srv := oidctest.New(t)
+
+	p, err := oidc.Discover(ctx, srv.URL, oidc.Client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	redirect := "http://example.com/callback"
+	authURL := p.AuthURL(srv.ClientID, redirect, "state", "nonce", "verifier")
+
+	c := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := c.Get(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	loc, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := loc.Query().Get("state"); s != "state" {
+		t.Fatalf("state: %q", s)
+	}
+
+	{ // Wrong verifier.
+		_, err := p.Exchange(ctx, srv.ClientID, srv.ClientSecret, redirect, loc.Query().Get("code"), "wrong")
+		if !ztest.ErrorContains(err, "invalid_grant") {
+			t.Fatalf("wrong error: %v", err)
+		}
+		resp, err := c.Get(authURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		loc, _ = url.Parse(resp.Header.Get("Location"))
+	}
+
+	tok, err := p.Exchange(ctx, srv.ClientID, srv.ClientSecret, redirect, loc.Query().Get("code"), "verifier")
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := p.Verify(ctx, tok, srv.ClientID, "nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := claims.String("email"); e != "oidc@example.com" {
+		t.Errorf("email: %q", e)
+	}
+	if v, ok := claims.Bool("email_verified"); !v || !ok {
+		t.Errorf("email_verified: %t, %t", v, ok)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	ctx := context.Background()
+	srv := oidctest.New(t)
+	// Keys that can't be parsed are skipped.
+	srv.ExtraKeys = []map[string]any{
+		{"kty": "RSA", "kid": "bad", "n": "!!!", "e": "AQAB"},
+		{"kty": "unknown", "kid": "unknown"},
+	}
+	p, err := oidc.Discover(ctx, srv.URL, oidc.Client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr string
+	}{
+		{"ok", srv.Token(map[string]any{"nonce": "n"}), ""},
+		{"audience list", srv.Token(map[string]any{"nonce": "n", "aud": []string{"x", srv.ClientID}}), ""},
+
+		{"malformed", "a.b", "malformed token"},
+		{"nonce", srv.Token(map[string]any{"nonce": "x"}), "wrong nonce"},
+		{"no nonce", srv.Token(nil), "wrong nonce"},
+		{"audience", srv.Token(map[string]any{"nonce": "n", "aud": "x"}), "not for client"},
+		{"issuer", srv.Token(map[string]any{"nonce": "n", "iss": "https://example.com"}), "wrong issuer"},
+		{"expired", srv.Token(map[string]any{"nonce": "n", "exp": time.Now().Add(-time.Hour).Unix()}), "expired"},
+		{"signature", func() string {
+			tok := srv.Token(map[string]any{"nonce": "n"})
+			return tok[:strings.LastIndexByte(tok, '.')+1] + "AAAA"
+		}(), "invalid signature"},
+		{"alg none", func() string {
+			tok := srv.Token(map[string]any{"nonce": "n"})
+			_, rest, _ := strings.Cut(tok, ".")
+			return "eyJhbGciOiJub25lIiwia2lkIjoidGVzdCJ9." + rest
+		}(), "unsupported algorithm"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := p.Verify(ctx, tt.token, srv.ClientID, "n")
+			if !ztest.ErrorContains(err, tt.wantErr) {
+				t.Errorf("wrong error\nhave: %v\nwant: %s", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPublicClient(t *testing.T) {
+	srv := oidctest.New(t)
+	_, err := oidc.Discover(context.Background(), srv.URL, oidc.PublicClient)
+	if !errors.Is(err, oidc.ErrNotPublic) {
+		t.Fatalf("wrong error: %v", err)
+	}
+
+	// Fails on the address rather than the scheme.
+	tls := httptest.NewTLSServer(http.NotFoundHandler())
+	defer tls.Close()
+	_, err = oidc.Discover(context.Background(), tls.URL, oidc.PublicClient)
+	if !errors.Is(err, oidc.ErrNotPublic) {
+		t.Fatalf("wrong error: %v", err)
+	}
+}
+
+func TestIsPublic(t *testing.T) {
+	tests := []struct {
+		in   string
+		want bool
+	}{
+		{"93.184.215.14", true},
+		{"2606:2800:21f:cb07:6820:80da:af6b:8b2c", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.0.0.1", false},
+		{"192.168.1.1", false},
+		{"172.16.0.1", false},
+		{"169.254.169.254", false},
+		{"100.64.0.1", false},
+		{"fd00::1", false},
+		{"fe80::1", false},
+		{"::ffff:127.0.0.1", false},
+		{"0.0.0.0", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			if have := oidc.IsPublic(netip.MustParseAddr(tt.in)); have != tt.want {
+				t.Errorf("have %t; want %t", have, tt.want)
+			}
+		})
+	}
+}
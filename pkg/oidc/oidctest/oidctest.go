@@ -0,0 +1,165 @@
+// Package oidctest provides a mock OpenID Connect provider for tests.
+package oidctest
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Server is a mock OpenID Connect provider.
+//
+// Requests to the authorization endpoint immediately redirect back with a
+// code, as if the user logged in.
+type Server struct {
+	*httptest.Server
+	ClientID     string
+	ClientSecret string
+
+	// Claims to add to the ID token, in addition to iss, aud, exp, iat, and
+	// nonce. The default is a sub and verified email.
+	Claims map[string]any
+
+	// Additional keys to add to the JWKS.
+	ExtraKeys []map[string]any
+
+	key   *rsa.PrivateKey
+	mu    sync.Mutex
+	codes map[string]code
+}
+
+type code struct {
+	nonce, challenge, redirect string
+}
+
+// New creates a new mock provider, which is closed when the test ends.
+func New(t testing.TB) *Server {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{
+		ClientID:     "goatcounter",
+		ClientSecret: "secret",
+		Claims:       map[string]any{"sub": "1", "email": "oidc@example.com", "email_verified": true},
+		key:          key,
+		codes:        make(map[string]code),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, 200, map[string]any{
+			"issuer":                 s.URL,
+			"authorization_endpoint": s.URL + "/authorize",
+			"token_endpoint":         s.URL + "/token",
+			"jwks_uri":               s.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		keys := []any{map[string]any{
+			"kty": "RSA",
+			"kid": "test",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(s.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(s.key.E)).Bytes()),
+		}}
+		for _, k := range s.ExtraKeys {
+			keys = append(keys, k)
+		}
+		writeJSON(w, 200, map[string]any{"keys": keys})
+	})
+	mux.HandleFunc("GET /authorize", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("client_id") != s.ClientID || q.Get("code_challenge_method") != "S256" {
+			http.Error(w, "invalid request", 400)
+			return
+		}
+		c := rand.Text()
+		s.mu.Lock()
+		s.codes[c] = code{q.Get("nonce"), q.Get("code_challenge"), q.Get("redirect_uri")}
+		s.mu.Unlock()
+
+		u, err := url.Parse(q.Get("redirect_uri"))
+		if err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		u.RawQuery = url.Values{"code": {c}, "state": {q.Get("state")}}.Encode()
+		http.Redirect(w, r, u.String(), http.StatusSeeOther)
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		if id != s.ClientID || secret != s.ClientSecret {
+			writeJSON(w, 401, map[string]any{"error": "invalid_client"})
+			return
+		}
+
+		s.mu.Lock()
+		c, ok := s.codes[r.FormValue("code")]
+		delete(s.codes, r.FormValue("code"))
+		s.mu.Unlock()
+
+		challenge := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+		if !ok || c.redirect != r.FormValue("redirect_uri") ||
+			c.challenge != base64.RawURLEncoding.EncodeToString(challenge[:]) {
+			writeJSON(w, 400, map[string]any{"error": "invalid_grant"})
+			return
+		}
+
+		claims := map[string]any{"nonce": c.nonce}
+		for k, v := range s.Claims {
+			claims[k] = v
+		}
+		writeJSON(w, 200, map[string]any{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"id_token":     s.Token(claims),
+		})
+	})
+	s.Server = httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Token creates a signed ID token with the claims; the iss, aud, iat, and exp
+// claims are set if they're not in claims.
+func (s *Server) Token(claims map[string]any) string {
+	c := map[string]any{
+		"iss": s.URL,
+		"aud": s.ClientID,
+		"iat": time.Now().Unix(),
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+	for k, v := range claims {
+		c[k] = v
+	}
+
+	head, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test", "typ": "JWT"})
+	body, _ := json.Marshal(c)
+	data := base64.RawURLEncoding.EncodeToString(head) + "." + base64.RawURLEncoding.EncodeToString(body)
+
+	sum := sha256.Sum256([]byte(data))
+	sig, err := rsa.SignPKCS1v15(nil, s.key, crypto.SHA256, sum[:])
+	if err != nil {
+		panic(err)
+	}
+	return data + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,177 @@
+package webauthn
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// Decode a single CBOR item from b, returning the remaining data.
+//
+// This only supports what's needed for WebAuthn: definite lengths, integers
+// (as int64), byte strings, text strings, arrays, maps, tags (which are
+// ignored), and simple values. Authenticators always use the "CTAP2 canonical"
+// encoding, which doesn't use indefinite lengths.
+func decodeCBOR(b []byte) (any, []byte, error) {
+	return decodeCBORDepth(b, 0)
+}
+
+func decodeCBORDepth(b []byte, depth int) (any, []byte, error) {
+	if depth > 16 {
+		return nil, nil, fmt.Errorf("cbor: nested too deeply")
+	}
+	if len(b) == 0 {
+		return nil, nil, fmt.Errorf("cbor: unexpected end of data")
+	}
+
+	major, info := b[0]>>5, b[0]&0x1f
+	b = b[1:]
+
+	// Simple values and floats use the additional info directly.
+	if major == 7 {
+		switch info {
+		case 20:
+			return false, b, nil
+		case 21:
+			return true, b, nil
+		case 22, 23:
+			return nil, b, nil
+		case 25:
+			if len(b) < 2 {
+				return nil, nil, fmt.Errorf("cbor: unexpected end of data")
+			}
+			return halfFloat(binary.BigEndian.Uint16(b)), b[2:], nil
+		case 26:
+			if len(b) < 4 {
+				return nil, nil, fmt.Errorf("cbor: unexpected end of data")
+			}
+			return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), b[4:], nil
+		case 27:
+			if len(b) < 8 {
+				return nil, nil, fmt.Errorf("cbor: unexpected end of data")
+			}
+			return math.Float64frombits(binary.BigEndian.Uint64(b)), b[8:], nil
+		default:
+			return nil, nil, fmt.Errorf("cbor: unsupported simple value %d", info)
+		}
+	}
+
+	var n uint64
+	switch {
+	case info < 24:
+		n = uint64(info)
+	case info == 24:
+		if len(b) < 1 {
+			return nil, nil, fmt.Errorf("cbor: unexpected end of data")
+		}
+		n, b = uint64(b[0]), b[1:]
+	case info == 25:
+		if len(b) < 2 {
+			return nil, nil, fmt.Errorf("cbor: unexpected end of data")
+		}
+		n, b = uint64(binary.BigEndian.Uint16(b)), b[2:]
+	case info == 26:
+		if len(b) < 4 {
+			return nil, nil, fmt.Errorf("cbor: unexpected end of data")
+		}
+		n, b = uint64(binary.BigEndian.Uint32(b)), b[4:]
+	case info == 27:
+		if len(b) < 8 {
+			return nil, nil, fmt.Errorf("cbor: unexpected end of data")
+		}
+		n, b = binary.BigEndian.Uint64(b), b[8:]
+	default:
+		return nil, nil, fmt.Errorf("cbor: indefinite length or reserved value %d", info)
+	}
+
+	switch major {
+	case 0:
+		if n > math.MaxInt64 {
+			return nil, nil, fmt.Errorf("cbor: integer overflow")
+		}
+		return int64(n), b, nil
+	case 1:
+		if n > math.MaxInt64 {
+			return nil, nil, fmt.Errorf("cbor: integer overflow")
+		}
+		return -1 - int64(n), b, nil
+	case 2, 3:
+		if n > uint64(len(b)) {
+			return nil, nil, fmt.Errorf("cbor: unexpected end of data")
+		}
+		if major == 2 {
+			return b[:n:n], b[n:], nil
+		}
+		return string(b[:n]), b[n:], nil
+	case 4:
+		if n > uint64(len(b)) { // Every item is at least one byte.
+			return nil, nil, fmt.Errorf("cbor: unexpected end of data")
+		}
+		arr := make([]any, 0, n)
+		for range n {
+			var (
+				v   any
+				err error
+			)
+			v, b, err = decodeCBORDepth(b, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			arr = append(arr, v)
+		}
+		return arr, b, nil
+	case 5:
+		if n > uint64(len(b)) {
+			return nil, nil, fmt.Errorf("cbor: unexpected end of data")
+		}
+		m := make(map[any]any, n)
+		for range n {
+			var (
+				k, v any
+				err  error
+			)
+			k, b, err = decodeCBORDepth(b, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			switch k.(type) {
+			case int64, string:
+			default:
+				return nil, nil, fmt.Errorf("cbor: unsupported map key type %T", k)
+			}
+			v, b, err = decodeCBORDepth(b, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			m[k] = v
+		}
+		return m, b, nil
+	case 6:
+		return decodeCBORDepth(b, depth+1)
+	}
+	panic("unreachable")
+}
+
+func halfFloat(h uint16) float64 {
+	var (
+		exp  = int(h>>10) & 0x1f
+		mant = float64(h & 0x3ff)
+		v    float64
+	)
+	switch exp {
+	case 0:
+		v = math.Ldexp(mant, -24)
+	case 31:
+		if mant == 0 {
+			v = math.Inf(1)
+		} else {
+			v = math.NaN()
+		}
+	default:
+		v = math.Ldexp(mant+1024, exp-25)
+	}
+	if h&0x8000 != 0 {
+		return -v
+	}
+	return v
+}
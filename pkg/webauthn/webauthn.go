@@ -0,0 +1,321 @@
+// Package webauthn implements the server side of WebAuthn registration and
+// authentication ceremonies.
+//
+// Attestation statements aren't verified; there's no need to restrict which
+// authenticators can be used, so every credential is treated as if it used the
+// "none" attestation format.
+package webauthn
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	_ "crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math/big"
+
+	"zgo.at/errors"
+)
+
+// Flags in the authenticator data.
+const (
+	FlagUserPresent    = 0x01
+	FlagUserVerified   = 0x04
+	FlagBackupEligible = 0x08
+	FlagBackedUp       = 0x10
+	FlagAttestedData   = 0x40
+	FlagExtensionData  = 0x80
+)
+
+// COSE algorithm identifiers we support, in order of preference.
+const (
+	AlgES256 = -7
+	AlgEdDSA = -8
+	AlgES384 = -35
+	AlgES512 = -36
+	AlgPS256 = -37
+	AlgRS256 = -257
+)
+
+// Algorithms is the list of supported algorithms, for the pubKeyCredParams
+// option.
+var Algorithms = []int{AlgES256, AlgEdDSA, AlgES384, AlgES512, AlgPS256, AlgRS256}
+
+// RelyingParty is the site the credentials are for.
+type RelyingParty struct {
+	// ID is the domain, without scheme or port.
+	ID string
+
+	// Origin is the scheme, host, and port; for example
+	// "https://example.com:8080".
+	Origin string
+}
+
+// Credential is a registered public key credential.
+type Credential struct {
+	ID           []byte // Credential ID, as chosen by the authenticator.
+	PublicKey    []byte // COSE_Key.
+	SignCount    uint32
+	UserVerified bool // Whether the user was verified with e.g. a PIN or biometric.
+	BackedUp     bool // Synced passkey.
+}
+
+// Assertion is the result of an authentication ceremony.
+type Assertion struct {
+	SignCount    uint32
+	UserVerified bool
+}
+
+// NewChallenge creates a new random challenge, encoded as base64url.
+func NewChallenge() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+type clientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+func (rp RelyingParty) checkClientData(typ, challenge string, clientDataJSON []byte) error {
+	var c clientData
+	err := json.Unmarshal(clientDataJSON, &c)
+	if err != nil {
+		return fmt.Errorf("clientDataJSON: %w", err)
+	}
+	if c.Type != typ {
+		return fmt.Errorf("wrong type %q", c.Type)
+	}
+	if challenge == "" || subtle.ConstantTimeCompare([]byte(c.Challenge), []byte(challenge)) != 1 {
+		return errors.New("wrong challenge")
+	}
+	if c.Origin != rp.Origin || c.CrossOrigin {
+		return fmt.Errorf("wrong origin %q", c.Origin)
+	}
+	return nil
+}
+
+type authData struct {
+	flags     byte
+	signCount uint32
+	credID    []byte
+	key       []byte
+}
+
+func (rp RelyingParty) parseAuthData(b []byte) (authData, error) {
+	if len(b) < 37 {
+		return authData{}, errors.New("authenticator data too short")
+	}
+	rpHash := sha256.Sum256([]byte(rp.ID))
+	if subtle.ConstantTimeCompare(b[:32], rpHash[:]) != 1 {
+		return authData{}, errors.New("wrong relying party ID")
+	}
+
+	a := authData{flags: b[32], signCount: binary.BigEndian.Uint32(b[33:37])}
+	if a.flags&FlagUserPresent == 0 {
+		return authData{}, errors.New("user not present")
+	}
+	if a.flags&FlagAttestedData == 0 {
+		return a, nil
+	}
+
+	b = b[37:]
+	if len(b) < 18 {
+		return authData{}, errors.New("attested credential data too short")
+	}
+	n := int(binary.BigEndian.Uint16(b[16:18]))
+	b = b[18:]
+	if n == 0 || n > 1023 || len(b) < n {
+		return authData{}, errors.New("invalid credential ID length")
+	}
+	a.credID, b = b[:n:n], b[n:]
+
+	_, rest, err := decodeCBOR(b)
+	if err != nil {
+		return authData{}, fmt.Errorf("credential public key: %w", err)
+	}
+	a.key = b[: len(b)-len(rest) : len(b)-len(rest)]
+	return a, nil
+}
+
+// Register verifies the response from navigator.credentials.create() and
+// returns the new credential.
+func (rp RelyingParty) Register(challenge string, clientDataJSON, attestationObject []byte) (*Credential, error) {
+	err := rp.checkClientData("webauthn.create", challenge, clientDataJSON)
+	if err != nil {
+		return nil, errors.Wrap(err, "webauthn.Register")
+	}
+
+	obj, _, err := decodeCBOR(attestationObject)
+	if err != nil {
+		return nil, errors.Wrap(err, "webauthn.Register: attestationObject")
+	}
+	m, _ := obj.(map[any]any)
+	raw, ok := m["authData"].([]byte)
+	if !ok {
+		return nil, errors.New("webauthn.Register: no authData in attestationObject")
+	}
+
+	a, err := rp.parseAuthData(raw)
+	if err != nil {
+		return nil, errors.Wrap(err, "webauthn.Register")
+	}
+	if a.credID == nil {
+		return nil, errors.New("webauthn.Register: no attested credential data")
+	}
+	if _, _, err := parseKey(a.key); err != nil {
+		return nil, errors.Wrap(err, "webauthn.Register")
+	}
+
+	return &Credential{
+		ID:           a.credID,
+		PublicKey:    a.key,
+		SignCount:    a.signCount,
+		UserVerified: a.flags&FlagUserVerified != 0,
+		BackedUp:     a.flags&FlagBackedUp != 0,
+	}, nil
+}
+
+// Login verifies the response from navigator.credentials.get() for the
+// credential with the given public key.
+//
+// The sign count should be checked by the caller: if it's not 0 it should be
+// higher than the previously stored sign count, as the credential may be
+// cloned otherwise.
+func (rp RelyingParty) Login(challenge string, publicKey, clientDataJSON, authenticatorData, signature []byte) (*Assertion, error) {
+	err := rp.checkClientData("webauthn.get", challenge, clientDataJSON)
+	if err != nil {
+		return nil, errors.Wrap(err, "webauthn.Login")
+	}
+	a, err := rp.parseAuthData(authenticatorData)
+	if err != nil {
+		return nil, errors.Wrap(err, "webauthn.Login")
+	}
+
+	key, alg, err := parseKey(publicKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "webauthn.Login")
+	}
+	sum := sha256.Sum256(clientDataJSON)
+	data := append(bytes.Clone(authenticatorData), sum[:]...)
+	err = verifySig(alg, key, data, signature)
+	if err != nil {
+		return nil, errors.Wrap(err, "webauthn.Login")
+	}
+
+	return &Assertion{
+		SignCount:    a.signCount,
+		UserVerified: a.flags&FlagUserVerified != 0,
+	}, nil
+}
+
+// Parse a COSE_Key.
+func parseKey(b []byte) (crypto.PublicKey, int, error) {
+	v, _, err := decodeCBOR(b)
+	if err != nil {
+		return nil, 0, fmt.Errorf("public key: %w", err)
+	}
+	m, ok := v.(map[any]any)
+	if !ok {
+		return nil, 0, errors.New("public key: not a map")
+	}
+	var (
+		kty, _ = m[int64(1)].(int64)
+		alg, _ = m[int64(3)].(int64)
+		crv, _ = m[int64(-1)].(int64)
+	)
+
+	switch kty {
+	case 1: // OKP
+		x, _ := m[int64(-2)].([]byte)
+		if alg != AlgEdDSA || crv != 6 || len(x) != ed25519.PublicKeySize {
+			return nil, 0, errors.New("public key: unsupported OKP key")
+		}
+		return ed25519.PublicKey(x), int(alg), nil
+	case 2: // EC2
+		var (
+			x, _  = m[int64(-2)].([]byte)
+			y, _  = m[int64(-3)].([]byte)
+			curve elliptic.Curve
+			want  int64
+		)
+		switch crv {
+		case 1:
+			curve, want = elliptic.P256(), AlgES256
+		case 2:
+			curve, want = elliptic.P384(), AlgES384
+		case 3:
+			curve, want = elliptic.P521(), AlgES512
+		default:
+			return nil, 0, fmt.Errorf("public key: unsupported curve %d", crv)
+		}
+		if alg != want {
+			return nil, 0, fmt.Errorf("public key: algorithm %d doesn't match curve %d", alg, crv)
+		}
+		size := (curve.Params().BitSize + 7) / 8
+		if len(x) != size || len(y) != size {
+			return nil, 0, errors.New("public key: invalid EC2 key")
+		}
+		k, err := ecdsa.ParseUncompressedPublicKey(curve, append(append([]byte{4}, x...), y...))
+		if err != nil {
+			return nil, 0, fmt.Errorf("public key: %w", err)
+		}
+		return k, int(alg), nil
+	case 3: // RSA
+		n, _ := m[int64(-1)].([]byte)
+		e, _ := m[int64(-2)].([]byte)
+		if (alg != AlgRS256 && alg != AlgPS256) || len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, 0, errors.New("public key: unsupported RSA key")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, int(alg), nil
+	default:
+		return nil, 0, fmt.Errorf("public key: unsupported key type %d", kty)
+	}
+}
+
+func verifySig(alg int, key crypto.PublicKey, data, sig []byte) error {
+	if alg == AlgEdDSA {
+		if !ed25519.Verify(key.(ed25519.PublicKey), data, sig) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	}
+
+	h := crypto.SHA256
+	switch alg {
+	case AlgES384:
+		h = crypto.SHA384
+	case AlgES512:
+		h = crypto.SHA512
+	}
+	hh := h.New()
+	hh.Write(data)
+	sum := hh.Sum(nil)
+
+	var ok bool
+	switch alg {
+	case AlgES256, AlgES384, AlgES512:
+		// WebAuthn uses ASN.1 DER signatures for ECDSA, unlike JOSE.
+		ok = ecdsa.VerifyASN1(key.(*ecdsa.PublicKey), sum, sig)
+	case AlgRS256:
+		ok = rsa.VerifyPKCS1v15(key.(*rsa.PublicKey), h, sum, sig) == nil
+	case AlgPS256:
+		ok = rsa.VerifyPSS(key.(*rsa.PublicKey), h, sum, sig, nil) == nil
+	}
+	if !ok {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
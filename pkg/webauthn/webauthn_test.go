@@ -0,0 +1,151 @@
+package webauthn
+
+import (
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"zgo.at/goatcounter/v2/pkg/webauthn/webauthntest"
+	"zgo.at/zstd/ztest"
+)
+
+func TestDecodeCBOR(t *testing.T) {
+	// From RFC 8949 appendix A.
+	tests := []struct {
+		in      string
+		want    any
+		wantErr string
+	}{
+		{"00", int64(0), ""},
+		{"17", int64(23), ""},
+		{"1818", int64(24), ""},
+		{"1903e8", int64(1000), ""},
+		{"1b000000e8d4a51000", int64(1000000000000), ""},
+		{"20", int64(-1), ""},
+		{"3903e7", int64(-1000), ""},
+		{"f4", false, ""},
+		{"f5", true, ""},
+		{"f6", nil, ""},
+		{"f93c00", 1.0, ""},
+		{"f9c400", -4.0, ""},
+		{"fa47c35000", 100000.0, ""},
+		{"40", []byte{}, ""},
+		{"4401020304", []byte{1, 2, 3, 4}, ""},
+		{"6449455446", "IETF", ""},
+		{"83010203", []any{int64(1), int64(2), int64(3)}, ""},
+		{"a201020304", map[any]any{int64(1): int64(2), int64(3): int64(4)}, ""},
+		{"a26161016162820203", map[any]any{"a": int64(1), "b": []any{int64(2), int64(3)}}, ""},
+		{"c11a514b67b0", int64(1363896240), ""},
+
+		{"", nil, "unexpected end"},
+		{"1a0000", nil, "unexpected end"},
+		{"450102", nil, "unexpected end"},
+		{"5f42010243030405ff", nil, "indefinite length"},
+		{"9bffffffffffffffff", nil, "unexpected end"},
+		{"a1f401", nil, "unsupported map key"},
+		{"818181818181818181818181818181818181", nil, "nested too deeply"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			in, err := hex.DecodeString(tt.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			have, rest, err := decodeCBOR(in)
+			if !ztest.ErrorContains(err, tt.wantErr) {
+				t.Fatalf("wrong error: %v", err)
+			}
+			if tt.wantErr != "" {
+				return
+			}
+			if len(rest) != 0 {
+				t.Errorf("rest: %x", rest)
+			}
+			if !reflect.DeepEqual(have, tt.want) {
+				t.Errorf("\nhave: %#v\nwant: %#v", have, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegisterLogin(t *testing.T) {
+	var (
+		rp   = RelyingParty{ID: "example.com", Origin: "https://example.com"}
+		auth = webauthntest.New(true)
+	)
+
+	{ // Wrong challenge and origin.
+		challenge := NewChallenge()
+		clientData, att := auth.Create(rp.ID, rp.Origin, challenge)
+		_, err := rp.Register(NewChallenge(), clientData, att)
+		if !ztest.ErrorContains(err, "wrong challenge") {
+			t.Fatalf("wrong error: %v", err)
+		}
+		clientData, att = auth.Create(rp.ID, "https://example.net", challenge)
+		_, err = rp.Register(challenge, clientData, att)
+		if !ztest.ErrorContains(err, "wrong origin") {
+			t.Fatalf("wrong error: %v", err)
+		}
+		clientData, att = auth.Create("example.net", rp.Origin, challenge)
+		_, err = rp.Register(challenge, clientData, att)
+		if !ztest.ErrorContains(err, "wrong relying party") {
+			t.Fatalf("wrong error: %v", err)
+		}
+	}
+
+	challenge := NewChallenge()
+	clientData, att := auth.Create(rp.ID, rp.Origin, challenge)
+	cred, err := rp.Register(challenge, clientData, att)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cred.ID, auth.ID) || !cred.UserVerified {
+		t.Errorf("%#v", cred)
+	}
+
+	tests := []struct {
+		name    string
+		modify  func(clientData, authData, sig []byte) ([]byte, []byte, []byte)
+		wantErr string
+	}{
+		{"ok", nil, ""},
+		{"signature", func(c, a, s []byte) ([]byte, []byte, []byte) {
+			s[len(s)-1] ^= 0xff
+			return c, a, s
+		}, "invalid signature"},
+		{"sign count", func(c, a, s []byte) ([]byte, []byte, []byte) {
+			a[36]++
+			return c, a, s
+		}, "invalid signature"},
+		{"type", func(c, a, s []byte) ([]byte, []byte, []byte) {
+			return []byte(`{"type":"webauthn.create"}`), a, s
+		}, "wrong type"},
+		{"user not present", func(c, a, s []byte) ([]byte, []byte, []byte) {
+			a[32] &^= FlagUserPresent
+			return c, a, s
+		}, "user not present"},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			challenge := NewChallenge()
+			c, a, s := auth.Get(rp.ID, rp.Origin, challenge, i%2 == 0)
+			if tt.modify != nil {
+				c, a, s = tt.modify(c, a, s)
+			}
+
+			have, err := rp.Login(challenge, cred.PublicKey, c, a, s)
+			if !ztest.ErrorContains(err, tt.wantErr) {
+				t.Fatalf("wrong error: %v", err)
+			}
+			if tt.wantErr != "" {
+				return
+			}
+			if h := fmt.Sprintf("%d %t", have.SignCount, have.UserVerified); h != fmt.Sprintf("%d %t", auth.SignCount, i%2 == 0) {
+				t.Errorf("%s", h)
+			}
+		})
+	}
+}
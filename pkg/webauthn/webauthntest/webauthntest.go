@@ -0,0 +1,143 @@
+// Package webauthntest provides a virtual WebAuthn authenticator for tests.
+package webauthntest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"slices"
+)
+
+// Authenticator is a virtual authenticator with a single ES256 credential.
+type Authenticator struct {
+	ID        []byte // Credential ID.
+	SignCount uint32 // Signature counter, incremented on every Get() if Count is set.
+	Count     bool
+
+	key *ecdsa.PrivateKey
+}
+
+// New creates a new authenticator; the sign count is incremented on every
+// signature if count is true.
+func New(count bool) *Authenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	id := make([]byte, 16)
+	rand.Read(id)
+	return &Authenticator{ID: id, Count: count, key: key}
+}
+
+// Create a credential, as navigator.credentials.create() does.
+func (a *Authenticator) Create(rpID, origin, challenge string) (clientDataJSON, attestationObject []byte) {
+	clientDataJSON = clientData("webauthn.create", origin, challenge)
+
+	pub, err := a.key.PublicKey.Bytes()
+	if err != nil {
+		panic(err)
+	}
+	key := encode(map[int]any{1: 2, 3: -7, -1: 1, -2: pub[1:33], -3: pub[33:]})
+
+	cred := make([]byte, 16) // AAGUID
+	cred = binary.BigEndian.AppendUint16(cred, uint16(len(a.ID)))
+	cred = append(cred, a.ID...)
+	cred = append(cred, key...)
+
+	authData := a.authData(rpID, 0x01|0x04|0x40, cred)
+	return clientDataJSON, encode(map[string]any{
+		"fmt":      "none",
+		"attStmt":  map[string]any{},
+		"authData": authData,
+	})
+}
+
+// Get an assertion, as navigator.credentials.get() does.
+func (a *Authenticator) Get(rpID, origin, challenge string, userVerified bool) (clientDataJSON, authenticatorData, signature []byte) {
+	clientDataJSON = clientData("webauthn.get", origin, challenge)
+
+	flags := byte(0x01)
+	if userVerified {
+		flags |= 0x04
+	}
+	if a.Count {
+		a.SignCount++
+	}
+	authenticatorData = a.authData(rpID, flags, nil)
+
+	sum := sha256.Sum256(clientDataJSON)
+	h := sha256.Sum256(append(slices.Clone(authenticatorData), sum[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, h[:])
+	if err != nil {
+		panic(err)
+	}
+	return clientDataJSON, authenticatorData, signature
+}
+
+func (a *Authenticator) authData(rpID string, flags byte, cred []byte) []byte {
+	h := sha256.Sum256([]byte(rpID))
+	b := append(h[:], flags)
+	b = binary.BigEndian.AppendUint32(b, a.SignCount)
+	return append(b, cred...)
+}
+
+func clientData(typ, origin, challenge string) []byte {
+	b, _ := json.Marshal(map[string]any{"type": typ, "challenge": challenge, "origin": origin, "crossOrigin": false})
+	return b
+}
+
+// Encode as CBOR; only supports the types used here.
+func encode(v any) []byte {
+	head := func(major byte, n int) []byte {
+		switch {
+		case n < 24:
+			return []byte{major<<5 | byte(n)}
+		case n < 256:
+			return []byte{major<<5 | 24, byte(n)}
+		default:
+			return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(n))
+		}
+	}
+
+	switch vv := v.(type) {
+	case int:
+		if vv < 0 {
+			return head(1, -1-vv)
+		}
+		return head(0, vv)
+	case []byte:
+		return append(head(2, len(vv)), vv...)
+	case string:
+		return append(head(3, len(vv)), vv...)
+	case map[int]any:
+		b := head(5, len(vv))
+		// Canonical order isn't needed, but keep it stable.
+		keys := make([]int, 0, len(vv))
+		for k := range vv {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		for _, k := range keys {
+			b = append(b, encode(k)...)
+			b = append(b, encode(vv[k])...)
+		}
+		return b
+	case map[string]any:
+		b := head(5, len(vv))
+		keys := make([]string, 0, len(vv))
+		for k := range vv {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		for _, k := range keys {
+			b = append(b, encode(k)...)
+			b = append(b, encode(vv[k])...)
+		}
+		return b
+	default:
+		panic("webauthntest.encode: unsupported type")
+	}
+}
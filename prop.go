@@ -0,0 +1,158 @@
+package goatcounter
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"slices"
+	"strconv"
+
+	"zgo.at/errors"
+	"zgo.at/zdb"
+	"zgo.at/zvalidate"
+)
+
+// Limits for custom properties.
+const (
+	maxProps        = 10
+	maxPropKeyLen   = 64
+	maxPropValueLen = 256
+	maxPropKeys     = 50  // Distinct keys per site.
+	maxPropValues   = 100 // Distinct values per key per site.
+)
+
+// PropOther is the value used for properties once a key has more than
+// maxPropValues distinct values, and the key and value used once a site has
+// more than maxPropKeys distinct keys.
+const PropOther = "(other)"
+
+// Props are custom properties for a pageview or event, such as "plan=pro" or
+// "variant=B".
+type Props map[string]string
+
+// UnmarshalText parses the props as a JSON object, for the query string.
+func (p *Props) UnmarshalText(v []byte) error { return p.UnmarshalJSON(v) }
+
+// UnmarshalJSON parses the props as a JSON object; numbers and booleans are
+// converted to a string.
+func (p *Props) UnmarshalJSON(v []byte) error {
+	var m map[string]any
+	err := json.Unmarshal(v, &m)
+	if err != nil {
+		return errors.Errorf("props: %w", err)
+	}
+
+	*p = make(Props, len(m))
+	for k, vv := range m {
+		switch vv := vv.(type) {
+		case string:
+			(*p)[k] = vv
+		case nil:
+			(*p)[k] = ""
+		case float64:
+			(*p)[k] = strconv.FormatFloat(vv, 'f', -1, 64)
+		case bool:
+			(*p)[k] = strconv.FormatBool(vv)
+		default:
+			return errors.Errorf("props: invalid value for %q: must be a string, number, or boolean", k)
+		}
+	}
+	return nil
+}
+
+func (p Props) validate(v *zvalidate.Validator) {
+	if len(p) > maxProps {
+		v.Append("props", "can have at most "+strconv.Itoa(maxProps)+" properties")
+	}
+	for k, vv := range p {
+		v.UTF8("props", k)
+		v.UTF8("props", vv)
+		v.Len("props", k, 1, maxPropKeyLen)
+		v.Len("props", vv, 0, maxPropValueLen)
+	}
+}
+
+type PropID int32
+
+// Prop is a custom property key/value pair.
+type Prop struct {
+	ID    PropID `db:"prop_id,id" json:"id"`
+	Site  SiteID `db:"site_id" json:"-"`
+	Key   string `db:"key" json:"key"`
+	Value string `db:"value" json:"value"`
+}
+
+func (Prop) Table() string { return "props" }
+
+func (p *Prop) GetOrInsert(ctx context.Context) error {
+	p.Site = MustGetSite(ctx).ID
+	k := strconv.Itoa(int(p.Site)) + "\x00" + p.Key + "\x00" + p.Value
+	c, ok := cacheProps(ctx).Get(k)
+	if ok {
+		*p = c
+		cacheProps(ctx).Touch(k)
+		return nil
+	}
+
+	err := zdb.Get(ctx, &p.ID, `/* Prop.GetOrInsert */
+		select prop_id from props where site_id = ? and key = ? and value = ?`,
+		p.Site, p.Key, p.Value)
+	if zdb.ErrNoRows(err) {
+		err = p.insert(ctx)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "Prop.GetOrInsert(%q, %q)", p.Key, p.Value)
+	}
+	cacheProps(ctx).Set(k, *p)
+	return nil
+}
+
+// insert a new prop, collapsing the key and/or value to "(other)" if there are
+// too many already; sending something like a user ID or timestamp would
+// otherwise add a new row for every pageview.
+func (p *Prop) insert(ctx context.Context) error {
+	var n int
+	err := zdb.Get(ctx, &n, `/* Prop.insert */
+		select count(*) from props where site_id = ? and key = ?`,
+		p.Site, p.Key)
+	if err != nil {
+		return err
+	}
+
+	if n == 0 && p.Key != PropOther {
+		err := zdb.Get(ctx, &n, `/* Prop.insert */
+			select count(distinct key) from props where site_id = ?`,
+			p.Site)
+		if err != nil {
+			return err
+		}
+		if n >= maxPropKeys {
+			p.Key, p.Value = PropOther, PropOther
+			return p.GetOrInsert(ctx)
+		}
+	} else if n >= maxPropValues && p.Value != PropOther {
+		p.Value = PropOther
+		return p.GetOrInsert(ctx)
+	}
+	return zdb.Insert(ctx, p)
+}
+
+// getOrInsertProps gets the IDs for all props, inserting them if they don't
+// exist yet.
+func getOrInsertProps(ctx context.Context, props Props) ([]PropID, error) {
+	if len(props) == 0 {
+		return nil, nil
+	}
+	ids := make([]PropID, 0, len(props))
+	for _, k := range slices.Sorted(maps.Keys(props)) {
+		p := Prop{Key: k, Value: props[k]}
+		err := p.GetOrInsert(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if !slices.Contains(ids, p.ID) { // Can be the same if collapsed to "(other)".
+			ids = append(ids, p.ID)
+		}
+	}
+	return ids, nil
+}
@@ -0,0 +1,231 @@
+package goatcounter_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	. "zgo.at/goatcounter/v2"
+	"zgo.at/goatcounter/v2/gctest"
+	"zgo.at/zstd/ztest"
+	"zgo.at/zstd/ztime"
+)
+
+func TestPropsUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr string
+	}{
+		{`{}`, `map[]`, ""},
+		{`{"plan": "pro"}`, `map[plan:pro]`, ""},
+		{`{"plan": "pro", "n": 42, "f": 1.5, "b": true, "x": null}`, `map[b:true f:1.5 n:42 plan:pro x:]`, ""},
+		{`{"a": ["x"]}`, `map[]`, `invalid value for "a"`},
+		{`{"a": {"b": "c"}}`, `map[]`, `invalid value for "a"`},
+		{`["x"]`, `map[]`, `props: json`},
+		{`xx`, `map[]`, `invalid character`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			var p Props
+			err := json.Unmarshal([]byte(tt.in), &p)
+			if !ztest.ErrorContains(err, tt.wantErr) {
+				t.Fatalf("wrong error\nhave: %v\nwant: %v", err, tt.wantErr)
+			}
+			if tt.wantErr != "" {
+				return
+			}
+			if have := fmt.Sprintf("%v", p); have != tt.want {
+				t.Errorf("\nhave: %s\nwant: %s", have, tt.want)
+			}
+
+			p = nil
+			err = p.UnmarshalText([]byte(tt.in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if have := fmt.Sprintf("%v", p); have != tt.want {
+				t.Errorf("UnmarshalText\nhave: %s\nwant: %s", have, tt.want)
+			}
+		})
+	}
+}
+
+func TestPropsValidate(t *testing.T) {
+	ctx := gctest.DB(t)
+
+	many := make(Props)
+	for i := range 11 {
+		many[fmt.Sprintf("k%d", i)] = "v"
+	}
+
+	tests := []struct {
+		in      Props
+		wantErr string
+	}{
+		{nil, ""},
+		{Props{"plan": "pro", "empty": ""}, ""},
+		{many, "can have at most 10 properties"},
+		{Props{"": "x"}, "props: must be longer than 1 characters"},
+		{Props{strings.Repeat("k", 65): "x"}, "props: must be shorter than 64 characters"},
+		{Props{"k": strings.Repeat("v", 257)}, "props: must be shorter than 256 characters"},
+		{Props{"k": "\xff"}, "props: must be UTF-8"},
+	}
+	for _, tt := range tests {
+		t.Run("", func(t *testing.T) {
+			h := Hit{Path: "/", Props: tt.in}
+			h.Defaults(ctx, true)
+			err := h.Validate(ctx, true)
+			if !ztest.ErrorContains(err, tt.wantErr) {
+				t.Errorf("wrong error\nhave: %v\nwant: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHitStatsListProps(t *testing.T) {
+	ctx := gctest.DB(t)
+
+	gctest.StoreHits(ctx, t, false,
+		Hit{FirstVisit: true, Props: Props{"plan": "pro", "variant": "B"}},
+		Hit{FirstVisit: true, Props: Props{"plan": "pro"}},
+		Hit{FirstVisit: true, Props: Props{"plan": "free"}},
+		Hit{FirstVisit: false, Props: Props{"plan": "free"}},
+		Hit{FirstVisit: true})
+
+	rng := ztime.NewRange(ztime.Now(ctx)).To(ztime.Now(ctx))
+
+	var stats HitStats
+	err := stats.ListProps(ctx, rng, PathFilter{}, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	have := fmt.Sprintf("%v", stats.Stats)
+	want := "[{ plan 3 <nil>} { variant 1 <nil>}]"
+	if have != want {
+		t.Errorf("\nhave: %s\nwant: %s", have, want)
+	}
+
+	stats = HitStats{}
+	err = stats.ListProp(ctx, "plan", rng, PathFilter{}, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	have = fmt.Sprintf("%v", stats.Stats)
+	want = "[{ pro 2 <nil>} { free 1 <nil>}]"
+	if have != want {
+		t.Errorf("\nhave: %s\nwant: %s", have, want)
+	}
+
+	stats = HitStats{}
+	err = stats.ListProps(ctx, rng, PathFilter{}, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats.Stats) != 1 || !stats.More {
+		t.Errorf("wrong limit: %v %t", stats.Stats, stats.More)
+	}
+}
+
+func TestPropGetOrInsertOther(t *testing.T) {
+	ctx := gctest.DB(t)
+
+	// The first 100 values are stored as-is.
+	for i := range 100 {
+		p := Prop{Key: "user", Value: fmt.Sprintf("%d", i)}
+		err := p.GetOrInsert(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Value != fmt.Sprintf("%d", i) {
+			t.Fatalf("value %d: %q", i, p.Value)
+		}
+	}
+
+	// New values after that are collapsed to "(other)".
+	var other PropID
+	for _, v := range []string{"100", "101", "100"} {
+		p := Prop{Key: "user", Value: v}
+		err := p.GetOrInsert(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Value != PropOther {
+			t.Errorf("value %s: %q", v, p.Value)
+		}
+		if other == 0 {
+			other = p.ID
+		}
+		if p.ID != other {
+			t.Errorf("value %s: ID %d; want %d", v, p.ID, other)
+		}
+	}
+
+	// Existing values and other keys are still stored.
+	for _, p := range []Prop{{Key: "user", Value: "42"}, {Key: "plan", Value: "pro"}} {
+		want := p.Value
+		err := p.GetOrInsert(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Value != want {
+			t.Errorf("%s: %q; want %q", p.Key, p.Value, want)
+		}
+	}
+}
+
+func TestPropGetOrInsertOtherKey(t *testing.T) {
+	ctx := gctest.DB(t)
+
+	// The first 50 keys are stored as-is.
+	for i := range 50 {
+		p := Prop{Key: fmt.Sprintf("k%d", i), Value: "v"}
+		err := p.GetOrInsert(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Key != fmt.Sprintf("k%d", i) {
+			t.Fatalf("key %d: %q", i, p.Key)
+		}
+	}
+
+	// New keys after that are collapsed to "(other)".
+	var other PropID
+	for _, k := range []string{"k50", "k51", "k50"} {
+		p := Prop{Key: k, Value: "v"}
+		err := p.GetOrInsert(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Key != PropOther || p.Value != PropOther {
+			t.Errorf("key %s: %q=%q", k, p.Key, p.Value)
+		}
+		if other == 0 {
+			other = p.ID
+		}
+		if p.ID != other {
+			t.Errorf("key %s: ID %d; want %d", k, p.ID, other)
+		}
+	}
+
+	// New values for existing keys are still stored.
+	p := Prop{Key: "k1", Value: "new"}
+	err := p.GetOrInsert(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Key != "k1" || p.Value != "new" {
+		t.Errorf("%q=%q", p.Key, p.Value)
+	}
+
+	// Props collapsed to the same "(other)" are only counted once per hit.
+	h := Hit{Path: "/", Props: Props{"x1": "a", "x2": "b"}}
+	err = h.Defaults(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(h.PropIDs) != 1 || h.PropIDs[0] != other {
+		t.Errorf("PropIDs: %v", h.PropIDs)
+	}
+}
@@ -15,8 +15,8 @@
 		if (!USER_SETTINGS.language)
 			USER_SETTINGS.language = 'en'
 
-		;[report_errors, bind_tooltip, bind_confirm, translate_calendar, onetime].forEach((f) => f.call())
-		;[page_dashboard, page_settings_main, page_settings_batchpurge, page_user_pref, page_user_api, page_user_dashboard, page_bosmang]
+		;[report_errors, bind_tooltip, bind_confirm, bind_webauthn, translate_calendar, onetime].forEach((f) => f.call())
+		;[page_dashboard, page_settings_main, page_settings_batchpurge, page_settings_users, page_user_pref, page_user_api, page_user_auth, page_user_dashboard, page_bosmang]
 			.forEach((f) => document.body.id.match(new RegExp('^' + f.name.replace(/_/g, '-'))) && f.call())
 	})
 
@@ -111,6 +111,53 @@
 		})
 	}
 
+	// WebAuthn uses ArrayBuffers, which we send as base64url.
+	let b64url_decode = (s) => Uint8Array.from(atob(s.replace(/-/g, '+').replace(/_/g, '/')), (c) => c.charCodeAt(0)),
+		b64url_encode = (b) => btoa(String.fromCharCode(...new Uint8Array(b))).replace(/\+/g, '-').replace(/\//g, '_').replace(/=+$/, '')
+
+	// POST for the WebAuthn ceremonies; errors are displayed in err.
+	let webauthn_post = function(url, data, err, success) {
+		jQuery.ajax({
+			url:      BASE_PATH + url,
+			method:   'POST',
+			dataType: 'json',
+			global:   false,
+			data:     data,
+			success:  success,
+			error:    (xhr) => err.text(xhr.responseJSON?.error || xhr.responseText || xhr.statusText),
+		})
+	}
+
+	// Sign in with a security key or passkey; this is a second factor if
+	// there's a loginmac, or a passwordless login if there isn't.
+	let bind_webauthn = function() {
+		if (!window.PublicKeyCredential)
+			return $('.webauthn-login').parent().remove()
+
+		$('.webauthn-login').on('click', function(e) {
+			e.preventDefault()
+			let btn = $(this),
+				err = btn.parent().find('.webauthn-error').text(''),
+				mfa = {loginmac: btn.attr('data-loginmac') || '', user_logintoken: btn.attr('data-user_logintoken') || ''}
+
+			webauthn_post('/user/webauthn/login-begin', mfa, err, (opts) => {
+				navigator.credentials.get({publicKey: {
+					challenge:        b64url_decode(opts.challenge),
+					rpId:             opts.rpId,
+					userVerification: opts.userVerification,
+					allowCredentials: opts.allowCredentials.map((c) => ({type: c.type, id: b64url_decode(c.id)})),
+				}}).then((cred) => {
+					webauthn_post('/user/webauthn/login', {...mfa,
+						id:                 cred.id,
+						client_data:        b64url_encode(cred.response.clientDataJSON),
+						authenticator_data: b64url_encode(cred.response.authenticatorData),
+						signature:          b64url_encode(cred.response.signature),
+					}, err, (data) => { location.href = data.redirect })
+				}).catch((e) => err.text(e.message))
+			})
+		})
+	}
+
 	// One-time messages.
 	let onetime = function() {
 		$('.onetime').each((_, elem) => {
@@ -185,6 +232,14 @@
 		})
 	}
 
+	let page_settings_users = () => {
+		// Per-site access doesn't do anything for admins.
+		$('.global-access input').on('change', () => {
+			let v = $('.global-access input:checked').val()
+			$('#access-sites').css('display', v === 'a' || v === '*' ? 'none' : 'block')
+		}).trigger('change')
+	}
+
 	var page_user_pref = function() {
 		// Set the timezone based on the browser's timezone.
 		$('#set-local-tz').on('click', function(e) {
@@ -226,6 +281,30 @@
 		$('.allsites input').trigger('change')
 	}
 
+	let page_user_auth = function() {
+		// Register a new security key.
+		$('#webauthn-add').on('submit', function(e) {
+			e.preventDefault()
+			let form = $(this),
+				err  = form.find('.webauthn-error').text('')
+
+			webauthn_post('/user/webauthn/register-begin', {csrf: CSRF}, err, (opts) => {
+				opts.challenge          = b64url_decode(opts.challenge)
+				opts.user.id            = b64url_decode(opts.user.id)
+				opts.excludeCredentials = opts.excludeCredentials.map((c) => ({type: c.type, id: b64url_decode(c.id)}))
+
+				navigator.credentials.create({publicKey: opts}).then((cred) => {
+					webauthn_post('/user/webauthn/register', {
+						csrf:               CSRF,
+						name:               form.find('[name="name"]').val(),
+						client_data:        b64url_encode(cred.response.clientDataJSON),
+						attestation_object: b64url_encode(cred.response.attestationObject),
+					}, err, () => location.reload())
+				}).catch((e) => err.text(e.message))
+			})
+		})
+	}
+
 	var page_user_dashboard = function() {
 		// Add new widget.
 		$('.widget-add-new select').on('change', function(e) {
@@ -26,7 +26,8 @@
 
 		opt.line = Object.assign({width: 2, color: '#f00', fill: '#fdd'}, opt.line)
 		opt.bar  = Object.assign({color: '#f00'}, opt.bar)
-		opt      = Object.assign({mode: 'line', max: 0, pad: 2, background: style('bg'), grid: [2.5, 22.5, 47.5]}, opt)
+		opt.mark = Object.assign({color: '#00f'}, opt.mark)
+		opt      = Object.assign({mode: 'line', max: 0, pad: 2, background: style('bg'), grid: [2.5, 22.5, 47.5], marks: []}, opt)
 
 		if (opt.max === 0)
 			opt.max = data.reduce((a, b) => b > a ? b : a)
@@ -53,6 +54,8 @@
 			draw_barchart(ctx, relData, barWidth, cWidth, cHeight, pad, opt.bar)
 		else
 			draw_linechart(ctx, relData, barWidth, cWidth, cHeight, pad, opt.line)
+		if (opt.marks.length)
+			draw_marks(ctx, opt.marks, barWidth, cHeight, pad, opt.mode === 'bar', opt.mark)
 
 		let self = {}
 
@@ -201,6 +204,22 @@
 		ctx.fill()
 	}
 
+	// Draw a dashed vertical line for every mark; marks are an index in data.
+	let draw_marks = function(ctx, marks, barWidth, cHeight, pad, isBar, opt) {
+		ctx.strokeStyle = opt.color
+		ctx.lineWidth   = 1
+		ctx.setLineDash([3, 2])
+
+		marks.forEach((m) => {
+			let x = Math.round(pad + barWidth*m + (isBar ? barWidth/2 : 0)) + .5
+			ctx.beginPath()
+			ctx.moveTo(x, pad)
+			ctx.lineTo(x, cHeight - pad)
+			ctx.stroke()
+		})
+		ctx.setLineDash([])
+	}
+
 	// Draw linechart.
 	let draw_linechart = function(ctx, data, barWidth, cWidth, cHeight, pad, opt) {
 		ctx.strokeStyle = opt.color
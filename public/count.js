@@ -11,12 +11,28 @@
 		try         { var set = JSON.parse(s.dataset.goatcounterSettings) }
 		catch (err) { console.error('invalid JSON in data-goatcounter-settings: ' + err) }
 		for (var k in set)
-			if (['no_onload', 'no_events', 'allow_local', 'allow_frame', 'path', 'title', 'referrer', 'event'].indexOf(k) > -1)
+			if (['no_onload', 'no_events', 'allow_local', 'allow_frame', 'path', 'title', 'referrer', 'event', 'props'].indexOf(k) > -1)
 				window.goatcounter[k] = set[k]
 	}
 
 	var enc = encodeURIComponent
 
+	// User-Agent Client Hints; Chromium browsers send a "frozen" User-Agent
+	// header, and the Sec-CH-UA headers often aren't sent to third-party
+	// domains. The platform version is only available asynchronously; it's
+	// sent if it's resolved by the time we count, but we don't wait for it.
+	var hints = {}
+	if (navigator.userAgentData) {
+		var uad = navigator.userAgentData
+		hints.ch  = (uad.brands || []).map(function(b) { return '"' + b.brand + '";v="' + b.version + '"' }).join(', ')
+		hints.chp = uad.platform
+		hints.chm = uad.mobile ? '?1' : '?0'
+		if (uad.getHighEntropyValues)
+			uad.getHighEntropyValues(['platformVersion']).then(
+				function(v) { hints.chpv = v.platformVersion },
+				function()  {})
+	}
+
 	// Get all data we're going to send off to the counter endpoint.
 	window.goatcounter.get_data = function(vars) {
 		vars = vars || {}
@@ -39,6 +55,14 @@
 		if (is_empty(data.t)) data.t = document.title
 		if (is_empty(data.p)) data.p = get_path()
 		if (vars.no_session) data.ns = (typeof(vars.no_session) === 'function' ? vars.no_session(false) : vars.no_session)
+		var props = (vars.props === undefined ? goatcounter.props : vars.props)
+		if (props && typeof(props) === 'object') data.pr = JSON.stringify(props)
+		if (!is_empty(vars.value)) {
+			data.v  = vars.value
+			data.cu = vars.currency
+		}
+		for (var k in hints)
+			data[k] = hints[k]
 
 		if (rcb) data.r = rcb(data.r)
 		if (tcb) data.t = tcb(data.t)
@@ -179,12 +203,20 @@
 
 		var send = function(elem) {
 			return function() {
+				var props
+				if (elem.dataset.goatcounterProps) {
+					try         { props = JSON.parse(elem.dataset.goatcounterProps) }
+					catch (err) { console.error('invalid JSON in data-goatcounter-props: ' + err) }
+				}
 				goatcounter.count({
 					event:      true,
 					path:       (elem.dataset.goatcounterClick || elem.name || elem.id || ''),
 					title:      (elem.dataset.goatcounterTitle || elem.title || (elem.innerHTML || '').substr(0, 200) || ''),
 					referrer:   (elem.dataset.goatcounterReferrer || elem.dataset.goatcounterReferral || ''),
 					no_session: ['1', 't', 'true'].indexOf((elem.dataset.goatcounterNoSession || '').toLowerCase()) !== -1,
+					props:      props,
+					value:      elem.dataset.goatcounterValue,
+					currency:   elem.dataset.goatcounterCurrency,
 				})
 			}
 		}
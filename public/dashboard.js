@@ -170,7 +170,7 @@
 		$('#dash-form').trigger('submit')
 	}
 
-	let filter_kw = /(\b(?:at:start|at:end|is:event|is:pageview|in:path|in:title)|\B:not)\b/g
+	let filter_kw = /(\b(?:at:start|at:end|is:event|is:pageview|in:path|in:title|channel:\w+)|\B:not)\b/g
 
 	// Highlight a filter pattern in the path and title.
 	let highlight_filter = () => {
@@ -604,6 +604,9 @@
 			data = stats.map((s) => [s.monthly]).reduce((a, b) => a.concat(b))
 		}
 
+		let annotations = find_annotations(c, stats, hourly, weekly || monthly).filter((a) => a.i < data.length),
+			revenue     = find_revenue(c, stats, weekly || monthly)
+
 		var chart = charty(ctx, data, {
 			mode: isBar ? 'bar' : 'line',
 			max:  max,
@@ -612,7 +615,13 @@
 				fill:  style('chart-fill'),
 				width: daily || weekly || monthly || ndays <= 14 ? 1.5 : 1
 			},
-			bar:  {color: style('chart-line')},
+			bar:   {color: style('chart-line')},
+			mark:  {color: style('chart-mark')},
+			marks: annotations.map((a) => a.i),
+			done:  (chart) => {
+				draw_anomalies(chart, c, stats, hourly ? 24 : 1, weekly || monthly)
+				draw_revenue(chart, revenue, hourly ? 24 : 1)
+			},
 		})
 		charts.push(chart)
 
@@ -664,6 +673,21 @@
 				}
 			}
 
+			let anomaly = find_anomaly(c, stats, hourly ? Math.floor(i / 24) : i, weekly || monthly)
+			if (anomaly)
+				title += '<br>' + T(`dashboard/anomaly-${anomaly.kind}`, {
+					count:    format_int(anomaly.count),
+					expected: format_int(Math.round(anomaly.expected)),
+				})
+			annotations.filter((a) => a.i === i).forEach((a) => {
+				title += '<br>' + $('<span>').text(a.label).html()
+			})
+			let rev = revenue[hourly ? Math.floor(i / 24) : i]
+			if (rev)
+				title += '<br>' + T('dashboard/totals/revenue', {
+					revenue: `${rev.toFixed(2)} ${$('<span>').text(c.dataset.revenueCurrency).html()}`,
+				})
+
 			tip.remove()
 			tip.html(title)
 			$('body').append(tip)
@@ -698,6 +722,89 @@
 		})
 	}
 
+	// Find the anomaly for stats[i]; if grouped then it's the first anomaly in
+	// that week or month.
+	var find_anomaly = function(c, stats, i, grouped) {
+		if (!c.dataset.anomalies || !stats[i])
+			return
+		let anomalies = JSON.parse(c.dataset.anomalies),
+			next      = stats[i+1] ? stats[i+1].day : '9999'
+		if (!grouped)
+			return anomalies.find((a) => a.day === stats[i].day)
+		return anomalies.find((a) => a.day >= stats[i].day && a.day < next)
+	}
+
+	// Find the annotations for this chart, with the index in the chart data; if
+	// grouped then it's the week or month the annotation is in.
+	var find_annotations = function(c, stats, hourly, grouped) {
+		if (!c.dataset.annotations)
+			return []
+		return JSON.parse(c.dataset.annotations).map((a) => {
+			let i = stats.findIndex((s, j) => grouped
+				? a.day >= s.day && a.day < (stats[j+1] ? stats[j+1].day : '9999')
+				: a.day === s.day)
+			if (i === -1)
+				return
+			return {i: hourly ? i*24 + a.hour : i, label: a.label}
+		}).filter((a) => a)
+	}
+
+	// Get the revenue for every entry in stats; if grouped then it's the sum for
+	// that week or month.
+	var find_revenue = function(c, stats, grouped) {
+		if (!c.dataset.revenue)
+			return []
+		let days = JSON.parse(c.dataset.revenue)
+		return stats.map((s, i) => {
+			let next = stats[i+1] ? stats[i+1].day : '9999'
+			return Object.keys(days).
+				filter((d) => grouped ? (d >= s.day && d < next) : d === s.day).
+				reduce((sum, d) => sum + days[d], 0)
+		})
+	}
+
+	// Draw the revenue as a line over the chart, scaled to the highest value.
+	var draw_revenue = function(chart, revenue, per) {
+		let max = Math.max(0, ...revenue)
+		if (max === 0)
+			return
+
+		let ctx     = chart.ctx(),
+			w       = chart.barWidth() * per,
+			pad     = chart.pad(),
+			cHeight = ctx.canvas.height / Math.max(1, window.devicePixelRatio || 1)
+		ctx.beginPath()
+		ctx.strokeStyle = style('chart-revenue')
+		ctx.lineWidth   = 1.5
+		revenue.forEach((r, i) => {
+			let p = Math.max(0, r) / max * 100
+			ctx.lineTo(Math.round(pad + w*i + w/2), (cHeight + pad - p/2) * (1 - pad/cHeight*2))
+		})
+		ctx.stroke()
+	}
+
+	// Mark days with an anomaly with a small triangle at the top of the chart.
+	var draw_anomalies = function(chart, c, stats, per, grouped) {
+		if (!c.dataset.anomalies)
+			return
+
+		let ctx = chart.ctx(),
+			w   = chart.barWidth() * per,
+			pad = chart.pad()
+		stats.forEach((s, i) => {
+			let a = find_anomaly(c, stats, i, grouped)
+			if (!a)
+				return
+			let x = pad + w*i + w/2
+			ctx.beginPath()
+			ctx.fillStyle = style(a.kind === 'spike' ? 'plus' : 'minus')
+			ctx.moveTo(x - 4, pad)
+			ctx.lineTo(x + 4, pad)
+			ctx.lineTo(x, pad + 6)
+			ctx.fill()
+		})
+	}
+
 	// Translate country and language names; we do this in JavaScript with Intl,
 	// which works fairly well and keeps the backend/database a lot simpler.
 	let translate_locations = function() {
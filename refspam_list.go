@@ -0,0 +1,388 @@
+package goatcounter
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"zgo.at/errors"
+	"zgo.at/zdb"
+	"zgo.at/zstd/ztime"
+)
+
+// RefspamServer is the SiteID for server-wide refspam entries.
+const RefspamServer SiteID = 0
+
+// Refspam states.
+const (
+	RefspamBlocked = "b" // Block referrals from this host.
+	RefspamReview  = "r" // Detected as likely spam, but needs review.
+	RefspamAllowed = "a" // Reviewed and not spam; won't be detected again.
+)
+
+// Refspam sources.
+const (
+	RefspamSourceBuiltin  = "builtin"
+	RefspamSourceUser     = "user"
+	RefspamSourceImport   = "import"
+	RefspamSourceDetected = "detected"
+)
+
+type RefspamID int32
+
+// Refspam is a host for which referrals are ignored.
+//
+// The SiteID is RefspamServer for entries that apply to all sites.
+type Refspam struct {
+	ID        RefspamID `db:"refspam_id,id" json:"id"`
+	SiteID    SiteID    `db:"site_id" json:"site_id"`
+	Host      string    `db:"host" json:"host"`
+	Source    string    `db:"source" json:"source"`
+	State     string    `db:"state" json:"state"`
+	Note      string    `db:"note" json:"note"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+}
+
+func (Refspam) Table() string { return "refspam" }
+
+var _ zdb.Defaulter = &Refspam{}
+
+func (r *Refspam) Defaults(ctx context.Context) {
+	r.Host = normalizeRefspamHost(r.Host)
+	if r.Source == "" {
+		r.Source = RefspamSourceUser
+	}
+	if r.State == "" {
+		r.State = RefspamBlocked
+	}
+	if r.CreatedAt.IsZero() {
+		r.CreatedAt = ztime.Now(ctx)
+	}
+}
+
+var _ zdb.Validator = &Refspam{}
+
+func (r *Refspam) Validate(ctx context.Context) error {
+	v := NewValidate(ctx)
+	v.Required("host", r.Host)
+	v.Hostname("host", r.Host)
+	v.Include("state", r.State, []string{RefspamBlocked, RefspamReview, RefspamAllowed})
+	v.Include("source", r.Source, []string{RefspamSourceBuiltin, RefspamSourceUser,
+		RefspamSourceImport, RefspamSourceDetected})
+	return v.ErrorOrNil()
+}
+
+// Insert a new entry.
+func (r *Refspam) Insert(ctx context.Context) error {
+	err := zdb.Insert(ctx, r)
+	if err != nil {
+		if zdb.ErrUnique(err) {
+			return errors.Wrap(fmt.Errorf("%q is already in the list", r.Host), "Refspam.Insert")
+		}
+		return errors.Wrap(err, "Refspam.Insert")
+	}
+	cacheRefspam(ctx).Delete(r.SiteID)
+	return nil
+}
+
+// ByID gets an entry by ID; this can be either for the current site or a
+// server-wide entry.
+func (r *Refspam) ByID(ctx context.Context, id RefspamID) error {
+	err := zdb.Get(ctx, r, `select * from refspam where refspam_id=$1 and site_id in ($2, $3)`,
+		id, RefspamServer, MustGetSite(ctx).ID)
+	return errors.Wrapf(err, "Refspam.ByID(%d)", id)
+}
+
+// UpdateState sets a new state.
+func (r *Refspam) UpdateState(ctx context.Context, state string) error {
+	r.State = state
+	err := zdb.Update(ctx, r, "state")
+	if err != nil {
+		return errors.Wrap(err, "Refspam.UpdateState")
+	}
+	cacheRefspam(ctx).Delete(r.SiteID)
+	return nil
+}
+
+// Delete this entry.
+func (r *Refspam) Delete(ctx context.Context) error {
+	err := zdb.Exec(ctx, `delete from refspam where refspam_id=$1`, r.ID)
+	if err != nil {
+		return errors.Wrapf(err, "Refspam.Delete(%d)", r.ID)
+	}
+	cacheRefspam(ctx).Delete(r.SiteID)
+	return nil
+}
+
+type Refspams []Refspam
+
+// List entries for the site, or server-wide entries if siteID is
+// RefspamServer.
+//
+// The query matches a part of the host if not empty.
+func (r *Refspams) List(ctx context.Context, siteID SiteID, state, query string, limit int) error {
+	if query != "" {
+		query = "%" + strings.ToLower(query) + "%"
+	}
+	err := zdb.Select(ctx, r, `/* Refspams.List */
+		select * from refspam
+		where site_id = :site and state = :state
+		{{:query and host like :query}}
+		order by host
+		limit :limit`,
+		map[string]any{
+			"site":  siteID,
+			"state": state,
+			"query": query,
+			"limit": limit,
+		})
+	return errors.Wrap(err, "Refspams.List")
+}
+
+// Count gets the number of blocked hosts.
+func (r Refspams) Count(ctx context.Context, siteID SiteID) (int, error) {
+	var n int
+	err := zdb.Get(ctx, &n, `select count(*) from refspam where site_id=$1 and state=$2`,
+		siteID, RefspamBlocked)
+	return n, errors.Wrap(err, "Refspams.Count")
+}
+
+// Import hosts from a list; this is one host per line, and lines starting with
+// # are ignored. Hosts that already exist are skipped.
+//
+// It returns the number of hosts in the list.
+func (r Refspams) Import(ctx context.Context, siteID SiteID, source string, fp io.Reader) (int, error) {
+	var (
+		hosts = make(map[string]struct{})
+		scan  = bufio.NewScanner(fp)
+		v     = NewValidate(ctx)
+		n     int
+	)
+	for scan.Scan() {
+		n++
+		line := strings.TrimSpace(scan.Text())
+		if i := strings.IndexByte(line, '#'); i > -1 {
+			line = strings.TrimSpace(line[:i])
+		}
+		if line == "" {
+			continue
+		}
+		h := normalizeRefspamHost(line)
+		v.Hostname(fmt.Sprintf("line %d", n), h)
+		hosts[h] = struct{}{}
+	}
+	if err := scan.Err(); err != nil {
+		return 0, errors.Wrap(err, "Refspams.Import")
+	}
+	if v.HasErrors() {
+		return 0, v
+	}
+
+	err := insertRefspam(ctx, siteID, source, slices.Sorted(maps.Keys(hosts)))
+	if err != nil {
+		return 0, errors.Wrap(err, "Refspams.Import")
+	}
+	return len(hosts), nil
+}
+
+// SeedRefspam inserts the built-in list of spam hosts as server-wide entries.
+// Existing entries are never modified.
+func SeedRefspam(ctx context.Context) (int, error) {
+	err := insertRefspam(ctx, RefspamServer, RefspamSourceBuiltin, slices.Sorted(maps.Keys(refspam)))
+	if err != nil {
+		return 0, errors.Wrap(err, "SeedRefspam")
+	}
+	return len(refspam), nil
+}
+
+func insertRefspam(ctx context.Context, siteID SiteID, source string, hosts []string) error {
+	if len(hosts) == 0 {
+		return nil
+	}
+	ins, err := zdb.NewBulkInsert(ctx, "refspam", []string{"site_id", "host", "source", "state", "created_at"})
+	if err != nil {
+		return err
+	}
+	ins.OnConflict(`on conflict do nothing`)
+	now := ztime.Now(ctx)
+	for _, h := range hosts {
+		ins.Values(siteID, h, source, RefspamBlocked, now)
+	}
+	err = ins.Finish()
+	if err != nil {
+		return err
+	}
+	cacheRefspam(ctx).Delete(siteID)
+	return nil
+}
+
+// refspamHosts gets all blocked hosts for the site from the cache, loading it
+// if needed.
+func refspamHosts(ctx context.Context, siteID SiteID) map[string]struct{} {
+	if h, ok := cacheRefspam(ctx).Get(siteID); ok {
+		return h
+	}
+
+	var hosts []string
+	err := zdb.Select(ctx, &hosts, `select host from refspam where site_id=$1 and state=$2`,
+		siteID, RefspamBlocked)
+	if err != nil {
+		// Don't cache, so it will retry on the next pageview.
+		refspamlog.Error(ctx, err, "site", siteID)
+		return nil
+	}
+
+	m := make(map[string]struct{}, len(hosts))
+	for _, h := range hosts {
+		m[h] = struct{}{}
+	}
+	cacheRefspam(ctx).Set(siteID, m)
+	return m
+}
+
+// isRefspam reports if this host is blocked for this site, either server-wide
+// or for the site.
+func isRefspam(ctx context.Context, siteID SiteID, host string) bool {
+	return matchRefspam(strings.ToLower(host), refspamHosts(ctx, RefspamServer), refspamHosts(ctx, siteID))
+}
+
+// matchRefspam reports if the host or any of its parent domains is in any of
+// the lists.
+func matchRefspam(host string, lists ...map[string]struct{}) bool {
+	for host != "" {
+		for _, l := range lists {
+			if _, ok := l[host]; ok {
+				return true
+			}
+		}
+		i := strings.IndexByte(host, '.')
+		if i == -1 {
+			break
+		}
+		host = host[i+1:]
+	}
+	return false
+}
+
+// normalizeRefspamHost gets the host from a hostname or URL.
+func normalizeRefspamHost(h string) string {
+	h = strings.ToLower(strings.TrimSpace(h))
+	if strings.Contains(h, "://") {
+		if u, err := url.Parse(h); err == nil {
+			h = u.Hostname()
+		}
+	}
+	if i := strings.IndexByte(h, '/'); i > -1 {
+		h = h[:i]
+	}
+	return strings.TrimSuffix(h, ".")
+}
+
+// Thresholds for DetectRefspam.
+var (
+	RefspamDetectSites = 5
+	RefspamDetectDays  = 1
+)
+
+// DetectRefspam finds referrers that are likely spam and adds them as
+// server-wide entries for review.
+//
+// A referrer is flagged if it sent visitors to at least RefspamDetectSites
+// sites in the last RefspamDetectDays days, and every session from it had just
+// a single pageview. Sessions are attributed to the first external referrer in
+// the session, as later pageviews have an internal referrer or none at all. Hosts that are already in the list (in any state) are
+// never flagged again.
+func DetectRefspam(ctx context.Context) (int, error) {
+	var rows []struct {
+		Ref      string `db:"ref"`
+		SiteID   SiteID `db:"site_id"`
+		Sessions int    `db:"sessions"`
+		Depth    int    `db:"depth"`
+	}
+	err := zdb.Select(ctx, &rows, `/* DetectRefspam */
+		with s as (
+			select site_id, session, count(*) as n
+			from hits
+			where created_at >= :start and session is not null
+			group by site_id, session
+		), ext as (
+			select hits.site_id, hits.session, min(hits.hit_id) as hit_id
+			from hits
+			join refs using (ref_id)
+			where hits.created_at >= :start and hits.session is not null and
+				hits.ref_id != 1 and refs.ref_scheme = :scheme
+			group by hits.site_id, hits.session
+		)
+		select refs.ref, s.site_id, count(*) as sessions, max(s.n) as depth
+		from ext
+		join s     on s.site_id = ext.site_id and s.session = ext.session
+		join hits  on hits.hit_id = ext.hit_id
+		join refs  on refs.ref_id = hits.ref_id
+		group by refs.ref, s.site_id`,
+		map[string]any{
+			"start":  ztime.Now(ctx).Add(-time.Duration(RefspamDetectDays) * 24 * time.Hour),
+			"scheme": RefSchemeHTTP,
+		})
+	if err != nil {
+		return 0, errors.Wrap(err, "DetectRefspam")
+	}
+
+	type stat struct {
+		sites    map[SiteID]struct{}
+		sessions int
+		deep     bool
+	}
+	hosts := make(map[string]*stat)
+	for _, r := range rows {
+		h := normalizeRefspamHost(r.Ref)
+		if h == "" {
+			continue
+		}
+		st, ok := hosts[h]
+		if !ok {
+			st = &stat{sites: make(map[SiteID]struct{})}
+			hosts[h] = st
+		}
+		st.sites[r.SiteID] = struct{}{}
+		st.sessions += r.Sessions
+		st.deep = st.deep || r.Depth > 1
+	}
+
+	var n int
+	for _, h := range slices.Sorted(maps.Keys(hosts)) {
+		st := hosts[h]
+		if st.deep || len(st.sites) < RefspamDetectSites {
+			continue
+		}
+
+		var exists bool
+		err := zdb.Get(ctx, &exists, `select exists(select 1 from refspam where site_id=$1 and host=$2)`,
+			RefspamServer, h)
+		if err != nil {
+			return n, errors.Wrap(err, "DetectRefspam")
+		}
+		if exists || matchRefspam(h, refspamHosts(ctx, RefspamServer)) {
+			continue
+		}
+
+		r := Refspam{
+			SiteID: RefspamServer,
+			Host:   h,
+			Source: RefspamSourceDetected,
+			State:  RefspamReview,
+			Note:   fmt.Sprintf("%d sites, %d sessions with one pageview", len(st.sites), st.sessions),
+		}
+		err = r.Insert(ctx)
+		if err != nil {
+			return n, errors.Wrap(err, "DetectRefspam")
+		}
+		n++
+	}
+	return n, nil
+}
@@ -0,0 +1,100 @@
+package goatcounter_test
+
+import (
+	"strings"
+	"testing"
+
+	. "zgo.at/goatcounter/v2"
+	"zgo.at/goatcounter/v2/gctest"
+	"zgo.at/zdb"
+	"zgo.at/zstd/zint"
+)
+
+func TestRefspamList(t *testing.T) {
+	ctx := gctest.DB(t)
+	site := MustGetSite(ctx)
+
+	e := Refspam{SiteID: site.ID, Host: "https://Spam.Example.com/path"}
+	err := e.Insert(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Host != "spam.example.com" {
+		t.Errorf("host not normalized: %q", e.Host)
+	}
+
+	var list Refspams
+	n, err := list.Import(ctx, site.ID, RefspamSourceImport, strings.NewReader(
+		"# Comment\n\nother.example.com\nspam.example.com  # Already exists\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("imported %d", n)
+	}
+
+	_, err = list.Import(ctx, site.ID, RefspamSourceImport, strings.NewReader("not a host!\n"))
+	if err == nil {
+		t.Error("no error for invalid host")
+	}
+
+	gctest.StoreHits(ctx, t, false,
+		Hit{Ref: "https://adcash.com/x"},             // Built-in list.
+		Hit{Ref: "https://www.spam.example.com/x"},   // Site list.
+		Hit{Ref: "https://other.example.com"},        // Imported.
+		Hit{Ref: "https://not-spam.example.com/foo"}) // Not in any list.
+
+	var refs []string
+	err = zdb.Select(ctx, &refs, `select ref from hits join refs using (ref_id)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refs) != 1 || refs[0] != "not-spam.example.com/foo" {
+		t.Errorf("%q", refs)
+	}
+}
+
+func TestDetectRefspam(t *testing.T) {
+	ctx := gctest.DB(t)
+
+	for i := range RefspamDetectSites {
+		siteCtx := gctest.Site(ctx, t, nil, nil)
+		var (
+			spam    = zint.Uint128{1, uint64(i)}
+			popular = zint.Uint128{2, uint64(i)}
+		)
+		gctest.StoreHits(siteCtx, t, false,
+			Hit{Session: spam, Ref: "https://spam.example.com"},
+			// Sends visitors to just as many sites, but they view more than one
+			// page; the later pageviews don't have the external referrer.
+			Hit{Session: popular, Ref: "https://news.example.org", Path: "/a"},
+			Hit{Session: popular, Path: "/b"},
+		)
+	}
+
+	n, err := DetectRefspam(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("detected %d", n)
+	}
+
+	var review Refspams
+	err = review.List(ctx, RefspamServer, RefspamReview, "", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(review) != 1 || review[0].Host != "spam.example.com" {
+		t.Fatalf("%#v", review)
+	}
+
+	// Don't add again.
+	n, err = DetectRefspam(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Fatalf("detected %d", n)
+	}
+}
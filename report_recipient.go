@@ -0,0 +1,132 @@
+package goatcounter
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"time"
+
+	"zgo.at/errors"
+	"zgo.at/zdb"
+	"zgo.at/zstd/zcrypto"
+	"zgo.at/zstd/ztime"
+)
+
+type ReportRecipientID int32
+
+// ReportRecipient is an extra recipient for a user's email reports.
+//
+// Recipients don't need to be users, but they need to confirm the address
+// before any reports are sent to it.
+type ReportRecipient struct {
+	ID     ReportRecipientID `db:"report_recipient_id,id"`
+	SiteID SiteID            `db:"site_id"`
+	UserID UserID            `db:"user_id"`
+
+	Email       string     `db:"email"`
+	Token       string     `db:"token"`
+	ConfirmedAt *time.Time `db:"confirmed_at"`
+	CreatedAt   time.Time  `db:"created_at"`
+}
+
+func (ReportRecipient) Table() string { return "report_recipients" }
+
+// Confirmed reports if this recipient confirmed the address.
+func (r ReportRecipient) Confirmed() bool { return r.ConfirmedAt != nil }
+
+// ByToken gets a recipient by the confirmation token.
+func (r *ReportRecipient) ByToken(ctx context.Context, token string) error {
+	err := zdb.Get(ctx, r, `/* ReportRecipient.ByToken */
+		select * from report_recipients where token=$1 and site_id=$2`,
+		token, MustGetAccount(ctx).ID)
+	return errors.Wrap(err, "ReportRecipient.ByToken")
+}
+
+// Confirm this recipient.
+func (r *ReportRecipient) Confirm(ctx context.Context) error {
+	if r.Confirmed() {
+		return nil
+	}
+	n := ztime.Now(ctx)
+	r.ConfirmedAt = &n
+	err := zdb.Update(ctx, r, "confirmed_at")
+	return errors.Wrap(err, "ReportRecipient.Confirm")
+}
+
+type ReportRecipients []ReportRecipient
+
+// List all recipients for this user.
+func (r *ReportRecipients) List(ctx context.Context, userID UserID) error {
+	err := zdb.Select(ctx, r, `/* ReportRecipients.List */
+		select * from report_recipients where user_id=$1 order by report_recipient_id`,
+		userID)
+	return errors.Wrap(err, "ReportRecipients.List")
+}
+
+// Confirmed gets the email addresses of all confirmed recipients.
+func (r ReportRecipients) Confirmed() []string {
+	l := make([]string, 0, len(r))
+	for _, rr := range r {
+		if rr.Confirmed() {
+			l = append(l, rr.Email)
+		}
+	}
+	return l
+}
+
+// Get a recipient by email address, or nil if there is no such recipient.
+func (r ReportRecipients) Get(email string) *ReportRecipient {
+	for i := range r {
+		if strings.EqualFold(r[i].Email, email) {
+			return &r[i]
+		}
+	}
+	return nil
+}
+
+// Update the recipients for the user to the list of email addresses.
+//
+// Recipients that are no longer in the list are removed, and new ones are
+// added as unconfirmed. The new recipients are returned, so that a
+// confirmation email can be sent.
+func (r *ReportRecipients) Update(ctx context.Context, user User, emails []string) (ReportRecipients, error) {
+	err := r.List(ctx, user.ID)
+	if err != nil {
+		return nil, errors.Wrap(err, "ReportRecipients.Update")
+	}
+
+	var added ReportRecipients
+	err = zdb.TX(ctx, func(ctx context.Context) error {
+		for _, rr := range *r {
+			if slices.ContainsFunc(emails, func(e string) bool { return strings.EqualFold(e, rr.Email) }) {
+				continue
+			}
+			err := zdb.Exec(ctx, `delete from report_recipients where report_recipient_id=$1`, rr.ID)
+			if err != nil {
+				return err
+			}
+		}
+		for _, e := range emails {
+			if r.Get(e) != nil || added.Get(e) != nil {
+				continue
+			}
+			rr := ReportRecipient{
+				SiteID:    user.Site,
+				UserID:    user.ID,
+				Email:     e,
+				Token:     zcrypto.Secret192(),
+				CreatedAt: ztime.Now(ctx),
+			}
+			err := zdb.Insert(ctx, &rr)
+			if err != nil {
+				return err
+			}
+			added = append(added, rr)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "ReportRecipients.Update")
+	}
+	return added, r.List(ctx, user.ID)
+}
@@ -0,0 +1,48 @@
+package goatcounter_test
+
+import (
+	"testing"
+
+	. "zgo.at/goatcounter/v2"
+	"zgo.at/goatcounter/v2/gctest"
+	"zgo.at/zdb"
+)
+
+func TestReportRecipientsUpdate(t *testing.T) {
+	ctx := gctest.DB(t)
+	user := MustGetUser(ctx)
+
+	var rcpt ReportRecipients
+	added, err := rcpt.Update(ctx, *user, []string{"a@example.com", "b@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(added) != 2 {
+		t.Fatalf("added: %v", added)
+	}
+	err = added[0].Confirm(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Keeps confirmation for existing addresses, and only returns new ones.
+	added, err = rcpt.Update(ctx, *user, []string{"A@example.com", "c@example.com", "c@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(added) != 1 || added[0].Email != "c@example.com" {
+		t.Fatalf("added: %v", added)
+	}
+	if have := rcpt.Confirmed(); len(have) != 1 || have[0] != "a@example.com" {
+		t.Errorf("confirmed: %v", have)
+	}
+
+	have := zdb.DumpString(ctx, `select email from report_recipients order by email`)
+	want := `
+		email
+		a@example.com
+		c@example.com`
+	if d := zdb.Diff(have, want); d != "" {
+		t.Error(d)
+	}
+}
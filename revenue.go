@@ -0,0 +1,181 @@
+package goatcounter
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"strconv"
+	"strings"
+
+	"zgo.at/errors"
+	"zgo.at/zdb"
+	"zgo.at/zstd/ztime"
+	"zgo.at/zvalidate"
+)
+
+// Money is a monetary value, in hundredths of the currency unit (e.g. cents).
+type Money int64
+
+// Largest value that can be sent for an event.
+const maxMoney = 1_000_000_000
+
+// ParseMoney parses a decimal value such as "49.95"; values are rounded to two
+// decimals.
+func ParseMoney(s string) (Money, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, errors.Errorf("invalid value: %q", s)
+	}
+	if math.Abs(f) > maxMoney {
+		return 0, errors.Errorf("value out of range: %q", s)
+	}
+	return Money(math.Round(f * 100)), nil
+}
+
+func (m Money) String() string {
+	var (
+		neg = m < 0
+		n   = int64(m)
+	)
+	if neg {
+		n = -n
+	}
+	s := strconv.FormatInt(n/100, 10) + "." + strconv.FormatInt(100+n%100, 10)[1:]
+	if neg {
+		return "-" + s
+	}
+	return s
+}
+
+// UnmarshalText parses the value with ParseMoney().
+func (m *Money) UnmarshalText(v []byte) error {
+	mm, err := ParseMoney(string(v))
+	*m = mm
+	return err
+}
+
+// UnmarshalJSON accepts both a number and string.
+func (m *Money) UnmarshalJSON(v []byte) error {
+	if string(v) == "null" {
+		*m = 0
+		return nil
+	}
+	return m.UnmarshalText(bytes.Trim(v, `"`))
+}
+
+// MarshalJSON writes the value as a number with two decimals.
+func (m Money) MarshalJSON() ([]byte, error) { return []byte(m.String()), nil }
+
+// validCurrency reports if this looks like an ISO 4217 currency code.
+func validCurrency(c string) bool {
+	if len(c) != 3 {
+		return false
+	}
+	for _, r := range c {
+		if r < 'A' || r > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// validateRevenue validates the value and currency; the currency is upper-cased
+// in Hit.Defaults(), but that's not run yet for /count.
+func validateRevenue(v *zvalidate.Validator, value Money, currency string) {
+	if value == 0 {
+		return
+	}
+	currency = strings.ToUpper(strings.TrimSpace(currency))
+	if currency == "" {
+		v.Append("currency", "must be set if value is set")
+	} else if !validCurrency(currency) {
+		v.Append("currency", "must be a three-letter ISO 4217 currency code")
+	}
+}
+
+type RevenueStat struct {
+	// ID for selecting more details; the path ID, campaign ID, or day.
+	ID       string `db:"id" json:"id,omitempty"`
+	Name     string `db:"name" json:"name"`         // Display name.
+	Currency string `db:"currency" json:"currency"` // ISO 4217 currency code.
+	Count    int    `db:"count" json:"count"`       // Number of events or pageviews with a value.
+	Total    Money  `db:"total" json:"total"`       // Sum of all values.
+	Average  Money  `db:"-" json:"average"`         // Average value.
+}
+
+type RevenueStats struct {
+	More  bool
+	Stats []RevenueStat
+}
+
+func (r *RevenueStats) list(ctx context.Context, query string, rng ztime.Range, pathFilter PathFilter, limit, offset int) error {
+	var (
+		user                    = MustGetUser(ctx)
+		filterSQL, filterParams = pathFilter.SQL(ctx)
+	)
+	err := zdb.Select(ctx, &r.Stats, "load:revenue_stats."+query, filterParams, map[string]any{
+		"site":   MustGetSite(ctx).ID,
+		"start":  asUTCDate(user, rng.Start),
+		"end":    asUTCDate(user, rng.End),
+		"filter": filterSQL,
+		"limit":  limit + 1,
+		"offset": offset,
+	})
+	if len(r.Stats) > limit {
+		r.More = true
+		r.Stats = r.Stats[:len(r.Stats)-1]
+	}
+	for i := range r.Stats {
+		if r.Stats[i].Count > 0 {
+			r.Stats[i].Average = Money(math.Round(float64(r.Stats[i].Total) / float64(r.Stats[i].Count)))
+		}
+	}
+	return errors.Wrap(err, "RevenueStats."+query)
+}
+
+// ListTotals lists the total revenue per currency for the given time period.
+func (r *RevenueStats) ListTotals(ctx context.Context, rng ztime.Range, pathFilter PathFilter, limit, offset int) error {
+	return r.list(ctx, "ListTotals", rng, pathFilter, limit, offset)
+}
+
+// ListPaths lists the revenue per path or event for the given time period.
+func (r *RevenueStats) ListPaths(ctx context.Context, rng ztime.Range, pathFilter PathFilter, limit, offset int) error {
+	return r.list(ctx, "ListPaths", rng, pathFilter, limit, offset)
+}
+
+// ListRefs lists the revenue per referrer for the given time period.
+//
+// The referrer is the referrer of the first pageview in the session, rather
+// than the referrer of the event itself.
+func (r *RevenueStats) ListRefs(ctx context.Context, rng ztime.Range, pathFilter PathFilter, limit, offset int) error {
+	return r.list(ctx, "ListRefs", rng, pathFilter, limit, offset)
+}
+
+// ListCampaigns lists the revenue per campaign for the given time period.
+//
+// The campaign is the campaign of the first pageview in the session, rather
+// than the campaign of the event itself.
+func (r *RevenueStats) ListCampaigns(ctx context.Context, rng ztime.Range, pathFilter PathFilter, limit, offset int) error {
+	return r.list(ctx, "ListCampaigns", rng, pathFilter, limit, offset)
+}
+
+// ListDays lists the revenue per day for the given time period.
+func (r *RevenueStats) ListDays(ctx context.Context, rng ztime.Range, pathFilter PathFilter, limit, offset int) error {
+	return r.list(ctx, "ListDays", rng, pathFilter, limit, offset)
+}
+
+// Days gets the total per day for a currency, for drawing the revenue line on
+// the totals chart.
+func (r RevenueStats) Days(currency string) map[string]Money {
+	days := make(map[string]Money)
+	for _, s := range r.Stats {
+		if s.Currency == currency {
+			days[s.Name] += s.Total
+		}
+	}
+	return days
+}
@@ -0,0 +1,149 @@
+package goatcounter_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	. "zgo.at/goatcounter/v2"
+	"zgo.at/goatcounter/v2/gctest"
+	"zgo.at/zstd/zjson"
+	"zgo.at/zstd/ztest"
+	"zgo.at/zstd/ztime"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Money
+		str     string
+		wantErr string
+	}{
+		{"", 0, "0.00", ""},
+		{"49", 4900, "49.00", ""},
+		{"49.95", 4995, "49.95", ""},
+		{" 0.5 ", 50, "0.50", ""},
+		{"1.999", 200, "2.00", ""},
+		{"-12.05", -1205, "-12.05", ""},
+		{"xx", 0, "", "invalid value"},
+		{"NaN", 0, "", "invalid value"},
+		{"1e12", 0, "", "out of range"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			have, err := ParseMoney(tt.in)
+			if !ztest.ErrorContains(err, tt.wantErr) {
+				t.Fatalf("wrong error\nhave: %v\nwant: %v", err, tt.wantErr)
+			}
+			if tt.wantErr != "" {
+				return
+			}
+			if have != tt.want {
+				t.Errorf("\nhave: %d\nwant: %d", have, tt.want)
+			}
+			if have.String() != tt.str {
+				t.Errorf("String()\nhave: %s\nwant: %s", have.String(), tt.str)
+			}
+		})
+	}
+}
+
+func TestMoneyUnmarshalJSON(t *testing.T) {
+	var have struct {
+		A, B, C Money
+	}
+	err := json.Unmarshal([]byte(`{"A": 49.95, "B": "12", "C": null}`), &have)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have.A != 4995 || have.B != 1200 || have.C != 0 {
+		t.Errorf("%#v", have)
+	}
+}
+
+func TestHitValidateRevenue(t *testing.T) {
+	ctx := gctest.DB(t)
+
+	tests := []struct {
+		value    Money
+		currency string
+		wantErr  string
+	}{
+		{0, "", ""},
+		{4900, "EUR", ""},
+		{4900, "eur", ""},
+		{4900, "", "currency: must be set if value is set"},
+		{4900, "EURO", "currency: must be a three-letter ISO 4217 currency code"},
+		{4900, "€", "currency: must be a three-letter ISO 4217 currency code"},
+	}
+	for _, tt := range tests {
+		t.Run("", func(t *testing.T) {
+			h := Hit{Path: "/", Value: tt.value, Currency: tt.currency}
+			h.Defaults(ctx, true)
+			err := h.Validate(ctx, true)
+			if !ztest.ErrorContains(err, tt.wantErr) {
+				t.Errorf("wrong error\nhave: %v\nwant: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRevenueStats(t *testing.T) {
+	ctx := gctest.DB(t)
+
+	gctest.StoreHits(ctx, t, false,
+		Hit{Path: "/a", Ref: "https://example.com", FirstVisit: true},
+		Hit{Path: "/b", Ref: "https://example.com/a"},
+		Hit{Path: "purchase", Event: true, Value: 4900, Currency: "eur"},
+		Hit{Path: "purchase", Event: true, Value: 2550, Currency: "EUR"},
+		Hit{Path: "purchase", Event: true, Value: 1000, Currency: "USD"})
+
+	rng := ztime.NewRange(ztime.Now(ctx)).To(ztime.Now(ctx))
+
+	tests := []struct {
+		name string
+		list func(*RevenueStats) error
+		want string
+	}{
+		{"totals", func(r *RevenueStats) error { return r.ListTotals(ctx, rng, PathFilter{}, 10, 0) }, `{
+			"More": false,
+			"Stats": [
+				{"name": "EUR", "currency": "EUR", "count": 2, "total": 74.5, "average": 37.25},
+				{"name": "USD", "currency": "USD", "count": 1, "total": 10, "average": 10}
+			]
+		}`},
+		{"paths", func(r *RevenueStats) error { return r.ListPaths(ctx, rng, PathFilter{}, 10, 0) }, `{
+			"More": false,
+			"Stats": [
+				{"id": "3", "name": "purchase", "currency": "EUR", "count": 2, "total": 74.5, "average": 37.25},
+				{"id": "3", "name": "purchase", "currency": "USD", "count": 1, "total": 10, "average": 10}
+			]
+		}`},
+		// The events are attributed to the referrer of the first pageview in
+		// the session.
+		{"refs", func(r *RevenueStats) error { return r.ListRefs(ctx, rng, PathFilter{}, 10, 0) }, `{
+			"More": false,
+			"Stats": [
+				{"name": "example.com", "currency": "EUR", "count": 2, "total": 74.5, "average": 37.25},
+				{"name": "example.com", "currency": "USD", "count": 1, "total": 10, "average": 10}
+			]
+		}`},
+		{"limit", func(r *RevenueStats) error { return r.ListTotals(ctx, rng, PathFilter{}, 1, 0) }, `{
+			"More": true,
+			"Stats": [
+				{"name": "EUR", "currency": "EUR", "count": 2, "total": 74.5, "average": 37.25}
+			]
+		}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var have RevenueStats
+			err := tt.list(&have)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d := ztest.Diff(zjson.MustMarshalString(have), tt.want, ztest.DiffJSON); d != "" {
+				t.Error(d)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package goatcounter
+
+import (
+	"context"
+
+	"zgo.at/errors"
+	"zgo.at/zdb"
+	"zgo.at/zstd/ztime"
+)
+
+// Sources for search queries.
+const (
+	SearchSourceGoogle = "google"
+	SearchSourceBing   = "bing"
+)
+
+type SearchQueryStat struct {
+	Query       string  `db:"query" json:"query"`
+	Clicks      int     `db:"clicks" json:"clicks"`
+	Impressions int     `db:"impressions" json:"impressions"`
+	Position    float64 `db:"position" json:"position"` // Average position, weighted by impressions.
+}
+
+type SearchQueryStats struct {
+	More  bool              `json:"more"`
+	Stats []SearchQueryStat `json:"stats"`
+}
+
+// List search queries imported from Google Search Console or Bing Webmaster
+// Tools, ordered by the number of clicks.
+func (s *SearchQueryStats) List(ctx context.Context, rng ztime.Range, pathFilter PathFilter, limit, offset int) error {
+	var (
+		user                    = MustGetUser(ctx)
+		filterSQL, filterParams = pathFilter.SQL(ctx)
+	)
+	err := zdb.Select(ctx, &s.Stats, "load:search_queries.List", filterParams, map[string]any{
+		"site":   MustGetSite(ctx).ID,
+		"start":  asUTCDate(user, rng.Start),
+		"end":    asUTCDate(user, rng.End),
+		"filter": filterSQL,
+		"limit":  limit + 1,
+		"offset": offset,
+	})
+	if len(s.Stats) > limit {
+		s.More = true
+		s.Stats = s.Stats[:len(s.Stats)-1]
+	}
+	return errors.Wrap(err, "SearchQueryStats.List")
+}
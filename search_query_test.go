@@ -0,0 +1,72 @@
+package goatcounter_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	. "zgo.at/goatcounter/v2"
+	"zgo.at/goatcounter/v2/gctest"
+	"zgo.at/zstd/ztime"
+)
+
+func TestImportSearchQueries(t *testing.T) {
+	ctx := gctest.DB(t)
+
+	gctest.StoreHits(ctx, t, false, Hit{Path: "/a"}, Hit{Path: "/b/"})
+
+	day := ztime.Now(ctx).Format("2006-01-02")
+	csv := "Date,Query,Page,Clicks,Impressions,CTR,Position\n" +
+		day + ",goatcounter,https://example.com/a,10,100,10%,2\n" +
+		day + ",goatcounter,http://example.com/b,5,100,5%,4\n" +
+		day + ",web analytics,https://example.com/a,\"1,000\",\"2,000\",50%,1.5\n" +
+		day + ",other,https://example.com/doesnt-exist,1,1,100%,1\n"
+
+	n, skipped, err := ImportSearchQueries(ctx, strings.NewReader(csv), SearchSourceGoogle, "csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 || skipped != 1 {
+		t.Errorf("n=%d; skipped=%d", n, skipped)
+	}
+
+	list := func(filter PathFilter) string {
+		t.Helper()
+		var s SearchQueryStats
+		err := s.List(ctx, ztime.NewRange(ztime.Now(ctx)).To(ztime.Now(ctx)), filter, 10, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fmt.Sprintf("%v", s.Stats)
+	}
+
+	want := "[{web analytics 1000 2000 1.5} {goatcounter 15 200 3}]"
+	if have := list(PathFilter{}); have != want {
+		t.Errorf("\nhave: %s\nwant: %s", have, want)
+	}
+
+	// Importing again should replace, rather than add.
+	json := `{"rows": [{"keys": ["` + day + `", "goatcounter", "https://example.com/b/"], "clicks": 7, "impressions": 50, "position": 3}]}`
+	_, _, err = ImportSearchQueries(ctx, strings.NewReader(json), SearchSourceGoogle, "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = "[{goatcounter 7 50 3}]"
+	if have := list(PathFilter{}); have != want {
+		t.Errorf("\nhave: %s\nwant: %s", have, want)
+	}
+
+	var a Path
+	err = a.ByPath(ctx, "/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have := list(PathFilterFromIDs([]PathID{a.ID})); have != "[]" {
+		t.Errorf("filter: %s", have)
+	}
+
+	_, _, err = ImportSearchQueries(ctx, strings.NewReader("query,clicks\nx,1\n"), SearchSourceBing, "csv")
+	if err == nil {
+		t.Error("no error for missing columns")
+	}
+}
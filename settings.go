@@ -38,6 +38,8 @@ const (
 	CollectLanguage                      // 64
 	CollectSession                       // 128
 	CollectHits                          // 256
+	CollectLocationCity                  // 512
+	CollectNetwork                       // 1024
 )
 
 type EmailReport uint8
@@ -54,6 +56,10 @@ const (
 var EmailReports = []EmailReport{EmailReportNever, EmailReportDaily,
 	EmailReportWeekly, EmailReportBiWeekly, EmailReportMonthly}
 
+// Widgets that can be included in email reports.
+var EmailReportWidgets = []string{"pages", "toprefs", "browsers", "systems",
+	"sizes", "locations", "languages", "networks", "devices", "engines", "props", "campaigns", "channels", "anomalies"}
+
 type (
 	// SiteSettings contains all the user-configurable settings for a site, with
 	// the exception of the domain settings.
@@ -69,24 +75,38 @@ type (
 		IgnoreIPs      Strings        `json:"ignore_ips"`
 		Collect        zint.Bitflag16 `json:"collect"`
 		CollectRegions Strings        `json:"collect_regions"`
+		CollectCities  Strings        `json:"collect_cities"`
 		AllowEmbed     Strings        `json:"allow_embed"`
+		EmbedWidgets   bool           `json:"embed_widgets"`
+		ServerSSO      bool           `json:"server_sso"`
+		CounterThemes  CounterThemes  `json:"counter_themes"`
 	}
 
 	// UserSettings are all user preferences.
 	UserSettings struct {
-		TwentyFourHours       bool        `json:"twenty_four_hours"`
-		SundayStartsWeek      bool        `json:"sunday_starts_week"`
-		Language              string      `json:"language"`
-		DateFormat            string      `json:"date_format"`
-		NumberFormat          rune        `json:"number_format"`
-		Timezone              *tz.Zone    `json:"timezone"`
-		Widgets               Widgets     `json:"widgets"`
-		Views                 Views       `json:"views"`
-		EmailReports          EmailReport `json:"email_reports"`
-		FewerNumbers          bool        `json:"fewer_numbers"`
-		FewerNumbersLockUntil time.Time   `json:"fewer_numbers_lock_until"`
-		Theme                 string      `json:"theme"`
-		Datepicker            bool        `json:"datepicker"`
+		TwentyFourHours       bool               `json:"twenty_four_hours"`
+		SundayStartsWeek      bool               `json:"sunday_starts_week"`
+		Language              string             `json:"language"`
+		DateFormat            string             `json:"date_format"`
+		NumberFormat          rune               `json:"number_format"`
+		Timezone              *tz.Zone           `json:"timezone"`
+		Widgets               Widgets            `json:"widgets"`
+		Views                 Views              `json:"views"`
+		EmailReports          EmailReport        `json:"email_reports"`
+		EmailReportOptions    EmailReportOptions `json:"email_report_options"`
+		FewerNumbers          bool               `json:"fewer_numbers"`
+		FewerNumbersLockUntil time.Time          `json:"fewer_numbers_lock_until"`
+		Theme                 string             `json:"theme"`
+		Datepicker            bool               `json:"datepicker"`
+	}
+
+	// EmailReportOptions are the contents of the email reports.
+	EmailReportOptions struct {
+		Widgets    Strings `json:"widgets"`    // Widgets to include; from EmailReportWidgets.
+		Filter     string  `json:"filter"`     // Only include paths matching this filter.
+		Compare    bool    `json:"compare"`    // Compare with the previous period.
+		Chart      bool    `json:"chart"`      // Add a chart of the totals.
+		Recipients Strings `json:"recipients"` // Also send to these addresses.
 	}
 
 	// Widgets is a list of widgets to be printed, in order.
@@ -300,6 +320,55 @@ func defaultWidgetSettings(ctx context.Context) map[string]WidgetSettings {
 				},
 			},
 		},
+		"networks": map[string]WidgetSetting{
+			"limit": WidgetSetting{
+				Type:  "number",
+				Label: z18n.T(ctx, "widget-setting/label/page-size|Page size"),
+				Help:  z18n.T(ctx, "widget-setting/help/page-size|Number of pages to load"),
+				Value: float64(6),
+				Attr:  `min="1" max="20"`,
+				Validate: func(v *zvalidate.Validator, val any) {
+					v.Range("limit", int64(val.(float64)), 1, 20)
+				},
+			},
+		},
+		"devices": map[string]WidgetSetting{
+			"limit": WidgetSetting{
+				Type:  "number",
+				Label: z18n.T(ctx, "widget-setting/label/page-size|Page size"),
+				Help:  z18n.T(ctx, "widget-setting/help/page-size|Number of pages to load"),
+				Value: float64(6),
+				Attr:  `min="1" max="20"`,
+				Validate: func(v *zvalidate.Validator, val any) {
+					v.Range("limit", int64(val.(float64)), 1, 20)
+				},
+			},
+		},
+		"engines": map[string]WidgetSetting{
+			"limit": WidgetSetting{
+				Type:  "number",
+				Label: z18n.T(ctx, "widget-setting/label/page-size|Page size"),
+				Help:  z18n.T(ctx, "widget-setting/help/page-size|Number of pages to load"),
+				Value: float64(6),
+				Attr:  `min="1" max="20"`,
+				Validate: func(v *zvalidate.Validator, val any) {
+					v.Range("limit", int64(val.(float64)), 1, 20)
+				},
+			},
+		},
+		"props": map[string]WidgetSetting{
+			"limit": WidgetSetting{
+				Type:  "number",
+				Label: z18n.T(ctx, "widget-setting/label/page-size|Page size"),
+				Help:  z18n.T(ctx, "widget-setting/help/page-size|Number of pages to load"),
+				Value: float64(6),
+				Attr:  `min="1" max="20"`,
+				Validate: func(v *zvalidate.Validator, val any) {
+					v.Range("limit", int64(val.(float64)), 1, 20)
+				},
+			},
+			"key": WidgetSetting{Hidden: true},
+		},
 		"campaigns": map[string]WidgetSetting{
 			"limit": WidgetSetting{
 				Type:  "number",
@@ -313,6 +382,43 @@ func defaultWidgetSettings(ctx context.Context) map[string]WidgetSettings {
 			},
 			"key": WidgetSetting{Hidden: true},
 		},
+		"channels": map[string]WidgetSetting{
+			"limit": WidgetSetting{
+				Type:  "number",
+				Label: z18n.T(ctx, "widget-setting/label/page-size|Page size"),
+				Help:  z18n.T(ctx, "widget-setting/help/page-size|Number of pages to load"),
+				Value: float64(6),
+				Attr:  `min="1" max="20"`,
+				Validate: func(v *zvalidate.Validator, val any) {
+					v.Range("limit", int64(val.(float64)), 1, 20)
+				},
+			},
+			"key": WidgetSetting{Hidden: true},
+		},
+		"searchqueries": map[string]WidgetSetting{
+			"limit": WidgetSetting{
+				Type:  "number",
+				Label: z18n.T(ctx, "widget-setting/label/page-size|Page size"),
+				Help:  z18n.T(ctx, "widget-setting/help/page-size|Number of pages to load"),
+				Value: float64(10),
+				Attr:  `min="1" max="100"`,
+				Validate: func(v *zvalidate.Validator, val any) {
+					v.Range("limit", int64(val.(float64)), 1, 100)
+				},
+			},
+		},
+		"anomalies": map[string]WidgetSetting{
+			"limit": WidgetSetting{
+				Type:  "number",
+				Label: z18n.T(ctx, "widget-setting/label/page-size|Page size"),
+				Help:  z18n.T(ctx, "widget-setting/help/page-size|Number of pages to load"),
+				Value: float64(10),
+				Attr:  `min="1" max="100"`,
+				Validate: func(v *zvalidate.Validator, val any) {
+					v.Range("limit", int64(val.(float64)), 1, 100)
+				},
+			},
+		},
 	}
 }
 
@@ -363,6 +469,9 @@ func (ss *SiteSettings) Defaults(ctx context.Context) {
 	if ss.Collect == 0 {
 		ss.Collect = CollectReferrer | CollectUserAgent | CollectScreenSize | CollectLocation | CollectLocationRegion | CollectSession
 	}
+	if ss.Collect.Has(CollectLocationCity) { // City is stored as part of the region.
+		ss.Collect |= CollectLocationRegion
+	}
 	if ss.Collect.Has(CollectLocationRegion) { // Collecting region without country makes no sense.
 		ss.Collect |= CollectLocation
 	}
@@ -398,6 +507,12 @@ func (ss *SiteSettings) Validate(ctx context.Context) error {
 			}
 		}
 	}
+	for i, t := range ss.CounterThemes {
+		v.Sub("counter_themes", strconv.Itoa(i), t.Validate(ctx))
+		if slices.IndexFunc(ss.CounterThemes[:i], func(tt CounterTheme) bool { return tt.Name == t.Name }) > -1 {
+			v.Append("counter_themes", z18n.T(ctx, "validate/counter-theme-exists|theme %(name) already exists", t.Name))
+		}
+	}
 
 	return v.ErrorOrNil()
 }
@@ -435,7 +550,7 @@ func (ss SiteSettings) CollectFlags(ctx context.Context) []CollectFlag {
 		},
 		{
 			Label: z18n.T(ctx, "data-collect/label/user-agent|User-Agent"),
-			Help:  z18n.T(ctx, "data-collect/help/user-agent|Browser and system name derived from the User-Agent header (the header itself is not stored)."),
+			Help:  z18n.T(ctx, "data-collect/help/user-agent|Browser, system, device type, and browser engine derived from the User-Agent header (the header itself is not stored)."),
 			Flag:  CollectUserAgent,
 		},
 		{
@@ -453,11 +568,21 @@ func (ss SiteSettings) CollectFlags(ctx context.Context) []CollectFlag {
 			Help:  z18n.T(ctx, "data-collect/help/region|Region, for example Texas, Bali, etc. The details for this differ per country."),
 			Flag:  CollectLocationRegion,
 		},
+		{
+			Label: z18n.T(ctx, "data-collect/label/city|City"),
+			Help:  z18n.T(ctx, "data-collect/help/city|City, for example Austin, Denpasar, etc. This is less accurate than the region."),
+			Flag:  CollectLocationCity,
+		},
 		{
 			Label: z18n.T(ctx, "data-collect/label/language|Language"),
 			Help:  z18n.T(ctx, "data-collect/help/language|Supported languages from Accept-Language."),
 			Flag:  CollectLanguage,
 		},
+		{
+			Label: z18n.T(ctx, "data-collect/label/network|Network"),
+			Help:  z18n.T(ctx, "data-collect/help/network|Network (ASN) and organisation, for example an ISP or hosting provider. Requires the <tt>-asndb</tt> flag."),
+			Flag:  CollectNetwork,
+		},
 	}
 }
 
@@ -630,6 +755,33 @@ func (ss *UserSettings) Defaults(ctx context.Context) {
 	if len(ss.Views) == 0 {
 		ss.Views = Views{{Name: "default", Period: "week"}}
 	}
+	ss.EmailReportOptions.Defaults()
+}
+
+// Defaults sets the defaults for reports that were never configured, which
+// is the same as what the reports looked like before they were configurable.
+//
+// Widgets is only nil if the options were never set; a report with all
+// widgets removed is stored as an empty list.
+func (o *EmailReportOptions) Defaults() {
+	if o.Widgets == nil {
+		o.Widgets = Strings{"pages", "toprefs"}
+		o.Compare = true
+	}
+}
+
+func (o EmailReportOptions) Validate(ctx context.Context) error {
+	v := NewValidate(ctx)
+	for _, w := range o.Widgets {
+		v.Include("widgets", w, EmailReportWidgets)
+	}
+	if len(o.Recipients) > 20 {
+		v.Append("recipients", z18n.T(ctx, "validate/too-many-recipients|can have at most 20 addresses"))
+	}
+	for _, r := range o.Recipients {
+		v.Email("recipients", r)
+	}
+	return v.ErrorOrNil()
 }
 
 func (ss *UserSettings) Validate(ctx context.Context) error {
@@ -653,6 +805,7 @@ func (ss *UserSettings) Validate(ctx context.Context) error {
 	if !slices.Contains(EmailReports, ss.EmailReports) {
 		v.Append("email_reports", "invalid value")
 	}
+	v.Sub("email_report_options", "", ss.EmailReportOptions.Validate(ctx))
 
 	v.Include("theme", ss.Theme, []string{"", "light", "dark"})
 
@@ -0,0 +1,223 @@
+package goatcounter
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"zgo.at/errors"
+	"zgo.at/z18n"
+	"zgo.at/zdb"
+	"zgo.at/zstd/zcrypto"
+	"zgo.at/zstd/ztime"
+)
+
+// Rolling periods for share links; it can also be a number of days.
+var ShareLinkPeriods = []string{"day", "week", "month", "quarter", "half-year", "year"}
+
+type ShareLinkID int32
+
+// ShareLink is a link to view a part of the dashboard without logging in.
+//
+// Every link has its own list of widgets, an optional path filter, and either a
+// rolling period ("last week") or a fixed date range.
+type ShareLink struct {
+	ID          ShareLinkID `db:"share_link_id,id" json:"id"`
+	SiteID      SiteID      `db:"site_id" json:"site_id"`
+	Name        string      `db:"name" json:"name"`
+	Token       string      `db:"token" json:"-"`
+	Widgets     Strings     `db:"widgets" json:"widgets"`
+	Filter      string      `db:"filter" json:"filter"`
+	Period      string      `db:"period" json:"period"`
+	PeriodStart *time.Time  `db:"period_start" json:"period_start"`
+	PeriodEnd   *time.Time  `db:"period_end" json:"period_end"`
+	ExpiresAt   *time.Time  `db:"expires_at" json:"expires_at"`
+	Password    []byte      `db:"password" json:"-"`
+	CreatedAt   time.Time   `db:"created_at" json:"created_at"`
+}
+
+func (ShareLink) Table() string { return "share_links" }
+
+var _ zdb.Defaulter = &ShareLink{}
+
+func (l *ShareLink) Defaults(ctx context.Context) {
+	if l.SiteID == 0 {
+		l.SiteID = MustGetSite(ctx).ID
+	}
+	if l.Token == "" {
+		l.Token = zcrypto.Secret192()
+	}
+	if l.CreatedAt.IsZero() {
+		l.CreatedAt = ztime.Now(ctx)
+	}
+	l.Name = strings.TrimSpace(l.Name)
+	l.Filter = strings.TrimSpace(l.Filter)
+	if l.PeriodStart != nil || l.PeriodEnd != nil {
+		l.Period = ""
+	}
+}
+
+var _ zdb.Validator = &ShareLink{}
+
+func (l *ShareLink) Validate(ctx context.Context) error {
+	v := NewValidate(ctx)
+	v.Required("site_id", l.SiteID)
+	v.Required("token", l.Token)
+	v.Required("name", l.Name)
+	v.Len("name", l.Name, 0, 200)
+	v.Len("filter", l.Filter, 0, 512)
+
+	if len(l.Widgets) == 0 {
+		v.Append("widgets", z18n.T(ctx, "validate/need-one|must select at least one"))
+	}
+	def := defaultWidgetSettings(ctx)
+	for _, w := range l.Widgets {
+		if _, ok := def[w]; !ok {
+			v.Append("widgets", z18n.T(ctx, "validate/unknown-widget|unknown widget: %(name)", w))
+		}
+	}
+
+	switch {
+	case l.PeriodStart != nil || l.PeriodEnd != nil:
+		v.Required("period_start", l.PeriodStart)
+		v.Required("period_end", l.PeriodEnd)
+		if l.PeriodStart != nil && l.PeriodEnd != nil && l.PeriodEnd.Before(*l.PeriodStart) {
+			v.Append("period_end", z18n.T(ctx, "validate/end-before-start|must be after the start date"))
+		}
+	case !slices.Contains(ShareLinkPeriods, l.Period):
+		n, err := strconv.Atoi(l.Period)
+		if err != nil || n < 1 {
+			v.Append("period", z18n.T(ctx, "validate/invalid-period|must be a period or a number of days"))
+		}
+	}
+
+	if l.ID == 0 && l.ExpiresAt != nil && !l.ExpiresAt.After(ztime.Now(ctx)) {
+		v.Append("expires_at", z18n.T(ctx, "validate/in-future|must be in the future"))
+	}
+	return v.ErrorOrNil()
+}
+
+// SetPassword sets the password to view this link; an empty string removes
+// the password.
+func (l *ShareLink) SetPassword(ctx context.Context, pwd string) error {
+	if pwd == "" {
+		l.Password = nil
+		return nil
+	}
+	cost := bcrypt.DefaultCost
+	if Config(ctx).BcryptMinCost {
+		cost = bcrypt.MinCost
+	}
+	p, err := bcrypt.GenerateFromPassword([]byte(pwd), cost)
+	if err != nil {
+		return errors.Errorf("ShareLink.SetPassword: %w", err)
+	}
+	l.Password = p
+	return nil
+}
+
+// CorrectPassword reports if the password is correct.
+func (l ShareLink) CorrectPassword(pwd string) bool {
+	return len(l.Password) > 0 && bcrypt.CompareHashAndPassword(l.Password, []byte(pwd)) == nil
+}
+
+// Expired reports if this link has expired.
+func (l ShareLink) Expired(ctx context.Context) bool {
+	return l.ExpiresAt != nil && !l.ExpiresAt.After(ztime.Now(ctx))
+}
+
+// CookieValue gets the value to store in the cookie after the link was
+// opened.
+//
+// For links with a password this includes a hash of the password, so changing
+// the password invalidates existing cookies.
+func (l ShareLink) CookieValue() string {
+	if len(l.Password) == 0 {
+		return l.Token
+	}
+	h := sha256.Sum256(append([]byte(l.Token+":"), l.Password...))
+	return l.Token + "." + hex.EncodeToString(h[:])
+}
+
+// UserSettings gets the settings to use for the dashboard, based on the
+// defaults for the site.
+func (l ShareLink) UserSettings(ctx context.Context, defaults UserSettings) UserSettings {
+	s := defaults
+	s.Widgets = make(Widgets, 0, len(l.Widgets))
+	for _, name := range l.Widgets {
+		if w := defaults.Widgets.Get(name); len(w) > 0 {
+			s.Widgets = append(s.Widgets, w[0])
+		} else {
+			s.Widgets = append(s.Widgets, NewWidget(name))
+		}
+	}
+	s.Views = Views{{Name: "default", Filter: l.Filter, Period: l.Period}}
+	return s
+}
+
+// Insert a new share link.
+func (l *ShareLink) Insert(ctx context.Context) error {
+	err := zdb.Insert(ctx, l)
+	return errors.Wrap(err, "ShareLink.Insert")
+}
+
+// Delete this share link.
+func (l *ShareLink) Delete(ctx context.Context) error {
+	err := zdb.Exec(ctx, `delete from share_links where share_link_id=$1 and site_id=$2`,
+		l.ID, MustGetSite(ctx).ID)
+	return errors.Wrapf(err, "ShareLink.Delete(%d)", l.ID)
+}
+
+func (l *ShareLink) ByID(ctx context.Context, id ShareLinkID) error {
+	err := zdb.Get(ctx, l, `/* ShareLink.ByID */
+		select * from share_links where share_link_id=$1 and site_id=$2`,
+		id, MustGetSite(ctx).ID)
+	return errors.Wrapf(err, "ShareLink.ByID(%d)", id)
+}
+
+func (l *ShareLink) ByToken(ctx context.Context, token string) error {
+	err := zdb.Get(ctx, l, `/* ShareLink.ByToken */
+		select * from share_links where token=$1 and site_id=$2`,
+		token, MustGetSite(ctx).ID)
+	return errors.Wrap(err, "ShareLink.ByToken")
+}
+
+// ByCookie gets the share link from the value set with CookieValue().
+func (l *ShareLink) ByCookie(ctx context.Context, v string) error {
+	token, _, _ := strings.Cut(v, ".")
+	err := l.ByToken(ctx, token)
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare([]byte(l.CookieValue()), []byte(v)) != 1 {
+		return errors.New("ShareLink.ByCookie: invalid cookie")
+	}
+	return nil
+}
+
+// WithShareLink sets the share link used to view the dashboard.
+func WithShareLink(ctx context.Context, l *ShareLink) context.Context {
+	return context.WithValue(ctx, keyShareLink, l)
+}
+
+// GetShareLink gets the share link set with WithShareLink(), or nil if there
+// is none.
+func GetShareLink(ctx context.Context) *ShareLink {
+	l, _ := ctx.Value(keyShareLink).(*ShareLink)
+	return l
+}
+
+type ShareLinks []ShareLink
+
+// List all share links for the current site.
+func (l *ShareLinks) List(ctx context.Context) error {
+	err := zdb.Select(ctx, l, `select * from share_links where site_id=$1 order by created_at`,
+		MustGetSite(ctx).ID)
+	return errors.Wrap(err, "ShareLinks.List")
+}
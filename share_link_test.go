@@ -0,0 +1,111 @@
+package goatcounter_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	. "zgo.at/goatcounter/v2"
+	"zgo.at/goatcounter/v2/gctest"
+	"zgo.at/zstd/ztime"
+)
+
+func TestShareLinkValidate(t *testing.T) {
+	ctx := gctest.DB(t)
+	now := ztime.Now(ctx)
+
+	tests := []struct {
+		in      ShareLink
+		wantErr string
+	}{
+		{ShareLink{Name: "x", Widgets: Strings{"pages"}, Period: "week"}, ""},
+		{ShareLink{Name: "x", Widgets: Strings{"pages"}, Period: "30"}, ""},
+		{ShareLink{Name: "x", Widgets: Strings{"pages"}, PeriodStart: new(now.Add(-48 * time.Hour)), PeriodEnd: new(now)}, ""},
+		{ShareLink{Name: "x", Widgets: Strings{"pages"}, Period: "week", ExpiresAt: new(now.Add(time.Hour))}, ""},
+
+		{ShareLink{Widgets: Strings{"pages"}, Period: "week"}, "name: must be set"},
+		{ShareLink{Name: "x", Period: "week"}, "widgets: must select at least one"},
+		{ShareLink{Name: "x", Widgets: Strings{"pages", "nope"}, Period: "week"}, "widgets: unknown widget: nope"},
+		{ShareLink{Name: "x", Widgets: Strings{"pages"}, Period: "fortnight"}, "period: must be a period"},
+		{ShareLink{Name: "x", Widgets: Strings{"pages"}, Period: "0"}, "period: must be a period"},
+		{ShareLink{Name: "x", Widgets: Strings{"pages"}, PeriodStart: new(now)}, "period_end: must be set"},
+		{ShareLink{Name: "x", Widgets: Strings{"pages"}, PeriodStart: new(now), PeriodEnd: new(now.Add(-48 * time.Hour))}, "period_end: must be after"},
+		{ShareLink{Name: "x", Widgets: Strings{"pages"}, Period: "week", ExpiresAt: new(now.Add(-time.Hour))}, "expires_at: must be in the future"},
+	}
+
+	for _, tt := range tests {
+		t.Run("", func(t *testing.T) {
+			tt.in.Defaults(ctx)
+			err := tt.in.Validate(ctx)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("\nhave: %v\nwant: %s", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestShareLinkCookie(t *testing.T) {
+	ctx := gctest.DB(t)
+
+	l := ShareLink{Name: "x", Widgets: Strings{"pages"}, Period: "week"}
+	err := l.SetPassword(ctx, "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = l.Insert(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if l.CorrectPassword("hunter3") || !l.CorrectPassword("hunter2") {
+		t.Error("CorrectPassword")
+	}
+
+	var have ShareLink
+	for _, c := range []string{"", l.Token, l.Token + ".x", "x." + strings.SplitN(l.CookieValue(), ".", 2)[1]} {
+		if err := have.ByCookie(ctx, c); err == nil {
+			t.Errorf("no error for %q", c)
+		}
+	}
+	err = have.ByCookie(ctx, l.CookieValue())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have.ID != l.ID {
+		t.Errorf("wrong ID: %d", have.ID)
+	}
+
+	// Changing the password invalidates the cookie.
+	old := l.CookieValue()
+	err = l.SetPassword(ctx, "hunter3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if old == l.CookieValue() {
+		t.Error("cookie value didn't change")
+	}
+}
+
+func TestShareLinkUserSettings(t *testing.T) {
+	ctx := gctest.DB(t)
+
+	l := ShareLink{Widgets: Strings{"browsers", "pages"}, Filter: "/blog", Period: "month"}
+	s := l.UserSettings(ctx, MustGetSite(ctx).UserDefaults)
+
+	var have []string
+	for _, w := range s.Widgets {
+		have = append(have, w.Name())
+	}
+	if strings.Join(have, " ") != "browsers pages" {
+		t.Errorf("wrong widgets: %v", have)
+	}
+	if v, _ := s.Views.Get("default"); v.Filter != "/blog" || v.Period != "month" {
+		t.Errorf("wrong view: %#v", v)
+	}
+}
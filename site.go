@@ -31,7 +31,7 @@ var reserved = []string{
 	"stat", "stats",
 }
 
-var statTables = []string{"system_stats", "browser_stats", "location_stats", "language_stats", "size_stats"}
+var statTables = []string{"system_stats", "browser_stats", "location_stats", "language_stats", "network_stats", "device_stats", "engine_stats", "prop_stats", "revenue_stats", "size_stats"}
 
 type (
 	SiteID  int32
@@ -136,6 +136,7 @@ func (s Site) ClearCache(ctx context.Context, full bool) {
 	if full {
 		cachePaths(ctx).Reset()
 		cacheChangedTitles(ctx).Reset()
+		cacheProps(ctx).Reset()
 	}
 }
 
@@ -500,21 +501,25 @@ func (s *Site) Find(ctx context.Context, ident string) error {
 }
 
 // ListSubs lists all subsites, including the current site and parent.
+//
+// Sites the user in the context doesn't have access to are not included.
 func (s *Site) ListSubs(ctx context.Context) ([]string, error) {
-	col := "cname"
-	if Config(ctx).GoatcounterCom {
-		col = "code"
+	var sites Sites
+	err := sites.ForAccount(ctx, s.ID)
+	if err != nil {
+		return nil, errors.Wrap(err, "Site.ListSubs")
 	}
-	var codes []string
-	err := zdb.Select(ctx, &codes, `/* Site.ListSubs */
-		select `+col+` from sites
-		where state=$1 and (parent=$2 or site_id=$2) or (
-			parent  = (select parent from sites where site_id=$2) or
-			site_id = (select parent from sites where site_id=$2)
-		) and state=$1
-		order by code
-		`, StateActive, s.ID)
-	return codes, errors.Wrap(err, "Site.ListSubs")
+	sites = sites.accessible(ctx)
+
+	codes := make([]string, 0, len(sites))
+	for _, ss := range sites {
+		if Config(ctx).GoatcounterCom {
+			codes = append(codes, ss.Code)
+		} else if ss.Cname != nil {
+			codes = append(codes, *ss.Cname)
+		}
+	}
+	return codes, nil
 }
 
 // Domain gets the global default domain, or this site's configured custom
@@ -577,7 +582,7 @@ func (s Site) IDOrParent() SiteID {
 // user intact.
 func (s Site) DeleteAll(ctx context.Context) error {
 	return zdb.TX(ctx, func(ctx context.Context) error {
-		for _, t := range append(statTables, "campaign_stats", "hit_counts", "ref_counts", "hits", "paths") {
+		for _, t := range append(statTables, "campaign_stats", "search_queries", "anomalies", "hit_counts", "ref_counts", "hits", "paths", "props") {
 			err := zdb.Exec(ctx, `delete from `+t+` where site_id=:id`, map[string]any{"id": s.ID})
 			if err != nil {
 				return errors.Wrap(err, "Site.DeleteAll: delete "+t)
@@ -604,7 +609,7 @@ func (s Site) DeleteOlderThan(ctx context.Context, days int) error {
 			return errors.Wrap(err, "Site.DeleteOlderThan: get paths")
 		}
 
-		for _, t := range append(statTables, "campaign_stats") {
+		for _, t := range append(statTables, "campaign_stats", "search_queries", "anomalies") {
 			err := zdb.Exec(ctx, `delete from `+t+` where site_id=$1 and day < `+ival, s.ID)
 			if err != nil {
 				return errors.Wrap(err, "Site.DeleteOlderThan: delete "+t)
@@ -679,11 +684,17 @@ func (s *Sites) UnscopedListCnames(ctx context.Context) error {
 }
 
 // ListSubs lists all subsites for the current site.
+//
+// Sites the user in the context doesn't have access to are not included.
 func (s *Sites) ListSubs(ctx context.Context) error {
 	err := zdb.Select(ctx, s, `/* Sites.ListSubs */
 		select * from sites where parent=$1 and state=$2 order by code`,
 		MustGetSite(ctx).ID, StateActive)
-	return errors.Wrap(err, "Sites.ListSubs")
+	if err != nil {
+		return errors.Wrap(err, "Sites.ListSubs")
+	}
+	*s = s.accessible(ctx)
+	return nil
 }
 
 // ForAccount gets all sites associated with an account.
@@ -700,6 +711,8 @@ func (s *Sites) ForAccount(ctx context.Context, accountID SiteID) error {
 }
 
 // ForThisAccount gets all sites associated with this account.
+//
+// Sites the user in the context doesn't have access to are not included.
 func (s *Sites) ForThisAccount(ctx context.Context, excludeCurrent bool) error {
 	site := MustGetSite(ctx)
 	err := s.ForAccount(ctx, site.ID)
@@ -707,6 +720,7 @@ func (s *Sites) ForThisAccount(ctx context.Context, excludeCurrent bool) error {
 		return errors.Wrap(err, "Sites.ForThisAccount")
 	}
 
+	*s = s.accessible(ctx)
 	if excludeCurrent {
 		ss := *s
 		for i := range ss {
@@ -720,6 +734,16 @@ func (s *Sites) ForThisAccount(ctx context.Context, excludeCurrent bool) error {
 	return nil
 }
 
+// accessible filters the sites to just those the user in the context has access
+// to. All sites are returned if there is no user.
+func (s Sites) accessible(ctx context.Context) Sites {
+	u := GetUser(ctx)
+	if u == nil || u.ID == 0 {
+		return s
+	}
+	return slices.DeleteFunc(s, func(ss Site) bool { return !u.HasAccess(ss.ID, AccessReadOnly) })
+}
+
 // ContainsCNAME reports if there is a site with this CNAME set.
 func (s *Sites) ContainsCNAME(ctx context.Context, cname string) (bool, error) {
 	var ok bool
@@ -187,6 +187,7 @@ func init() {
 			{href: "start", label: "Getting started"},
 			// {href: "wordpress", label: "WordPress"},
 			{href: "visitor-counter", label: "Visitor counter"},
+			{href: "embed", label: "Embeddable widgets"},
 			{href: "events", label: "Events"},
 			{href: "csp", label: "Content-Security-Policy"},
 			{href: "js", label: "JavaScript API"}}},
@@ -314,6 +315,13 @@ func init() {
 	tplfunc.Add("nformat", func(n any, u User) string {
 		return tplfunc.Number(n, u.Settings.NumberFormat)
 	})
+	tplfunc.Add("revenue_list", func(stats []RevenueStat) string {
+		l := make([]string, 0, len(stats))
+		for _, s := range stats {
+			l = append(l, s.Total.String()+" "+s.Currency)
+		}
+		return strings.Join(l, ", ")
+	})
 
 	tplfunc.Add("totp_barcode", func(email string, secret []byte) template.HTML {
 		img, err := otp.URL(secret, "GoatCounter", email).PNGDataURL(200)
@@ -517,6 +525,18 @@ type (
 		Rows    int
 		Errors  *errors.Group
 	}
+	TplEmailAPITokenExpiry struct {
+		Context context.Context
+		Site    Site
+		User    User
+		Token   APIToken
+	}
+	TplEmailReportRecipient struct {
+		Context   context.Context
+		Site      Site
+		User      User
+		Recipient ReportRecipient
+	}
 )
 
 var tplE = ztpl.ExecuteBytes
@@ -529,3 +549,9 @@ func (t TplEmailAddUser) Render() ([]byte, error)       { return tplE("email_add
 func (t TplEmailImportError) Render() ([]byte, error)   { return tplE("email_import_error.gotxt", t) }
 func (t TplEmailExportDone) Render() ([]byte, error)    { return tplE("email_export_done.gotxt", t) }
 func (t TplEmailImportDone) Render() ([]byte, error)    { return tplE("email_import_done.gotxt", t) }
+func (t TplEmailAPITokenExpiry) Render() ([]byte, error) {
+	return tplE("email_api_token_expiry.gotxt", t)
+}
+func (t TplEmailReportRecipient) Render() ([]byte, error) {
+	return tplE("email_report_recipient.gotxt", t)
+}
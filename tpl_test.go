@@ -5,6 +5,7 @@ import (
 	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"zgo.at/errors"
 	. "zgo.at/goatcounter/v2"
@@ -42,6 +43,14 @@ func TestTpl(t *testing.T) {
 		{TplEmailImportError{ctx, errors.Unwrap(errors.New("oh noes"))}},
 		{TplEmailImportDone{ctx, site, 42, errors.NewGroup(10)}},
 		{TplEmailImportDone{ctx, site, 42, errs}},
+		{TplEmailAPITokenExpiry{ctx, site, user, APIToken{
+			Name:      "deploy",
+			ExpiresAt: new(time.Date(2026, 11, 1, 12, 0, 0, 0, time.UTC)),
+		}}},
+		{TplEmailReportRecipient{ctx, site, user, ReportRecipient{
+			Email: "b@example.com",
+			Token: "T-RCPT",
+		}}},
 		//{TplEmailAddUser{ctx, site, user, "foo@example.com"}},
 
 		{TplEmailExportDone{ctx, site, user, Export{
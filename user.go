@@ -2,11 +2,18 @@ package goatcounter
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
 	"database/sql"
 	"database/sql/driver"
+	"encoding/hex"
 	"fmt"
+	"maps"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
+	"unicode"
 
 	"golang.org/x/crypto/bcrypt"
 	"zgo.at/errors"
@@ -74,6 +81,12 @@ func (u *User) Defaults(ctx context.Context) {
 		u.EmailToken = new(zcrypto.Secret192())
 	}
 
+	// An empty per-site access means "use the default from all", and admins
+	// always have access to all sites.
+	maps.DeleteFunc(u.Access, func(k string, a UserAccess) bool {
+		return k != "all" && (a == "" || u.AccessAdmin())
+	})
+
 	u.Settings.Defaults(ctx)
 }
 
@@ -89,6 +102,7 @@ func (u *User) Validate(ctx context.Context) error {
 	if len(u.Access) == 0 {
 		v.Append("access", "must be set")
 	}
+	u.Access.validate(ctx, &v, u.Site)
 
 	v.Sub("settings", "", u.Settings.Validate(ctx))
 
@@ -154,7 +168,7 @@ func (u *User) Delete(ctx context.Context, lastAdmin bool) error {
 		}
 		admins = admins.Admins()
 		if len(admins) == 1 && admins[0].ID == u.ID {
-			return fmt.Errorf("can't delete last admin user for site %d", u.Site)
+			return guru.New(400, z18n.T(ctx, "error/delete-last-admin|Can't delete the last admin user"))
 		}
 	}
 
@@ -163,8 +177,15 @@ func (u *User) Delete(ctx context.Context, lastAdmin bool) error {
 		return errors.Wrap(err, "User.Delete")
 	}
 
-	err = zdb.Exec(ctx, `delete from users where user_id=? and site_id=?`,
-		u.ID, account.ID)
+	err = zdb.TX(ctx, func(ctx context.Context) error {
+		for _, t := range []string{"webauthn_credentials", "recovery_codes", "report_recipients", "users"} {
+			err := zdb.Exec(ctx, `delete from `+t+` where user_id=? and site_id=?`, u.ID, account.ID)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 	return errors.Wrap(err, "User.Delete")
 }
 
@@ -340,6 +361,62 @@ func (u *User) DisableTOTP(ctx context.Context) error {
 	return errors.Wrap(err, "User.DisableTOTP")
 }
 
+// GenerateRecoveryCodes creates new one-time recovery codes, which can be used
+// instead of the second factor. Any existing codes are removed.
+//
+// Only a hash is stored, so this is the only time the codes are available.
+func (u *User) GenerateRecoveryCodes(ctx context.Context) ([]string, error) {
+	codes := make([]string, 10)
+	err := zdb.TX(ctx, func(ctx context.Context) error {
+		err := zdb.Exec(ctx, `delete from recovery_codes where user_id=$1`, u.ID)
+		if err != nil {
+			return err
+		}
+		for i := range codes {
+			c := strings.ToLower(rand.Text()[:10])
+			codes[i] = c[:5] + "-" + c[5:]
+			err := zdb.Exec(ctx, `insert into recovery_codes (site_id, user_id, code, created_at) values ($1, $2, $3, $4)`,
+				u.Site, u.ID, hashRecoveryCode(c), ztime.Now(ctx))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return codes, errors.Wrap(err, "User.GenerateRecoveryCodes")
+}
+
+// UseRecoveryCode checks if the recovery code is valid, and removes it if it
+// is.
+func (u *User) UseRecoveryCode(ctx context.Context, code string) (bool, error) {
+	code = strings.Map(func(r rune) rune {
+		if r == '-' || unicode.IsSpace(r) {
+			return -1
+		}
+		return unicode.ToLower(r)
+	}, code)
+	if code == "" {
+		return false, nil
+	}
+
+	var ids []int64
+	err := zdb.Select(ctx, &ids, `delete from recovery_codes where user_id=$1 and code=$2 returning recovery_code_id`,
+		u.ID, hashRecoveryCode(code))
+	return len(ids) > 0, errors.Wrap(err, "User.UseRecoveryCode")
+}
+
+// RecoveryCodes gets the number of unused recovery codes.
+func (u *User) RecoveryCodes(ctx context.Context) (int, error) {
+	var n int
+	err := zdb.Get(ctx, &n, `select count(*) from recovery_codes where user_id=$1`, u.ID)
+	return n, errors.Wrap(err, "User.RecoveryCodes")
+}
+
+func hashRecoveryCode(code string) string {
+	h := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(h[:])
+}
+
 // Login a user; create a new key, CSRF token, and reset the request date.
 func (u *User) Login(ctx context.Context) error {
 	if u.ID == 0 {
@@ -386,25 +463,31 @@ func (u *User) CSRFToken() string {
 	return *u.Token
 }
 
+// SiteAccess gets the access level for a site.
+//
+// The "all" entry applies to every site in the account, unless there's an entry
+// for the site ID. Admins and superusers always have access to all sites.
+func (u User) SiteAccess(siteID SiteID) UserAccess {
+	all := u.Access["all"]
+	if all == AccessAdmin || all == AccessSuperuser {
+		return all
+	}
+	if a, ok := u.Access[strconv.FormatInt(int64(siteID), 10)]; ok {
+		return a
+	}
+	return all
+}
+
 // HasAccess checks if this user has access to this site for the permission.
-func (u User) HasAccess(check UserAccess) bool {
-	switch check {
-	default:
+func (u User) HasAccess(siteID SiteID, check UserAccess) bool {
+	if check.level() == 0 {
 		return false
-	case AccessSuperuser:
-		return u.Access["all"] == AccessSuperuser
-	case AccessAdmin:
-		return u.Access["all"] == AccessSuperuser || u.Access["all"] == AccessAdmin
-	case AccessSettings:
-		return u.Access["all"] == AccessSuperuser || u.Access["all"] == AccessAdmin || u.Access["all"] == AccessSettings
-	case AccessReadOnly:
-		return u.Access["all"] == AccessSuperuser || u.Access["all"] == AccessAdmin || u.Access["all"] == AccessSettings || u.Access["all"] == AccessReadOnly
 	}
+	return u.SiteAccess(siteID).level() >= check.level()
 }
 
 func (u User) AccessSuperuser() bool { return u.Access["all"] == AccessSuperuser }
 func (u User) AccessAdmin() bool     { return u.AccessSuperuser() || u.Access["all"] == AccessAdmin }
-func (u User) AccessSettings() bool  { return u.AccessAdmin() || u.Access["all"] == AccessSettings }
 
 // EmailReportRange gets the time range of the next report to send out.
 //
@@ -442,6 +525,29 @@ func (u User) EmailReportRange(ctx context.Context) ztime.Range {
 	return ztime.NewRange(start.Time.Truncate(time.Second)).To(end.Time.Truncate(time.Second))
 }
 
+// PreviousEmailReportRange gets the time range of the last complete report
+// period, for previewing the report. This uses weekly reports if reports are
+// disabled.
+func (u User) PreviousEmailReportRange(ctx context.Context) ztime.Range {
+	if u.Settings.EmailReports == EmailReportNever {
+		u.Settings.EmailReports = EmailReportWeekly
+	}
+
+	now := ztime.Time{Time: ztime.Now(ctx).In(u.Settings.Timezone.Loc())}
+	switch u.Settings.EmailReports {
+	case EmailReportDaily:
+		now = now.AddPeriod(-1, ztime.Day)
+	case EmailReportWeekly:
+		now = now.AddPeriod(-7, ztime.Day)
+	case EmailReportBiWeekly:
+		now = now.AddPeriod(-14, ztime.Day)
+	case EmailReportMonthly:
+		now = now.StartOf(ztime.Month).AddPeriod(-1, ztime.Day)
+	}
+	u.LastReportAt = now.Time
+	return u.EmailReportRange(ctx)
+}
+
 func (u User) EmailShort() string {
 	local, _, ok := strings.Cut(u.Email, "@")
 	if ok {
@@ -514,6 +620,7 @@ type (
 )
 
 const (
+	AccessNone      UserAccess = "-"
 	AccessReadOnly  UserAccess = "r"
 	AccessSettings  UserAccess = "s"
 	AccessAdmin     UserAccess = "a"
@@ -523,6 +630,8 @@ const (
 // TODO: this is not translated.
 func (u UserAccess) String() string {
 	switch u {
+	case AccessNone, "":
+		return "no access"
 	case AccessReadOnly:
 		return "read only"
 	case AccessSettings:
@@ -536,6 +645,71 @@ func (u UserAccess) String() string {
 	}
 }
 
+func (u UserAccess) level() int {
+	switch u {
+	case AccessReadOnly:
+		return 1
+	case AccessSettings:
+		return 2
+	case AccessAdmin:
+		return 3
+	case AccessSuperuser:
+		return 4
+	default:
+		return 0
+	}
+}
+
+// Sites gets the access for the sites with an explicit entry, excluding "all".
+func (u UserAccesses) Sites() map[SiteID]UserAccess {
+	m := make(map[SiteID]UserAccess)
+	for k, a := range u {
+		id, err := zstrconv.ParseInt[SiteID](k, 10)
+		if err == nil {
+			m[id] = a
+		}
+	}
+	return m
+}
+
+func (u UserAccesses) validate(ctx context.Context, v *zvalidate.Validator, accountID SiteID) {
+	var ids []SiteID
+	for k, a := range u {
+		if k == "all" {
+			v.Include("access.all", string(a), []string{string(AccessNone), string(AccessReadOnly),
+				string(AccessSettings), string(AccessAdmin), string(AccessSuperuser)})
+			continue
+		}
+
+		id, err := zstrconv.ParseInt[SiteID](k, 10)
+		if err != nil {
+			v.Append("access."+k, "must be \"all\" or a site ID")
+			continue
+		}
+		// Admin access is for the entire account; it makes no sense to have it
+		// on just one site.
+		v.Include("access."+k, string(a), []string{string(AccessNone), string(AccessReadOnly),
+			string(AccessSettings)})
+		ids = append(ids, id)
+	}
+
+	if len(ids) == 0 || accountID == 0 {
+		return
+	}
+	var sites Sites
+	err := sites.ForAccount(ctx, accountID)
+	if err != nil {
+		v.Append("access", err.Error())
+		return
+	}
+	have := sites.IDs()
+	for _, id := range ids {
+		if !slices.Contains(have, int32(id)) {
+			v.Append("access."+strconv.FormatInt(int64(id), 10), "site doesn't exist or is not in this account")
+		}
+	}
+}
+
 // Value implements the SQL Value function to determine what to store in the DB.
 func (u UserAccesses) Value() (driver.Value, error) { return json.Marshal(u) }
 
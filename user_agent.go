@@ -11,17 +11,20 @@ import (
 
 type UserAgent struct {
 	UserAgent string
+	Hints     ClientHints
 	Isbot     uint8
 	BrowserID BrowserID
 	SystemID  SystemID
+	Device    string // Device type; one of the Device* constants.
+	Engine    string // Browser engine; one of the Engine* constants, or "" if not known.
 }
 
 func (p *UserAgent) GetOrInsert(ctx context.Context) error {
-	shortUA := gadget.ShortenUA(p.UserAgent)
-	c, ok := cacheUA(ctx).Get(p.UserAgent)
+	k := gadget.ShortenUA(p.UserAgent) + p.Hints.String()
+	c, ok := cacheUA(ctx).Get(k)
 	if ok {
 		*p = c
-		cacheUA(ctx).Touch(shortUA)
+		cacheUA(ctx).Touch(k)
 		return nil
 	}
 
@@ -31,6 +34,8 @@ func (p *UserAgent) GetOrInsert(ctx context.Context) error {
 		system  System
 	)
 
+	p.Hints.apply(&ua)
+
 	err := browser.GetOrInsert(ctx, ua.BrowserName, ua.BrowserVersion)
 	if err != nil {
 		return errors.Wrap(err, "UserAgent.GetOrInsert")
@@ -43,9 +48,12 @@ func (p *UserAgent) GetOrInsert(ctx context.Context) error {
 	}
 	p.SystemID = system.ID
 
-	p.Isbot = uint8(isbot.UserAgent(p.UserAgent))
+	bot := isbot.UserAgent(p.UserAgent)
+	p.Isbot = uint8(bot)
+	p.Device = deviceType(p.UserAgent, ua, p.Hints, bot)
+	p.Engine = browserEngine(p.UserAgent, ua)
 
-	cacheUA(ctx).Set(shortUA, *p)
+	cacheUA(ctx).Set(k, *p)
 	return nil
 }
 
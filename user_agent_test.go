@@ -1,6 +1,7 @@
 package goatcounter_test
 
 import (
+	"fmt"
 	"reflect"
 	"strings"
 	"testing"
@@ -10,6 +11,7 @@ import (
 	"zgo.at/isbot"
 	"zgo.at/zdb"
 	"zgo.at/zstd/ztest"
+	"zgo.at/zstd/ztime"
 )
 
 func TestUserAgentGetOrInsert(t *testing.T) {
@@ -37,7 +39,8 @@ func TestUserAgentGetOrInsert(t *testing.T) {
 		if err != nil {
 			t.Fatal(err)
 		}
-		test(ua, UserAgent{UserAgent: ua.UserAgent, BrowserID: 1, SystemID: 1, Isbot: isbot.NoBotNoMatch}, `
+		test(ua, UserAgent{UserAgent: ua.UserAgent, BrowserID: 1, SystemID: 1, Isbot: isbot.NoBotNoMatch,
+			Device: DeviceDesktop, Engine: EngineGecko}, `
 			browser
 			Firefox 79
 			system
@@ -51,7 +54,8 @@ func TestUserAgentGetOrInsert(t *testing.T) {
 		if err != nil {
 			t.Fatal(err)
 		}
-		test(ua, UserAgent{UserAgent: ua.UserAgent, BrowserID: 1, SystemID: 1, Isbot: isbot.NoBotNoMatch}, `
+		test(ua, UserAgent{UserAgent: ua.UserAgent, BrowserID: 1, SystemID: 1, Isbot: isbot.NoBotNoMatch,
+			Device: DeviceDesktop, Engine: EngineGecko}, `
 			browser
 			Firefox 79
 			system
@@ -65,7 +69,8 @@ func TestUserAgentGetOrInsert(t *testing.T) {
 		if err != nil {
 			t.Fatal(err)
 		}
-		test(ua, UserAgent{UserAgent: ua.UserAgent, BrowserID: 1, SystemID: 2, Isbot: isbot.NoBotNoMatch}, `
+		test(ua, UserAgent{UserAgent: ua.UserAgent, BrowserID: 1, SystemID: 2, Isbot: isbot.NoBotNoMatch,
+			Device: DeviceDesktop, Engine: EngineGecko}, `
 			browser
 			Firefox 79
 			system
@@ -80,7 +85,8 @@ func TestUserAgentGetOrInsert(t *testing.T) {
 		if err != nil {
 			t.Fatal(err)
 		}
-		test(ua, UserAgent{UserAgent: ua.UserAgent, BrowserID: 2, SystemID: 2, Isbot: isbot.NoBotNoMatch}, `
+		test(ua, UserAgent{UserAgent: ua.UserAgent, BrowserID: 2, SystemID: 2, Isbot: isbot.NoBotNoMatch,
+			Device: DeviceDesktop, Engine: EngineGecko}, `
 			browser
 			Firefox 79
 			Firefox 71
@@ -90,3 +96,94 @@ func TestUserAgentGetOrInsert(t *testing.T) {
 		`)
 	}
 }
+
+func TestUserAgentDevice(t *testing.T) {
+	ctx := gctest.DB(t)
+
+	tests := []struct {
+		ua, device, engine string
+	}{
+		{"Mozilla/5.0 (X11; Linux x86_64; rv:79.0) Gecko/20100101 Firefox/79.0",
+			DeviceDesktop, EngineGecko},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+			DeviceDesktop, EngineBlink},
+		{"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Safari/605.1.15",
+			DeviceDesktop, EngineWebKit},
+		{"Mozilla/5.0 (Windows NT 6.1; WOW64; Trident/7.0; rv:11.0) like Gecko",
+			DeviceDesktop, EngineTrident},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/70.0.3538.102 Safari/537.36 Edge/18.19582",
+			DeviceDesktop, EngineEdgeHTML},
+		{"Opera/9.80 (Windows NT 6.1; WOW64) Presto/2.12.388 Version/12.18",
+			DeviceDesktop, EnginePresto},
+		{"Mozilla/5.0 (iPhone; CPU iPhone OS 17_1 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Mobile/15E148 Safari/604.1",
+			DeviceMobile, EngineWebKit},
+		{"Mozilla/5.0 (iPhone; CPU iPhone OS 17_1 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) CriOS/119.0.6045.169 Mobile/15E148 Safari/604.1",
+			DeviceMobile, EngineWebKit},
+		{"Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.6099.43 Mobile Safari/537.36",
+			DeviceMobile, EngineBlink},
+		{"Mozilla/5.0 (Android 14; Mobile; rv:120.0) Gecko/120.0 Firefox/120.0",
+			DeviceMobile, EngineGecko},
+		{"Mozilla/5.0 (iPad; CPU OS 12_2 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/12.1 Mobile/15E148 Safari/604.1",
+			DeviceTablet, EngineWebKit},
+		{"Mozilla/5.0 (Linux; Android 13; SM-X700) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.6099.43 Safari/537.36",
+			DeviceTablet, EngineBlink},
+		{"Mozilla/5.0 (SMART-TV; Linux; Tizen 6.0) AppleWebKit/537.36 (KHTML, like Gecko) SamsungBrowser/4.0 Chrome/76.0.3809.146 TV Safari/537.36",
+			DeviceTV, EngineBlink},
+		{"Mozilla/5.0 (PlayStation; PlayStation 5/2.26) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/13.0 Safari/605.1.15",
+			DeviceTV, EngineWebKit},
+		{"curl/8.4.0",
+			DeviceBot, ""},
+		{"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+			DeviceBot, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run("", func(t *testing.T) {
+			ua := UserAgent{UserAgent: tt.ua}
+			err := ua.GetOrInsert(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ua.Device != tt.device || ua.Engine != tt.engine {
+				t.Errorf("\nhave: %q %q\nwant: %q %q", ua.Device, ua.Engine, tt.device, tt.engine)
+			}
+		})
+	}
+}
+
+func TestHitStatsListDevices(t *testing.T) {
+	ctx := gctest.DB(t)
+
+	var (
+		firefox = "Mozilla/5.0 (X11; Linux x86_64; rv:79.0) Gecko/20100101 Firefox/79.0"
+		iphone  = "Mozilla/5.0 (iPhone; CPU iPhone OS 17_1 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Mobile/15E148 Safari/604.1"
+	)
+	gctest.StoreHits(ctx, t, false,
+		Hit{FirstVisit: true, UserAgentHeader: firefox},
+		Hit{FirstVisit: true, UserAgentHeader: iphone},
+		Hit{FirstVisit: true, UserAgentHeader: iphone})
+
+	rng := ztime.NewRange(ztime.Now(ctx)).To(ztime.Now(ctx))
+
+	var stats HitStats
+	err := stats.ListDevices(ctx, rng, PathFilter{}, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	have := fmt.Sprintf("%v", stats.Stats)
+	want := "[{mobile Mobile 2 <nil>} {desktop Desktop 1 <nil>}]"
+	if have != want {
+		t.Errorf("\nhave: %s\nwant: %s", have, want)
+	}
+
+	stats = HitStats{}
+	err = stats.ListEngines(ctx, rng, PathFilter{}, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	have = fmt.Sprintf("%v", stats.Stats)
+	want = "[{WebKit WebKit 2 <nil>} {Gecko Gecko 1 <nil>}]"
+	if have != want {
+		t.Errorf("\nhave: %s\nwant: %s", have, want)
+	}
+}
@@ -2,11 +2,16 @@ package goatcounter_test
 
 import (
 	"context"
+	"fmt"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
 	"zgo.at/goatcounter/v2"
+	"zgo.at/goatcounter/v2/gctest"
 	"zgo.at/tz"
+	"zgo.at/zstd/ztest"
 	"zgo.at/zstd/ztime"
 )
 
@@ -80,3 +85,137 @@ func TestUserEmailReportRange(t *testing.T) {
 		})
 	}
 }
+
+func TestUserAccess(t *testing.T) {
+	ctx := gctest.DB(t)
+
+	account := goatcounter.MustGetSite(ctx)
+	var ids []goatcounter.SiteID
+	for _, c := range []string{"two", "three"} {
+		s := goatcounter.Site{Code: c, Cname: new(c + ".localhost"), Parent: &account.ID}
+		err := s.Insert(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, s.ID)
+	}
+	key := func(id goatcounter.SiteID) string { return strconv.FormatInt(int64(id), 10) }
+
+	u := goatcounter.User{
+		Site:          account.ID,
+		Email:         "user@example.com",
+		EmailVerified: true,
+		Access: goatcounter.UserAccesses{
+			"all":       goatcounter.AccessReadOnly,
+			key(ids[0]): goatcounter.AccessSettings,
+			key(ids[1]): goatcounter.AccessNone,
+		},
+	}
+	err := u.Insert(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		site  goatcounter.SiteID
+		check goatcounter.UserAccess
+		want  bool
+	}{
+		{account.ID, goatcounter.AccessReadOnly, true},
+		{account.ID, goatcounter.AccessSettings, false},
+		{ids[0], goatcounter.AccessSettings, true},
+		{ids[0], goatcounter.AccessAdmin, false},
+		{ids[1], goatcounter.AccessReadOnly, false},
+		{ids[1], goatcounter.AccessNone, false},
+	}
+	for _, tt := range tests {
+		if have := u.HasAccess(tt.site, tt.check); have != tt.want {
+			t.Errorf("HasAccess(%d, %q): %t", tt.site, tt.check, have)
+		}
+	}
+
+	var sites goatcounter.Sites
+	err = sites.ForThisAccount(goatcounter.WithUser(ctx, &u), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := fmt.Sprintf("%v", sites.IDs()), fmt.Sprintf("[%d %d]", account.ID, ids[0]); have != want {
+		t.Errorf("ForThisAccount\nhave: %s\nwant: %s", have, want)
+	}
+
+	{ // Admins can always access everything.
+		admin := u
+		admin.Access = goatcounter.UserAccesses{"all": goatcounter.AccessAdmin, key(ids[1]): goatcounter.AccessNone}
+		if !admin.HasAccess(ids[1], goatcounter.AccessAdmin) {
+			t.Error("admin doesn't have access")
+		}
+	}
+
+	{ // Validation.
+		u.Access = goatcounter.UserAccesses{"all": goatcounter.AccessReadOnly, key(ids[0]): goatcounter.AccessAdmin}
+		err := u.Update(ctx, false)
+		if !ztest.ErrorContains(err, "access."+key(ids[0])) {
+			t.Errorf("wrong error: %v", err)
+		}
+
+		u.Access = goatcounter.UserAccesses{"all": goatcounter.AccessReadOnly, "9999": goatcounter.AccessReadOnly}
+		err = u.Update(ctx, false)
+		if !ztest.ErrorContains(err, "not in this account") {
+			t.Errorf("wrong error: %v", err)
+		}
+	}
+}
+
+func TestEmailReportOptionsValidate(t *testing.T) {
+	ctx := gctest.DB(t)
+
+	tests := []struct {
+		in      goatcounter.EmailReportOptions
+		wantErr string
+	}{
+		{goatcounter.EmailReportOptions{Widgets: goatcounter.Strings{"pages", "browsers"}, Recipients: goatcounter.Strings{"a@example.com"}}, ""},
+		{goatcounter.EmailReportOptions{Widgets: goatcounter.Strings{"nope"}}, "widgets: must be one of"},
+		{goatcounter.EmailReportOptions{Recipients: goatcounter.Strings{"not an email"}}, "recipients: must be a valid email"},
+		{goatcounter.EmailReportOptions{Recipients: make(goatcounter.Strings, 21)}, "recipients: can have at most 20 addresses"},
+	}
+
+	for _, tt := range tests {
+		t.Run("", func(t *testing.T) {
+			err := tt.in.Validate(ctx)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("\nhave: %v\nwant: %s", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEmailReportOptionsDefaults(t *testing.T) {
+	var o goatcounter.EmailReportOptions
+	o.Defaults()
+	if !o.Compare || o.Widgets.String() != "pages, toprefs" {
+		t.Errorf("%#v", o)
+	}
+
+	// Don't reset options that were set by the user.
+	o = goatcounter.EmailReportOptions{Widgets: goatcounter.Strings{}}
+	o.Defaults()
+	if o.Compare || len(o.Widgets) != 0 {
+		t.Errorf("%#v", o)
+	}
+
+	var ss goatcounter.UserSettings
+	err := ss.Scan([]byte(`{"email_report_options": {"widgets": ""}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ss.EmailReportOptions.Defaults()
+	if ss.EmailReportOptions.Compare || len(ss.EmailReportOptions.Widgets) != 0 {
+		t.Errorf("%#v", ss.EmailReportOptions)
+	}
+}
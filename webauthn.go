@@ -0,0 +1,140 @@
+package goatcounter
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"zgo.at/errors"
+	"zgo.at/goatcounter/v2/pkg/webauthn"
+	"zgo.at/guru"
+	"zgo.at/z18n"
+	"zgo.at/zdb"
+	"zgo.at/zstd/ztime"
+)
+
+type WebAuthnCredentialID int32
+
+// WebAuthnCredential is a security key or passkey registered by a user, which
+// can be used as a second factor or to sign in without a password.
+type WebAuthnCredential struct {
+	ID     WebAuthnCredentialID `db:"webauthn_credential_id,id" json:"-"`
+	SiteID SiteID               `db:"site_id" json:"-"`
+	UserID UserID               `db:"user_id" json:"-"`
+
+	Name         string `db:"name" json:"name"`
+	CredentialID string `db:"credential_id" json:"-"` // base64url-encoded.
+	PublicKey    []byte `db:"public_key" json:"-"`    // COSE_Key.
+	SignCount    int64  `db:"sign_count" json:"-"`
+
+	CreatedAt  time.Time  `db:"created_at" json:"created_at"`
+	LastUsedAt *time.Time `db:"last_used_at" json:"last_used_at"`
+}
+
+func (WebAuthnCredential) Table() string { return "webauthn_credentials" }
+
+var _ zdb.Defaulter = &WebAuthnCredential{}
+
+func (c *WebAuthnCredential) Defaults(ctx context.Context) {
+	c.Name = strings.TrimSpace(c.Name)
+	if c.CreatedAt.IsZero() {
+		c.CreatedAt = ztime.Now(ctx)
+	}
+}
+
+var _ zdb.Validator = &WebAuthnCredential{}
+
+func (c *WebAuthnCredential) Validate(ctx context.Context) error {
+	v := NewValidate(ctx)
+	v.Required("name", c.Name)
+	v.Len("name", c.Name, 0, 50)
+	v.Required("credential_id", c.CredentialID)
+	if len(c.PublicKey) == 0 {
+		v.Append("public_key", "must be set")
+	}
+	return v.ErrorOrNil()
+}
+
+// Insert a new credential.
+func (c *WebAuthnCredential) Insert(ctx context.Context) error {
+	if c.ID > 0 {
+		return errors.New("ID > 0")
+	}
+	c.SiteID = MustGetAccount(ctx).ID
+	err := zdb.Insert(ctx, c)
+	return errors.Wrap(err, "WebAuthnCredential.Insert")
+}
+
+// ByID gets a credential for the current user by ID.
+func (c *WebAuthnCredential) ByID(ctx context.Context, id WebAuthnCredentialID) error {
+	err := zdb.Get(ctx, c, `select * from webauthn_credentials where webauthn_credential_id=$1 and user_id=$2`,
+		id, GetUser(ctx).ID)
+	return errors.Wrapf(err, "WebAuthnCredential.ByID(%d)", id)
+}
+
+// ByCredentialID gets a credential by the credential ID chosen by the
+// authenticator.
+func (c *WebAuthnCredential) ByCredentialID(ctx context.Context, credID string) error {
+	err := zdb.Get(ctx, c, `select * from webauthn_credentials where credential_id=$1 and site_id=$2`,
+		credID, MustGetAccount(ctx).ID)
+	return errors.Wrapf(err, "WebAuthnCredential.ByCredentialID(%q)", credID)
+}
+
+// Use records that this credential was used to sign in.
+//
+// The signature counter should always increase, unless the authenticator
+// doesn't use one (and the counter is always 0). A lower counter means the
+// authenticator was probably cloned.
+func (c *WebAuthnCredential) Use(ctx context.Context, signCount uint32) error {
+	if (signCount != 0 || c.SignCount != 0) && int64(signCount) <= c.SignCount {
+		return guru.New(403, z18n.T(ctx, "error/webauthn-sign-count|The signature counter for %(name) went backwards; this may be a cloned security key", c.Name))
+	}
+
+	c.SignCount, c.LastUsedAt = int64(signCount), new(ztime.Now(ctx))
+	err := zdb.Exec(ctx, `update webauthn_credentials set sign_count=$1, last_used_at=$2 where webauthn_credential_id=$3`,
+		c.SignCount, c.LastUsedAt, c.ID)
+	return errors.Wrap(err, "WebAuthnCredential.Use")
+}
+
+// Delete this credential.
+func (c *WebAuthnCredential) Delete(ctx context.Context) error {
+	err := zdb.Exec(ctx, `delete from webauthn_credentials where webauthn_credential_id=$1 and user_id=$2`,
+		c.ID, c.UserID)
+	return errors.Wrapf(err, "WebAuthnCredential.Delete(%d)", c.ID)
+}
+
+type WebAuthnCredentials []WebAuthnCredential
+
+// List all credentials for a user.
+func (c *WebAuthnCredentials) List(ctx context.Context, userID UserID) error {
+	err := zdb.Select(ctx, c, `select * from webauthn_credentials where user_id=$1 order by created_at`, userID)
+	return errors.Wrap(err, "WebAuthnCredentials.List")
+}
+
+// WebAuthnChallengeExpiry is how long a challenge can be used after it was
+// created.
+const WebAuthnChallengeExpiry = 5 * time.Minute
+
+// NewWebAuthnChallenge creates a new challenge and stores it, so the response
+// from the browser can be checked against it.
+func NewWebAuthnChallenge(ctx context.Context) (string, error) {
+	challenge := webauthn.NewChallenge()
+	err := zdb.Exec(ctx, `insert into webauthn_challenges (site_id, challenge, created_at) values ($1, $2, $3)`,
+		MustGetAccount(ctx).ID, challenge, ztime.Now(ctx))
+	return challenge, errors.Wrap(err, "NewWebAuthnChallenge")
+}
+
+// UseWebAuthnChallenge checks if the challenge is valid, and removes it if it
+// is, so it can only be used once.
+func UseWebAuthnChallenge(ctx context.Context, challenge string) (bool, error) {
+	if challenge == "" {
+		return false, nil
+	}
+
+	var ids []int64
+	err := zdb.Select(ctx, &ids, `delete from webauthn_challenges
+		where site_id=$1 and challenge=$2 and created_at > $3
+		returning webauthn_challenge_id`,
+		MustGetAccount(ctx).ID, challenge, ztime.Now(ctx).Add(-WebAuthnChallengeExpiry))
+	return len(ids) > 0, errors.Wrap(err, "UseWebAuthnChallenge")
+}
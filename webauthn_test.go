@@ -0,0 +1,90 @@
+package goatcounter_test
+
+import (
+	"testing"
+	"time"
+
+	"zgo.at/goatcounter/v2"
+	"zgo.at/goatcounter/v2/gctest"
+	"zgo.at/zstd/ztest"
+	"zgo.at/zstd/ztime"
+)
+
+func TestWebAuthnCredentialUse(t *testing.T) {
+	ctx := gctest.DB(t)
+
+	c := goatcounter.WebAuthnCredential{
+		UserID:       goatcounter.GetUser(ctx).ID,
+		Name:         "key",
+		CredentialID: "AAAA",
+		PublicKey:    []byte{1},
+	}
+	err := c.Insert(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	{ // Authenticators without a counter always send 0.
+		err := c.Use(ctx, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = c.Use(ctx, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range []uint32{5, 4, 0} {
+		err = c.Use(ctx, n)
+		if !ztest.ErrorContains(err, "went backwards") {
+			t.Errorf("%d: wrong error: %v", n, err)
+		}
+	}
+
+	var got goatcounter.WebAuthnCredential
+	err = got.ByCredentialID(ctx, "AAAA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.SignCount != 5 || got.LastUsedAt == nil {
+		t.Errorf("%d %v", got.SignCount, got.LastUsedAt)
+	}
+
+	c.ID = 0
+	err = c.Insert(ctx)
+	if err == nil {
+		t.Error("no error for inserting the same credential twice")
+	}
+}
+
+func TestWebAuthnChallenge(t *testing.T) {
+	ctx := gctest.DB(t)
+
+	challenge, err := goatcounter.NewWebAuthnChallenge(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []bool{true, false} {
+		ok, err := goatcounter.UseWebAuthnChallenge(ctx, challenge)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != want {
+			t.Errorf("%d: %t", i, ok)
+		}
+	}
+
+	challenge, err = goatcounter.NewWebAuthnChallenge(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx = ztime.WithNow(ctx, ztime.Now(ctx).Add(goatcounter.WebAuthnChallengeExpiry+time.Second))
+	ok, err := goatcounter.UseWebAuthnChallenge(ctx, challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Error("expired challenge was accepted")
+	}
+}
@@ -0,0 +1,57 @@
+package widgets
+
+import (
+	"context"
+	"html/template"
+
+	"zgo.at/goatcounter/v2"
+	"zgo.at/z18n"
+)
+
+type Anomalies struct {
+	id     int
+	loaded bool
+	err    error
+	html   template.HTML
+	s      goatcounter.WidgetSettings
+
+	Limit     int
+	Anomalies goatcounter.Anomalies
+}
+
+func (w Anomalies) Name() string { return "anomalies" }
+func (w Anomalies) Type() string { return "hchart" }
+func (w Anomalies) Label(ctx context.Context) string {
+	return z18n.T(ctx, "label/anomalies|Unusual days")
+}
+func (w *Anomalies) SetHTML(h template.HTML)             { w.html = h }
+func (w Anomalies) HTML() template.HTML                  { return w.html }
+func (w *Anomalies) SetErr(h error)                      { w.err = h }
+func (w Anomalies) Err() error                           { return w.err }
+func (w Anomalies) ID() int                              { return w.id }
+func (w Anomalies) Settings() goatcounter.WidgetSettings { return w.s }
+
+func (w *Anomalies) SetSettings(s goatcounter.WidgetSettings) {
+	w.s = s
+	if x := s["limit"].Value; x != nil {
+		w.Limit = int(x.(float64))
+	}
+}
+
+func (w *Anomalies) GetData(ctx context.Context, a Args) (more bool, err error) {
+	err = w.Anomalies.List(ctx, a.Rng, a.PathFilter, w.Limit)
+	w.loaded = true
+	return false, err
+}
+
+func (w Anomalies) RenderHTML(ctx context.Context, shared SharedData) (string, any) {
+	return "_dashboard_anomalies.gohtml", struct {
+		Context   context.Context
+		ID        int
+		Loaded    bool
+		Err       error
+		Header    string
+		User      *goatcounter.User
+		Anomalies goatcounter.Anomalies
+	}{ctx, w.id, w.loaded, w.err, w.Label(ctx), shared.User, w.Anomalies}
+}
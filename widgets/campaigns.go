@@ -19,6 +19,7 @@ type Campaigns struct {
 	Limit    int
 	Campaign goatcounter.CampaignID
 	Stats    goatcounter.HitStats
+	Revenue  goatcounter.RevenueStats
 }
 
 func (w Campaigns) Name() string                         { return "campaigns" }
@@ -46,14 +47,16 @@ func (w *Campaigns) GetData(ctx context.Context, a Args) (more bool, err error)
 		err = w.Stats.ListCampaign(ctx, w.Campaign, a.Rng, a.PathFilter, w.Limit, a.Offset)
 	} else {
 		err = w.Stats.ListCampaigns(ctx, a.Rng, a.PathFilter, w.Limit, a.Offset)
+		if err == nil && a.Offset == 0 {
+			err = w.Revenue.ListCampaigns(ctx, a.Rng, a.PathFilter, w.Limit, 0)
+		}
 	}
 	w.loaded = true
 	return w.Stats.More, err
 }
 
 func (w Campaigns) RenderHTML(ctx context.Context, shared SharedData) (string, any) {
-	//return "_dashboard_campaigns.gohtml", struct {
-	return "_dashboard_hchart.gohtml", struct {
+	return "_dashboard_campaigns.gohtml", struct {
 		Context      context.Context
 		Base         string
 		Name         string
@@ -68,7 +71,8 @@ func (w Campaigns) RenderHTML(ctx context.Context, shared SharedData) (string, a
 		TotalUTC     int
 		Stats        goatcounter.HitStats
 		Campaign     goatcounter.CampaignID
+		Revenue      goatcounter.RevenueStats
 	}{ctx, goatcounter.Config(ctx).BasePath, w.Name(), w.id, true, shared.RowsOnly, w.Campaign == 0, w.loaded, w.err,
 		isCol(ctx, goatcounter.CollectReferrer), w.Label(ctx),
-		shared.TotalUTC, w.Stats, w.Campaign}
+		shared.TotalUTC, w.Stats, w.Campaign, w.Revenue}
 }
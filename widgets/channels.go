@@ -0,0 +1,71 @@
+package widgets
+
+import (
+	"context"
+	"html/template"
+
+	"zgo.at/goatcounter/v2"
+	"zgo.at/z18n"
+)
+
+type Channels struct {
+	id     int
+	loaded bool
+	err    error
+	html   template.HTML
+	s      goatcounter.WidgetSettings
+
+	Limit   int
+	Channel string
+	Stats   goatcounter.HitStats
+}
+
+func (w Channels) Name() string                         { return "channels" }
+func (w Channels) Type() string                         { return "hchart" }
+func (w Channels) Label(ctx context.Context) string     { return z18n.T(ctx, "label/channels|Channels") }
+func (w *Channels) SetHTML(h template.HTML)             { w.html = h }
+func (w Channels) HTML() template.HTML                  { return w.html }
+func (w *Channels) SetErr(h error)                      { w.err = h }
+func (w Channels) Err() error                           { return w.err }
+func (w Channels) ID() int                              { return w.id }
+func (w Channels) Settings() goatcounter.WidgetSettings { return w.s }
+
+func (w *Channels) SetSettings(s goatcounter.WidgetSettings) {
+	w.s = s
+	if x := s["limit"].Value; x != nil {
+		w.Limit = int(x.(float64))
+	}
+	if x := s["key"].Value; x != nil {
+		w.Channel = x.(string)
+	}
+}
+
+func (w *Channels) GetData(ctx context.Context, a Args) (more bool, err error) {
+	if w.Channel != "" {
+		err = w.Stats.ListChannel(ctx, w.Channel, a.Rng, a.PathFilter, w.Limit, a.Offset)
+	} else {
+		err = w.Stats.ListChannels(ctx, a.Rng, a.PathFilter, w.Limit, a.Offset)
+	}
+	w.loaded = true
+	return w.Stats.More, err
+}
+
+func (w Channels) RenderHTML(ctx context.Context, shared SharedData) (string, any) {
+	return "_dashboard_hchart.gohtml", struct {
+		Context      context.Context
+		Base         string
+		Name         string
+		ID           int
+		CanConfigure bool
+		RowsOnly     bool
+		HasSubMenu   bool
+		Loaded       bool
+		Err          error
+		IsCollected  bool
+		Header       string
+		TotalUTC     int
+		Stats        goatcounter.HitStats
+	}{ctx, goatcounter.Config(ctx).BasePath, w.Name(), w.id, true, shared.RowsOnly, w.Channel == "", w.loaded, w.err,
+		isCol(ctx, goatcounter.CollectReferrer), w.Label(ctx),
+		shared.TotalUTC, w.Stats}
+}
@@ -0,0 +1,67 @@
+package widgets
+
+import (
+	"context"
+	"html/template"
+
+	"zgo.at/goatcounter/v2"
+	"zgo.at/z18n"
+)
+
+type Engines struct {
+	id     int
+	loaded bool
+	err    error
+	html   template.HTML
+	s      goatcounter.WidgetSettings
+
+	Limit int
+	Stats goatcounter.HitStats
+}
+
+func (w Engines) Name() string { return "engines" }
+func (w Engines) Type() string { return "hchart" }
+func (w Engines) Label(ctx context.Context) string {
+	return z18n.T(ctx, "label/engine-stats|Browser engine stats")
+}
+func (w *Engines) SetHTML(h template.HTML)             { w.html = h }
+func (w Engines) HTML() template.HTML                  { return w.html }
+func (w *Engines) SetErr(h error)                      { w.err = h }
+func (w Engines) Err() error                           { return w.err }
+func (w Engines) ID() int                              { return w.id }
+func (w Engines) Settings() goatcounter.WidgetSettings { return w.s }
+
+func (w *Engines) SetSettings(s goatcounter.WidgetSettings) {
+	w.s = s
+	if x := s["limit"].Value; x != nil {
+		w.Limit = int(x.(float64))
+	}
+}
+
+func (w *Engines) GetData(ctx context.Context, a Args) (more bool, err error) {
+	err = w.Stats.ListEngines(ctx, a.Rng, a.PathFilter, w.Limit, a.Offset)
+	w.loaded = true
+	return w.Stats.More, err
+}
+
+func (w Engines) RenderHTML(ctx context.Context, shared SharedData) (string, any) {
+	header := z18n.T(ctx, "header/engines|Browser engines")
+
+	return "_dashboard_hchart.gohtml", struct {
+		Context      context.Context
+		Base         string
+		Name         string
+		ID           int
+		CanConfigure bool
+		RowsOnly     bool
+		HasSubMenu   bool
+		Loaded       bool
+		Err          error
+		IsCollected  bool
+		Header       string
+		TotalUTC     int
+		Stats        goatcounter.HitStats
+	}{ctx, goatcounter.Config(ctx).BasePath, w.Name(), w.id, true, shared.RowsOnly, false, w.loaded, w.err,
+		isCol(ctx, goatcounter.CollectUserAgent),
+		header, shared.TotalUTC, w.Stats}
+}
@@ -3,6 +3,7 @@ package widgets
 import (
 	"context"
 	"html/template"
+	"strings"
 
 	"zgo.at/goatcounter/v2"
 	"zgo.at/z18n"
@@ -65,9 +66,19 @@ func (w Locations) RenderHTML(ctx context.Context, shared SharedData) (string, a
 		if err != nil {
 			w.err = err
 		}
-		header = z18n.T(ctx, "header/locations-for|Locations for %(country)", l.CountryName)
+		if l.Region == "" {
+			header = z18n.T(ctx, "header/locations-for|Locations for %(country)", l.CountryName)
+		} else {
+			header = z18n.T(ctx, "header/locations-for-region|Locations for %(region), %(country)",
+				z18n.P{"region": l.RegionName, "country": l.CountryName})
+		}
 	}
 
+	// Link to the regions for a country, and to the cities for a region if
+	// those are collected.
+	hasSubMenu := w.Detail == "" ||
+		(!strings.Contains(w.Detail, "-") && isCol(ctx, goatcounter.CollectLocationCity))
+
 	return "_dashboard_hchart.gohtml", struct {
 		Context       context.Context
 		Base          string
@@ -84,6 +95,6 @@ func (w Locations) RenderHTML(ctx context.Context, shared SharedData) (string, a
 		Stats         goatcounter.HitStats
 		Detail        string
 		MostlyUnknown bool
-	}{ctx, goatcounter.Config(ctx).BasePath, w.Name(), w.id, true, shared.RowsOnly, w.Detail == "", w.loaded, w.err,
+	}{ctx, goatcounter.Config(ctx).BasePath, w.Name(), w.id, true, shared.RowsOnly, hasSubMenu, w.loaded, w.err,
 		isCol(ctx, goatcounter.CollectLocation), header, shared.TotalUTC, w.Stats, w.Detail, w.MostlyUnknown}
 }
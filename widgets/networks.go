@@ -0,0 +1,67 @@
+package widgets
+
+import (
+	"context"
+	"html/template"
+
+	"zgo.at/goatcounter/v2"
+	"zgo.at/z18n"
+)
+
+type Networks struct {
+	id     int
+	loaded bool
+	err    error
+	html   template.HTML
+	s      goatcounter.WidgetSettings
+
+	Limit int
+	Stats goatcounter.HitStats
+}
+
+func (w Networks) Name() string { return "networks" }
+func (w Networks) Type() string { return "hchart" }
+func (w Networks) Label(ctx context.Context) string {
+	return z18n.T(ctx, "label/network-stats|Network stats")
+}
+func (w *Networks) SetHTML(h template.HTML)             { w.html = h }
+func (w Networks) HTML() template.HTML                  { return w.html }
+func (w *Networks) SetErr(h error)                      { w.err = h }
+func (w Networks) Err() error                           { return w.err }
+func (w Networks) ID() int                              { return w.id }
+func (w Networks) Settings() goatcounter.WidgetSettings { return w.s }
+
+func (w *Networks) SetSettings(s goatcounter.WidgetSettings) {
+	w.s = s
+	if x := s["limit"].Value; x != nil {
+		w.Limit = int(x.(float64))
+	}
+}
+
+func (w *Networks) GetData(ctx context.Context, a Args) (more bool, err error) {
+	err = w.Stats.ListNetworks(ctx, a.Rng, a.PathFilter, w.Limit, a.Offset)
+	w.loaded = true
+	return w.Stats.More, err
+}
+
+func (w Networks) RenderHTML(ctx context.Context, shared SharedData) (string, any) {
+	header := z18n.T(ctx, "header/networks|Networks")
+
+	return "_dashboard_hchart.gohtml", struct {
+		Context      context.Context
+		Base         string
+		Name         string
+		ID           int
+		CanConfigure bool
+		RowsOnly     bool
+		HasSubMenu   bool
+		Loaded       bool
+		Err          error
+		IsCollected  bool
+		Header       string
+		TotalUTC     int
+		Stats        goatcounter.HitStats
+	}{ctx, goatcounter.Config(ctx).BasePath, w.Name(), w.id, true, shared.RowsOnly, false, w.loaded, w.err,
+		isCol(ctx, goatcounter.CollectNetwork),
+		header, shared.TotalUTC, w.Stats}
+}
@@ -30,6 +30,7 @@ type Pages struct {
 	Max              int
 	Exclude          []goatcounter.PathID
 	Diff             []float64
+	Annotations      goatcounter.Annotations
 }
 
 func (w Pages) Name() string                         { return "pages" }
@@ -84,6 +85,10 @@ func (w *Pages) GetData(ctx context.Context, a Args) (bool, error) {
 		errs.Append(err)
 	}
 
+	if w.Style != "text" {
+		errs.Append(w.Annotations.ListRange(ctx, a.Rng))
+	}
+
 	wg.Wait()
 
 	for _, p := range w.Pages {
@@ -141,6 +146,14 @@ func (w Pages) RenderHTML(ctx context.Context, shared SharedData) (string, any)
 		}
 	}
 
+	var annotations [][]goatcounter.ChartAnnotation
+	if len(w.Annotations) > 0 {
+		annotations = make([][]goatcounter.ChartAnnotation, len(w.Pages))
+		for i := range w.Pages {
+			annotations[i] = w.Annotations.Chart(ctx, &w.Pages[i])
+		}
+	}
+
 	return t, struct {
 		Context context.Context
 		Site    *goatcounter.Site
@@ -161,16 +174,17 @@ func (w Pages) RenderHTML(ctx context.Context, shared SharedData) (string, any)
 		TotalEvents  int
 		MorePages    bool
 
-		Style    string
-		Refs     goatcounter.HitStats
-		ShowRefs goatcounter.PathID
-		Diff     []float64
+		Style       string
+		Refs        goatcounter.HitStats
+		ShowRefs    goatcounter.PathID
+		Diff        []float64
+		Annotations [][]goatcounter.ChartAnnotation
 	}{
 		ctx, shared.Site, shared.User,
 		w.id, w.loaded, w.err, w.Pages, shared.Args.Rng, shared.Args.Group,
 		shared.Args.AllowGroups, len(w.Exclude) + 1, w.Max,
 		w.Display, shared.Total, shared.TotalEvents, w.More,
 		w.Style, w.Refs, shared.Args.ShowRefs,
-		w.Diff,
+		w.Diff, annotations,
 	}
 }
@@ -0,0 +1,74 @@
+package widgets
+
+import (
+	"context"
+	"html/template"
+
+	"zgo.at/goatcounter/v2"
+	"zgo.at/z18n"
+)
+
+type Props struct {
+	id     int
+	loaded bool
+	err    error
+	html   template.HTML
+	s      goatcounter.WidgetSettings
+
+	Limit  int
+	Detail string
+	Stats  goatcounter.HitStats
+}
+
+func (w Props) Name() string { return "props" }
+func (w Props) Type() string { return "hchart" }
+func (w Props) Label(ctx context.Context) string {
+	return z18n.T(ctx, "label/prop-stats|Property stats")
+}
+func (w *Props) SetHTML(h template.HTML)             { w.html = h }
+func (w Props) HTML() template.HTML                  { return w.html }
+func (w *Props) SetErr(h error)                      { w.err = h }
+func (w Props) Err() error                           { return w.err }
+func (w Props) ID() int                              { return w.id }
+func (w Props) Settings() goatcounter.WidgetSettings { return w.s }
+
+func (w *Props) SetSettings(s goatcounter.WidgetSettings) {
+	if x := s["limit"].Value; x != nil {
+		w.Limit = int(x.(float64))
+	}
+	if x := s["key"].Value; x != nil {
+		w.Detail = x.(string)
+	}
+	w.s = s
+}
+
+func (w *Props) GetData(ctx context.Context, a Args) (more bool, err error) {
+	if w.Detail != "" {
+		err = w.Stats.ListProp(ctx, w.Detail, a.Rng, a.PathFilter, w.Limit, a.Offset)
+	} else {
+		err = w.Stats.ListProps(ctx, a.Rng, a.PathFilter, w.Limit, a.Offset)
+	}
+	w.loaded = true
+	return w.Stats.More, err
+}
+
+func (w Props) RenderHTML(ctx context.Context, shared SharedData) (string, any) {
+	return "_dashboard_hchart.gohtml", struct {
+		Context      context.Context
+		Base         string
+		Name         string
+		ID           int
+		CanConfigure bool
+		RowsOnly     bool
+		HasSubMenu   bool
+		Loaded       bool
+		Err          error
+		IsCollected  bool
+		Header       string
+		TotalUTC     int
+		Stats        goatcounter.HitStats
+		Detail       string
+	}{ctx, goatcounter.Config(ctx).BasePath, w.Name(), w.id, true, shared.RowsOnly, w.Detail == "", w.loaded, w.err,
+		true, z18n.T(ctx, "header/props|Properties"),
+		shared.TotalUTC, w.Stats, w.Detail}
+}
@@ -0,0 +1,60 @@
+package widgets
+
+import (
+	"context"
+	"html/template"
+
+	"zgo.at/goatcounter/v2"
+	"zgo.at/z18n"
+)
+
+type SearchQueries struct {
+	id     int
+	loaded bool
+	err    error
+	html   template.HTML
+	s      goatcounter.WidgetSettings
+
+	Limit int
+	Stats goatcounter.SearchQueryStats
+}
+
+func (w SearchQueries) Name() string { return "searchqueries" }
+func (w SearchQueries) Type() string { return "hchart" }
+func (w SearchQueries) Label(ctx context.Context) string {
+	return z18n.T(ctx, "label/search-queries|Search queries")
+}
+func (w *SearchQueries) SetHTML(h template.HTML)             { w.html = h }
+func (w SearchQueries) HTML() template.HTML                  { return w.html }
+func (w *SearchQueries) SetErr(h error)                      { w.err = h }
+func (w SearchQueries) Err() error                           { return w.err }
+func (w SearchQueries) ID() int                              { return w.id }
+func (w SearchQueries) Settings() goatcounter.WidgetSettings { return w.s }
+
+func (w *SearchQueries) SetSettings(s goatcounter.WidgetSettings) {
+	w.s = s
+	if x := s["limit"].Value; x != nil {
+		w.Limit = int(x.(float64))
+	}
+}
+
+func (w *SearchQueries) GetData(ctx context.Context, a Args) (more bool, err error) {
+	err = w.Stats.List(ctx, a.Rng, a.PathFilter, w.Limit, a.Offset)
+	w.loaded = true
+	return w.Stats.More, err
+}
+
+func (w SearchQueries) RenderHTML(ctx context.Context, shared SharedData) (string, any) {
+	return "_dashboard_search_queries.gohtml", struct {
+		Context  context.Context
+		Base     string
+		ID       int
+		RowsOnly bool
+		Loaded   bool
+		Err      error
+		Header   string
+		User     *goatcounter.User
+		Stats    goatcounter.SearchQueryStats
+	}{ctx, goatcounter.Config(ctx).BasePath, w.id, shared.RowsOnly, w.loaded, w.err,
+		w.Label(ctx), shared.User, w.Stats}
+}
@@ -18,6 +18,7 @@ type TopRefs struct {
 	Limit   int
 	Ref     string
 	TopRefs goatcounter.HitStats
+	Revenue goatcounter.RevenueStats
 }
 
 func (w TopRefs) Name() string                         { return "toprefs" }
@@ -45,6 +46,9 @@ func (w *TopRefs) GetData(ctx context.Context, a Args) (more bool, err error) {
 		err = w.TopRefs.ListTopRef(ctx, w.Ref, a.Rng, a.PathFilter, w.Limit, a.Offset)
 	} else {
 		err = w.TopRefs.ListTopRefs(ctx, a.Rng, a.PathFilter, w.Limit, a.Offset)
+		if err == nil && a.Offset == 0 {
+			err = w.Revenue.ListRefs(ctx, a.Rng, a.PathFilter, w.Limit, 0)
+		}
 	}
 	w.loaded = true
 	return w.TopRefs.More, err
@@ -65,6 +69,7 @@ func (w TopRefs) RenderHTML(ctx context.Context, shared SharedData) (string, any
 		Total        int
 		Stats        goatcounter.HitStats
 		Ref          string
+		Revenue      goatcounter.RevenueStats
 	}{ctx, goatcounter.Config(ctx).BasePath, w.Name(), w.id, true, shared.RowsOnly, w.Ref == "", w.loaded, w.err,
-		isCol(ctx, goatcounter.CollectReferrer), shared.Total, w.TopRefs, w.Ref}
+		isCol(ctx, goatcounter.CollectReferrer), shared.Total, w.TopRefs, w.Ref, w.Revenue}
 }
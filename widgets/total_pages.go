@@ -19,6 +19,10 @@ type TotalPages struct {
 	Align, NoEvents bool
 	Style           string
 	Total           goatcounter.HitList
+	Anomalies       goatcounter.Anomalies
+	Annotations     goatcounter.Annotations
+	Revenue         goatcounter.RevenueStats
+	RevenueDays     goatcounter.RevenueStats
 }
 
 func (w TotalPages) Name() string { return "totalpages" }
@@ -48,6 +52,18 @@ func (w *TotalPages) SetSettings(s goatcounter.WidgetSettings) {
 
 func (w *TotalPages) GetData(ctx context.Context, a Args) (more bool, err error) {
 	err = w.Total.Totals(ctx, a.Rng, a.PathFilter, a.Group, w.NoEvents)
+	if err == nil && a.PathFilter.IsZero() {
+		err = w.Anomalies.ListTotals(ctx, a.Rng)
+	}
+	if err == nil {
+		err = w.Annotations.ListRange(ctx, a.Rng)
+	}
+	if err == nil {
+		err = w.Revenue.ListTotals(ctx, a.Rng, a.PathFilter, 10, 0)
+	}
+	if err == nil && len(w.Revenue.Stats) > 0 {
+		err = w.RevenueDays.ListDays(ctx, a.Rng, a.PathFilter, 10_000, 0)
+	}
 	w.loaded = true
 	return false, err
 }
@@ -69,6 +85,11 @@ func (w TotalPages) RenderHTML(ctx context.Context, shared SharedData) (string,
 		w.Total.Stats[j].Hourly = w.Total.Stats[j].Hourly[:hour+1]
 	}
 
+	var revCurrency string
+	if len(w.Revenue.Stats) > 0 {
+		revCurrency = w.Revenue.Stats[0].Currency
+	}
+
 	return "_dashboard_totals.gohtml", struct {
 		Context context.Context
 		Site    *goatcounter.Site
@@ -86,10 +107,19 @@ func (w TotalPages) RenderHTML(ctx context.Context, shared SharedData) (string,
 		Total       int
 		TotalEvents int
 
-		Style string
+		Style       string
+		Anomalies   goatcounter.Anomalies
+		Annotations []goatcounter.ChartAnnotation
+
+		// Revenue per currency, and the revenue per day for the currency with
+		// the highest total for the line on the chart.
+		Revenue         []goatcounter.RevenueStat
+		RevenueCurrency string
+		RevenueDays     map[string]goatcounter.Money
 	}{ctx, shared.Site, shared.User, w.id, w.loaded, w.err,
 		w.Align, w.NoEvents,
 		w.Total, shared.Args.Group, w.Total.Max,
 		shared.Total, shared.TotalEvents,
-		w.Style}
+		w.Style, w.Anomalies, w.Annotations.Chart(ctx, nil),
+		w.Revenue.Stats, revCurrency, w.RevenueDays.Days(revCurrency)}
 }
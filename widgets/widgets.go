@@ -155,11 +155,18 @@ func ListAllWidgets() List {
 		NewWidget(context.Background(), "browsers", 0),
 		NewWidget(context.Background(), "locations", 0),
 		NewWidget(context.Background(), "languages", 0),
+		NewWidget(context.Background(), "networks", 0),
+		NewWidget(context.Background(), "devices", 0),
+		NewWidget(context.Background(), "engines", 0),
+		NewWidget(context.Background(), "props", 0),
 		NewWidget(context.Background(), "pages", 0),
 		NewWidget(context.Background(), "sizes", 0),
 		NewWidget(context.Background(), "systems", 0),
 		NewWidget(context.Background(), "toprefs", 0),
 		NewWidget(context.Background(), "campaigns", 0),
+		NewWidget(context.Background(), "channels", 0),
+		NewWidget(context.Background(), "searchqueries", 0),
+		NewWidget(context.Background(), "anomalies", 0),
 		NewWidget(context.Background(), "totalpages", 0),
 	}
 }
@@ -177,6 +184,12 @@ func NewWidget(ctx context.Context, name string, id int) Widget {
 		return &TopRefs{id: id}
 	case "campaigns":
 		return &Campaigns{id: id}
+	case "channels":
+		return &Channels{id: id}
+	case "searchqueries":
+		return &SearchQueries{id: id}
+	case "anomalies":
+		return &Anomalies{id: id}
 	case "browsers":
 		return &Browsers{id: id}
 	case "systems":
@@ -187,6 +200,14 @@ func NewWidget(ctx context.Context, name string, id int) Widget {
 		return &Locations{id: id}
 	case "languages":
 		return &Languages{id: id}
+	case "networks":
+		return &Networks{id: id}
+	case "devices":
+		return &Devices{id: id}
+	case "engines":
+		return &Engines{id: id}
+	case "props":
+		return &Props{id: id}
 	}
 	log.Errorf(ctx, "unknown widget: %q", name)
 	return &Dummy{}